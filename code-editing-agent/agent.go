@@ -2,45 +2,142 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/genai"
 )
 
+// defaultModel is the model used when none is configured.
+const defaultModel = "gemini-3-flash-preview"
+
+// defaultMaxToolRounds bounds how many rounds of tool calls one turn may make.
+const defaultMaxToolRounds = 25
+
 // Agent manages the conversation and tool execution.
 type Agent struct {
-	client         *genai.Client
+	client         ModelClient
 	getUserMessage func() (string, bool)
 	sandbox        *PathSandbox
+	tools          *ToolContext
+	registry       *Registry
+	confirm        ConfirmFunc           // Asked before running tools that can modify anything; if nil, such calls are refused
+	toolPolicies   map[string]ToolPolicy // Per-tool overrides of when confirm is asked
+	style          Styler                // Colors the agent's own terminal output
+	turnTimeout    time.Duration         // Upper bound on one turn's model requests and tool calls; 0 disables
+	maxToolRounds  int                   // Rounds of tool calls allowed per turn; 0 means unlimited
+	maxRepeatCalls int                   // Identical consecutive calls allowed before short-circuiting; 0 disables
+	maxResultBytes int                   // Largest encoded tool result sent to the model; 0 disables truncation
+	out            io.Writer             // Where the conversation and the agent's own messages are printed
+	events         EventSink
 	history        []*genai.Content
 	model          string
 	config         *genai.GenerateContentConfig
-	debugMode      bool
+	logger         *slog.Logger
+	sessionPath    string     // If set, history is saved here after each turn
+	tracer         *Tracer    // If set, every model request and response is recorded
+	maxRetries     int        // Retries for transient stream errors before any output
+	usage          Usage      // Accumulated token counts for the session
+	maxTokens      int        // Session token budget; 0 means unlimited
+	showUsage      bool       // Print running token totals after each turn
+	stats          *ToolStats // Per-tool call counts and latency
+	showStats      bool       // Print the tool latency table when the session ends
+
+	compactThreshold int // Estimated history tokens that trigger compaction; 0 disables
+	compactKeepTurns int // Recent turns kept verbatim when compacting
+	maxHistory       int // History entries kept before the oldest are dropped; 0 means unlimited
+	maxHistoryBytes  int // Serialized history size before the oldest entries are dropped; 0 means unlimited
 }
 
-// NewAgent creates a new Agent.
-func NewAgent(client *genai.Client, getUserMessage func() (string, bool), sandbox *PathSandbox, debugMode bool) *Agent {
-	model := "gemini-3-flash-preview"
-	return &Agent{
+// NewAgent creates a new Agent configured by cfg.
+// A non-empty cfg.SystemPrompt is sent as the system instruction on every request,
+// followed by a summary of the project when cfg.AutoContext is set.
+// The conversation is printed to out. It fails if cfg enables or disables a
+// tool that does not exist.
+func NewAgent(client ModelClient, getUserMessage func() (string, bool), sandbox *PathSandbox, cfg *Config, out io.Writer, logger *slog.Logger) (*Agent, error) {
+	registry := NewDefaultRegistry()
+	if err := registry.Restrict(cfg.EnableTools, cfg.DisableTools); err != nil {
+		return nil, err
+	}
+	for name := range cfg.ToolPolicies {
+		if _, ok := registry.Lookup(name); !ok {
+			return nil, fmt.Errorf("unknown tool in policy: %s", name)
+		}
+	}
+	config := &genai.GenerateContentConfig{
+		Tools:           registry.GenaiTools(),
+		Temperature:     cfg.Temperature,
+		TopP:            cfg.TopP,
+		MaxOutputTokens: cfg.MaxOutputTokens,
+	}
+
+	tools := NewToolContext(sandbox, logger)
+	tools.AllowedCommands = cfg.AllowedCommands
+	tools.CommandTimeout = cfg.CommandTimeout
+	tools.Formatters = cfg.Formatters
+	tools.DryRun = cfg.DryRun
+
+	instruction := cfg.SystemPrompt
+	if cfg.AutoContext {
+		if summary := autoContext(context.Background(), tools); summary != "" {
+			instruction = strings.TrimSpace(instruction + "\n\n" + summary)
+		}
+	}
+	if instruction != "" {
+		config.SystemInstruction = genai.NewContentFromText(instruction, genai.RoleUser)
+	}
+
+	style := Styler{Color: cfg.Color}
+	sink := NewTerminalSink(out, style, cfg.WrapWidth)
+	sink.Markdown = cfg.RenderMarkdown
+	sink.Spinner = cfg.Spinner
+	agent := &Agent{
 		client:         client,
 		getUserMessage: getUserMessage,
 		sandbox:        sandbox,
+		tools:          tools,
+		registry:       registry,
+		toolPolicies:   cfg.ToolPolicies,
+		style:          style,
+		out:            out,
+		events:         sink,
 		history:        []*genai.Content{},
-		model:          model,
-		config: &genai.GenerateContentConfig{
-			Tools: getTools(),
-		},
-		debugMode: debugMode,
+		model:          cfg.Model,
+		config:         config,
+		logger:         logger,
+		stats:          NewToolStats(),
+		maxRetries:     defaultMaxRetries,
+		turnTimeout:    cfg.TurnTimeout,
+		maxToolRounds:  cfg.MaxToolRounds,
+		maxRepeatCalls: cfg.MaxRepeatCalls,
+		maxResultBytes: cfg.MaxResultBytes,
+		maxTokens:      cfg.MaxTokens,
+		showUsage:      cfg.ShowUsage,
+		showStats:      cfg.ShowStats,
+
+		compactThreshold: cfg.CompactThreshold,
+		compactKeepTurns: cfg.CompactKeepTurns,
+		maxHistory:       cfg.MaxHistory,
+		maxHistoryBytes:  cfg.MaxHistoryBytes,
 	}
+	agent.tools.TokenCounter = agent
+	return agent, nil
 }
 
 // Run starts the main agent loop.
+// Cancelling ctx interrupts the current turn and ends the session cleanly:
+// the interrupted turn is dropped and the history is saved.
 func (a *Agent) Run(ctx context.Context) error {
-	fmt.Printf("Chat with %s (use ctrl-c to exit)\n", a.model)
+	fmt.Fprintf(a.out, "Chat with %s (use ctrl-c to exit, /help for commands)\n", a.model)
 
 	for {
-		fmt.Print("\033[94mYou:\033[0m ")
+		fmt.Fprint(a.out, a.style.Paint(colorBlue, "You:"), " ")
 		userInput, ok := a.getUserMessage()
 		if !ok {
 			break
@@ -48,33 +145,131 @@ func (a *Agent) Run(ctx context.Context) error {
 		if strings.TrimSpace(userInput) == "" {
 			continue
 		}
-
-		// Append user message to history
-		userContent := &genai.Content{
-			Role: "user",
-			Parts: []*genai.Part{
-				{Text: userInput},
-			},
+		if isMetaCommand(userInput) {
+			a.handleMetaCommand(ctx, userInput)
+			continue
 		}
-		a.history = append(a.history, userContent)
 
-		// Stream and handle function calls
-		if err := a.processStreamWithTools(ctx); err != nil {
+		_, err := a.runTurn(ctx, userInput)
+		if ctx.Err() != nil {
+			break
+		}
+		if errors.Is(err, errTokenBudgetExceeded) {
+			fmt.Fprintf(a.out, "Token budget of %d reached (%d used); ending the session.\n", a.maxTokens, a.usage.TotalTokens)
+			break
+		}
+		if err != nil {
 			return err
 		}
 	}
 
+	if ctx.Err() != nil {
+		if a.sessionPath != "" {
+			if err := a.SaveHistory(a.sessionPath); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(a.out, "\nInterrupted. Goodbye!")
+	}
+
+	if a.showStats {
+		a.stats.WriteTable(a.out)
+	}
+
 	return nil
 }
 
+// RunOnce runs a single turn for prompt, including any tool calls, and returns
+// the model's final answer. It is used for non-interactive runs, so any
+// failure, interruption, or exhausted budget is returned as an error.
+func (a *Agent) RunOnce(ctx context.Context, prompt string) (string, error) {
+	text, err := a.runTurn(ctx, prompt)
+	if a.showStats {
+		a.stats.WriteTable(a.out)
+	}
+	return text, err
+}
+
+// runTurn sends one user message and handles the response, running tool calls
+// until the model answers, and returns the text of that answer. It returns
+// errTokenBudgetExceeded once the session budget is spent. If ctx is
+// cancelled, the partial turn is dropped from history so it stays
+// well-formed, and ctx.Err() is returned.
+func (a *Agent) runTurn(ctx context.Context, input string) (string, error) {
+	// Shrink the history before it outgrows the context window
+	if err := a.compactHistory(ctx); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+	a.trimHistory(a.maxHistory)
+
+	// Append user message to history
+	userContent := &genai.Content{
+		Role: "user",
+		Parts: []*genai.Part{
+			{Text: input},
+		},
+	}
+	a.history = append(a.history, userContent)
+	a.enforceHistoryBytes()
+
+	// Lookups cached during a previous turn may be stale by now
+	a.sandbox.ClearCache()
+
+	turnCtx := ctx
+	if a.turnTimeout > 0 {
+		var cancel context.CancelFunc
+		turnCtx, cancel = context.WithTimeout(ctx, a.turnTimeout)
+		defer cancel()
+	}
+
+	// Stream and handle function calls
+	text, err := a.processStreamWithTools(turnCtx)
+	if ctx.Err() != nil {
+		a.dropTurn(userContent)
+		return "", ctx.Err()
+	}
+	if errors.Is(turnCtx.Err(), context.DeadlineExceeded) {
+		// Close the turn with a note so the model knows its work was cut short
+		// and the history ends on a model response.
+		note := fmt.Sprintf("The turn timed out after %s before I finished; the work above may be incomplete.", a.turnTimeout)
+		a.history = append(a.history, genai.NewContentFromText(note, genai.RoleModel))
+		fmt.Fprintln(a.out, a.style.Paint(colorRed, note))
+		text, err = note, nil
+	}
+	if err == nil && a.overBudget() {
+		err = errTokenBudgetExceeded
+	}
+	if err != nil && !errors.Is(err, errTokenBudgetExceeded) {
+		return text, err
+	}
+
+	if a.showUsage {
+		fmt.Fprintln(a.out, a.style.Paint(colorGray, fmt.Sprintf("Tokens: %d prompt, %d response, %d total",
+			a.usage.PromptTokens, a.usage.CandidateTokens, a.usage.TotalTokens)))
+	}
+
+	if a.sessionPath != "" {
+		if err := a.SaveHistory(a.sessionPath); err != nil {
+			return text, err
+		}
+	}
+
+	return text, err
+}
+
 // processStreamWithTools handles a single turn of streaming + tool calls.
-// It repeats until no more function calls are returned.
-func (a *Agent) processStreamWithTools(ctx context.Context) error {
-	for {
+// It repeats until no more function calls are returned, and returns the text
+// of the final model response.
+func (a *Agent) processStreamWithTools(ctx context.Context) (string, error) {
+	repeats := newRepeatTracker(a.maxRepeatCalls)
+	for round := 0; ; round++ {
 		// Stream the model response
 		modelContent, calls, err := a.streamModelResponse(ctx)
 		if err != nil {
-			return err
+			return "", err
 		}
 
 		// Append the model response to history
@@ -82,11 +277,25 @@ func (a *Agent) processStreamWithTools(ctx context.Context) error {
 
 		// If no tool calls, we're done with this turn
 		if len(calls) == 0 {
-			break
+			return contentText(modelContent), nil
+		}
+
+		// Stop before running more tools once the budget is spent. The pending
+		// calls are dropped from history so it never ends on an unanswered call.
+		if a.overBudget() {
+			a.history = a.history[:len(a.history)-1]
+			return "", errTokenBudgetExceeded
+		}
+
+		// A model that keeps calling tools is told to stop and answer instead
+		if a.maxToolRounds > 0 && round >= a.maxToolRounds {
+			a.logger.Warn("tool call limit reached", "limit", a.maxToolRounds)
+			fmt.Fprintln(a.out, a.style.Paint(colorRed, fmt.Sprintf("Tool call limit of %d rounds reached; asking for a final answer.", a.maxToolRounds)))
+			return a.finalAnswer(ctx, calls)
 		}
 
 		// Execute all tool calls and collect responses
-		toolResponseParts := a.executeToolCalls(calls)
+		toolResponseParts := a.executeToolCalls(ctx, calls, repeats)
 
 		// Create a user message containing all function responses
 		toolResponseContent := &genai.Content{
@@ -94,38 +303,141 @@ func (a *Agent) processStreamWithTools(ctx context.Context) error {
 			Parts: toolResponseParts,
 		}
 		a.history = append(a.history, toolResponseContent)
+		a.enforceHistoryBytes()
 
 		// Continue the loop to stream the next model response
 	}
+}
 
-	return nil
+// dropTurn removes the turn begun by userContent from the history. Trimming
+// and repairs during the turn shift entries, so the turn is found by its user
+// message; if that was trimmed away, everything left belongs to the turn.
+func (a *Agent) dropTurn(userContent *genai.Content) {
+	start := slices.Index(a.history, userContent)
+	a.history = a.history[:max(start, 0)]
+}
+
+// enforceHistoryBytes trims the history to its byte ceiling and tells the
+// user when anything was dropped.
+func (a *Agent) enforceHistoryBytes() {
+	if dropped := a.fitHistory(a.maxHistoryBytes); dropped > 0 {
+		fmt.Fprintln(a.out, a.style.Paint(colorYellow, fmt.Sprintf("History trimmed to fit: dropped the %d oldest entries to stay under %d bytes.", dropped, a.maxHistoryBytes)))
+	}
+}
+
+// contentText joins the text parts of content, skipping model thoughts.
+func contentText(content *genai.Content) string {
+	var text strings.Builder
+	for _, part := range content.Parts {
+		if !part.Thought {
+			text.WriteString(part.Text)
+		}
+	}
+	return text.String()
+}
+
+// finalAnswer refuses the pending calls and requests one last response with
+// tool calling disabled, so the turn ends with an answer for the user. It
+// returns the text of that answer.
+func (a *Agent) finalAnswer(ctx context.Context, calls []*genai.FunctionCall) (string, error) {
+	limit := NewErrorResult("too_many_calls", fmt.Sprintf("tool call limit of %d rounds reached for this turn", a.maxToolRounds), []string{
+		"Do not call any more tools; give the user your final answer with what you have",
+	})
+	parts := make([]*genai.Part, len(calls))
+	for i, call := range calls {
+		a.events.OnToolResult(call, limit)
+		parts[i] = &genai.Part{
+			FunctionResponse: &genai.FunctionResponse{
+				Name:     call.Name,
+				Response: limit.AsMap(),
+			},
+		}
+	}
+	a.history = append(a.history, &genai.Content{Role: "user", Parts: parts})
+
+	config := *a.config
+	config.ToolConfig = &genai.ToolConfig{
+		FunctionCallingConfig: &genai.FunctionCallingConfig{Mode: genai.FunctionCallingConfigModeNone},
+	}
+	saved := a.config
+	a.config = &config
+	defer func() { a.config = saved }()
+
+	modelContent, _, err := a.streamModelResponse(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Drop any calls the model made anyway so history never ends on one
+	modelContent.Parts = slices.DeleteFunc(modelContent.Parts, func(p *genai.Part) bool {
+		return p.FunctionCall != nil
+	})
+	a.history = append(a.history, modelContent)
+	return contentText(modelContent), nil
 }
 
 // streamModelResponse streams the model response and returns the merged content + any function calls.
+// Transient errors are retried with exponential backoff, but only while nothing
+// has been emitted for this response, so partial output is never duplicated.
 func (a *Agent) streamModelResponse(ctx context.Context) (*genai.Content, []*genai.FunctionCall, error) {
-	stream := a.client.Models.GenerateContentStream(ctx, a.model, a.history, a.config)
+	// An unanswered call or orphaned response fails the request with an
+	// unhelpful 400, so fix the history up before sending it
+	var problems []string
+	a.history, problems = repairHistory(a.history)
+	for _, problem := range problems {
+		a.logger.Warn("repaired history", "problem", problem)
+	}
+
+	for attempt := 0; ; attempt++ {
+		modelContent, calls, emitted, err := a.streamModelResponseOnce(ctx)
+		if err == nil || emitted || attempt >= a.maxRetries || !isRetryableError(ctx, err) {
+			return modelContent, calls, err
+		}
+
+		delay := backoffDelay(attempt)
+		a.logger.Debug("retrying stream", "delay", delay, "attempt", attempt+1, "max_retries", a.maxRetries, "error", err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// streamModelResponseOnce makes a single streaming request. emitted reports
+// whether any part was received before an error occurred.
+func (a *Agent) streamModelResponseOnce(ctx context.Context) (modelContent *genai.Content, calls []*genai.FunctionCall, emitted bool, err error) {
+	a.events.OnModelWaiting()
+	a.tracer.Request(a.model, a.history, a.config)
+	stream := a.client.Stream(ctx, a.model, a.history, a.config)
 
 	var allParts []*genai.Part
 	var allCalls []*genai.FunctionCall
-	firstOutput := true
+	var usage *genai.GenerateContentResponseUsageMetadata
 
 	for resp, err := range stream {
 		if err != nil {
-			return nil, nil, fmt.Errorf("stream error: %w", err)
+			a.events.OnModelDone()
+			a.tracer.Response(&genai.Content{Role: "model", Parts: allParts}, usage, err)
+			return nil, nil, emitted, fmt.Errorf("stream error: %w", err)
+		}
+
+		if resp != nil && resp.UsageMetadata != nil {
+			usage = resp.UsageMetadata // Final chunk carries the totals
 		}
 
 		if resp == nil || len(resp.Candidates) == 0 {
 			continue
 		}
 
+		if resp.Candidates[0].Content == nil {
+			continue
+		}
+
 		for _, part := range resp.Candidates[0].Content.Parts {
-			// Handle text: print immediately
+			emitted = true
+
+			// Handle text: emit immediately
 			if part.Text != "" {
-				if firstOutput {
-					fmt.Print("\033[93mGemini:\033[0m ")
-					firstOutput = false
-				}
-				fmt.Print(part.Text)
+				a.events.OnModelText(part.Text)
 			}
 
 			// Handle function calls: collect for later
@@ -140,17 +452,17 @@ func (a *Agent) streamModelResponse(ctx context.Context) (*genai.Content, []*gen
 		}
 	}
 
-	if !firstOutput {
-		fmt.Println() // Newline after streaming text
-	}
+	a.events.OnModelDone()
+	a.usage.add(usage)
 
 	// Merge all parts into a single model content
-	modelContent := &genai.Content{
+	modelContent = &genai.Content{
 		Role:  "model",
 		Parts: allParts,
 	}
+	a.tracer.Response(modelContent, usage, nil)
 
-	return modelContent, allCalls, nil
+	return modelContent, allCalls, emitted, nil
 }
 
 // partHasData returns true when the part sets a concrete data field.
@@ -165,21 +477,94 @@ func partHasData(part *genai.Part) bool {
 		part.CodeExecutionResult != nil)
 }
 
+// runTool executes a single call, asking for confirmation first when required,
+// and records its latency.
+func (a *Agent) runTool(ctx context.Context, call *genai.FunctionCall) *ToolResult {
+	if a.toolPolicies[call.Name] == PolicyNever {
+		return blockedResult(call.Name)
+	}
+	if a.needsConfirmation(call.Name) {
+		if a.confirm == nil {
+			return unapprovedResult(call.Name)
+		}
+		if !a.confirm(call.Name, call.Args) {
+			return declinedResult(call.Name)
+		}
+	}
+
+	start := time.Now()
+	result := a.registry.Execute(ctx, call, a.tools)
+	a.stats.Record(call.Name, time.Since(start))
+
+	// Anything but a read-only tool may have changed the filesystem
+	if !a.registry.IsReadOnly(call.Name) {
+		a.sandbox.ClearCache()
+	}
+	return result
+}
+
+// maxConcurrentTools bounds how many read-only tool calls run at once.
+const maxConcurrentTools = 4
+
+// runsConcurrently reports whether a call to the named tool may run alongside
+// others: it must be read-only and need no confirmation, so prompts are never
+// shown, or answered, out of order.
+func (a *Agent) runsConcurrently(name string) bool {
+	return a.registry.IsReadOnly(name) && !a.needsConfirmation(name)
+}
+
 // executeToolCalls executes all function calls and returns FunctionResponse parts.
-func (a *Agent) executeToolCalls(calls []*genai.FunctionCall) []*genai.Part {
+// Consecutive read-only calls run concurrently (see runsConcurrently); any other
+// call runs on its own, so writes stay ordered relative to the reads around them. Parts are returned
+// in the same order as calls. Calls that repeat too often, per repeats, are
+// answered without running.
+func (a *Agent) executeToolCalls(ctx context.Context, calls []*genai.FunctionCall, repeats *repeatTracker) []*genai.Part {
 	parts := make([]*genai.Part, len(calls))
 
-	for i, call := range calls {
-		fmt.Printf("\033[92m→ %s\033[0m\n", call.Name)
+	for start := 0; start < len(calls); {
+		end := start + 1
+		if a.runsConcurrently(calls[start].Name) {
+			for end < len(calls) && a.runsConcurrently(calls[end].Name) {
+				end++
+			}
+		}
 
-		result := executeTool(call, a.sandbox, a.debugMode)
+		batch := calls[start:end]
+		results := make([]*ToolResult, len(batch))
+		for _, call := range batch {
+			a.events.OnToolCall(call)
+		}
 
-		parts[i] = &genai.Part{
-			FunctionResponse: &genai.FunctionResponse{
-				Name:     call.Name,
-				Response: result.AsMap(),
-			},
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, maxConcurrentTools)
+		for i, call := range batch {
+			if repeats.observe(call) {
+				a.logger.Warn("repeated tool call short-circuited", "tool", call.Name, "limit", repeats.limit)
+				results[i] = repeatedCallResult(call, repeats.limit)
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results[i] = a.runTool(ctx, call)
+			}()
 		}
+		wg.Wait()
+
+		for i, call := range batch {
+			results[i] = truncateResult(results[i], a.maxResultBytes)
+			a.events.OnToolResult(call, results[i])
+			parts[start+i] = &genai.Part{
+				FunctionResponse: &genai.FunctionResponse{
+					Name:     call.Name,
+					Response: results[i].AsMap(),
+				},
+			}
+		}
+
+		start = end
 	}
 
 	return parts
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// newTestAgent returns an agent over a sandbox in a temporary directory,
+// answered by client. configure, if non-nil, adjusts the config first.
+func newTestAgent(t *testing.T, client ModelClient, configure func(*Config)) *Agent {
+	t.Helper()
+	return newTestAgentWithOutput(t, client, io.Discard, configure)
+}
+
+// newTestAgentWithOutput is newTestAgent printing the conversation to out.
+func newTestAgentWithOutput(t *testing.T, client ModelClient, out io.Writer, configure func(*Config)) *Agent {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Color = false
+	cfg.Root = t.TempDir()
+	if configure != nil {
+		configure(cfg)
+	}
+	sandbox, err := NewPathSandbox(cfg.Root, WithConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent, err := NewAgent(client, func() (string, bool) { return "", false }, sandbox, cfg, out, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+// functionCallContent is a model response calling name with args.
+func functionCallContent(name string, args map[string]any) *genai.Content {
+	return &genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{genai.NewPartFromFunctionCall(name, args)}}
+}
+
+// cancellingClient cancels its context when asked for a response after the
+// first n, as a user pressing ctrl-c mid-turn would.
+type cancellingClient struct {
+	*ReplayClient
+	cancel context.CancelFunc
+	n      int
+}
+
+func (c *cancellingClient) Stream(ctx context.Context, model string, history []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	if c.n--; c.n < 0 {
+		c.cancel()
+	}
+	return c.ReplayClient.Stream(ctx, model, history, config)
+}
+
+func TestRunTurnCancelAfterTrim(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &cancellingClient{
+		ReplayClient: NewScriptedClient(functionCallContent("list_files", map[string]any{"path": "."})),
+		cancel:       cancel,
+		n:            1,
+	}
+	agent := newTestAgent(t, client, func(cfg *Config) { cfg.MaxHistoryBytes = 1500 })
+
+	// Earlier turns big enough that the tool response pushes them out
+	filler := strings.Repeat("x", 400)
+	for range 3 {
+		agent.history = append(agent.history,
+			genai.NewContentFromText("question "+filler, genai.RoleUser),
+			genai.NewContentFromText("answer "+filler, genai.RoleModel))
+	}
+
+	_, err := agent.runTurn(ctx, "list the files")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("runTurn error = %v, want context.Canceled", err)
+	}
+	for i, content := range agent.history {
+		if contentText(content) == "list the files" || len(functionCalls(content)) > 0 || hasFunctionResponse(content) {
+			t.Errorf("history[%d] is from the cancelled turn: %+v", i, content)
+		}
+	}
+	if len(agent.history) >= 6 {
+		t.Errorf("history has %d entries, want fewer than 6 after trimming", len(agent.history))
+	}
+}
+
+func TestRunTurnCancelWithoutTrim(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &cancellingClient{
+		ReplayClient: NewScriptedClient(functionCallContent("list_files", map[string]any{"path": "."})),
+		cancel:       cancel,
+		n:            1,
+	}
+	agent := newTestAgent(t, client, nil)
+	earlier := []*genai.Content{
+		genai.NewContentFromText("hello", genai.RoleUser),
+		genai.NewContentFromText("hi", genai.RoleModel),
+	}
+	agent.history = append(agent.history, earlier...)
+
+	if _, err := agent.runTurn(ctx, "list the files"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("runTurn error = %v, want context.Canceled", err)
+	}
+	if len(agent.history) != len(earlier) || agent.history[0] != earlier[0] || agent.history[1] != earlier[1] {
+		t.Errorf("history after cancel = %d entries, want the %d earlier ones", len(agent.history), len(earlier))
+	}
+}
+
+// scriptedInput returns a getUserMessage that types lines one at a time and
+// then ends the session.
+func scriptedInput(lines ...string) func() (string, bool) {
+	return func() (string, bool) {
+		if len(lines) == 0 {
+			return "", false
+		}
+		line := lines[0]
+		lines = lines[1:]
+		return line, true
+	}
+}
+
+// toolResponses returns the function responses in history, in order.
+func toolResponses(history []*genai.Content) []*genai.FunctionResponse {
+	var responses []*genai.FunctionResponse
+	for _, content := range history {
+		for _, part := range content.Parts {
+			if part.FunctionResponse != nil {
+				responses = append(responses, part.FunctionResponse)
+			}
+		}
+	}
+	return responses
+}
+
+func TestRunToolLoop(t *testing.T) {
+	client := NewScriptedClient(
+		functionCallContent("read_file", map[string]any{"path": "notes.txt"}),
+		functionCallContent("write_file", map[string]any{"path": "copy.txt", "content": "hello\nworld\n"}),
+		genai.NewContentFromText("Copied the notes.", genai.RoleModel),
+	)
+	var out strings.Builder
+	agent := newTestAgentWithOutput(t, client, &out, nil)
+	writeTree(t, agent.sandbox.Root, map[string]string{"notes.txt": "hello\n"})
+	agent.getUserMessage = scriptedInput("copy notes.txt to copy.txt and add a line")
+	agent.confirm = ApproveAll
+
+	if err := agent.Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(agent.sandbox.Root, "copy.txt"))
+	if err != nil || string(data) != "hello\nworld\n" {
+		t.Errorf("copy.txt = %q, %v; want %q", data, err, "hello\nworld\n")
+	}
+	if !strings.Contains(out.String(), "Copied the notes.") {
+		t.Errorf("output is missing the final answer:\n%s", out.String())
+	}
+	if client.next != len(client.responses) {
+		t.Errorf("model was asked %d times, want %d", client.next, len(client.responses))
+	}
+
+	// user, read call, read result, write call, write result, answer
+	if len(agent.history) != 6 {
+		t.Fatalf("history has %d entries, want 6", len(agent.history))
+	}
+	responses := toolResponses(agent.history)
+	if len(responses) != 2 || responses[0].Name != "read_file" || responses[1].Name != "write_file" {
+		t.Fatalf("tool responses = %+v, want read_file then write_file", responses)
+	}
+	for _, response := range responses {
+		if response.Response["ok"] != true {
+			t.Errorf("%s failed: %v", response.Name, response.Response)
+		}
+	}
+	if content := fmt.Sprint(responses[0].Response); !strings.Contains(content, "hello") {
+		t.Errorf("read_file response is missing the file content: %s", content)
+	}
+	if last := agent.history[len(agent.history)-1]; last.Role != genai.RoleModel || contentText(last) != "Copied the notes." {
+		t.Errorf("last history entry = %+v, want the model's answer", last)
+	}
+}
+
+func TestRunToolErrorRecovery(t *testing.T) {
+	client := NewScriptedClient(
+		functionCallContent("read_file", map[string]any{"path": "note.txt"}),
+		functionCallContent("list_files", map[string]any{"path": "."}),
+		functionCallContent("read_file", map[string]any{"path": "notes.txt"}),
+		genai.NewContentFromText("The notes say hello.", genai.RoleModel),
+	)
+	var out strings.Builder
+	agent := newTestAgentWithOutput(t, client, &out, nil)
+	writeTree(t, agent.sandbox.Root, map[string]string{"notes.txt": "hello\n"})
+	agent.getUserMessage = scriptedInput("what do my notes say?", "thanks")
+
+	// The second message finds the script exhausted, as a model that stopped
+	// answering would, and ends the session with that error
+	err := agent.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "exhausted") {
+		t.Fatalf("Run error = %v, want the exhausted script", err)
+	}
+	if !strings.Contains(out.String(), "The notes say hello.") {
+		t.Errorf("output is missing the answer after recovering:\n%s", out.String())
+	}
+
+	responses := toolResponses(agent.history)
+	if len(responses) != 3 {
+		t.Fatalf("got %d tool responses, want 3", len(responses))
+	}
+	failed := responses[0].Response
+	if failed["ok"] != false {
+		t.Fatalf("reading a missing file succeeded: %v", failed)
+	}
+	if code := failed["error"].(map[string]any)["code"]; code != "not_found" {
+		t.Errorf("error code = %v, want not_found", code)
+	}
+	if suggestions := fmt.Sprint(failed["error"]); !strings.Contains(suggestions, "notes.txt") {
+		t.Errorf("error does not suggest the file that exists: %s", suggestions)
+	}
+	for _, response := range responses[1:] {
+		if response.Response["ok"] != true {
+			t.Errorf("%s failed after recovering: %v", response.Name, response.Response)
+		}
+	}
+}
+
+func TestExecuteToolCallsConcurrently(t *testing.T) {
+	agent := newTestAgent(t, NewScriptedClient(), nil)
+	files := map[string]string{}
+	for i := range 5 {
+		files[fmt.Sprintf("file%d.txt", i)] = fmt.Sprintf("content %d", i)
+	}
+	writeTree(t, agent.sandbox.Root, files)
+
+	// slow_read waits until every read_file after it has finished, so it
+	// only succeeds if they are not queued behind it
+	var fastDone sync.WaitGroup
+	readFileTool, _ := agent.registry.Lookup("read_file")
+	agent.registry.Register(&FuncTool{
+		Decl: &genai.FunctionDeclaration{Name: "read_file", Parameters: readFileTool.Declaration().Parameters},
+		Run: func(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+			defer fastDone.Done()
+			return readFileTool.Execute(ctx, args, tc)
+		},
+		ReadOnly: true,
+	})
+	agent.registry.Register(&FuncTool{
+		Decl: &genai.FunctionDeclaration{Name: "slow_read"},
+		Run: func(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+			done := make(chan struct{})
+			go func() { fastDone.Wait(); close(done) }()
+			select {
+			case <-done:
+				return NewSuccessResult(map[string]any{"waited": true})
+			case <-time.After(5 * time.Second):
+				return NewErrorResult("timeout", "the other reads never finished", nil)
+			}
+		},
+		ReadOnly: true,
+	})
+
+	calls := []*genai.FunctionCall{{Name: "slow_read", Args: map[string]any{}}}
+	for i := range 5 {
+		calls = append(calls, &genai.FunctionCall{Name: "read_file", Args: map[string]any{"path": fmt.Sprintf("file%d.txt", i)}})
+	}
+	fastDone.Add(5)
+	parts := agent.executeToolCalls(context.Background(), calls, newRepeatTracker(0))
+
+	if len(parts) != len(calls) {
+		t.Fatalf("got %d responses for %d calls", len(parts), len(calls))
+	}
+	if slow := parts[0].FunctionResponse; slow.Name != "slow_read" || slow.Response["ok"] != true {
+		t.Errorf("slow_read = %v, want it to finish after the reads it did not block", slow.Response)
+	}
+	for i, part := range parts[1:] {
+		response := part.FunctionResponse
+		if response.Name != "read_file" || response.Response["ok"] != true {
+			t.Errorf("response %d = %s %v, want a successful read_file", i+1, response.Name, response.Response)
+			continue
+		}
+		if want := fmt.Sprintf("content %d", i); !strings.Contains(fmt.Sprint(response.Response), want) {
+			t.Errorf("response %d = %v, want the content of file%d.txt", i+1, response.Response, i)
+		}
+	}
+}
+
+func TestExecuteToolCallsOrdersWrites(t *testing.T) {
+	agent := newTestAgent(t, NewScriptedClient(), nil)
+	agent.confirm = ApproveAll
+	writeTree(t, agent.sandbox.Root, map[string]string{"shared.txt": "original"})
+
+	calls := []*genai.FunctionCall{
+		{Name: "read_file", Args: map[string]any{"path": "shared.txt"}},
+		{Name: "write_file", Args: map[string]any{"path": "shared.txt", "content": "first write"}},
+		{Name: "read_file", Args: map[string]any{"path": "shared.txt"}},
+		{Name: "write_file", Args: map[string]any{"path": "shared.txt", "content": "second write"}},
+		{Name: "read_file", Args: map[string]any{"path": "shared.txt"}},
+	}
+	// Run it several times, since a race would only show up some of the time
+	for range 20 {
+		parts := agent.executeToolCalls(context.Background(), calls, newRepeatTracker(0))
+		for i, want := range []string{"", "", "first write", "", "second write"} {
+			response := parts[i].FunctionResponse
+			if response.Name != calls[i].Name || response.Response["ok"] != true {
+				t.Fatalf("response %d = %s %v, want a successful %s", i, response.Name, response.Response, calls[i].Name)
+			}
+			if want != "" && !strings.Contains(fmt.Sprint(response.Response), want) {
+				t.Fatalf("read %d = %v, want it to see %q", i, response.Response, want)
+			}
+		}
+		data, err := os.ReadFile(filepath.Join(agent.sandbox.Root, "shared.txt"))
+		if err != nil || string(data) != "second write" {
+			t.Fatalf("shared.txt = %q, %v; want the last write", data, err)
+		}
+		agent.sandbox.ClearCache()
+	}
+}
+
+// recordingClient remembers the config of every Stream request.
+type recordingClient struct {
+	*ReplayClient
+	configs []*genai.GenerateContentConfig
+}
+
+func (c *recordingClient) Stream(ctx context.Context, model string, history []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	c.configs = append(c.configs, config)
+	return c.ReplayClient.Stream(ctx, model, history, config)
+}
+
+func TestSystemPromptOnEveryRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		prompt string
+		want   string // "" means no instruction is sent
+	}{
+		{"set", "You are editing a Go project.", "You are editing a Go project."},
+		{"unset", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &recordingClient{ReplayClient: NewScriptedClient(
+				functionCallContent("list_files", map[string]any{"path": "."}),
+				genai.NewContentFromText("first", genai.RoleModel),
+				genai.NewContentFromText("second", genai.RoleModel),
+			)}
+			agent := newTestAgent(t, client, func(cfg *Config) { cfg.SystemPrompt = tt.prompt })
+			for _, input := range []string{"one", "two"} {
+				if _, err := agent.runTurn(context.Background(), input); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(client.configs) != 3 {
+				t.Fatalf("got %d requests, want 3", len(client.configs))
+			}
+			for i, config := range client.configs {
+				got := ""
+				if config.SystemInstruction != nil {
+					got = contentText(config.SystemInstruction)
+				}
+				if got != tt.want {
+					t.Errorf("request %d system instruction = %q, want %q", i, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTurnTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		toolDelay    time.Duration
+		wantTimedOut bool
+	}{
+		{"slow tool", 50 * time.Millisecond, time.Minute, true},
+		{"fast tool", 5 * time.Second, 0, false},
+		{"disabled", 0, 10 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewScriptedClient(
+				functionCallContent("slow_tool", map[string]any{}),
+				genai.NewContentFromText("Done.", genai.RoleModel),
+				genai.NewContentFromText("Next answer.", genai.RoleModel),
+			)
+			var out strings.Builder
+			agent := newTestAgentWithOutput(t, client, &out, func(cfg *Config) { cfg.TurnTimeout = tt.timeout })
+			agent.registry.Register(&FuncTool{
+				Decl: &genai.FunctionDeclaration{Name: "slow_tool"},
+				Run: func(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+					select {
+					case <-time.After(tt.toolDelay):
+						return NewSuccessResult(map[string]any{"finished": true})
+					case <-ctx.Done():
+						return NewErrorResult("timeout", ctx.Err().Error(), nil)
+					}
+				},
+				ReadOnly: true,
+			})
+
+			start := time.Now()
+			answer, err := agent.runTurn(context.Background(), "go")
+			if err != nil {
+				t.Fatalf("runTurn: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Fatalf("runTurn took %v; the tool did not stop with the turn", elapsed)
+			}
+			if timedOut := strings.Contains(answer, "timed out"); timedOut != tt.wantTimedOut {
+				t.Errorf("answer = %q, want timed out %v", answer, tt.wantTimedOut)
+			}
+			if tt.wantTimedOut {
+				if !strings.Contains(out.String(), "The turn timed out") {
+					t.Errorf("output does not tell the user the turn timed out:\n%s", out.String())
+				}
+				last := agent.history[len(agent.history)-1]
+				if last.Role != genai.RoleModel || !strings.Contains(contentText(last), "timed out") {
+					t.Errorf("history ends with %s %q, want the model's timeout note", last.Role, contentText(last))
+				}
+			}
+			checkWellFormed(t, agent.history)
+
+			// The next turn starts with a fresh deadline
+			if answer, err := agent.runTurn(context.Background(), "again"); err != nil || answer == "" {
+				t.Errorf("next turn = %q, %v; want an answer", answer, err)
+			}
+		})
+	}
+}
+
+func TestRunCommandHonorsContext(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("no sleep command")
+	}
+	tc := newTestToolContext(t, nil)
+	tc.AllowedCommands = []string{"sleep"}
+	tc.CommandTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result := runCommand(ctx, map[string]any{"command": "sleep", "args": []any{"30"}}, tc)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("run_command took %v after its context ended", elapsed)
+	}
+	if result.OK || result.Error.Code != "timeout" || !strings.Contains(result.Error.Message, "turn ended") {
+		t.Errorf("run_command = %s, want a timeout for the ended turn", resultJSON(t, result))
+	}
+}
+
+// loopingClient is a model that calls a tool on every request. Unless
+// stubborn, it answers once tool calling is turned off, as a real model must.
+type loopingClient struct {
+	*ReplayClient
+	stubborn bool
+	requests int
+	sameArgs bool // Repeat one call instead of varying its arguments
+}
+
+func (c *loopingClient) Stream(ctx context.Context, model string, history []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	c.requests++
+	content := functionCallContent("ping", map[string]any{"n": c.requests})
+	if c.sameArgs {
+		content = functionCallContent("ping", map[string]any{"n": 0})
+	}
+	if tc := config.ToolConfig; !c.stubborn && tc != nil && tc.FunctionCallingConfig.Mode == genai.FunctionCallingConfigModeNone {
+		content = genai.NewContentFromText("Final answer.", genai.RoleModel)
+	}
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		yield(&genai.GenerateContentResponse{Candidates: []*genai.Candidate{{Content: content}}}, nil)
+	}
+}
+
+// registerPing adds a read-only ping tool to agent and returns how many
+// times it has run.
+func registerPing(agent *Agent) *atomic.Int32 {
+	var runs atomic.Int32
+	agent.registry.Register(&FuncTool{
+		Decl: &genai.FunctionDeclaration{Name: "ping"},
+		Run: func(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+			runs.Add(1)
+			return NewSuccessResult(map[string]any{"pong": true})
+		},
+		ReadOnly: true,
+	})
+	return &runs
+}
+
+func TestToolRoundLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		limit        int
+		stubborn     bool
+		wantAnswer   string
+		wantRuns     int32
+		wantRequests int
+	}{
+		{"one round", 1, false, "Final answer.", 1, 3},
+		{"default limit", defaultMaxToolRounds, false, "Final answer.", defaultMaxToolRounds, defaultMaxToolRounds + 2},
+		{"model keeps calling anyway", 3, true, "", 3, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &loopingClient{ReplayClient: NewScriptedClient(), stubborn: tt.stubborn}
+			var out strings.Builder
+			agent := newTestAgentWithOutput(t, client, &out, func(cfg *Config) { cfg.MaxToolRounds = tt.limit })
+			runs := registerPing(agent)
+
+			answer, err := agent.runTurn(context.Background(), "go")
+			if err != nil {
+				t.Fatalf("runTurn: %v", err)
+			}
+			if answer != tt.wantAnswer {
+				t.Errorf("answer = %q, want %q", answer, tt.wantAnswer)
+			}
+			if n := runs.Load(); n != tt.wantRuns {
+				t.Errorf("ping ran %d times, want %d", n, tt.wantRuns)
+			}
+			if client.requests != tt.wantRequests {
+				t.Errorf("sent %d requests, want %d", client.requests, tt.wantRequests)
+			}
+			if !strings.Contains(out.String(), "Tool call limit") {
+				t.Errorf("output does not mention the limit:\n%s", out.String())
+			}
+			// The refused calls are answered with too_many_calls
+			var refused int
+			for _, resp := range toolResponses(agent.history) {
+				if errMap, ok := resp.Response["error"].(map[string]any); ok && errMap["code"] == "too_many_calls" {
+					refused++
+				}
+			}
+			if refused != 1 {
+				t.Errorf("%d calls refused with too_many_calls, want 1", refused)
+			}
+			checkWellFormed(t, agent.history)
+		})
+	}
+}
+
+func TestRepeatedCallsShortCircuit(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       int
+		wantRuns    int32
+		wantRepeats int
+	}{
+		{"default limit", defaultMaxRepeatCalls, defaultMaxRepeatCalls, 6 - defaultMaxRepeatCalls},
+		{"limit of one", 1, 1, 5},
+		{"disabled", 0, 6, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &loopingClient{ReplayClient: NewScriptedClient(), sameArgs: true}
+			agent := newTestAgent(t, client, func(cfg *Config) {
+				cfg.MaxToolRounds = 6
+				cfg.MaxRepeatCalls = tt.limit
+			})
+			runs := registerPing(agent)
+
+			if _, err := agent.runTurn(context.Background(), "go"); err != nil {
+				t.Fatalf("runTurn: %v", err)
+			}
+			if n := runs.Load(); n != tt.wantRuns {
+				t.Errorf("ping ran %d times, want %d", n, tt.wantRuns)
+			}
+			var repeats int
+			for _, resp := range toolResponses(agent.history) {
+				if errMap, ok := resp.Response["error"].(map[string]any); ok && errMap["code"] == "repeated_call" {
+					repeats++
+				}
+			}
+			if repeats != tt.wantRepeats {
+				t.Errorf("%d calls short-circuited, want %d", repeats, tt.wantRepeats)
+			}
+		})
+	}
+}
+
+func TestRepeatTracker(t *testing.T) {
+	call := func(name string, args map[string]any) *genai.FunctionCall {
+		return &genai.FunctionCall{Name: name, Args: args}
+	}
+	a := call("read_file", map[string]any{"path": "a", "start_line": 1})
+	aReordered := call("read_file", map[string]any{"start_line": 1, "path": "a"})
+	b := call("read_file", map[string]any{"path": "b"})
+	tests := []struct {
+		name  string
+		limit int
+		calls []*genai.FunctionCall
+		want  []bool
+	}{
+		{"under the limit", 2, []*genai.FunctionCall{a, a}, []bool{false, false}},
+		{"over the limit", 2, []*genai.FunctionCall{a, a, a, a}, []bool{false, false, true, true}},
+		{"argument order does not matter", 2, []*genai.FunctionCall{a, aReordered, a}, []bool{false, false, true}},
+		{"a different call resets the count", 2, []*genai.FunctionCall{a, a, b, a, a}, []bool{false, false, false, false, false}},
+		{"same arguments, other tool", 1, []*genai.FunctionCall{a, call("stat_file", a.Args)}, []bool{false, false}},
+		{"disabled", 0, []*genai.FunctionCall{a, a, a}, []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		tracker := newRepeatTracker(tt.limit)
+		for i, c := range tt.calls {
+			if got := tracker.observe(c); got != tt.want[i] {
+				t.Errorf("%s: call %d observed = %v, want %v", tt.name, i, got, tt.want[i])
+			}
+		}
+	}
+}
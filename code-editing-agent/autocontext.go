@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	autoContextExtensions  = 6       // Extensions listed, most lines first
+	autoContextEntries     = 40      // Top-level entries listed
+	autoContextReadmeLines = 5       // README lines quoted
+	maxAutoContextBytes    = 2 << 10 // Upper bound on the whole summary
+)
+
+// autoContext summarizes the project from project_overview for --auto-context,
+// so the model knows what it is working in before the first message. It is
+// kept to a few lines to cost few tokens on every request. It returns "" when
+// the overview fails.
+func autoContext(ctx context.Context, tc *ToolContext) string {
+	result := projectOverview(ctx, nil, tc)
+	if !result.OK {
+		return ""
+	}
+	data := result.Data
+
+	var b strings.Builder
+	b.WriteString("Project context, gathered automatically when the session started:\n")
+	fmt.Fprintf(&b, "- Root directory: %s\n", filepath.Base(tc.Sandbox.Root))
+
+	if found, _ := data["build_files"].([]map[string]any); len(found) > 0 {
+		names := make([]string, len(found))
+		for i, f := range found {
+			names[i] = fmt.Sprintf("%s (%s)", f["file"], f["ecosystem"])
+		}
+		fmt.Fprintf(&b, "- Build files: %s\n", strings.Join(names, ", "))
+	}
+	if language, ok := data["primary_language"].(string); ok {
+		fmt.Fprintf(&b, "- Primary language: %s\n", language)
+	}
+	if counts, _ := data["extensions"].([]map[string]any); len(counts) > 0 {
+		var parts []string
+		for _, c := range counts[:min(len(counts), autoContextExtensions)] {
+			parts = append(parts, fmt.Sprintf("%s %d files/%d lines", c["extension"], c["files"], c["lines"]))
+		}
+		fmt.Fprintf(&b, "- Files by extension: %s\n", strings.Join(parts, ", "))
+	}
+	if tree, _ := data["tree"].([]map[string]any); len(tree) > 0 {
+		var names []string
+		for _, node := range tree[:min(len(tree), autoContextEntries)] {
+			names = append(names, node["name"].(string))
+		}
+		if len(tree) > autoContextEntries {
+			names = append(names, fmt.Sprintf("and %d more", len(tree)-autoContextEntries))
+		}
+		fmt.Fprintf(&b, "- Top-level entries: %s\n", strings.Join(names, ", "))
+	}
+	if readme, ok := data["readme"].(map[string]any); ok {
+		lines := strings.Split(readme["start"].(string), "\n")
+		fmt.Fprintf(&b, "- %s begins:\n", readme["file"])
+		for _, line := range lines[:min(len(lines), autoContextReadmeLines)] {
+			fmt.Fprintf(&b, "    %s\n", line)
+		}
+	}
+	b.WriteString("Use project_overview, tree, or read_file for more detail.")
+
+	summary := b.String()
+	if len(summary) > maxAutoContextBytes {
+		cut := maxAutoContextBytes
+		for cut > 0 && !utf8.RuneStart(summary[cut]) {
+			cut--
+		}
+		summary = summary[:cut] + "\n..."
+	}
+	return summary
+}
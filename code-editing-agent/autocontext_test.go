@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+func TestAutoContext(t *testing.T) {
+	many := map[string]string{}
+	for i := range autoContextEntries + 3 {
+		many[fmt.Sprintf("f%02d.txt", i)] = "x\n"
+	}
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []string // Lines the summary contains
+		wantNot []string // Substrings it must not contain
+	}{
+		{name: "go module",
+			files: map[string]string{
+				"go.mod":    "module demo\n",
+				"main.go":   "package main\n\nfunc main() {}\n",
+				"README.md": "# Demo\none\ntwo\nthree\nfour\nfive\nsix\n",
+			},
+			want: []string{
+				"- Build files: go.mod (Go)",
+				"- Primary language: Go",
+				"- Files by extension: .md 1 files/7 lines, .go 1 files/3 lines, .mod 1 files/1 lines",
+				"- Top-level entries: README.md, go.mod, main.go",
+				"- README.md begins:",
+				"    # Demo",
+				"    four",
+			},
+			wantNot: []string{"five"}},
+		{name: "no build files or readme",
+			files:   map[string]string{"notes.txt": "hi\n"},
+			want:    []string{"- Top-level entries: notes.txt"},
+			wantNot: []string{"Build files", "Primary language", "begins:"}},
+		{name: "many top-level entries",
+			files: many,
+			want:  []string{"f39.txt, and 3 more"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, tt.files)
+			summary := autoContext(context.Background(), tc)
+			lines := strings.Split(summary, "\n")
+			if !strings.HasPrefix(summary, "Project context") {
+				t.Errorf("summary starts %q", lines[0])
+			}
+			if !strings.HasSuffix(summary, "Use project_overview, tree, or read_file for more detail.") {
+				t.Errorf("summary ends %q", lines[len(lines)-1])
+			}
+			if root := "- Root directory: " + filepath.Base(tc.Sandbox.Root); lines[1] != root {
+				t.Errorf("line 1 = %q, want %q", lines[1], root)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(summary, want) {
+					t.Errorf("summary lacks %q:\n%s", want, summary)
+				}
+			}
+			for _, unwanted := range tt.wantNot {
+				if strings.Contains(summary, unwanted) {
+					t.Errorf("summary contains %q:\n%s", unwanted, summary)
+				}
+			}
+		})
+	}
+}
+
+func TestAutoContextSize(t *testing.T) {
+	// Long multibyte names push the summary over its limit; the cut must
+	// land on a rune boundary.
+	files := map[string]string{}
+	for i := range autoContextEntries {
+		files[fmt.Sprintf("%02d-%s.txt", i, strings.Repeat("é", 40))] = "x\n"
+	}
+	summary := autoContext(context.Background(), newTestToolContext(t, files))
+	if len(summary) > maxAutoContextBytes+len("\n...") {
+		t.Errorf("summary is %d bytes, limit %d", len(summary), maxAutoContextBytes)
+	}
+	if !strings.HasSuffix(summary, "\n...") {
+		t.Errorf("truncated summary ends %q", summary[len(summary)-20:])
+	}
+	if !utf8.ValidString(summary) {
+		t.Error("truncation split a rune")
+	}
+}
+
+func TestAutoContextSeedsFirstRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		autoContext bool
+		prompt      string
+		wantPrefix  string // "" means no instruction is sent
+		wantSummary bool
+	}{
+		{"enabled", true, "", "Project context", true},
+		{"after the system prompt", true, "Be brief.", "Be brief.\n\nProject context", true},
+		{"disabled", false, "", "", false},
+		{"disabled keeps the prompt", false, "Be brief.", "Be brief.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &recordingClient{ReplayClient: NewScriptedClient(
+				genai.NewContentFromText("ok", genai.RoleModel),
+			)}
+			agent := newTestAgent(t, client, func(cfg *Config) {
+				cfg.AutoContext = tt.autoContext
+				cfg.SystemPrompt = tt.prompt
+				if err := os.WriteFile(filepath.Join(cfg.Root, "go.mod"), []byte("module demo\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if _, err := agent.runTurn(context.Background(), "hello"); err != nil {
+				t.Fatal(err)
+			}
+			if len(client.configs) != 1 {
+				t.Fatalf("got %d requests, want 1", len(client.configs))
+			}
+			got := ""
+			if instruction := client.configs[0].SystemInstruction; instruction != nil {
+				got = contentText(instruction)
+			}
+			if !strings.HasPrefix(got, tt.wantPrefix) || (tt.wantPrefix == "") != (got == "") {
+				t.Errorf("system instruction = %q, want prefix %q", got, tt.wantPrefix)
+			}
+			if has := strings.Contains(got, "- Build files: go.mod (Go)"); has != tt.wantSummary {
+				t.Errorf("summary present = %t, want %t:\n%s", has, tt.wantSummary, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// fsCache memoizes symlink evaluations and directory listings so a turn that
+// explores the same directories repeatedly hits the disk once. It must be
+// cleared whenever the filesystem may have changed.
+type fsCache struct {
+	mu    sync.Mutex
+	evals map[string]cachedEval
+	dirs  map[string]cachedDir
+}
+
+type cachedEval struct {
+	real string
+	err  error
+}
+
+type cachedDir struct {
+	entries []os.DirEntry
+	err     error
+}
+
+// evalSymlinks is FS.EvalSymlinks, served from the cache when possible.
+func (s *PathSandbox) evalSymlinks(path string) (string, error) {
+	c := &s.cache
+	c.mu.Lock()
+	if hit, ok := c.evals[path]; ok {
+		c.mu.Unlock()
+		return hit.real, hit.err
+	}
+	c.mu.Unlock()
+
+	real, err := s.FS.EvalSymlinks(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.evals == nil {
+		c.evals = make(map[string]cachedEval)
+	}
+	c.evals[path] = cachedEval{real, err}
+	return real, err
+}
+
+// ReadDir is FS.ReadDir, served from the cache when possible. Callers must
+// not modify the returned slice.
+func (s *PathSandbox) ReadDir(dir string) ([]os.DirEntry, error) {
+	c := &s.cache
+	c.mu.Lock()
+	if hit, ok := c.dirs[dir]; ok {
+		c.mu.Unlock()
+		return hit.entries, hit.err
+	}
+	c.mu.Unlock()
+
+	entries, err := s.FS.ReadDir(dir)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dirs == nil {
+		c.dirs = make(map[string]cachedDir)
+	}
+	c.dirs[dir] = cachedDir{entries, err}
+	return entries, err
+}
+
+// ClearCache forgets every cached lookup. The agent calls it at the start of
+// each turn and after any tool that may have changed the filesystem.
+func (s *PathSandbox) ClearCache() {
+	c := &s.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evals = nil
+	c.dirs = nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// countingFS is a FileSystem that counts directory listings and symlink
+// evaluations by path.
+type countingFS struct {
+	FileSystem
+	mu    sync.Mutex
+	reads map[string]int
+	evals map[string]int
+}
+
+func newCountingFS(fsys FileSystem) *countingFS {
+	return &countingFS{FileSystem: fsys, reads: make(map[string]int), evals: make(map[string]int)}
+}
+
+func (c *countingFS) ReadDir(name string) ([]os.DirEntry, error) {
+	c.mu.Lock()
+	c.reads[name]++
+	c.mu.Unlock()
+	return c.FileSystem.ReadDir(name)
+}
+
+func (c *countingFS) EvalSymlinks(name string) (string, error) {
+	c.mu.Lock()
+	c.evals[name]++
+	c.mu.Unlock()
+	return c.FileSystem.EvalSymlinks(name)
+}
+
+func (c *countingFS) counts(name string) (reads, evals int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reads[name], c.evals[name]
+}
+
+func TestSandboxCache(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"src/main.go": "", "src/util.go": ""})
+	fsys := newCountingFS(osFileSystem{})
+	sandbox, err := NewPathSandbox(root, WithFileSystem(fsys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(sandbox.Root, "src")
+	main := filepath.Join(src, "main.go")
+
+	steps := []struct {
+		name      string
+		do        func()
+		wantReads int // Cumulative listings of src
+		wantEvals int // Cumulative evaluations of src/main.go
+	}{
+		{"first listing", func() { sandbox.ReadDir(src) }, 1, 0},
+		{"cached listing", func() { sandbox.ReadDir(src) }, 1, 0},
+		{"first resolve", func() { sandbox.Resolve("src/main.go", AccessReadFile) }, 1, 1},
+		{"cached resolve", func() { sandbox.Resolve("src/main.go", AccessReadFile) }, 1, 1},
+		{"cleared", sandbox.ClearCache, 1, 1},
+		{"listing after clearing", func() { sandbox.ReadDir(src) }, 2, 1},
+		{"resolve after clearing", func() { sandbox.Resolve("src/main.go", AccessReadFile) }, 2, 2},
+	}
+	for _, step := range steps {
+		step.do()
+		reads, _ := fsys.counts(src)
+		_, evals := fsys.counts(main)
+		if reads != step.wantReads || evals != step.wantEvals {
+			t.Errorf("after %s: %d listings, %d evaluations; want %d, %d", step.name, reads, evals, step.wantReads, step.wantEvals)
+		}
+	}
+}
+
+func TestSandboxCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		call        *genai.FunctionCall
+		wantCleared bool
+	}{
+		{"read-only tool keeps the cache", &genai.FunctionCall{Name: "list_files", Args: map[string]any{"path": "src"}}, false},
+		{"write clears it", &genai.FunctionCall{Name: "write_file", Args: map[string]any{"path": "src/new.go", "content": "x"}}, true},
+		{"delete clears it", &genai.FunctionCall{Name: "delete_file", Args: map[string]any{"path": "src/main.go"}}, true},
+		{"move clears it", &genai.FunctionCall{Name: "move_file", Args: map[string]any{"source": "src/main.go", "destination": "main.go"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := newTestAgent(t, NewScriptedClient(genai.NewContentFromText("Done.", genai.RoleModel)), nil)
+			agent.confirm = ApproveAll
+			writeTree(t, agent.sandbox.Root, map[string]string{"src/main.go": ""})
+			fsys := newCountingFS(agent.sandbox.FS)
+			agent.sandbox.FS = fsys
+			src := filepath.Join(agent.sandbox.Root, "src")
+
+			agent.sandbox.ReadDir(src)
+			agent.runTool(context.Background(), tt.call)
+			before, _ := fsys.counts(src)
+			agent.sandbox.ReadDir(src)
+			after, _ := fsys.counts(src)
+			if cleared := after > before; cleared != tt.wantCleared {
+				t.Errorf("cache cleared = %v, want %v", cleared, tt.wantCleared)
+			}
+
+			// A new turn always starts with an empty cache
+			if _, err := agent.runTurn(context.Background(), "hi"); err != nil {
+				t.Fatal(err)
+			}
+			agent.sandbox.ReadDir(src)
+			if again, _ := fsys.counts(src); again != after+1 {
+				t.Error("cache survived into the next turn")
+			}
+		})
+	}
+}
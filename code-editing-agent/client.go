@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// Backends accepted by --backend.
+const (
+	backendGemini = "gemini"
+	backendVertex = "vertex"
+)
+
+// resolveClientConfig builds the client configuration for the chosen backend
+// and checks that its credentials are present. Each setting comes from its
+// flag, then the environment variable the genai SDK reads:
+//
+//   - backend: --backend, then $GOOGLE_GENAI_USE_VERTEXAI ("1" or "true"
+//     selects vertex), then gemini
+//   - gemini: --api-key, then $GOOGLE_API_KEY, then $GEMINI_API_KEY
+//   - vertex: --project, then $GOOGLE_CLOUD_PROJECT; --location, then
+//     $GOOGLE_CLOUD_LOCATION, then $GOOGLE_CLOUD_REGION. Requests are
+//     authenticated with application default credentials.
+func resolveClientConfig(backend, apiKey, project, location string) (*genai.ClientConfig, error) {
+	if backend == "" {
+		switch strings.ToLower(os.Getenv("GOOGLE_GENAI_USE_VERTEXAI")) {
+		case "1", "true":
+			backend = backendVertex
+		default:
+			backend = backendGemini
+		}
+	}
+
+	switch backend {
+	case backendGemini:
+		apiKey = firstNonEmpty(apiKey, os.Getenv("GOOGLE_API_KEY"), os.Getenv("GEMINI_API_KEY"))
+		if apiKey == "" {
+			return nil, fmt.Errorf("the gemini backend needs an API key: set GEMINI_API_KEY (or GOOGLE_API_KEY) or pass --api-key")
+		}
+		return &genai.ClientConfig{Backend: genai.BackendGeminiAPI, APIKey: apiKey}, nil
+
+	case backendVertex:
+		if apiKey != "" {
+			return nil, fmt.Errorf("--api-key applies only to the gemini backend; vertex uses application default credentials")
+		}
+		project = firstNonEmpty(project, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+		location = firstNonEmpty(location, os.Getenv("GOOGLE_CLOUD_LOCATION"), os.Getenv("GOOGLE_CLOUD_REGION"))
+		var missing []string
+		if project == "" {
+			missing = append(missing, "a project (--project or GOOGLE_CLOUD_PROJECT)")
+		}
+		if location == "" {
+			missing = append(missing, "a location (--location or GOOGLE_CLOUD_LOCATION)")
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("the vertex backend needs %s", strings.Join(missing, " and "))
+		}
+		return &genai.ClientConfig{Backend: genai.BackendVertexAI, Project: project, Location: location}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown backend %q (want %s or %s)", backend, backendGemini, backendVertex)
+	}
+}
+
+// clientConfigAttrs describes config for logging. The API key is never
+// included, only whether one is set.
+func clientConfigAttrs(config *genai.ClientConfig) []any {
+	return []any{
+		"backend", config.Backend.String(),
+		"project", config.Project,
+		"location", config.Location,
+		"api_key", redactSecret(config.APIKey),
+	}
+}
+
+// redactSecret stands in for a secret in output.
+func redactSecret(secret string) string {
+	if secret == "" {
+		return "(unset)"
+	}
+	return "[REDACTED]"
+}
+
+// firstNonEmpty returns the first non-empty value.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
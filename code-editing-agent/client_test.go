@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestResolveClientConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		backend  string
+		apiKey   string
+		project  string
+		location string
+		want     *genai.ClientConfig
+		wantErr  string // Substring of the error; "" for success
+	}{
+		{name: "flag key", apiKey: "flag-key",
+			want: &genai.ClientConfig{Backend: genai.BackendGeminiAPI, APIKey: "flag-key"}},
+		{name: "flag wins over env", env: map[string]string{"GOOGLE_API_KEY": "google-key", "GEMINI_API_KEY": "gemini-key"}, apiKey: "flag-key",
+			want: &genai.ClientConfig{Backend: genai.BackendGeminiAPI, APIKey: "flag-key"}},
+		{name: "google env before gemini env", env: map[string]string{"GOOGLE_API_KEY": "google-key", "GEMINI_API_KEY": "gemini-key"},
+			want: &genai.ClientConfig{Backend: genai.BackendGeminiAPI, APIKey: "google-key"}},
+		{name: "gemini env", env: map[string]string{"GEMINI_API_KEY": "gemini-key"},
+			want: &genai.ClientConfig{Backend: genai.BackendGeminiAPI, APIKey: "gemini-key"}},
+		{name: "no key", wantErr: "needs an API key"},
+		{name: "vertex", backend: backendVertex, project: "proj", env: map[string]string{"GOOGLE_CLOUD_REGION": "us-east1"},
+			want: &genai.ClientConfig{Backend: genai.BackendVertexAI, Project: "proj", Location: "us-east1"}},
+		{name: "vertex from env", env: map[string]string{"GOOGLE_GENAI_USE_VERTEXAI": "true", "GOOGLE_CLOUD_PROJECT": "proj", "GOOGLE_CLOUD_LOCATION": "europe-west4"},
+			want: &genai.ClientConfig{Backend: genai.BackendVertexAI, Project: "proj", Location: "europe-west4"}},
+		{name: "vertex rejects a key", backend: backendVertex, apiKey: "flag-key", project: "proj", location: "us-east1",
+			wantErr: "--api-key applies only to the gemini backend"},
+		{name: "vertex missing settings", backend: backendVertex, wantErr: "needs a project (--project or GOOGLE_CLOUD_PROJECT) and a location"},
+		{name: "unknown backend", backend: "openai", wantErr: `unknown backend "openai"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"GOOGLE_GENAI_USE_VERTEXAI", "GOOGLE_API_KEY", "GEMINI_API_KEY", "GOOGLE_CLOUD_PROJECT", "GOOGLE_CLOUD_LOCATION", "GOOGLE_CLOUD_REGION"} {
+				t.Setenv(name, tt.env[name])
+			}
+			got, err := resolveClientConfig(tt.backend, tt.apiKey, tt.project, tt.location)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("resolveClientConfig error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveClientConfig: %v", err)
+			}
+			if got.Backend != tt.want.Backend || got.APIKey != tt.want.APIKey || got.Project != tt.want.Project || got.Location != tt.want.Location {
+				t.Errorf("resolveClientConfig = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientConfigLogNeverShowsKey(t *testing.T) {
+	const key = "AIzaSy-secret-key-123"
+	tests := []struct {
+		name   string
+		config *genai.ClientConfig
+		json   bool
+		want   string
+	}{
+		{"text", &genai.ClientConfig{Backend: genai.BackendGeminiAPI, APIKey: key}, false, "[REDACTED]"},
+		{"json", &genai.ClientConfig{Backend: genai.BackendGeminiAPI, APIKey: key}, true, `"api_key":"[REDACTED]"`},
+		{"no key", &genai.ClientConfig{Backend: genai.BackendVertexAI, Project: "proj"}, false, "(unset)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			newLogger(&out, true, tt.json).Debug("client config", clientConfigAttrs(tt.config)...)
+			if strings.Contains(out.String(), key) || strings.Contains(out.String(), "secret") {
+				t.Errorf("config dump shows the key:\n%s", out.String())
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("config dump = %q, want it to contain %q", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -2,23 +2,48 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"strings"
 
 	"google.golang.org/genai"
 )
 
-func listModels() {
+// listModels prints the name and display name of every available model to w.
+func listModels(w io.Writer, config *genai.ClientConfig) error {
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, nil)
+	client, err := genai.NewClient(ctx, config)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	for model, err := range client.Models.All(ctx) {
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		fmt.Printf("%s\t%s\n", model.Name, model.DisplayName)
+		fmt.Fprintf(w, "%s\t%s\n", model.Name, model.DisplayName)
 	}
+	return nil
+}
+
+// errUnknownModel is returned by validateModel for a model the API does not
+// offer.
+var errUnknownModel = errors.New("unknown model")
+
+// validateModel checks that model is offered by the API.
+// The returned error lists the available models when it is not.
+func validateModel(ctx context.Context, client ModelClient, model string) error {
+	var available []string
+	for m, err := range client.All(ctx) {
+		if err != nil {
+			return fmt.Errorf("failed to list models: %w", err)
+		}
+		name := strings.TrimPrefix(m.Name, "models/")
+		if name == strings.TrimPrefix(model, "models/") {
+			return nil
+		}
+		available = append(available, name)
+	}
+	return fmt.Errorf("%w %q; available models:\n  %s", errUnknownModel, model, strings.Join(available, "\n  "))
 }
@@ -0,0 +1,46 @@
+package main
+
+import "os"
+
+// ANSI escape sequences for the colors the terminal output uses.
+const (
+	colorBold    = "\033[1m"
+	colorGray    = "\033[90m"
+	colorRed     = "\033[91m"
+	colorGreen   = "\033[92m"
+	colorYellow  = "\033[93m"
+	colorBlue    = "\033[94m"
+	colorMagenta = "\033[95m"
+	colorCyan    = "\033[96m"
+	colorReset   = "\033[0m"
+)
+
+// Glyphs marking tool activity in terminal output. They are plain UTF-8
+// literals; keep them here so they are written once.
+const (
+	glyphToolCall   = "→"
+	glyphToolResult = "←"
+)
+
+// Styler colors terminal output, or leaves it plain when Color is false.
+type Styler struct {
+	Color bool
+}
+
+// Paint wraps text in color when coloring is on.
+func (s Styler) Paint(color, text string) string {
+	if !s.Color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// colorEnabled reports whether output to out should be colored: not when
+// --no-color is given, when $NO_COLOR is set to anything non-empty (see
+// no-color.org), or when out is not a terminal.
+func colorEnabled(noColor bool, out *os.File) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTerminal(out)
+}
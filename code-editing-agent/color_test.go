@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+func TestStylerPaint(t *testing.T) {
+	tests := []struct {
+		name  string
+		color bool
+		want  string
+	}{
+		{"on", true, colorGreen + "ok" + colorReset},
+		{"off", false, "ok"},
+	}
+	for _, tt := range tests {
+		if got := (Styler{Color: tt.color}).Paint(colorGreen, "ok"); got != tt.want {
+			t.Errorf("%s: Paint = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestColorEnabled(t *testing.T) {
+	// /dev/null is a character device, which is what isTerminal looks for
+	device, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer device.Close()
+	regular, err := os.Create(filepath.Join(t.TempDir(), "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer regular.Close()
+
+	tests := []struct {
+		name    string
+		noColor bool
+		env     string
+		out     *os.File
+		want    bool
+	}{
+		{"terminal", false, "", device, true},
+		{"flag", true, "", device, false},
+		{"NO_COLOR", false, "1", device, false},
+		{"redirected to a file", false, "", regular, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NO_COLOR", tt.env)
+			if got := colorEnabled(tt.noColor, tt.out); got != tt.want {
+				t.Errorf("colorEnabled = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminalSinkColor(t *testing.T) {
+	call := &genai.FunctionCall{Name: "read_file"}
+	tests := []struct {
+		name      string
+		color     bool
+		markdown  bool
+		wantColor bool
+	}{
+		{"plain", false, false, false},
+		{"plain markdown", false, true, false},
+		{"colored", true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			sink := NewTerminalSink(&out, Styler{Color: tt.color}, 0)
+			sink.Markdown = tt.markdown
+			sink.OnToolCall(call)
+			sink.OnModelText("Here is **the** `answer`.\n")
+			sink.OnModelDone()
+
+			if got := strings.Contains(out.String(), "\033["); got != tt.wantColor {
+				t.Errorf("output has escapes = %v, want %v:\n%q", got, tt.wantColor, out.String())
+			}
+			for _, want := range []string{"read_file", "Gemini:", "answer"} {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output %q does not contain %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestToolGlyphs(t *testing.T) {
+	call := &genai.FunctionCall{Name: "list_files"}
+	tests := []struct {
+		name  string
+		emit  func(sink *TerminalSink)
+		color bool
+		want  string
+	}{
+		{"call", func(sink *TerminalSink) { sink.OnToolCall(call) }, false, "→ list_files\n"},
+		{"colored call", func(sink *TerminalSink) { sink.OnToolCall(call) }, true, colorGreen + "→ list_files" + colorReset + "\n"},
+		{"verbose result", func(sink *TerminalSink) { sink.OnToolResult(call, NewSuccessResult(map[string]any{})) }, false, "← list_files\n{\n  \"data\": {},\n  \"ok\": true\n}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			sink := NewTerminalSink(&out, Styler{Color: tt.color}, 0)
+			sink.Verbose = &out
+			tt.emit(sink)
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+
+	// Guard against the glyphs being double-encoded again
+	for glyph, want := range map[string]rune{glyphToolCall: '\u2192', glyphToolResult: '\u2190'} {
+		if r, size := utf8.DecodeRuneInString(glyph); r != want || size != len(glyph) {
+			t.Errorf("glyph %q, want %q", glyph, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// commandUsage lists the REPL meta-commands and how to enter multi-line
+// messages.
+const commandUsage = `Commands:
+  /reset          Clear the conversation history
+  /save <file>    Save the conversation history to file
+  /model [name]   Show or switch the model
+  /tools          List available tools
+  /help           Show this help
+
+Multi-line messages:
+  End a line with \ to continue on the next line, or put the message
+  between two lines holding only """.`
+
+// isMetaCommand reports whether a line of input is a REPL meta-command
+// rather than a message for the model.
+func isMetaCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), "/")
+}
+
+// handleMetaCommand runs a REPL meta-command. Commands never reach the
+// model or the history; their output is printed directly.
+func (a *Agent) handleMetaCommand(ctx context.Context, input string) {
+	fields := strings.Fields(input)
+	name, args := fields[0], fields[1:]
+
+	switch name {
+	case "/reset":
+		a.history = []*genai.Content{}
+		fmt.Fprintln(a.out, "History cleared.")
+
+	case "/save":
+		if len(args) != 1 {
+			fmt.Fprintln(a.out, "Usage: /save <file>")
+			return
+		}
+		if err := a.SaveHistory(args[0]); err != nil {
+			fmt.Fprintf(a.out, "Error: %v\n", err)
+			return
+		}
+		fmt.Fprintf(a.out, "Saved %d history entries to %s\n", len(a.history), args[0])
+
+	case "/model":
+		if len(args) == 0 {
+			fmt.Fprintf(a.out, "Current model: %s\n", a.model)
+			return
+		}
+		if len(args) != 1 {
+			fmt.Fprintln(a.out, "Usage: /model [name]")
+			return
+		}
+		if err := validateModel(ctx, a.client, args[0]); err != nil {
+			fmt.Fprintf(a.out, "Error: %v\n", err)
+			return
+		}
+		a.model = args[0]
+		fmt.Fprintf(a.out, "Switched to %s\n", a.model)
+
+	case "/tools":
+		for _, tool := range a.config.Tools {
+			for _, decl := range tool.FunctionDeclarations {
+				fmt.Fprintf(a.out, "  %-16s %s\n", decl.Name, decl.Description)
+			}
+		}
+
+	case "/help":
+		fmt.Fprintln(a.out, commandUsage)
+
+	default:
+		fmt.Fprintf(a.out, "Unknown command: %s\n%s\n", name, commandUsage)
+	}
+}
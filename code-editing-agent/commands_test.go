@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestIsMetaCommand(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"/reset", true},
+		{"  /model gemini-a", true},
+		{"hello", false},
+		{"what does a/b mean?", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isMetaCommand(tt.input); got != tt.want {
+			t.Errorf("isMetaCommand(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestHandleMetaCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string // {dir} is replaced with a temporary directory
+		want        []string
+		wantHistory int    // Entries left in the two-entry history
+		wantModel   string // "" means unchanged
+		wantSaved   string // File that should hold the history, under {dir}
+	}{
+		{name: "reset", input: "/reset", want: []string{"History cleared."}},
+		{name: "save", input: "/save {dir}/session.json", want: []string{"Saved 2 history entries to "},
+			wantHistory: 2, wantSaved: "session.json"},
+		{name: "save without a file", input: "/save", want: []string{"Usage: /save <file>"}, wantHistory: 2},
+		{name: "save fails", input: "/save {dir}/missing/session.json", want: []string{"Error: failed to write session"}, wantHistory: 2},
+		{name: "show the model", input: "/model", want: []string{"Current model: gemini-a"}, wantHistory: 2},
+		{name: "switch the model", input: "/model gemini-b", want: []string{"Switched to gemini-b"},
+			wantHistory: 2, wantModel: "gemini-b"},
+		{name: "unknown model is rejected", input: "/model gemini-z", want: []string{`Error: unknown model "gemini-z"`, "gemini-b"},
+			wantHistory: 2},
+		{name: "model with extra arguments", input: "/model gemini-b now", want: []string{"Usage: /model [name]"}, wantHistory: 2},
+		{name: "tools", input: "/tools", want: []string{"  read_file ", "  list_files "}, wantHistory: 2},
+		{name: "help", input: "/help", want: []string{commandUsage}, wantHistory: 2},
+		{name: "unknown command", input: "/quit", want: []string{"Unknown command: /quit", "/reset          Clear the conversation history"},
+			wantHistory: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			client := &modelsClient{models: []string{"gemini-a", "gemini-b"}}
+			agent := newTestAgentWithOutput(t, client, &out, func(cfg *Config) { cfg.Model = "gemini-a" })
+			agent.history = []*genai.Content{
+				genai.NewContentFromText("hello", genai.RoleUser),
+				genai.NewContentFromText("hi", genai.RoleModel),
+			}
+			dir := t.TempDir()
+
+			agent.handleMetaCommand(context.Background(), strings.ReplaceAll(tt.input, "{dir}", dir))
+
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output = %q, want it to contain %q", out.String(), want)
+				}
+			}
+			if len(agent.history) != tt.wantHistory {
+				t.Errorf("history has %d entries, want %d", len(agent.history), tt.wantHistory)
+			}
+			wantModel := tt.wantModel
+			if wantModel == "" {
+				wantModel = "gemini-a"
+			}
+			if agent.model != wantModel {
+				t.Errorf("model = %q, want %q", agent.model, wantModel)
+			}
+			if tt.wantSaved != "" {
+				data, err := os.ReadFile(filepath.Join(dir, tt.wantSaved))
+				if err != nil || !strings.Contains(string(data), `"hello"`) {
+					t.Errorf("saved history = %q, %v; want it to hold the conversation", data, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/genai"
+)
+
+const (
+	defaultCompactThreshold = 200000 // Estimated tokens before history is compacted
+	defaultCompactKeepTurns = 4      // Most recent turns kept verbatim
+)
+
+// summaryPrompt asks the model to condense the older part of the conversation.
+const summaryPrompt = "Summarize the conversation so far for your own future reference. " +
+	"Include the user's goals, decisions made, files read or changed, and any open tasks. " +
+	"Be concise and do not call any tools."
+
+// estimateTokens roughly estimates the token count of contents (about 4 bytes per token).
+func estimateTokens(contents []*genai.Content) int {
+	total := 0
+	for _, content := range contents {
+		total += contentBytes(content) / 4
+	}
+	return total
+}
+
+// turnStarts returns the indexes of history entries that begin a user turn.
+func turnStarts(history []*genai.Content) []int {
+	var starts []int
+	for i, content := range history {
+		if isTurnStart(content) {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// isTurnStart reports whether content begins a user turn, i.e. is a user
+// message carrying text rather than function responses.
+func isTurnStart(content *genai.Content) bool {
+	if content.Role != genai.RoleUser {
+		return false
+	}
+	for _, part := range content.Parts {
+		if part.FunctionResponse != nil {
+			return false
+		}
+		if part.Text != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// trimmedNote stands in for the start of a turn that was trimmed away, so
+// the history still opens with a user message.
+const trimmedNote = "(The earlier part of this conversation was trimmed to save space.)"
+
+// dropOldest returns history without its first cut entries, and how many
+// entries were dropped. The cut moves on
+// to the next user turn, so the history never starts with a model response or
+// an orphaned function response. When cut falls inside the last turn, the
+// rest of that turn is kept behind trimmedNote instead; history[cut] must not
+// then hold function responses.
+func dropOldest(history []*genai.Content, cut int) ([]*genai.Content, int) {
+	for i := cut; i < len(history); i++ {
+		if isTurnStart(history[i]) {
+			return append([]*genai.Content{}, history[i:]...), i
+		}
+	}
+	if cut >= len(history) {
+		return []*genai.Content{}, len(history)
+	}
+	return append([]*genai.Content{genai.NewContentFromText(trimmedNote, genai.RoleUser)}, history[cut:]...), cut
+}
+
+// compactHistory replaces older turns with a model-written summary once the
+// estimated history size crosses compactThreshold. The last compactKeepTurns
+// turns are kept verbatim. Turns are only split at user messages, so every
+// function call in the kept history still has its response.
+func (a *Agent) compactHistory(ctx context.Context) error {
+	if a.compactThreshold <= 0 || estimateTokens(a.history) < a.compactThreshold {
+		return nil
+	}
+
+	keep := max(a.compactKeepTurns, 0)
+	starts := turnStarts(a.history)
+	if len(starts) <= keep {
+		return nil
+	}
+	cut := len(a.history)
+	if keep > 0 {
+		cut = starts[len(starts)-keep]
+	}
+
+	older := a.history[:cut]
+	request := append(append([]*genai.Content{}, older...), genai.NewContentFromText(summaryPrompt, genai.RoleUser))
+	config := &genai.GenerateContentConfig{
+		SystemInstruction: a.config.SystemInstruction,
+		Tools:             a.config.Tools,
+		ToolConfig: &genai.ToolConfig{
+			FunctionCallingConfig: &genai.FunctionCallingConfig{Mode: genai.FunctionCallingConfigModeNone},
+		},
+	}
+
+	a.tracer.Request(a.model, request, config)
+	resp, err := a.client.GenerateContent(ctx, a.model, request, config)
+	if err != nil {
+		a.tracer.Response(nil, nil, err)
+		return fmt.Errorf("failed to summarize history: %w", err)
+	}
+	a.usage.add(resp.UsageMetadata)
+	if len(resp.Candidates) > 0 {
+		a.tracer.Response(resp.Candidates[0].Content, resp.UsageMetadata, nil)
+	} else {
+		a.tracer.Response(nil, resp.UsageMetadata, nil)
+	}
+
+	summary := resp.Text()
+	if summary == "" {
+		return fmt.Errorf("failed to summarize history: empty summary")
+	}
+
+	a.logger.Debug("compacted history", "entries", cut)
+
+	compacted := []*genai.Content{
+		genai.NewContentFromText("Summary of the earlier conversation:\n"+summary, genai.RoleUser),
+	}
+	a.history = append(compacted, a.history[cut:]...)
+	return nil
+}
+
+// trimHistory drops the oldest history entries until at most maxMessages
+// remain. Whole turns are dropped where possible (see dropOldest); an entry
+// holding function responses is never left at the front, since the model
+// call it answers would be gone and the API rejects the orphaned response,
+// so a call and its response are always kept or dropped together. When the
+// last turn alone is longer than maxMessages, the note opening it is one
+// entry more. maxMessages <= 0 disables trimming.
+func (a *Agent) trimHistory(maxMessages int) {
+	if maxMessages <= 0 || len(a.history) <= maxMessages {
+		return
+	}
+
+	cut := len(a.history) - maxMessages
+	for cut < len(a.history) && hasFunctionResponse(a.history[cut]) {
+		cut++
+	}
+
+	var dropped int
+	a.history, dropped = dropOldest(a.history, cut)
+	a.logger.Debug("trimmed history", "entries", dropped)
+}
+
+// hasFunctionResponse reports whether content answers a function call.
+func hasFunctionResponse(content *genai.Content) bool {
+	for _, part := range content.Parts {
+		if part.FunctionResponse != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultMaxHistoryBytes is the default ceiling on the serialized history.
+const defaultMaxHistoryBytes = 8 << 20
+
+// fitHistory drops the oldest history entries once the serialized history
+// outgrows maxBytes, so sessions that read many large files cannot grow
+// without bound. The newest entry is always kept, together with the call it
+// answers, a call is never separated from its response, and the history still
+// starts with a user message (see dropOldest). maxBytes <= 0 disables the
+// ceiling. It reports how many entries were dropped.
+func (a *Agent) fitHistory(maxBytes int) int {
+	if maxBytes <= 0 || len(a.history) == 0 {
+		return 0
+	}
+
+	sizes := make([]int, len(a.history))
+	total := 0
+	for i, content := range a.history {
+		sizes[i] = contentBytes(content)
+		total += sizes[i]
+	}
+	if total <= maxBytes {
+		return 0
+	}
+
+	cut := 0
+	for cut < len(a.history)-1 && total > maxBytes {
+		total -= sizes[cut]
+		cut++
+	}
+	if cut > 0 && hasFunctionResponse(a.history[cut]) {
+		cut--
+	}
+	if cut == 0 {
+		return 0
+	}
+
+	var dropped int
+	a.history, dropped = dropOldest(a.history, cut)
+	a.logger.Debug("trimmed history to fit", "entries", dropped, "max_bytes", maxBytes)
+	return dropped
+}
+
+// contentBytes returns the size of content encoded as JSON.
+func contentBytes(content *genai.Content) int {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
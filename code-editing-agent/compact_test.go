@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// toolRound is a model call to list_files and the user entry answering it.
+func toolRound() []*genai.Content {
+	return []*genai.Content{
+		functionCallContent("list_files", map[string]any{"path": "."}),
+		{Role: genai.RoleUser, Parts: []*genai.Part{genai.NewPartFromFunctionResponse("list_files", map[string]any{"ok": true})}},
+	}
+}
+
+// conversation builds a history from turns: a string starts a user turn,
+// "model:" text is a model answer, and "tool" is a tool round.
+func conversation(turns ...string) []*genai.Content {
+	var history []*genai.Content
+	for _, turn := range turns {
+		switch {
+		case turn == "tool":
+			history = append(history, toolRound()...)
+		case strings.HasPrefix(turn, "model:"):
+			history = append(history, genai.NewContentFromText(strings.TrimPrefix(turn, "model:"), genai.RoleModel))
+		default:
+			history = append(history, genai.NewContentFromText(turn, genai.RoleUser))
+		}
+	}
+	return history
+}
+
+// checkWellFormed fails unless history opens with a user turn and needs no
+// repairs.
+func checkWellFormed(t *testing.T, history []*genai.Content) {
+	t.Helper()
+	if len(history) == 0 {
+		return
+	}
+	if !isTurnStart(history[0]) {
+		t.Errorf("history starts with %s entry %+v, want a user turn", history[0].Role, history[0].Parts)
+	}
+	if _, problems := repairHistory(history); len(problems) > 0 {
+		t.Errorf("history needs repairs: %v", problems)
+	}
+}
+
+func TestTrimHistory(t *testing.T) {
+	tests := []struct {
+		name      string
+		history   []*genai.Content
+		max       int
+		wantLen   int
+		wantFirst string
+	}{
+		{"under limit", conversation("a", "model:1", "b", "model:2"), 4, 4, "a"},
+		{"disabled", conversation("a", "model:1", "b", "model:2"), 0, 4, "a"},
+		{"drops whole turns", conversation("a", "model:1", "b", "model:2", "c", "model:3"), 4, 4, "b"},
+		{"skips to the next turn", conversation("a", "model:1", "b", "model:2", "c", "model:3"), 3, 2, "c"},
+		{"skips tool rounds", conversation("a", "tool", "model:1", "b", "model:2"), 4, 2, "b"},
+		{"last turn too long", conversation("a", "model:1", "b", "tool", "tool", "model:2"), 3, 4, trimmedNote},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Agent{history: tt.history, logger: slog.New(slog.DiscardHandler)}
+			a.trimHistory(tt.max)
+			if len(a.history) != tt.wantLen {
+				t.Fatalf("len(history) = %d, want %d", len(a.history), tt.wantLen)
+			}
+			if got := contentText(a.history[0]); got != tt.wantFirst {
+				t.Errorf("first entry = %q, want %q", got, tt.wantFirst)
+			}
+			checkWellFormed(t, a.history)
+		})
+	}
+}
+
+// parallelRound is a model entry calling two tools at once and the user entry
+// answering both.
+func parallelRound() []*genai.Content {
+	return []*genai.Content{
+		{Role: genai.RoleModel, Parts: []*genai.Part{
+			genai.NewPartFromFunctionCall("read_file", map[string]any{"path": "a"}),
+			genai.NewPartFromFunctionCall("read_file", map[string]any{"path": "b"}),
+		}},
+		{Role: genai.RoleUser, Parts: []*genai.Part{
+			genai.NewPartFromFunctionResponse("read_file", map[string]any{"ok": true}),
+			genai.NewPartFromFunctionResponse("read_file", map[string]any{"ok": true}),
+		}},
+	}
+}
+
+func TestTrimHistoryKeepsPairs(t *testing.T) {
+	interleaved := conversation("a", "tool", "model:1", "b", "tool", "tool", "model:2", "c")
+	withParallel := conversation("a", "tool")
+	withParallel = append(withParallel, parallelRound()...)
+	withParallel = append(withParallel, conversation("model:1", "b", "tool")...)
+	withParallel = append(withParallel, parallelRound()...)
+	withParallel = append(withParallel, conversation("model:2")...)
+	tests := []struct {
+		name    string
+		history []*genai.Content
+	}{
+		{"interleaved tool turns", interleaved},
+		{"parallel calls", withParallel},
+		{"one long turn", conversation("a", "tool", "tool", "tool", "model:1")},
+	}
+	for _, tt := range tests {
+		// Every limit, so every possible cut point is tried
+		for n := 1; n <= len(tt.history); n++ {
+			t.Run(fmt.Sprintf("%s/max %d", tt.name, n), func(t *testing.T) {
+				a := &Agent{history: slices.Clone(tt.history), logger: slog.New(slog.DiscardHandler)}
+				a.trimHistory(n)
+				checkWellFormed(t, a.history)
+				if a.history[len(a.history)-1] != tt.history[len(tt.history)-1] {
+					t.Error("newest entry was dropped")
+				}
+				// Only the note opening a cut-off turn may go over the limit
+				if limit := n + 1; len(a.history) > limit || (len(a.history) == limit && contentText(a.history[0]) != trimmedNote) {
+					t.Errorf("len(history) = %d, want at most %d", len(a.history), n)
+				}
+				// The kept entries are the newest ones, in order
+				kept := a.history
+				if contentText(kept[0]) == trimmedNote {
+					kept = kept[1:]
+				}
+				if !slices.Equal(kept, tt.history[len(tt.history)-len(kept):]) {
+					t.Error("kept entries are not the newest ones in order")
+				}
+			})
+		}
+	}
+}
+
+func TestFitHistory(t *testing.T) {
+	big := strings.Repeat("x", 1000)
+	tests := []struct {
+		name        string
+		history     []*genai.Content
+		maxBytes    int
+		wantDropped int
+		wantFirst   string
+	}{
+		{"fits", conversation("a", "model:1"), 10000, 0, "a"},
+		{"disabled", conversation(big, "model:"+big), 0, 0, big},
+		{"drops whole turns", conversation(big, "model:"+big, "b", "model:2"), 500, 2, "b"},
+		{"never starts with a model answer", conversation(big, "model:1", "b", "model:2"), 500, 2, "b"},
+		{"keeps the current turn behind a note", conversation(big, "tool", "tool"), 600, 1, trimmedNote},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Agent{history: tt.history, logger: slog.New(slog.DiscardHandler)}
+			if dropped := a.fitHistory(tt.maxBytes); dropped != tt.wantDropped {
+				t.Errorf("fitHistory dropped %d entries, want %d", dropped, tt.wantDropped)
+			}
+			if got := contentText(a.history[0]); got != tt.wantFirst {
+				t.Errorf("first entry = %.20q, want %.20q", got, tt.wantFirst)
+			}
+			if last := tt.history[len(tt.history)-1]; a.history[len(a.history)-1] != last {
+				t.Error("newest entry was dropped")
+			}
+			checkWellFormed(t, a.history)
+		})
+	}
+}
+
+func TestCompactHistory(t *testing.T) {
+	history := func() []*genai.Content {
+		return conversation("a", "model:1", "b", "tool", "model:2", "c", "tool", "model:3")
+	}
+	summary := genai.NewContentFromText("They asked about a, b, and c.", genai.RoleModel)
+	tests := []struct {
+		name      string
+		threshold int
+		keep      int
+		responses []*genai.Content
+		wantErr   string
+		wantLen   int    // Entries afterwards
+		wantFirst string // Text of the first entry afterwards
+		wantCalls int    // Summary requests sent
+	}{
+		{"disabled", 0, 1, nil, "", 10, "a", 0},
+		{"under the threshold", 1_000_000, 1, nil, "", 10, "a", 0},
+		{"keeps the last turn", 1, 1, []*genai.Content{summary}, "", 5, "Summary of the earlier conversation:\nThey asked about a, b, and c.", 1},
+		{"keeps two turns with their tool rounds", 1, 2, []*genai.Content{summary}, "", 9, "Summary of the earlier conversation:\nThey asked about a, b, and c.", 1},
+		{"summarizes everything", 1, 0, []*genai.Content{summary}, "", 1, "Summary of the earlier conversation:\nThey asked about a, b, and c.", 1},
+		{"fewer turns than kept", 1, 3, nil, "", 10, "a", 0},
+		{"empty summary", 1, 1, []*genai.Content{genai.NewContentFromText("", genai.RoleModel)}, "empty summary", 10, "a", 1},
+		{"request fails", 1, 1, nil, "failed to summarize history", 10, "a", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewScriptedClient(tt.responses...)
+			agent := newTestAgent(t, client, func(cfg *Config) {
+				cfg.CompactThreshold = tt.threshold
+				cfg.CompactKeepTurns = tt.keep
+			})
+			agent.history = history()
+			original := agent.history
+
+			err := agent.compactHistory(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("compactHistory error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("compactHistory: %v", err)
+			}
+			if len(agent.history) != tt.wantLen {
+				t.Fatalf("len(history) = %d, want %d", len(agent.history), tt.wantLen)
+			}
+			if got := contentText(agent.history[0]); got != tt.wantFirst {
+				t.Errorf("first entry = %q, want %q", got, tt.wantFirst)
+			}
+			// Whatever was not summarized is the original entries, in order
+			kept := agent.history
+			if tt.wantLen < len(original) {
+				kept = agent.history[1:]
+			}
+			for i, content := range kept {
+				if content != original[len(original)-len(kept)+i] {
+					t.Errorf("kept entry %d is not the original", i)
+				}
+			}
+			if client.next != tt.wantCalls {
+				t.Errorf("sent %d summary requests, want %d", client.next, tt.wantCalls)
+			}
+			checkWellFormed(t, agent.history)
+		})
+	}
+}
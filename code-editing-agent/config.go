@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config gathers the settings that shape a session: the model and how it
+// samples, the sandbox, which tools are offered and how they run, and the
+// per-turn and per-session limits. main builds one from the defaults, the
+// config file, the environment, and flags, then hands it to NewPathSandbox
+// (through WithConfig) and NewAgent.
+type Config struct {
+	Model           string
+	SystemPrompt    string   // Sent as the system instruction when non-empty
+	AutoContext     bool     // Add a summary of the project to the system instruction
+	Temperature     *float32 // nil keeps the model's default
+	TopP            *float32 // nil keeps the model's default
+	MaxOutputTokens int32    // 0 keeps the model's default
+	Debug           bool     // Enable debug logging
+	LogJSON         bool     // Write debug logging as JSON lines
+	Color           bool     // Color terminal output with ANSI escapes
+	WrapWidth       int      // Soft-wrap streamed model text at this many columns; 0 disables
+	RenderMarkdown  bool     // Render each complete response as Markdown instead of streaming it
+	Spinner         bool     // Animate a spinner while waiting for a response
+
+	Root               string        // Project root the sandbox confines tools to
+	AllowPaths         []string      // If non-empty, only matching paths are accessible
+	DenyPaths          []string      // Paths that are never accessible
+	WriteQuota         int64         // Bytes tools may write per session; 0 means unlimited
+	FollowSymlinks     SymlinkPolicy // Which symlinks the sandbox follows
+	AllowAbsolutePaths bool          // Accept absolute tool paths that land under the root
+
+	EnableTools     []string              // If non-empty, the only tools offered
+	DisableTools    []string              // Tools withheld from the model
+	ToolPolicies    map[string]ToolPolicy // Per-tool always, never, or ask overrides
+	AllowedCommands []string              // Commands run_command and other tools may execute
+	CommandTimeout  time.Duration         // Per-command timeout
+	Formatters      map[string]string     // Per-extension format_code overrides
+	DryRun          bool                  // Simulate writes instead of performing them
+
+	TurnTimeout      time.Duration // Upper bound on one turn; 0 disables
+	MaxToolRounds    int           // Rounds of tool calls per turn; 0 means unlimited
+	MaxRepeatCalls   int           // Identical consecutive calls allowed; 0 disables the check
+	MaxResultBytes   int           // Largest encoded tool result; 0 disables truncation
+	MaxTokens        int           // Session token budget; 0 means unlimited
+	CompactThreshold int           // Estimated history tokens that trigger compaction; 0 disables
+	CompactKeepTurns int           // Recent turns kept verbatim when compacting
+	MaxHistory       int           // History entries kept before the oldest are dropped; 0 means unlimited
+	MaxHistoryBytes  int           // Serialized history size before the oldest entries are dropped; 0 means unlimited
+	ShowUsage        bool          // Print running token totals after each turn
+	ShowStats        bool          // Print the tool latency table when the session ends
+}
+
+// DefaultConfig returns the built-in settings. The project root is left
+// empty; main fills it in.
+func DefaultConfig() *Config {
+	return &Config{
+		Model:            defaultModel,
+		Color:            true,
+		DenyPaths:        []string{".git"},
+		FollowSymlinks:   SymlinkWithinRoot,
+		AllowedCommands:  slices.Clone(defaultAllowedCommands),
+		CommandTimeout:   defaultCommandTimeout,
+		MaxToolRounds:    defaultMaxToolRounds,
+		MaxRepeatCalls:   defaultMaxRepeatCalls,
+		MaxResultBytes:   defaultMaxResultBytes,
+		CompactThreshold: defaultCompactThreshold,
+		CompactKeepTurns: defaultCompactKeepTurns,
+		MaxHistoryBytes:  defaultMaxHistoryBytes,
+	}
+}
+
+// configFileNames are the config files looked for in the working directory
+// when --config is not given, in order of preference.
+var configFileNames = []string{"agent.toml", "agent.json"}
+
+// protectedSettings loosen the sandbox or skip confirmation, so they are only
+// taken from a file named with --config. A file found in the working directory
+// may have come with the project the agent is about to work on.
+var protectedSettings = []string{"allow-absolute-paths", "allow-commands", "deny-paths", "follow-symlinks", "root", "tool-policy", "yes"}
+
+// ConfigFile is the settings read from an agent.toml or agent.json file. Each
+// top-level key names a command-line flag (without the dashes) and supplies
+// its value; a formatters table maps file extensions to format_code commands.
+//
+// A flag given on the command line wins over the file, and the file wins over
+// the flag's environment variable and built-in default, since settings from
+// the file count as set flags.
+type ConfigFile struct {
+	Path       string            // File the settings came from
+	Discovered bool              // Found in the working directory rather than named with --config
+	Settings   map[string]string // Flag name to value, in flag syntax
+	Formatters map[string]string // Extension to formatter command line
+}
+
+// findConfigFile returns the config file to load: explicit if set, otherwise
+// the first of configFileNames in dir. It returns "" when there is none.
+func findConfigFile(explicit, dir string) (string, error) {
+	if explicit != "" {
+		return explicit, nil
+	}
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// LoadConfigFile reads a config file, choosing the format by extension:
+// .toml for TOML, anything else for JSON.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &ConfigFile{Path: path, Settings: map[string]string{}, Formatters: map[string]string{}}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = cfg.parseTOML(data)
+	} else {
+		err = cfg.parseJSON(data)
+	}
+	if err == nil {
+		cfg.Formatters, err = normalizeFormatters(cfg.Formatters)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// parseJSON reads a JSON object of settings. Lists become comma-separated
+// flag values.
+func (c *ConfigFile) parseJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	for key, value := range raw {
+		if key == "formatters" {
+			table, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("formatters must be an object")
+			}
+			for ext, command := range table {
+				s, ok := command.(string)
+				if !ok {
+					return fmt.Errorf("formatters.%s must be a string", ext)
+				}
+				c.Formatters[ext] = s
+			}
+			continue
+		}
+
+		s, err := settingString(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		c.Settings[key] = s
+	}
+	return nil
+}
+
+// settingString renders a decoded JSON value in flag syntax.
+func settingString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("list items must be strings")
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// parseTOML reads the subset of TOML the settings need: key = value pairs
+// with string, number, boolean, and string-array values, comments, and a
+// [formatters] table.
+func (c *ConfigFile) parseTOML(data []byte) error {
+	table := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(stripTOMLComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("line %d: malformed table header", lineNumber)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table != "formatters" {
+				return fmt.Errorf("line %d: unknown table [%s]", lineNumber, table)
+			}
+			continue
+		}
+
+		rawKey, rawValue, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		key, err := tomlKey(strings.TrimSpace(rawKey))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		value, err := tomlValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		if table == "formatters" {
+			c.Formatters[key] = value
+		} else {
+			c.Settings[key] = value
+		}
+	}
+	return scanner.Err()
+}
+
+// stripTOMLComment removes a trailing # comment that is not inside a string.
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch ch := line[i]; {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// tomlKey returns a bare or quoted key.
+func tomlKey(raw string) (string, error) {
+	if raw == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	if raw[0] == '"' || raw[0] == '\'' {
+		return tomlString(raw)
+	}
+	return raw, nil
+}
+
+// tomlValue renders a TOML value in flag syntax. Arrays of strings become
+// comma-separated lists.
+func tomlValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case raw[0] == '"' || raw[0] == '\'':
+		return tomlString(raw)
+	case raw[0] == '[':
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("arrays must be on one line")
+		}
+		var items []string
+		for _, item := range splitTOMLArray(raw[1 : len(raw)-1]) {
+			s, err := tomlString(item)
+			if err != nil {
+				return "", fmt.Errorf("array items must be strings")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	default:
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(raw, "_", ""), 64); err != nil {
+			return "", fmt.Errorf("unsupported value %s", raw)
+		}
+		return strings.ReplaceAll(raw, "_", ""), nil
+	}
+}
+
+// splitTOMLArray splits the inside of a one-line array on commas outside
+// strings, dropping a trailing empty item.
+func splitTOMLArray(inner string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(inner); i++ {
+		switch ch := inner[i]; {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == ',':
+			items = append(items, strings.TrimSpace(inner[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(inner[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+// tomlString decodes a basic ("...") or literal ('...') string.
+func tomlString(raw string) (string, error) {
+	if len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'' {
+		return raw[1 : len(raw)-1], nil
+	}
+	if len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"' {
+		return strconv.Unquote(raw)
+	}
+	return "", fmt.Errorf("malformed string %s", raw)
+}
+
+// Apply sets each setting's flag unless it was given on the command line.
+// Keys that name no flag, and protected settings in a discovered file, are
+// reported to warn and otherwise ignored.
+func (c *ConfigFile) Apply(flags *flag.FlagSet, warn io.Writer) error {
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	keys := make([]string, 0, len(c.Settings))
+	for key := range c.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if flags.Lookup(key) == nil || key == "config" {
+			fmt.Fprintf(warn, "Warning: %s: unknown setting %q ignored\n", c.Path, key)
+			continue
+		}
+		if c.Discovered && slices.Contains(protectedSettings, key) {
+			fmt.Fprintf(warn, "Warning: %s: setting %q ignored; it is only read from a file passed with --config\n", c.Path, key)
+			continue
+		}
+		if explicit[key] {
+			continue
+		}
+		if err := flags.Set(key, c.Settings[key]); err != nil {
+			return fmt.Errorf("%s: invalid %s %q: %w", c.Path, key, c.Settings[key], err)
+		}
+	}
+	return nil
+}
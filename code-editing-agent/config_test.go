@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name           string
+		file           string
+		content        string
+		wantSettings   map[string]string
+		wantFormatters map[string]string
+		wantErr        string // Substring of the error; "" for success
+	}{
+		{name: "toml", file: "agent.toml", content: `
+# Model settings
+model = "gemini-2.5-pro"   # trailing comment
+temperature = 0.5
+max-tool-rounds = 1_000
+dry-run = true
+deny-paths = [".git", "secrets/*", ]
+system-prompt = 'Say "hi" # not a comment'
+
+[formatters]
+py = "black -q -"
+".JS" = "prettier --stdin-filepath {path}"
+`,
+			wantSettings: map[string]string{
+				"model": "gemini-2.5-pro", "temperature": "0.5", "max-tool-rounds": "1000", "dry-run": "true",
+				"deny-paths": ".git,secrets/*", "system-prompt": `Say "hi" # not a comment`,
+			},
+			wantFormatters: map[string]string{".py": "black -q -", ".js": "prettier --stdin-filepath {path}"}},
+		{name: "toml escapes", file: "agent.toml", content: `system-prompt = "line one\nline \"two\""`,
+			wantSettings: map[string]string{"system-prompt": "line one\nline \"two\""}, wantFormatters: map[string]string{}},
+		{name: "json", file: "agent.json", content: `{"model": "gemini-2.5-pro", "write-quota": 1048576, "yes": false,
+			"disable-tools": ["run_command", "git_commit"], "formatters": {"rs": "rustfmt --emit stdout"}}`,
+			wantSettings:   map[string]string{"model": "gemini-2.5-pro", "write-quota": "1048576", "yes": "false", "disable-tools": "run_command,git_commit"},
+			wantFormatters: map[string]string{".rs": "rustfmt --emit stdout"}},
+		{name: "other extension is json", file: "settings.conf", content: `{"model": "m"}`,
+			wantSettings: map[string]string{"model": "m"}, wantFormatters: map[string]string{}},
+		{name: "toml unknown table", file: "agent.toml", content: "[tools]\nx = 1\n", wantErr: "line 1: unknown table [tools]"},
+		{name: "toml missing equals", file: "agent.toml", content: "model\n", wantErr: "line 1: expected key = value"},
+		{name: "toml multi-line array", file: "agent.toml", content: "deny-paths = [\n", wantErr: "arrays must be on one line"},
+		{name: "toml bare word", file: "agent.toml", content: "model = gemini\n", wantErr: "unsupported value gemini"},
+		{name: "toml number in array", file: "agent.toml", content: "deny-paths = [1]\n", wantErr: "array items must be strings"},
+		{name: "toml missing value", file: "agent.toml", content: "model =\n", wantErr: "missing value"},
+		{name: "json syntax", file: "agent.json", content: `{"model": }`, wantErr: "parse"},
+		{name: "json nested object", file: "agent.json", content: `{"model": {"name": "m"}}`, wantErr: "model: unsupported value"},
+		{name: "json formatters not an object", file: "agent.json", content: `{"formatters": "gofmt"}`, wantErr: "formatters must be an object"},
+		{name: "json bad formatter extension", file: "agent.json", content: `{"formatters": {"": "x"}}`, wantErr: "empty file extension"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadConfigFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("LoadConfigFile error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfigFile: %v", err)
+			}
+			if !maps.Equal(got.Settings, tt.wantSettings) {
+				t.Errorf("settings = %q, want %q", got.Settings, tt.wantSettings)
+			}
+			if !maps.Equal(got.Formatters, tt.wantFormatters) {
+				t.Errorf("formatters = %q, want %q", got.Formatters, tt.wantFormatters)
+			}
+		})
+	}
+}
+
+func TestFindConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		explicit string
+		want     string
+	}{
+		{"none", nil, "", ""},
+		{"json", []string{"agent.json"}, "", "agent.json"},
+		{"toml preferred", []string{"agent.json", "agent.toml"}, "", "agent.toml"},
+		{"explicit", []string{"agent.toml"}, "/etc/agent.json", "/etc/agent.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := findConfigFile(tt.explicit, dir)
+			want := tt.want
+			if want != "" && tt.explicit == "" {
+				want = filepath.Join(dir, want)
+			}
+			if err != nil || got != want {
+				t.Errorf("findConfigFile = %q, %v; want %q", got, err, want)
+			}
+		})
+	}
+}
+
+func TestConfigFileApply(t *testing.T) {
+	tests := []struct {
+		name         string
+		settings     map[string]string
+		discovered   bool
+		args         []string // Command line
+		wantModel    string
+		wantRound    int
+		wantYes      bool
+		wantCommands string // "" skips the check
+		wantWarn     string
+		wantErr      string
+	}{
+		{name: "file sets the model", settings: map[string]string{"model": "from-file"},
+			wantModel: "from-file", wantRound: 25},
+		{name: "flag overrides the file", settings: map[string]string{"model": "from-file", "max-tool-rounds": "5"},
+			args: []string{"--model", "from-flag"}, wantModel: "from-flag", wantRound: 5},
+		{name: "unknown key warns", settings: map[string]string{"model": "from-file", "colour": "red"},
+			wantModel: "from-file", wantRound: 25, wantWarn: `unknown setting "colour" ignored`},
+		{name: "config is not a setting", settings: map[string]string{"config": "other.toml"},
+			wantModel: defaultModel, wantRound: 25, wantWarn: `unknown setting "config" ignored`},
+		{name: "invalid value", settings: map[string]string{"max-tool-rounds": "many"},
+			wantErr: `invalid max-tool-rounds "many"`},
+		{name: "given file sets safety settings", settings: map[string]string{"yes": "true", "allow-commands": "rm"},
+			wantModel: defaultModel, wantRound: 25, wantYes: true, wantCommands: "rm"},
+		{name: "discovered file cannot set safety settings", discovered: true,
+			settings:  map[string]string{"model": "from-file", "yes": "true", "allow-commands": "rm"},
+			wantModel: "from-file", wantRound: 25, wantCommands: "go",
+			wantWarn: `setting "allow-commands" ignored; it is only read from a file passed with --config`},
+		{name: "discovered file still leaves flags alone", discovered: true,
+			settings: map[string]string{"yes": "true"}, args: []string{"--yes"},
+			wantModel: defaultModel, wantRound: 25, wantYes: true, wantCommands: "go", wantWarn: `setting "yes" ignored`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("agent", flag.ContinueOnError)
+			model := flags.String("model", defaultModel, "")
+			rounds := flags.Int("max-tool-rounds", 25, "")
+			yes := flags.Bool("yes", false, "")
+			commands := flags.String("allow-commands", "go", "")
+			flags.String("config", "", "")
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			var warn strings.Builder
+			file := &ConfigFile{Path: "agent.toml", Settings: tt.settings, Discovered: tt.discovered}
+			err := file.Apply(flags, &warn)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Apply error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if *model != tt.wantModel || *rounds != tt.wantRound {
+				t.Errorf("model, rounds = %q, %d; want %q, %d", *model, *rounds, tt.wantModel, tt.wantRound)
+			}
+			if *yes != tt.wantYes || (tt.wantCommands != "" && *commands != tt.wantCommands) {
+				t.Errorf("yes, allow-commands = %v, %q; want %v, %q", *yes, *commands, tt.wantYes, tt.wantCommands)
+			}
+			if !strings.Contains(warn.String(), tt.wantWarn) || (tt.wantWarn == "" && warn.Len() > 0) {
+				t.Errorf("warnings = %q, want %q", warn.String(), tt.wantWarn)
+			}
+			// Settings from the file count as given, so they beat the environment
+			if _, ok := tt.settings["model"]; ok && !flagWasSet(flags, "model") {
+				t.Error("model from the file is not treated as set")
+			}
+		})
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Root = t.TempDir()
+	sandbox, err := NewPathSandbox(cfg.Root, WithConfig(cfg))
+	if err != nil {
+		t.Fatalf("NewPathSandbox with the defaults: %v", err)
+	}
+	agent, err := NewAgent(NewScriptedClient(), func() (string, bool) { return "", false }, sandbox, cfg, io.Discard, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("NewAgent with the defaults: %v", err)
+	}
+
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"model", agent.model, defaultModel},
+		{"max tool rounds", agent.maxToolRounds, defaultMaxToolRounds},
+		{"max repeat calls", agent.maxRepeatCalls, defaultMaxRepeatCalls},
+		{"max result bytes", agent.maxResultBytes, defaultMaxResultBytes},
+		{"compact threshold", agent.compactThreshold, defaultCompactThreshold},
+		{"compact keep turns", agent.compactKeepTurns, defaultCompactKeepTurns},
+		{"max history bytes", agent.maxHistoryBytes, defaultMaxHistoryBytes},
+		{"turn timeout", agent.turnTimeout, time.Duration(0)},
+		{"command timeout", agent.tools.CommandTimeout, defaultCommandTimeout},
+		{"allowed commands", strings.Join(agent.tools.AllowedCommands, ","), strings.Join(defaultAllowedCommands, ",")},
+		{"dry run", agent.tools.DryRun, false},
+		{"system instruction", agent.config.SystemInstruction == nil, true},
+		{"temperature", agent.config.Temperature == nil, true},
+		{"deny rules", strings.Join(sandbox.Deny, ","), ".git"},
+		{"symlink policy", sandbox.FollowSymlinks, SymlinkWithinRoot},
+		{"write quota", sandbox.WriteQuota, int64(0)},
+		{"every tool offered", len(agent.config.Tools[0].FunctionDeclarations), len(agent.registry.Names())},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	// The defaults are copies, so changing one config leaves the next alone
+	cfg.AllowedCommands[0] = "changed"
+	if DefaultConfig().AllowedCommands[0] == "changed" {
+		t.Error("DefaultConfig shares its allowed commands between calls")
+	}
+}
+
+func TestNewAgentAppliesConfig(t *testing.T) {
+	temperature := float32(0.2)
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		wantErr   string
+		check     func(t *testing.T, agent *Agent)
+	}{
+		{name: "enabled tools", configure: func(cfg *Config) { cfg.EnableTools = []string{"read_file", "list_files"} },
+			check: func(t *testing.T, agent *Agent) {
+				if !agent.registry.Enabled("read_file") || agent.registry.Enabled("write_file") {
+					t.Error("only read_file and list_files should be enabled")
+				}
+			}},
+		{name: "disabled tools", configure: func(cfg *Config) { cfg.DisableTools = []string{"run_command"} },
+			check: func(t *testing.T, agent *Agent) {
+				if agent.registry.Enabled("run_command") || !agent.registry.Enabled("read_file") {
+					t.Error("only run_command should be disabled")
+				}
+			}},
+		{name: "unknown enabled tool", configure: func(cfg *Config) { cfg.EnableTools = []string{"teleport"} },
+			wantErr: "teleport"},
+		{name: "unknown tool policy", configure: func(cfg *Config) { cfg.ToolPolicies = map[string]ToolPolicy{"teleport": PolicyAlways} },
+			wantErr: "unknown tool in policy: teleport"},
+		{name: "generation and prompt", configure: func(cfg *Config) {
+			cfg.Model = "custom-model"
+			cfg.Temperature = &temperature
+			cfg.MaxOutputTokens = 64
+			cfg.SystemPrompt = "Be brief."
+		},
+			check: func(t *testing.T, agent *Agent) {
+				if agent.model != "custom-model" || agent.config.Temperature == nil || *agent.config.Temperature != temperature || agent.config.MaxOutputTokens != 64 {
+					t.Errorf("model %q, config %+v; want the configured generation settings", agent.model, agent.config)
+				}
+				if got := contentText(agent.config.SystemInstruction); got != "Be brief." {
+					t.Errorf("system instruction = %q", got)
+				}
+			}},
+		{name: "tool settings", configure: func(cfg *Config) {
+			cfg.AllowedCommands = []string{"make"}
+			cfg.CommandTimeout = time.Second
+			cfg.DryRun = true
+			cfg.Formatters = map[string]string{".go": "gofumpt"}
+		},
+			check: func(t *testing.T, agent *Agent) {
+				tc := agent.tools
+				if strings.Join(tc.AllowedCommands, ",") != "make" || tc.CommandTimeout != time.Second || !tc.DryRun || tc.Formatters[".go"] != "gofumpt" {
+					t.Errorf("tool context = %+v, want the configured settings", tc)
+				}
+			}},
+		{name: "limits", configure: func(cfg *Config) {
+			cfg.TurnTimeout = time.Minute
+			cfg.MaxToolRounds = 3
+			cfg.MaxTokens = 1000
+			cfg.MaxHistory = 10
+		},
+			check: func(t *testing.T, agent *Agent) {
+				if agent.turnTimeout != time.Minute || agent.maxToolRounds != 3 || agent.maxTokens != 1000 || agent.maxHistory != 10 {
+					t.Error("agent limits do not match the config")
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.configure(cfg)
+			sandbox, err := NewPathSandbox(t.TempDir(), WithConfig(cfg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			agent, err := NewAgent(NewScriptedClient(), func() (string, bool) { return "", false }, sandbox, cfg, io.Discard, slog.New(slog.DiscardHandler))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("NewAgent error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewAgent: %v", err)
+			}
+			tt.check(t, agent)
+		})
+	}
+}
+
+func TestWithConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		wantErr   string
+		check     func(t *testing.T, s *PathSandbox)
+	}{
+		{name: "settings", configure: func(cfg *Config) {
+			cfg.WriteQuota = 1 << 20
+			cfg.FollowSymlinks = SymlinkDeny
+			cfg.AllowAbsolutePaths = true
+			cfg.AllowPaths = []string{"src/**"}
+			cfg.DenyPaths = []string{"secrets"}
+		},
+			check: func(t *testing.T, s *PathSandbox) {
+				if s.WriteQuota != 1<<20 || s.FollowSymlinks != SymlinkDeny || !s.AllowAbsolutePaths ||
+					strings.Join(s.Allow, ",") != "src/**" || strings.Join(s.Deny, ",") != "secrets" {
+					t.Errorf("sandbox = %+v, want the configured settings", s)
+				}
+			}},
+		{name: "invalid rule", configure: func(cfg *Config) { cfg.DenyPaths = []string{"[unclosed"} },
+			wantErr: `invalid path rule "[unclosed"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.configure(cfg)
+			s, err := NewPathSandbox(t.TempDir(), WithConfig(cfg))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("NewPathSandbox error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewPathSandbox: %v", err)
+			}
+			tt.check(t, s)
+		})
+	}
+}
+
+func TestDiscoveredConfigCannotSkipConfirmation(t *testing.T) {
+	for _, explicit := range []bool{false, true} {
+		dir := t.TempDir()
+		root := filepath.Join(dir, "project")
+		replay := filepath.Join(dir, "trace.jsonl")
+		records := `{"type":"response","content":{"role":"model","parts":[{"functionCall":{"name":"write_file","args":{"path":"out.txt","content":"hello"}}}]}}` + "\n" +
+			`{"type":"response","content":{"role":"model","parts":[{"text":"Done."}]}}` + "\n"
+		writeTree(t, dir, map[string]string{
+			"trace.jsonl":        records,
+			"project/agent.toml": "yes = true\n",
+		})
+		t.Chdir(root)
+
+		args := []string{"agent", "--replay", replay, "--no-preflight", "--history-file", "", "--prompt", "write it"}
+		if explicit {
+			args = append(args, "--config", "agent.toml")
+		}
+		var stdout, stderr bytes.Buffer
+		if code := run(args, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+			t.Fatalf("explicit=%v: run exit code = %d, want %d (stderr: %s)", explicit, code, exitOK, stderr.String())
+		}
+		_, err := os.Stat(filepath.Join(root, "out.txt"))
+		if wrote := err == nil; wrote != explicit {
+			t.Errorf("explicit=%v: file written = %v, want %v", explicit, wrote, explicit)
+		}
+		if warned := strings.Contains(stderr.String(), `setting "yes" ignored`); warned == explicit {
+			t.Errorf("explicit=%v: warned = %v, want %v (stderr: %s)", explicit, warned, !explicit, stderr.String())
+		}
+	}
+}
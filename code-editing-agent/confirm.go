@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ConfirmFunc asks the user whether a tool call that can modify files or run
+// commands may proceed. It returns true to allow the call.
+type ConfirmFunc func(tool string, args map[string]any) bool
+
+// ApproveAll is the ConfirmFunc for --yes: every call is allowed.
+func ApproveAll(tool string, args map[string]any) bool {
+	return true
+}
+
+// ToolPolicy says whether calls to a tool run, are refused, or need approval.
+type ToolPolicy string
+
+const (
+	PolicyAlways ToolPolicy = "always" // Run without asking
+	PolicyNever  ToolPolicy = "never"  // Refuse without running
+	PolicyAsk    ToolPolicy = "ask"    // Ask first, even for read-only tools
+)
+
+// ParseToolPolicies parses a comma-separated list of tool=policy pairs, such
+// as "write_file=ask,run_command=never".
+func ParseToolPolicies(s string) (map[string]ToolPolicy, error) {
+	policies := map[string]ToolPolicy{}
+	for _, entry := range parseList(s) {
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		policy := ToolPolicy(strings.ToLower(strings.TrimSpace(value)))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid tool policy %q: expected tool=policy", entry)
+		}
+		switch policy {
+		case PolicyAlways, PolicyNever, PolicyAsk:
+			policies[name] = policy
+		default:
+			return nil, fmt.Errorf("invalid policy %q for %s: must be always, never, or ask", value, name)
+		}
+	}
+	return policies, nil
+}
+
+// maxConfirmArgsLen bounds how much of a call's arguments the prompt shows.
+const maxConfirmArgsLen = 200
+
+// needsConfirmation reports whether a call to the named tool must be approved
+// first. A tool's policy decides when it has one; otherwise any enabled tool
+// that is not read-only must be, unless this is a dry run and the tool only
+// simulates its changes in one. Tools that run commands are always asked
+// about. Such calls are refused when the agent has no confirm func to ask.
+func (a *Agent) needsConfirmation(name string) bool {
+	if !a.registry.Enabled(name) {
+		return false
+	}
+	switch a.toolPolicies[name] {
+	case PolicyAsk:
+		return true
+	case PolicyAlways:
+		return false
+	}
+	if a.tools.DryRun && a.registry.SimulatesDryRun(name) {
+		return false
+	}
+	return !a.registry.IsReadOnly(name)
+}
+
+// declinedResult tells the model the user refused a tool call.
+func declinedResult(tool string) *ToolResult {
+	return NewErrorResult("permission_denied", fmt.Sprintf("the user declined to run %s", tool), []string{
+		"Ask the user how they would like to proceed instead of retrying the same call",
+	})
+}
+
+// unapprovedResult tells the model a tool call needed approval that no one
+// was there to give, as in a one-shot run without --yes.
+func unapprovedResult(tool string) *ToolResult {
+	return NewErrorResult("permission_denied", fmt.Sprintf("%s needs the user's approval, and this non-interactive run cannot ask for it", tool), []string{
+		"Do what you can with tools that need no approval, and tell the user what was left undone",
+		"The user can rerun with --yes to allow such calls",
+	})
+}
+
+// blockedResult tells the model a tool's policy forbids calling it.
+func blockedResult(tool string) *ToolResult {
+	return NewErrorResult("permission_denied", fmt.Sprintf("%s is blocked by the tool policy", tool), []string{
+		"Use a different tool, or ask the user to change the policy",
+	})
+}
+
+// NewTerminalConfirm returns a ConfirmFunc that prints a y/N prompt to out and
+// reads the answer with readLine. Anything but "y" or "yes" declines.
+func NewTerminalConfirm(out io.Writer, readLine func() (string, bool), style Styler) ConfirmFunc {
+	return func(tool string, args map[string]any) bool {
+		summary, _ := json.Marshal(args)
+		if len(summary) > maxConfirmArgsLen {
+			summary = append(summary[:maxConfirmArgsLen], "..."...)
+		}
+		fmt.Fprint(out, style.Paint(colorYellow, fmt.Sprintf("Allow %s %s? [y/N]", tool, summary)), " ")
+
+		answer, ok := readLine()
+		if !ok {
+			return false
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+}
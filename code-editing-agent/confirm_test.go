@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+func TestParseToolPolicies(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]ToolPolicy
+		wantErr string
+	}{
+		{"", map[string]ToolPolicy{}, ""},
+		{"write_file=ask", map[string]ToolPolicy{"write_file": PolicyAsk}, ""},
+		{" write_file = ALWAYS , run_command=never ", map[string]ToolPolicy{"write_file": PolicyAlways, "run_command": PolicyNever}, ""},
+		{"write_file", nil, "expected tool=policy"},
+		{"=ask", nil, "expected tool=policy"},
+		{"write_file=sometimes", nil, "must be always, never, or ask"},
+	}
+	for _, tt := range tests {
+		got, err := ParseToolPolicies(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseToolPolicies(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseToolPolicies(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// stubConfirm answers every prompt with answer and records the tools asked about.
+type stubConfirm struct {
+	mu     sync.Mutex
+	answer bool
+	asked  []string
+}
+
+func (s *stubConfirm) confirm(tool string, args map[string]any) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.asked = append(s.asked, tool)
+	return s.answer
+}
+
+func TestRunToolPolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		tool      string
+		policy    ToolPolicy
+		dryRun    bool
+		confirm   *stubConfirm // nil leaves the agent with no one to ask
+		wantAsked bool
+		wantRun   bool
+		wantError string
+	}{
+		{"write asks by default, approved", "write_file", "", false, &stubConfirm{answer: true}, true, true, ""},
+		{"write asks by default, declined", "write_file", "", false, &stubConfirm{}, true, false, "declined"},
+		{"always skips the prompt", "write_file", PolicyAlways, false, &stubConfirm{}, false, true, ""},
+		{"never refuses without asking", "write_file", PolicyNever, false, &stubConfirm{answer: true}, false, false, "blocked by the tool policy"},
+		{"never applies to read-only tools", "list_files", PolicyNever, false, &stubConfirm{answer: true}, false, false, "blocked by the tool policy"},
+		{"read-only runs without asking", "list_files", "", false, &stubConfirm{}, false, true, ""},
+		{"ask applies to read-only tools", "list_files", PolicyAsk, false, &stubConfirm{}, true, false, "declined"},
+		{"no one to ask refuses writes", "write_file", "", false, nil, false, false, "non-interactive"},
+		{"no one to ask refuses ask policies", "list_files", PolicyAsk, false, nil, false, false, "non-interactive"},
+		{"no one to ask still runs always", "write_file", PolicyAlways, false, nil, false, true, ""},
+		{"no one to ask still runs read-only tools", "list_files", "", false, nil, false, true, ""},
+		{"dry run skips the prompt for simulated writes", "write_file", "", true, &stubConfirm{}, false, false, ""},
+		{"run_command in a dry run still asks", "run_command", "", true, &stubConfirm{}, true, false, "declined"},
+		{"run_command in a dry run runs once approved", "run_command", "", true, &stubConfirm{answer: true}, true, true, ""},
+		{"run_command in a dry run with no one to ask is refused", "run_command", "", true, nil, false, false, "non-interactive"},
+		{"run_tests in a dry run still asks", "run_tests", "", true, &stubConfirm{}, true, false, "declined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := newTestAgent(t, NewScriptedClient(), func(cfg *Config) {
+				cfg.DryRun = tt.dryRun
+				cfg.AllowedCommands = []string{"touch"}
+			})
+			if tt.policy != "" {
+				agent.toolPolicies = map[string]ToolPolicy{tt.tool: tt.policy}
+			}
+			if tt.confirm != nil {
+				agent.confirm = tt.confirm.confirm
+			}
+
+			// Calls that change something leave a file behind when they run
+			args, changed := map[string]any{"path": "."}, ""
+			switch tt.tool {
+			case "write_file":
+				args, changed = map[string]any{"path": "out.txt", "content": "hello"}, "out.txt"
+			case "run_command":
+				args, changed = map[string]any{"command": "touch", "args": []any{"ran.txt"}}, "ran.txt"
+			case "run_tests":
+				args = map[string]any{}
+			}
+			result := agent.runTool(context.Background(), &genai.FunctionCall{Name: tt.tool, Args: args})
+
+			if tt.confirm != nil {
+				if asked := len(tt.confirm.asked) > 0; asked != tt.wantAsked {
+					t.Errorf("confirm asked = %v, want %v", asked, tt.wantAsked)
+				}
+			}
+			if tt.wantError != "" {
+				if result.OK || result.Error.Code != "permission_denied" || !strings.Contains(result.Error.Message, tt.wantError) {
+					t.Errorf("result = %+v, want permission_denied containing %q", result, tt.wantError)
+				}
+			} else if !result.OK {
+				t.Errorf("result = %+v, want success", result.Error)
+			}
+			if changed != "" {
+				_, err := os.Stat(filepath.Join(agent.sandbox.Root, changed))
+				if wrote := err == nil; wrote != tt.wantRun {
+					t.Errorf("%s written = %v, want %v", changed, wrote, tt.wantRun)
+				}
+			}
+		})
+	}
+}
+
+func TestConfirmationIsSerialized(t *testing.T) {
+	agent := newTestAgent(t, NewScriptedClient(), nil)
+	agent.toolPolicies = map[string]ToolPolicy{"list_files": PolicyAsk, "stat_file": PolicyAsk}
+
+	var active, overlaps atomic.Int32
+	var mu sync.Mutex
+	var order []string
+	agent.confirm = func(tool string, args map[string]any) bool {
+		if active.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		defer active.Add(-1)
+		time.Sleep(10 * time.Millisecond) // A user taking a moment to answer
+		mu.Lock()
+		order = append(order, args["path"].(string))
+		mu.Unlock()
+		return true
+	}
+
+	var calls []*genai.FunctionCall
+	var want []string
+	for i := range 6 {
+		name := "list_files"
+		if i%2 == 1 {
+			name = "stat_file"
+		}
+		path := "." + strings.Repeat("/.", i)
+		calls = append(calls, &genai.FunctionCall{Name: name, Args: map[string]any{"path": path}})
+		want = append(want, path)
+	}
+	parts := agent.executeToolCalls(context.Background(), calls, newRepeatTracker(0))
+
+	if n := overlaps.Load(); n > 0 {
+		t.Errorf("confirm was asked %d times while another prompt was open", n)
+	}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("prompts in order %v, want call order %v", order, want)
+	}
+	for i, part := range parts {
+		if part.FunctionResponse.Name != calls[i].Name {
+			t.Errorf("response %d is for %s, want %s", i, part.FunctionResponse.Name, calls[i].Name)
+		}
+	}
+}
+
+func TestOneShotNeedsYesForWrites(t *testing.T) {
+	for _, yes := range []bool{false, true} {
+		dir := t.TempDir()
+		replay := filepath.Join(dir, "trace.jsonl")
+		records := `{"type":"response","content":{"role":"model","parts":[{"functionCall":{"name":"write_file","args":{"path":"out.txt","content":"hello"}}}]}}` + "\n" +
+			`{"type":"response","content":{"role":"model","parts":[{"text":"Done."}]}}` + "\n"
+		if err := os.WriteFile(replay, []byte(records), 0644); err != nil {
+			t.Fatal(err)
+		}
+		root := filepath.Join(dir, "project")
+		if err := os.Mkdir(root, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		args := []string{"agent", "--replay", replay, "--root", root, "--no-preflight", "--history-file", "", "--prompt", "write it"}
+		if yes {
+			args = append(args, "--yes")
+		}
+		var stdout, stderr bytes.Buffer
+		if code := run(args, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+			t.Fatalf("yes=%v: run exit code = %d, want %d (stderr: %s)", yes, code, exitOK, stderr.String())
+		}
+		_, err := os.Stat(filepath.Join(root, "out.txt"))
+		if wrote := err == nil; wrote != yes {
+			t.Errorf("yes=%v: file written = %v, want %v", yes, wrote, yes)
+		}
+	}
+}
+
+func TestNeedsConfirmation(t *testing.T) {
+	tests := []struct {
+		name     string
+		tool     string
+		policy   ToolPolicy
+		dryRun   bool
+		disabled bool
+		want     bool
+	}{
+		{"write", "write_file", "", false, false, true},
+		{"delete", "delete_file", "", false, false, true},
+		{"read", "read_file", "", false, false, false},
+		{"write in a dry run", "write_file", "", true, false, false},
+		{"ask in a dry run", "write_file", PolicyAsk, true, false, true},
+		{"always", "delete_file", PolicyAlways, false, false, false},
+		{"ask on a read", "read_file", PolicyAsk, false, false, true},
+		{"disabled", "write_file", "", false, true, false},
+		{"unknown tool", "no_such_tool", "", false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := newTestAgent(t, NewScriptedClient(), nil)
+			agent.tools.DryRun = tt.dryRun
+			if tt.policy != "" {
+				agent.toolPolicies = map[string]ToolPolicy{tt.tool: tt.policy}
+			}
+			if tt.disabled {
+				if err := agent.registry.Restrict(nil, []string{tt.tool}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := agent.needsConfirmation(tt.tool); got != tt.want {
+				t.Errorf("needsConfirmation(%s) = %v, want %v", tt.tool, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminalConfirm(t *testing.T) {
+	tests := []struct {
+		answer     string
+		ok         bool // Whether a line could be read
+		args       map[string]any
+		want       bool
+		wantPrompt string
+	}{
+		{"y", true, map[string]any{"path": "a.txt"}, true, `Allow write_file {"path":"a.txt"}? [y/N] `},
+		{"YES\n", true, nil, true, "Allow write_file null? [y/N] "},
+		{"  yes  ", true, nil, true, ""},
+		{"n", true, nil, false, ""},
+		{"", true, nil, false, ""},
+		{"yep", true, nil, false, ""},
+		{"y", false, nil, false, ""},
+		{"y", true, map[string]any{"content": strings.Repeat("x", 500)}, true, `Allow write_file {"content":"` + strings.Repeat("x", maxConfirmArgsLen-12) + `...? [y/N] `},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		confirm := NewTerminalConfirm(&out, func() (string, bool) { return tt.answer, tt.ok }, Styler{})
+		if got := confirm("write_file", tt.args); got != tt.want {
+			t.Errorf("answer %q (ok %v) = %v, want %v", tt.answer, tt.ok, got, tt.want)
+		}
+		if tt.wantPrompt != "" && out.String() != tt.wantPrompt {
+			t.Errorf("answer %q: prompt = %q, want %q", tt.answer, out.String(), tt.wantPrompt)
+		}
+	}
+}
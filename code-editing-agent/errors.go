@@ -1,10 +1,13 @@
 package main
 
+import "fmt"
+
 // ToolError represents a structured error from a tool call.
 type ToolError struct {
 	Code        string   `json:"code"`
 	Message     string   `json:"message"`
 	Suggestions []string `json:"suggestions,omitempty"`
+	Candidates  []string `json:"candidates,omitempty"` // Raw names behind the suggestions, for programmatic use
 }
 
 // ToolResult is the result envelope for all tool calls.
@@ -23,11 +26,15 @@ func (r *ToolResult) AsMap() map[string]any {
 		result["data"] = r.Data
 	}
 	if r.Error != nil {
-		result["error"] = map[string]any{
+		errMap := map[string]any{
 			"code":        r.Error.Code,
 			"message":     r.Error.Message,
 			"suggestions": r.Error.Suggestions,
 		}
+		if len(r.Error.Candidates) > 0 {
+			errMap["candidates"] = r.Error.Candidates
+		}
+		result["error"] = errMap
 	}
 	return result
 }
@@ -40,6 +47,14 @@ func NewSuccessResult(data map[string]any) *ToolResult {
 	}
 }
 
+// simulatedResult creates a successful result for a dry-run operation.
+// The data is marked so the model knows no change was actually made.
+func simulatedResult(data map[string]any) *ToolResult {
+	data["simulated"] = true
+	data["message"] = fmt.Sprintf("dry run: %s (no changes were made)", data["message"])
+	return NewSuccessResult(data)
+}
+
 // NewErrorResult creates a failed tool result.
 func NewErrorResult(code, message string, suggestions []string) *ToolResult {
 	return &ToolResult{
@@ -54,5 +69,7 @@ func NewErrorResult(code, message string, suggestions []string) *ToolResult {
 
 // NewErrorResultFromSandbox converts a SandboxError to a ToolResult.
 func NewErrorResultFromSandbox(err *SandboxError) *ToolResult {
-	return NewErrorResult(err.Code, err.Message, err.Suggestions)
+	result := NewErrorResult(err.Code, err.Message, err.Suggestions)
+	result.Error.Candidates = err.Candidates
+	return result
 }
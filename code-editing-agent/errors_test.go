@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAsMap(t *testing.T) {
+	withCandidates := NewErrorResultFromSandbox(&SandboxError{
+		Code:        "not_found",
+		Message:     "path not found: mian.go",
+		Suggestions: formatSuggestions([]string{"main.go", "main_test.go"}),
+		Candidates:  []string{"main.go", "main_test.go"},
+	})
+	tests := []struct {
+		name   string
+		result *ToolResult
+		want   map[string]any
+	}{
+		{"success", NewSuccessResult(map[string]any{"content": "x"}),
+			map[string]any{"ok": true, "data": map[string]any{"content": "x"}}},
+		{"success without data", NewSuccessResult(nil),
+			map[string]any{"ok": true}},
+		{"error", NewErrorResult("io_error", "failed", []string{"Try again"}),
+			map[string]any{"ok": false, "error": map[string]any{"code": "io_error", "message": "failed", "suggestions": []string{"Try again"}}}},
+		{"error with candidates", withCandidates,
+			map[string]any{"ok": false, "error": map[string]any{
+				"code":        "not_found",
+				"message":     "path not found: mian.go",
+				"suggestions": []string{"Did you mean 'main.go'?", "Did you mean 'main_test.go'?"},
+				"candidates":  []string{"main.go", "main_test.go"},
+			}}},
+	}
+	for _, tt := range tests {
+		if got := tt.result.AsMap(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: AsMap = %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSuggestions(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{nil, nil},
+		{[]string{"a.go"}, []string{"Did you mean 'a.go'?"}},
+		{[]string{"a", "b", "c", "d"}, []string{"Did you mean 'a'?", "Did you mean 'b'?", "Did you mean 'c'?"}},
+	}
+	for _, tt := range tests {
+		if got := formatSuggestions(tt.names); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("formatSuggestions(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestNotFoundCarriesCandidates(t *testing.T) {
+	tc := newTestToolContext(t, map[string]string{"main.go": "", "README.md": ""})
+	result := readFile(context.Background(), map[string]any{"path": "mai"}, tc)
+	if result.OK {
+		t.Fatal("read_file of a missing file succeeded")
+	}
+
+	// Both forms survive the trip to the model as JSON
+	var decoded struct {
+		Error struct {
+			Code        string   `json:"code"`
+			Suggestions []string `json:"suggestions"`
+			Candidates  []string `json:"candidates"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(resultJSON(t, result)), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Error.Code != "not_found" {
+		t.Errorf("code = %s, want not_found", decoded.Error.Code)
+	}
+	if want := []string{"main.go"}; !reflect.DeepEqual(decoded.Error.Candidates, want) {
+		t.Errorf("candidates = %q, want %q", decoded.Error.Candidates, want)
+	}
+	if want := []string{"Did you mean 'main.go'?"}; !reflect.DeepEqual(decoded.Error.Suggestions, want) {
+		t.Errorf("suggestions = %q, want %q", decoded.Error.Suggestions, want)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// EventSink receives the agent's activity as it happens.
+// Implementations let callers render or record a session without parsing stdout.
+type EventSink interface {
+	// OnModelWaiting is called when a request is sent, before any of the
+	// response arrives.
+	OnModelWaiting()
+	// OnModelText is called for each chunk of streamed model text.
+	OnModelText(text string)
+	// OnModelDone is called when a model response has finished streaming or
+	// the request failed.
+	OnModelDone()
+	// OnToolCall is called before a tool is executed.
+	OnToolCall(call *genai.FunctionCall)
+	// OnToolResult is called with the result sent back to the model for a call.
+	OnToolResult(call *genai.FunctionCall, result *ToolResult)
+}
+
+// TerminalSink renders agent events as terminal output, colored unless its
+// Styler is off.
+type TerminalSink struct {
+	Verbose  io.Writer // If set, receives each tool result as indented JSON
+	Markdown bool      // Render each complete response as Markdown instead of streaming it raw
+	Spinner  bool      // Animate a spinner while waiting for a response; needs a terminal
+
+	out       io.Writer
+	style     Styler
+	wrapWidth int             // Columns to soft-wrap model text at; 0 disables
+	wrapper   *wordWrapper    // Wraps the current response while streaming
+	streaming bool            // True once the current response has printed text
+	pending   strings.Builder // The current response, held for Markdown rendering
+
+	spinnerStop chan struct{} // Closed to stop the running spinner
+	spinnerDone chan struct{} // Closed once the spinner has stopped drawing
+}
+
+// spinnerFrames are drawn in turn while waiting for a response.
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// spinnerInterval is how long each spinner frame is shown.
+const spinnerInterval = 100 * time.Millisecond
+
+// modelPrefix labels each model response.
+const modelPrefix = "Gemini: "
+
+// NewTerminalSink creates a TerminalSink writing to out. A positive wrapWidth
+// soft-wraps model text at that many columns.
+func NewTerminalSink(out io.Writer, style Styler, wrapWidth int) *TerminalSink {
+	return &TerminalSink{out: out, style: style, wrapWidth: wrapWidth}
+}
+
+// OnModelWaiting starts the spinner when Spinner is set.
+func (t *TerminalSink) OnModelWaiting() {
+	if !t.Spinner || t.spinnerStop != nil {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	t.spinnerStop, t.spinnerDone = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(spinnerInterval)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprint(t.out, "\r", t.style.Paint(colorGray, spinnerFrames[i%len(spinnerFrames)]))
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stopSpinner stops the spinner, if it is running, and erases it so the
+// next output starts on a clean line.
+func (t *TerminalSink) stopSpinner() {
+	if t.spinnerStop == nil {
+		return
+	}
+	close(t.spinnerStop)
+	<-t.spinnerDone
+	t.spinnerStop, t.spinnerDone = nil, nil
+	fmt.Fprint(t.out, "\r\033[K")
+}
+
+// OnModelText prints streamed text, prefixing the first chunk of each
+// response. With Markdown set, the text is held until the response is done.
+func (t *TerminalSink) OnModelText(text string) {
+	t.stopSpinner()
+	var dest io.Writer = t.out
+	if t.Markdown {
+		dest = &t.pending
+	}
+	if !t.streaming {
+		fmt.Fprint(t.out, t.style.Paint(colorYellow, strings.TrimSpace(modelPrefix)), " ")
+		t.streaming = true
+		if t.wrapWidth > 0 {
+			t.wrapper = newWordWrapper(dest, t.wrapWidth, len(modelPrefix))
+		}
+	}
+	if t.wrapper != nil {
+		t.wrapper.WriteString(text)
+		return
+	}
+	fmt.Fprint(dest, text)
+}
+
+// OnModelDone ends the current line of streamed text.
+func (t *TerminalSink) OnModelDone() {
+	t.stopSpinner()
+	if t.streaming {
+		if t.wrapper != nil {
+			t.wrapper.Flush()
+			t.wrapper = nil
+		}
+		if t.Markdown {
+			fmt.Fprint(t.out, renderMarkdown(t.pending.String(), t.style))
+			t.pending.Reset()
+		}
+		fmt.Fprintln(t.out) // Newline after streaming text
+		t.streaming = false
+	}
+}
+
+// OnToolCall prints the name of the tool being called.
+func (t *TerminalSink) OnToolCall(call *genai.FunctionCall) {
+	t.stopSpinner()
+	fmt.Fprintln(t.out, t.style.Paint(colorGreen, glyphToolCall+" "+call.Name))
+}
+
+// OnToolResult echoes the result exactly as the model will receive it when
+// Verbose is set.
+func (t *TerminalSink) OnToolResult(call *genai.FunctionCall, result *ToolResult) {
+	if t.Verbose == nil {
+		return
+	}
+	data, err := json.MarshalIndent(result.AsMap(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(t.Verbose, "%s %s: failed to encode result: %v\n", glyphToolResult, call.Name, err)
+		return
+	}
+	fmt.Fprintf(t.Verbose, "%s %s\n%s\n", glyphToolResult, call.Name, data)
+}
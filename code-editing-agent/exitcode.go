@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Process exit codes, so scripts can tell why the agent stopped.
+const (
+	exitOK          = 0   // The session ended normally, including at end of input
+	exitError       = 1   // A runtime failure: the model, a tool, or reading or writing files
+	exitUsage       = 2   // Invalid flags, config, or other settings (as the flag package uses)
+	exitAuth        = 3   // Missing or rejected credentials, or an unusable backend setup
+	exitUnavailable = 4   // The API could not be reached
+	exitInterrupted = 130 // Stopped by ctrl-c (128 + SIGINT)
+)
+
+// exitCodeFor picks the exit code for an error that ended the run. ctx is
+// the session's signal context, so a run cut short by ctrl-c is told apart
+// from one that failed.
+func exitCodeFor(ctx context.Context, err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	if errors.Is(err, errCredentialsRejected) {
+		return exitAuth
+	}
+	if apiErr, ok := asAPIError(err); ok && isAuthError(apiErr) {
+		return exitAuth
+	}
+	if errors.Is(err, errUnreachable) {
+		return exitUnavailable
+	}
+	if errors.Is(err, errUnknownModel) {
+		return exitUsage
+	}
+	return exitError
+}
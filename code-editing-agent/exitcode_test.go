@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want int
+	}{
+		{"no error", context.Background(), nil, exitOK},
+		{"no error after ctrl-c", cancelled, nil, exitOK},
+		{"runtime failure", context.Background(), errors.New("failed to write file"), exitError},
+		{"model error", context.Background(), genai.APIError{Code: 500, Message: "Internal error."}, exitError},
+		{"rate limited", context.Background(), &genai.APIError{Code: 429, Message: "Quota exceeded."}, exitError},
+		{"rejected by preflight", context.Background(), fmt.Errorf("%w: bad key", errCredentialsRejected), exitAuth},
+		{"rejected mid-session", context.Background(), fmt.Errorf("turn failed: %w", genai.APIError{Code: 401, Message: "Unauthenticated"}), exitAuth},
+		{"rejected key as a pointer", context.Background(), &genai.APIError{Code: 400, Message: "API key not valid."}, exitAuth},
+		{"unreachable", context.Background(), fmt.Errorf("%w: %w", errUnreachable, &net.DNSError{Err: "no such host"}), exitUnavailable},
+		{"unknown model", context.Background(), fmt.Errorf("%w %q", errUnknownModel, "gemini-z"), exitUsage},
+		{"interrupted", cancelled, context.Canceled, exitInterrupted},
+		{"interrupted wins over the cause", cancelled, errCredentialsRejected, exitInterrupted},
+	}
+	for _, tt := range tests {
+		if got := exitCodeFor(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("%s: exitCodeFor(%v) = %d, want %d", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	writeReplay := func(name string, responses ...string) string {
+		var b strings.Builder
+		for _, r := range responses {
+			b.WriteString(`{"type":"response",` + r + "}\n")
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	answer := writeReplay("answer.jsonl", `"content":{"role":"model","parts":[{"text":"Hello"}]}`)
+	failure := writeReplay("failure.jsonl", `"error":"Invalid argument.","error_code":400`)
+	rejected := writeReplay("rejected.jsonl", `"error":"API key not valid.","error_code":400`)
+
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		env        map[string]string
+		wantCode   int
+		wantStderr string
+	}{
+		{name: "clean end of input", args: []string{"--replay", answer}, stdin: "", wantCode: exitOK},
+		{name: "session ends after a turn", args: []string{"--replay", answer}, stdin: "hi\n", wantCode: exitOK},
+		{name: "one-shot answer", args: []string{"--replay", answer, "--prompt", "hi"}, wantCode: exitOK},
+		{name: "one-shot model error", args: []string{"--replay", failure, "--prompt", "hi"},
+			wantCode: exitError, wantStderr: "Error running agent"},
+		{name: "interactive model error", args: []string{"--replay", failure}, stdin: "hi\n",
+			wantCode: exitError, wantStderr: "Error running agent"},
+		{name: "credentials rejected mid-session", args: []string{"--replay", rejected, "--prompt", "hi"},
+			wantCode: exitAuth, wantStderr: "API key not valid"},
+		{name: "missing replay", args: []string{"--replay", filepath.Join(dir, "missing.jsonl"), "--prompt", "hi"},
+			wantCode: exitError, wantStderr: "Error loading replay"},
+		{name: "missing credentials", args: []string{"--prompt", "hi"},
+			env:      map[string]string{"GEMINI_API_KEY": "", "GOOGLE_API_KEY": "", "GOOGLE_GENAI_USE_VERTEXAI": ""},
+			wantCode: exitAuth, wantStderr: "Error configuring client"},
+		{name: "bad setting", args: []string{"--replay", answer, "--follow-symlinks", "sometimes"},
+			wantCode: exitUsage, wantStderr: "Error configuring sandbox"},
+		{name: "missing root", args: []string{"--replay", answer, "--root", filepath.Join(dir, "missing")},
+			wantCode: exitUsage, wantStderr: "Error creating sandbox"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"agent", "--root", dir, "--no-preflight", "--history-file", ""}, tt.args...)
+			code := run(args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Errorf("run exit code = %d, want %d (stderr: %s)", code, tt.wantCode, stderr.String())
+			}
+			if tt.wantStderr == "" && stderr.Len() > 0 {
+				t.Errorf("stderr = %q, want nothing", stderr.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
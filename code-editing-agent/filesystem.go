@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// File is an open file as returned by FileSystem.Open.
+type File interface {
+	io.ReadSeekCloser
+	Stat() (os.FileInfo, error)
+}
+
+// FileSystem is all the file access the sandbox and tools perform, on
+// absolute paths. Resolve checks paths through it too, so an implementation
+// may present any tree under the root: the local disk, an in-memory tree for
+// tests (see MemFileSystem), or a wrapper that records calls or injects
+// failures.
+type FileSystem interface {
+	Open(name string) (File, error)
+	ReadFile(name string) ([]byte, error)
+	// WriteFile replaces name with data, keeping an existing file's mode.
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	// AppendFile appends data to name, creating it with perm if it does not
+	// exist, and returns the file's new size.
+	AppendFile(name string, data []byte, perm os.FileMode) (int64, error)
+	// Truncate cuts the existing file name down to size bytes in place.
+	Truncate(name string, size int64) error
+	ReadDir(name string) ([]os.DirEntry, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	// EvalSymlinks returns name with every symlink in it resolved, as
+	// filepath.EvalSymlinks does. It fails if name does not exist.
+	EvalSymlinks(name string) (string, error)
+	Mkdir(name string, perm os.FileMode) error
+	MkdirAll(name string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+}
+
+// osFileSystem is the FileSystem backed by the local disk.
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(name, data, perm)
+}
+
+func (osFileSystem) AppendFile(name string, data []byte, perm os.FileMode) (int64, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		return 0, err
+	}
+	return appendFile(f, data)
+}
+
+func (osFileSystem) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+func (osFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFileSystem) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+func (osFileSystem) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (osFileSystem) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// rootFileSystem is the FileSystem backed by the local disk through an
+// os.Root. Every path is opened relative to the root directory one component
+// at a time, and a symlink that leads outside the root is refused when the
+// file is opened, not only when Resolve checked it. That closes the window in
+// which a symlink swapped after Resolve could send a read or write outside
+// the root. Symlinks that stay inside the root are still followed.
+type rootFileSystem struct {
+	root *os.Root
+	dir  string // Absolute path of the root, which resolved paths start with
+}
+
+// newRootFileSystem opens dir as the root all access is confined to.
+func newRootFileSystem(dir string) (*rootFileSystem, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &rootFileSystem{root: root, dir: dir}, nil
+}
+
+// rel turns a resolved absolute path into one relative to the root.
+func (r *rootFileSystem) rel(op, name string) (string, error) {
+	rel, err := filepath.Rel(r.dir, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: errors.New("path escapes from the sandbox root")}
+	}
+	return rel, nil
+}
+
+func (r *rootFileSystem) Open(name string) (File, error) {
+	rel, err := r.rel("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return r.root.Open(rel)
+}
+
+func (r *rootFileSystem) ReadFile(name string) ([]byte, error) {
+	rel, err := r.rel("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return r.root.ReadFile(rel)
+}
+
+// WriteFile writes through a temporary file in the same directory and renames
+// it into place, like writeFileAtomic, with every step inside the root.
+func (r *rootFileSystem) WriteFile(name string, data []byte, perm os.FileMode) (err error) {
+	rel, err := r.rel("write", name)
+	if err != nil {
+		return err
+	}
+	if info, statErr := r.root.Stat(rel); statErr == nil {
+		perm = info.Mode().Perm()
+	}
+
+	var tmp *os.File
+	var tmpRel string
+	for range 10 {
+		tmpRel = filepath.Join(filepath.Dir(rel), fmt.Sprintf(".%s.tmp-%d", filepath.Base(rel), rand.Uint32()))
+		tmp, err = r.root.OpenFile(tmpRel, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			r.root.Remove(tmpRel)
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = r.root.Chmod(tmpRel, perm); err != nil {
+		return err
+	}
+	return r.root.Rename(tmpRel, rel)
+}
+
+func (r *rootFileSystem) AppendFile(name string, data []byte, perm os.FileMode) (int64, error) {
+	rel, err := r.rel("append", name)
+	if err != nil {
+		return 0, err
+	}
+	f, err := r.root.OpenFile(rel, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		return 0, err
+	}
+	return appendFile(f, data)
+}
+
+func (r *rootFileSystem) Truncate(name string, size int64) error {
+	rel, err := r.rel("truncate", name)
+	if err != nil {
+		return err
+	}
+	f, err := r.root.OpenFile(rel, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (r *rootFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	rel, err := r.rel("open", name)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := r.root.Open(rel)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	entries, err := dir.ReadDir(-1)
+	// Sorted by name, as os.ReadDir returns them
+	slices.SortFunc(entries, func(a, b os.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, err
+}
+
+func (r *rootFileSystem) Stat(name string) (os.FileInfo, error) {
+	rel, err := r.rel("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return r.root.Stat(rel)
+}
+
+func (r *rootFileSystem) Lstat(name string) (os.FileInfo, error) {
+	rel, err := r.rel("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return r.root.Lstat(rel)
+}
+
+// EvalSymlinks resolves name on the disk. Opening the result still goes
+// through the root, so a link swapped in afterwards cannot lead outside it.
+func (r *rootFileSystem) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+func (r *rootFileSystem) Mkdir(name string, perm os.FileMode) error {
+	rel, err := r.rel("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return r.root.Mkdir(rel, perm)
+}
+
+func (r *rootFileSystem) MkdirAll(name string, perm os.FileMode) error {
+	rel, err := r.rel("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return r.root.MkdirAll(rel, perm)
+}
+
+func (r *rootFileSystem) Remove(name string) error {
+	rel, err := r.rel("remove", name)
+	if err != nil {
+		return err
+	}
+	return r.root.Remove(rel)
+}
+
+func (r *rootFileSystem) Rename(oldpath, newpath string) error {
+	oldRel, err := r.rel("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	newRel, err := r.rel("rename", newpath)
+	if err != nil {
+		return err
+	}
+	return r.root.Rename(oldRel, newRel)
+}
+
+// appendFile writes data to f, opened for appending, closes it, and returns
+// its size afterwards.
+func appendFile(f *os.File, data []byte) (int64, error) {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	return info.Size(), f.Close()
+}
+
+// walkDir is filepath.WalkDir over fsys: it calls fn for root and everything
+// under it, in lexical order, without following symlinks.
+func walkDir(fsys FileSystem, root string, fn fs.WalkDirFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDirEntry(fsys, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDirEntry(fsys FileSystem, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			// Skipped this directory
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		// Second call, to report the ReadDir error
+		if err = fn(path, d, err); err != nil {
+			if err == fs.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if err := walkDirEntry(fsys, filepath.Join(path, entry.Name()), entry, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
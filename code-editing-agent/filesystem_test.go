@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// diskFileSystems returns each FileSystem backed by the local disk, rooted at
+// dir where that matters.
+func diskFileSystems(t *testing.T, dir string) map[string]FileSystem {
+	t.Helper()
+	root, err := newRootFileSystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.root.Close() })
+	return map[string]FileSystem{"os": osFileSystem{}, "root": root}
+}
+
+// checkNoTempFiles fails if a write left a temporary file behind in dir.
+func checkNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		data    string
+		wantErr bool
+		want    string // Content of path afterwards
+	}{
+		{"new file", "new.txt", "fresh", false, "fresh"},
+		{"overwrite", "old.txt", "replaced", false, "replaced"},
+		{"overwrite with nothing", "old.txt", "", false, ""},
+		{"missing directory", "nowhere/new.txt", "x", true, ""},
+		{"over a directory", "dir", "x", true, ""},
+	}
+	for _, tt := range tests {
+		for fsName := range diskFileSystems(t, t.TempDir()) {
+			t.Run(fsName+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				writeTree(t, dir, map[string]string{"old.txt": "original", "dir/keep": ""})
+				fsys := diskFileSystems(t, dir)[fsName]
+				path := filepath.Join(dir, tt.path)
+
+				// A reader that opened the file before the write keeps seeing
+				// the old contents, since the file is replaced, not rewritten
+				before, err := os.Open(filepath.Join(dir, "old.txt"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer before.Close()
+
+				err = fsys.WriteFile(path, []byte(tt.data), 0644)
+				if gotErr := err != nil; gotErr != tt.wantErr {
+					t.Fatalf("WriteFile error = %v, want error %v", err, tt.wantErr)
+				}
+				if !tt.wantErr {
+					if got, _ := os.ReadFile(path); string(got) != tt.want {
+						t.Errorf("%s = %q, want %q", tt.path, got, tt.want)
+					}
+				}
+				if old, _ := io.ReadAll(before); string(old) != "original" {
+					t.Errorf("open reader saw %q, want the original contents", old)
+				}
+				checkNoTempFiles(t, dir)
+				checkNoTempFiles(t, filepath.Join(dir, "dir"))
+			})
+		}
+	}
+}
+
+func TestSwappedSymlinkIsRefused(t *testing.T) {
+	tests := []struct {
+		name   string
+		access PathAccess
+		op     func(fsys FileSystem, path string) error
+	}{
+		{"read", AccessReadFile, func(fsys FileSystem, path string) error {
+			_, err := fsys.ReadFile(path)
+			return err
+		}},
+		{"open", AccessReadFile, func(fsys FileSystem, path string) error {
+			f, err := fsys.Open(path)
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{"stat", AccessReadFile, func(fsys FileSystem, path string) error {
+			_, err := fsys.Stat(path)
+			return err
+		}},
+		{"list", AccessListDir, func(fsys FileSystem, path string) error {
+			_, err := fsys.ReadDir(filepath.Dir(path))
+			return err
+		}},
+		{"write", AccessWriteFile, func(fsys FileSystem, path string) error {
+			return fsys.WriteFile(path, []byte("overwritten"), 0644)
+		}},
+		{"remove", AccessDeleteFile, func(fsys FileSystem, path string) error {
+			return fsys.Remove(path)
+		}},
+		{"rename", AccessMoveFile, func(fsys FileSystem, path string) error {
+			return fsys.Rename(path, path+".moved")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, outside := t.TempDir(), t.TempDir()
+			writeTree(t, root, map[string]string{"sub/file.txt": "inside"})
+			writeTree(t, outside, map[string]string{"file.txt": "secret"})
+			sandbox, err := NewPathSandbox(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resolved, err := sandbox.Resolve("sub/file.txt", tt.access)
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+
+			// Between Resolve and the access, sub becomes a link out of the root
+			if err := os.Rename(filepath.Join(root, "sub"), filepath.Join(root, "old")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(outside, filepath.Join(root, "sub")); err != nil {
+				t.Skipf("cannot create symlinks: %v", err)
+			}
+
+			if err := tt.op(sandbox.FS, resolved); err == nil {
+				t.Errorf("%s through the swapped link succeeded", tt.name)
+			}
+			entries, _ := os.ReadDir(outside)
+			if got, _ := os.ReadFile(filepath.Join(outside, "file.txt")); string(got) != "secret" || len(entries) != 1 {
+				t.Errorf("outside directory changed: file.txt = %q, %d entries", got, len(entries))
+			}
+		})
+	}
+}
+
+func TestRootFileSystemRefusesOutsidePaths(t *testing.T) {
+	dir := t.TempDir()
+	fsys, err := newRootFileSystem(filepath.Join(dir, "root"))
+	if err == nil {
+		t.Fatal("newRootFileSystem opened a missing directory")
+	}
+	if err := os.Mkdir(filepath.Join(dir, "root"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if fsys, err = newRootFileSystem(filepath.Join(dir, "root")); err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.root.Close()
+	writeTree(t, dir, map[string]string{"outside.txt": "secret"})
+
+	for _, name := range []string{filepath.Join(dir, "outside.txt"), dir, filepath.Join(dir, "root", "..", "outside.txt")} {
+		if _, err := fsys.ReadFile(name); err == nil || !strings.Contains(err.Error(), "escapes") {
+			t.Errorf("ReadFile(%s) = %v, want an escape error", name, err)
+		}
+		if err := fsys.WriteFile(name, []byte("x"), 0644); err == nil {
+			t.Errorf("WriteFile(%s) succeeded", name)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "outside.txt")); string(got) != "secret" {
+		t.Errorf("outside.txt = %q after refused writes", got)
+	}
+}
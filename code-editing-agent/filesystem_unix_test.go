@@ -0,0 +1,78 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestWriteFileFailureKeepsOriginal makes the write to the temporary file
+// fail partway by lowering the file size limit, then checks the file being
+// replaced is untouched. The limit applies to the whole process, so the write
+// runs in a child copy of the test binary where nothing else is writing.
+func TestWriteFileFailureKeepsOriginal(t *testing.T) {
+	if fsName := os.Getenv("AGENT_TEST_LIMITED_WRITE"); fsName != "" {
+		limitedWrite(fsName, os.Getenv("AGENT_TEST_DIR"))
+		return
+	}
+
+	for _, fsName := range []string{"os", "root"} {
+		t.Run(fsName, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, map[string]string{"data.txt": "original contents"})
+
+			cmd := exec.Command(os.Args[0], "-test.run=^TestWriteFileFailureKeepsOriginal$")
+			cmd.Env = append(os.Environ(), "AGENT_TEST_LIMITED_WRITE="+fsName, "AGENT_TEST_DIR="+dir)
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("limited write: %v\n%s", err, out)
+			}
+
+			if got, _ := os.ReadFile(filepath.Join(dir, "data.txt")); string(got) != "original contents" {
+				t.Errorf("data.txt = %q after a failed write, want the original", got)
+			}
+			checkNoTempFiles(t, dir)
+		})
+	}
+}
+
+// limitedWrite runs in the child process. It tries to replace data.txt in dir
+// with more bytes than the file size limit allows and exits nonzero unless the
+// write fails.
+func limitedWrite(fsName, dir string) {
+	fail := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+		os.Exit(1)
+	}
+	var fsys FileSystem = osFileSystem{}
+	if fsName == "root" {
+		root, err := newRootFileSystem(dir)
+		if err != nil {
+			fail("open root: %v", err)
+		}
+		fsys = root
+	}
+
+	// Without this, crossing the limit kills the process with SIGXFSZ
+	signal.Ignore(syscall.SIGXFSZ)
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_FSIZE, &limit); err != nil {
+		fail("read the file size limit: %v", err)
+	}
+	limit.Cur = 8
+	if err := unix.Setrlimit(unix.RLIMIT_FSIZE, &limit); err != nil {
+		fail("lower the file size limit: %v", err)
+	}
+	if err := fsys.WriteFile(filepath.Join(dir, "data.txt"), make([]byte, 4096), 0644); err == nil {
+		fail("WriteFile succeeded past the file size limit")
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// defaultFormatters maps file extensions to the formatter format_code runs
+// when no override is configured. Each command reads the file on stdin and
+// writes the formatted source to stdout; {path} expands to the file's path
+// relative to the project root, for formatters that pick a parser by name.
+var defaultFormatters = map[string]string{
+	".go":   "gofmt",
+	".py":   "black -q -",
+	".js":   "prettier --stdin-filepath {path}",
+	".jsx":  "prettier --stdin-filepath {path}",
+	".ts":   "prettier --stdin-filepath {path}",
+	".tsx":  "prettier --stdin-filepath {path}",
+	".json": "prettier --stdin-filepath {path}",
+	".css":  "prettier --stdin-filepath {path}",
+	".rs":   "rustfmt --emit stdout",
+	".c":    "clang-format --assume-filename {path}",
+	".h":    "clang-format --assume-filename {path}",
+	".cpp":  "clang-format --assume-filename {path}",
+}
+
+// maxFormatFiles caps how many files one format_code call may rewrite.
+const maxFormatFiles = 200
+
+// LoadFormatters reads per-extension formatter overrides from a JSON object
+// such as {".py": "black -q -", ".js": "prettier --stdin-filepath {path}"}.
+// Keys may omit the leading dot; an empty command disables formatting for
+// that extension.
+func LoadFormatters(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	formatters, err := normalizeFormatters(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return formatters, nil
+}
+
+// normalizeFormatters lower-cases extensions and adds any missing leading dot,
+// so keys match what formatterFor looks up.
+func normalizeFormatters(raw map[string]string) (map[string]string, error) {
+	formatters := make(map[string]string, len(raw))
+	for ext, command := range raw {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" || ext == "." {
+			return nil, fmt.Errorf("empty file extension")
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		formatters[ext] = strings.TrimSpace(command)
+	}
+	return formatters, nil
+}
+
+// formatterFor returns the formatter command line for a file name: a
+// configured override first, then the built-in default. Go files without an
+// override use goimports when it is allowed and installed, since it also
+// fixes imports. It reports false when the extension has no formatter.
+func formatterFor(tc *ToolContext, name string) ([]string, bool) {
+	ext := strings.ToLower(filepath.Ext(name))
+	command, ok := tc.Formatters[ext]
+	if !ok {
+		command, ok = defaultFormatters[ext]
+		if ext == ".go" && slices.Contains(tc.AllowedCommands, "goimports") {
+			if _, err := exec.LookPath("goimports"); err == nil {
+				command = "goimports"
+			}
+		}
+	}
+	fields := strings.Fields(command)
+	return fields, ok && len(fields) > 0
+}
+
+// runFormatter pipes content through a formatter command line and returns the
+// formatted text. rel is the file's root-relative path, substituted for {path}.
+func runFormatter(ctx context.Context, tc *ToolContext, command []string, rel string, content []byte) (string, *ToolResult) {
+	args := make([]string, len(command)-1)
+	for i, arg := range command[1:] {
+		args[i] = strings.ReplaceAll(arg, "{path}", filepath.ToSlash(rel))
+	}
+
+	out, failure := execAllowedInput(ctx, tc, content, command[0], args...)
+	if failure != nil {
+		return "", failure
+	}
+	if out.exitCode != 0 {
+		return "", NewErrorResult("parse_error", fmt.Sprintf("%s failed on %s: %s", command[0], filepath.ToSlash(rel), strings.TrimSpace(out.stderr)), []string{
+			"Fix the errors reported and format again",
+		})
+	}
+	// A formatter that prints nothing for a non-empty file is misconfigured
+	// (e.g. it writes in place instead of to stdout); never blank the file
+	if out.stdout == "" && strings.TrimSpace(string(content)) != "" {
+		return "", NewErrorResult("io_error", fmt.Sprintf("%s produced no output for %s", command[0], filepath.ToSlash(rel)), []string{
+			"Formatter commands must read the file on stdin and write the result to stdout",
+		})
+	}
+	return out.stdout, nil
+}
+
+// formatCode formats a file, or every file under a directory that has a
+// formatter, in place.
+func formatCode(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getStringArg(args, "path")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessReadFile)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	info, err := tc.Sandbox.FS.Stat(resolvedPath)
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to stat path: %v", err), nil)
+	}
+
+	var planned []patchedFile
+	var failed []map[string]any
+	var notAllowed []string
+	if info.IsDir() {
+		planned, failed, notAllowed, err = formatTree(ctx, tc, resolvedPath)
+		if ctx.Err() != nil {
+			return NewErrorResult("timeout", "formatting was stopped because the turn ended", nil)
+		}
+		if err != nil {
+			return NewErrorResult("too_large", err.Error(), []string{
+				"Format a smaller directory or individual files",
+			})
+		}
+	} else {
+		command, ok := formatterFor(tc, resolvedPath)
+		if !ok {
+			return NewSuccessResult(map[string]any{
+				"message": fmt.Sprintf("no formatter is configured for %s; left unchanged", path),
+				"changed": false,
+				"files":   []string{},
+			})
+		}
+		writePath, err := tc.Sandbox.Resolve(path, AccessWriteFile)
+		if sandboxErr, ok := err.(*SandboxError); ok {
+			return NewErrorResultFromSandbox(sandboxErr)
+		}
+		if err != nil {
+			return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+		}
+		content, err := tc.Sandbox.FS.ReadFile(writePath)
+		if err != nil {
+			return NewErrorResult("io_error", fmt.Sprintf("failed to read file: %v", err), nil)
+		}
+		rel, err := filepath.Rel(tc.Sandbox.Root, writePath)
+		if err != nil {
+			return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+		}
+		formatted, failure := runFormatter(ctx, tc, command, rel, content)
+		if failure != nil {
+			return failure
+		}
+		if formatted != string(content) {
+			planned = append(planned, patchedFile{
+				display:  path,
+				resolved: writePath,
+				existed:  true,
+				previous: content,
+				updated:  formatted,
+			})
+		}
+	}
+
+	var diff strings.Builder
+	files := make([]string, len(planned))
+	for i, f := range planned {
+		diff.WriteString(unifiedDiff(f.display, string(f.previous), f.updated))
+		files[i] = f.display
+	}
+	data := map[string]any{
+		"changed": len(planned) > 0,
+		"files":   files,
+		"diff":    truncateDiff(diff.String()),
+	}
+	if len(failed) > 0 {
+		data["failed"] = failed
+	}
+	if len(notAllowed) > 0 {
+		data["skipped_formatters"] = notAllowed
+		data["hint"] = "Some files were skipped because their formatter is not on the command allowlist (--allow-commands)"
+	}
+
+	if tc.DryRun {
+		data["message"] = fmt.Sprintf("would reformat %d file(s)", len(planned))
+		return simulatedResult(data)
+	}
+
+	var written []journalEntry
+	for _, f := range planned {
+		if err := writeWithQuota(tc.Sandbox, f.resolved, []byte(f.updated), false); err != nil {
+			if len(written) > 0 {
+				tc.Journal.record(journalEntry{Op: "format", Display: path, Group: written})
+			}
+			return NewErrorResult("io_error", fmt.Sprintf("failed to write %s: %v", f.display, err), nil)
+		}
+		written = append(written, journalEntry{Op: "edit", Path: f.resolved, Display: f.display, Existed: true, Previous: f.previous})
+	}
+	if len(written) > 0 {
+		tc.Journal.record(journalEntry{Op: "format", Display: path, Group: written})
+	}
+
+	data["message"] = fmt.Sprintf("reformatted %d file(s)", len(planned))
+	return NewSuccessResult(data)
+}
+
+// formatTree formats every file under dir that has a formatter, skipping
+// ignored paths. Files whose formatter fails are reported in failed rather
+// than aborting the rest; files whose formatter is not on the command
+// allowlist are skipped and the formatter listed in notAllowed.
+func formatTree(ctx context.Context, tc *ToolContext, dir string) (planned []patchedFile, failed []map[string]any, notAllowed []string, err error) {
+	failed = []map[string]any{}
+	ignore := NewIgnoreMatcher(tc.Sandbox.FS, tc.Sandbox.Root)
+	err = walkDir(tc.Sandbox.FS, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable entries rather than aborting the whole walk
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		rel, err := filepath.Rel(tc.Sandbox.Root, p)
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == trashDir || tc.Sandbox.Excluded(rel, true) || ignore.Match(rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignore.Match(rel, false) {
+			return nil
+		}
+		command, ok := formatterFor(tc, d.Name())
+		if !ok {
+			return nil
+		}
+		if !slices.Contains(tc.AllowedCommands, command[0]) {
+			if !slices.Contains(notAllowed, command[0]) {
+				notAllowed = append(notAllowed, command[0])
+			}
+			return nil
+		}
+
+		// Skip anything the sandbox would refuse to read or write
+		if _, err := tc.Sandbox.Resolve(rel, AccessReadFile); err != nil {
+			return nil
+		}
+		realPath, err := tc.Sandbox.Resolve(rel, AccessWriteFile)
+		if err != nil {
+			return nil
+		}
+		info, err := tc.Sandbox.FS.Stat(realPath)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > tc.MaxReadBytes {
+			return nil
+		}
+		content, err := tc.Sandbox.FS.ReadFile(realPath)
+		if err != nil {
+			return nil
+		}
+
+		formatted, failure := runFormatter(ctx, tc, command, rel, content)
+		if failure != nil {
+			failed = append(failed, map[string]any{"file": filepath.ToSlash(rel), "error": failure.Error.Message})
+			return nil
+		}
+		if formatted == string(content) {
+			return nil
+		}
+		if len(planned) >= maxFormatFiles {
+			return fmt.Errorf("more than %d files need formatting", maxFormatFiles)
+		}
+		planned = append(planned, patchedFile{
+			display:  filepath.ToSlash(rel),
+			resolved: realPath,
+			existed:  true,
+			previous: content,
+			updated:  formatted,
+		})
+		return nil
+	})
+	return planned, failed, notAllowed, err
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFormatCode(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt is not installed")
+	}
+	const messy = "package main\nfunc main(){\nx:=1\n_=x}\n"
+	const tidy = "package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n"
+	files := map[string]string{
+		"main.go":        messy,
+		"pkg/util.go":    messy,
+		"pkg/tidy.go":    tidy,
+		"notes.unknown":  "  left   alone  ",
+		"broken/bad.go":  "package main\nfunc {\n",
+		"vendor/skip.go": messy,
+		".gitignore":     "vendor/\n",
+	}
+	// gofmt only, so the result does not depend on goimports being installed
+	gofmtOnly := func(tc *ToolContext) { tc.AllowedCommands = []string{"gofmt"} }
+	tests := []struct {
+		name      string
+		path      string
+		setup     func(tc *ToolContext)
+		dryRun    bool
+		wantErr   string // Error code; "" for success
+		want      map[string]string
+		wantFiles []string
+	}{
+		{name: "file", path: "main.go", setup: gofmtOnly,
+			want: map[string]string{"main.go": tidy, "pkg/util.go": messy}, wantFiles: []string{"main.go"}},
+		{name: "already formatted", path: "pkg/tidy.go", setup: gofmtOnly,
+			want: map[string]string{"pkg/tidy.go": tidy}, wantFiles: []string{}},
+		{name: "directory", path: "pkg", setup: gofmtOnly,
+			want: map[string]string{"pkg/util.go": tidy, "main.go": messy}, wantFiles: []string{"pkg/util.go"}},
+		{name: "whole tree skips ignored and broken files", path: ".", setup: gofmtOnly,
+			want:      map[string]string{"main.go": tidy, "pkg/util.go": tidy, "vendor/skip.go": messy, "broken/bad.go": files["broken/bad.go"]},
+			wantFiles: []string{"main.go", "pkg/util.go"}},
+		{name: "unsupported file", path: "notes.unknown", setup: gofmtOnly,
+			want: map[string]string{"notes.unknown": files["notes.unknown"]}, wantFiles: []string{}},
+		{name: "syntax error", path: "broken/bad.go", setup: gofmtOnly, wantErr: "parse_error",
+			want: map[string]string{"broken/bad.go": files["broken/bad.go"]}},
+		{name: "dry run", path: "main.go", setup: gofmtOnly, dryRun: true,
+			want: map[string]string{"main.go": messy}, wantFiles: []string{"main.go"}},
+		{name: "formatter not allowed", path: "main.go", setup: func(tc *ToolContext) { tc.AllowedCommands = []string{"go"} },
+			wantErr: "permission_denied", want: map[string]string{"main.go": messy}},
+		{name: "missing", path: "none.go", wantErr: "not_found"},
+		{name: "outside the root", path: "..", wantErr: "permission_denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, files)
+			tc.DryRun = tt.dryRun
+			if tt.setup != nil {
+				tt.setup(tc)
+			}
+			result := formatCode(context.Background(), map[string]any{"path": tt.path}, tc)
+			if tt.wantErr != "" {
+				if result.OK || result.Error.Code != tt.wantErr {
+					t.Errorf("result = %s, want error %s", resultJSON(t, result), tt.wantErr)
+				}
+			} else if !result.OK {
+				t.Fatalf("failed: %s", resultJSON(t, result))
+			}
+			for name, want := range tt.want {
+				if got, _ := readTestFile(t, tc, name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if tt.wantFiles != nil {
+				got, _ := result.Data["files"].([]string)
+				if !slices.Equal(got, tt.wantFiles) {
+					t.Errorf("files = %q, want %q", got, tt.wantFiles)
+				}
+				if changed := result.Data["changed"]; changed != (len(tt.wantFiles) > 0) {
+					t.Errorf("changed = %v with files %q", changed, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatCodeUndo(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt is not installed")
+	}
+	const messy = "package main\nvar  x=1\n"
+	tc := newTestToolContext(t, map[string]string{"a.go": messy, "b.go": messy})
+	tc.AllowedCommands = []string{"gofmt"}
+	result := formatCode(context.Background(), map[string]any{"path": "."}, tc)
+	if !result.OK {
+		t.Fatalf("format_code failed: %s", resultJSON(t, result))
+	}
+	if diff, _ := result.Data["diff"].(string); !strings.Contains(diff, "+var x = 1") {
+		t.Errorf("diff = %q, want the reformatting", diff)
+	}
+	if undo := undoLastEdit(context.Background(), map[string]any{}, tc); !undo.OK {
+		t.Fatalf("undo failed: %s", resultJSON(t, undo))
+	}
+	for _, name := range []string{"a.go", "b.go"} {
+		if got, _ := readTestFile(t, tc, name); got != messy {
+			t.Errorf("%s = %q after undo, want the original", name, got)
+		}
+	}
+}
+
+func TestFormatterFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		allowed   []string
+		file      string
+		want      []string // nil when there is no formatter
+	}{
+		{"go default", nil, []string{"gofmt"}, "main.go", []string{"gofmt"}},
+		{"python default", nil, nil, "app.py", []string{"black", "-q", "-"}},
+		{"extension case", nil, nil, "App.TSX", []string{"prettier", "--stdin-filepath", "{path}"}},
+		{"unknown extension", nil, nil, "notes.txt", nil},
+		{"no extension", nil, nil, "Makefile", nil},
+		{"override", map[string]string{".py": "ruff format -"}, nil, "app.py", []string{"ruff", "format", "-"}},
+		{"override beats goimports", map[string]string{".go": "gofumpt"}, []string{"goimports"}, "main.go", []string{"gofumpt"}},
+		{"new extension", map[string]string{".txt": "fmt -w 80"}, nil, "notes.txt", []string{"fmt", "-w", "80"}},
+		{"disabled", map[string]string{".go": ""}, []string{"gofmt"}, "main.go", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &ToolContext{Formatters: tt.overrides, AllowedCommands: tt.allowed}
+			got, ok := formatterFor(tc, tt.file)
+			if ok != (tt.want != nil) || (ok && !slices.Equal(got, tt.want)) {
+				t.Errorf("formatterFor(%q) = %q, %v; want %q", tt.file, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeFormatters(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"dotted", map[string]string{".py": "black -q -"}, map[string]string{".py": "black -q -"}, false},
+		{"no dot", map[string]string{"js": "prettier"}, map[string]string{".js": "prettier"}, false},
+		{"case and space", map[string]string{" .RS ": "  rustfmt  "}, map[string]string{".rs": "rustfmt"}, false},
+		{"disabled", map[string]string{"go": ""}, map[string]string{".go": ""}, false},
+		{"empty extension", map[string]string{"": "x"}, nil, true},
+		{"bare dot", map[string]string{".": "x"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeFormatters(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("normalizeFormatters = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil || !maps.Equal(got, tt.want) {
+				t.Errorf("normalizeFormatters = %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFormatters(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string // absent for a missing file
+		want    map[string]string
+		wantErr string
+	}{
+		{"valid", `{"py": "black -q -", ".JS": "prettier --stdin-filepath {path}"}`,
+			map[string]string{".py": "black -q -", ".js": "prettier --stdin-filepath {path}"}, ""},
+		{"not json", `.py = "black"`, nil, "parse"},
+		{"bad extension", `{"": "x"}`, nil, "empty file extension"},
+		{"missing", absent, nil, "no such file"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("formatters%d.json", i))
+			if tt.content != absent {
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := LoadFormatters(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("LoadFormatters error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !maps.Equal(got, tt.want) {
+				t.Errorf("LoadFormatters = %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguredFormatters(t *testing.T) {
+	files := map[string]string{"a.up": "shout\n", "b.up": "loud\n", "c.py": "x=1\n"}
+	tests := []struct {
+		name       string
+		path       string
+		formatters map[string]string
+		allowed    []string
+		wantErr    string // Error code; "" for success
+		want       map[string]string
+		wantData   map[string]any
+	}{
+		{name: "custom command", path: "a.up", formatters: map[string]string{".up": "tr a-z A-Z"}, allowed: []string{"tr"},
+			want: map[string]string{"a.up": "SHOUT\n"}},
+		{name: "directory", path: ".", formatters: map[string]string{".up": "tr a-z A-Z"}, allowed: []string{"tr"},
+			want: map[string]string{"a.up": "SHOUT\n", "b.up": "LOUD\n", "c.py": "x=1\n"},
+			// black is not on the allowlist, so c.py is skipped and reported
+			wantData: map[string]any{"skipped_formatters": []string{"black"}}},
+		{name: "not allowed", path: "a.up", formatters: map[string]string{".up": "tr a-z A-Z"}, allowed: []string{"gofmt"},
+			wantErr: "permission_denied", want: map[string]string{"a.up": "shout\n"}},
+		{name: "no output", path: "a.up", formatters: map[string]string{".up": "true"}, allowed: []string{"true"},
+			wantErr: "io_error", want: map[string]string{"a.up": "shout\n"}},
+		{name: "disabled default", path: "c.py", formatters: map[string]string{".py": ""}, allowed: []string{"black"},
+			want: map[string]string{"c.py": "x=1\n"}, wantData: map[string]any{"changed": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, files)
+			tc.Formatters = tt.formatters
+			tc.AllowedCommands = tt.allowed
+			result := formatCode(context.Background(), map[string]any{"path": tt.path}, tc)
+			if tt.wantErr != "" {
+				if result.OK || result.Error.Code != tt.wantErr {
+					t.Errorf("result = %s, want error %s", resultJSON(t, result), tt.wantErr)
+				}
+			} else if !result.OK {
+				t.Fatalf("failed: %s", resultJSON(t, result))
+			}
+			for name, want := range tt.want {
+				if got, _ := readTestFile(t, tc, name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			for key, want := range tt.wantData {
+				if got := result.Data[key]; !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
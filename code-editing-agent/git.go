@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// gitDiff returns the working-tree (or staged) changes under the sandbox root.
+func gitDiff(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	staged, err := getOptionalBoolArg(args, "staged", false)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	if failure := requireGitRepo(ctx, tc); failure != nil {
+		return failure
+	}
+
+	// --relative limits the patch to the sandbox root and strips its prefix,
+	// so paths match what the file tools accept. --no-renames shows a rename
+	// as a deletion and an addition, so both paths are checked below.
+	gitArgs := []string{"diff", "--no-color", "--no-ext-diff", "--relative", "--no-renames"}
+	if staged {
+		gitArgs = append(gitArgs, "--staged")
+	}
+
+	// List the changed files first and diff only those the sandbox would let
+	// the model see, leaving out denied and .agentignore'd files. Stat access
+	// accepts deleted files.
+	out, failure := execAllowed(ctx, tc, "git", append(slices.Clone(gitArgs), "--name-only", "-z")...)
+	if failure != nil {
+		return failure
+	}
+	if out.exitCode != 0 {
+		return gitFailure("git diff", out)
+	}
+	var pathspecs []string
+	withheld := 0
+	for _, name := range strings.Split(out.stdout, "\x00") {
+		if name == "" {
+			continue
+		}
+		if _, err := tc.Sandbox.Resolve(name, AccessStat); err != nil {
+			withheld++
+			continue
+		}
+		pathspecs = append(pathspecs, ":(literal)"+name)
+	}
+
+	diff := ""
+	if len(pathspecs) > 0 {
+		out, failure = execAllowed(ctx, tc, "git", append(append(gitArgs, "--"), pathspecs...)...)
+		if failure != nil {
+			return failure
+		}
+		if out.exitCode != 0 {
+			return gitFailure("git diff", out)
+		}
+		diff = out.stdout
+	}
+
+	return NewSuccessResult(map[string]any{
+		"staged":         staged,
+		"diff":           truncateDiff(diff),
+		"truncated":      len(diff) > maxDiffBytes,
+		"withheld_files": withheld,
+	})
+}
+
+// gitCommit stages the given paths (or every change under the sandbox root)
+// and commits them with the model's message.
+func gitCommit(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	message, err := getStringArg(args, "message")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if strings.TrimSpace(message) == "" {
+		return NewErrorResult("invalid_argument", "message cannot be empty", nil)
+	}
+
+	paths, err := getOptionalStringSliceArg(args, "paths")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	// Stage paths relative to the root, which is git's working directory, so
+	// nothing outside the sandbox is ever added
+	pathspecs := []string{"."}
+	if len(paths) > 0 {
+		pathspecs = pathspecs[:0]
+		for _, path := range paths {
+			// Stat access accepts deleted files, whose removal is staged too
+			resolvedPath, err := tc.Sandbox.Resolve(path, AccessStat)
+			if sandboxErr, ok := err.(*SandboxError); ok {
+				return NewErrorResultFromSandbox(sandboxErr)
+			}
+			if err != nil {
+				return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+			}
+			rel, err := filepath.Rel(tc.Sandbox.Root, resolvedPath)
+			if err != nil {
+				return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+			}
+			pathspecs = append(pathspecs, rel)
+		}
+	}
+
+	if failure := requireGitRepo(ctx, tc); failure != nil {
+		return failure
+	}
+
+	// Stage and commit only the changed files the sandbox would let the model
+	// touch, so a plain "commit everything" never picks up denied or
+	// .agentignore'd files such as .env
+	unstaged, failure := gitListFiles(ctx, tc, append([]string{"ls-files", "-z", "--modified", "--deleted", "--others", "--exclude-standard", "--"}, pathspecs...)...)
+	if failure != nil {
+		return failure
+	}
+	staged, failure := gitListFiles(ctx, tc, append([]string{"diff", "--cached", "--name-only", "--relative", "--no-renames", "-z", "--"}, pathspecs...)...)
+	if failure != nil {
+		return failure
+	}
+	var names, toAdd, toCommit []string
+	withheld := 0
+	for _, name := range slices.Compact(slices.Sorted(slices.Values(append(unstaged, staged...)))) {
+		if _, err := tc.Sandbox.Resolve(name, AccessStat); err != nil {
+			withheld++
+			continue
+		}
+		names = append(names, name)
+		toCommit = append(toCommit, ":(literal)"+name)
+		if slices.Contains(unstaged, name) {
+			toAdd = append(toAdd, ":(literal)"+name)
+		}
+	}
+	if len(toCommit) == 0 {
+		return nothingToCommit()
+	}
+
+	if tc.DryRun {
+		return simulatedResult(map[string]any{
+			"message": fmt.Sprintf("would stage and commit %s", strings.Join(names, ", ")),
+		})
+	}
+
+	if len(toAdd) > 0 {
+		out, failure := execAllowed(ctx, tc, "git", append([]string{"add", "-A", "--"}, toAdd...)...)
+		if failure != nil {
+			return failure
+		}
+		if out.exitCode != 0 {
+			return gitFailure("git add", out)
+		}
+	}
+
+	// Files whose changes were undone leave nothing staged
+	committed, failure := gitListFiles(ctx, tc, append([]string{"diff", "--cached", "--name-only", "--relative", "--no-renames", "-z", "--"}, toCommit...)...)
+	if failure != nil {
+		return failure
+	}
+	if len(committed) == 0 {
+		return nothingToCommit()
+	}
+
+	// Naming the files keeps anything else staged, inside the root or not,
+	// out of the commit
+	out, failure := execAllowed(ctx, tc, "git", append([]string{"commit", "-q", "-m", message, "--"}, toCommit...)...)
+	if failure != nil {
+		return failure
+	}
+	if out.exitCode != 0 {
+		return gitFailure("git commit", out)
+	}
+
+	out, failure = execAllowed(ctx, tc, "git", "rev-parse", "HEAD")
+	if failure != nil {
+		return failure
+	}
+	if out.exitCode != 0 {
+		return gitFailure("git rev-parse", out)
+	}
+
+	return NewSuccessResult(map[string]any{
+		"commit":         strings.TrimSpace(out.stdout),
+		"files":          committed,
+		"withheld_files": withheld,
+	})
+}
+
+// nothingToCommit reports a git_commit call that found no changes to commit.
+func nothingToCommit() *ToolResult {
+	return NewErrorResult("invalid_argument", "nothing to commit: no changes are staged", []string{
+		"Use git_diff to check for changes, or pass the paths you edited",
+	})
+}
+
+// gitListFiles runs a git command printing NUL-separated paths relative to
+// the sandbox root and returns them.
+func gitListFiles(ctx context.Context, tc *ToolContext, args ...string) ([]string, *ToolResult) {
+	out, failure := execAllowed(ctx, tc, "git", args...)
+	if failure != nil {
+		return nil, failure
+	}
+	if out.exitCode != 0 {
+		return nil, gitFailure("git "+args[0], out)
+	}
+	var names []string
+	for _, name := range strings.Split(out.stdout, "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// requireGitRepo returns an error result unless the sandbox root is inside a
+// git working tree.
+func requireGitRepo(ctx context.Context, tc *ToolContext) *ToolResult {
+	out, failure := execAllowed(ctx, tc, "git", "rev-parse", "--is-inside-work-tree")
+	if failure != nil {
+		return failure
+	}
+	if out.exitCode != 0 || strings.TrimSpace(out.stdout) != "true" {
+		return NewErrorResult("not_found", "project root is not inside a git repository", []string{
+			"Run 'git init' in the project root to start tracking changes",
+		})
+	}
+	return nil
+}
+
+// gitFailure reports a git command that exited non-zero, surfacing its stderr.
+func gitFailure(what string, out *commandOutput) *ToolResult {
+	msg := strings.TrimSpace(out.stderr)
+	if msg == "" {
+		msg = strings.TrimSpace(out.stdout)
+	}
+	return NewErrorResult("io_error", what+" failed: "+msg, nil)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runGit runs git in dir, failing the test if it does.
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestGitDiffWithholdsHiddenFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	files := map[string]string{
+		".agentignore":   "secrets/\n",
+		"secrets/token":  "TOPSECRET\n",
+		"private/notes":  "PRIVATE\n",
+		"public.txt":     "hello\n",
+		"renamed/old.md": "moved\n",
+	}
+	tc := newTestToolContext(t, files, func(s *PathSandbox) { s.Deny = []string{".git", "private"} })
+	root := tc.Sandbox.Root
+	runGit(t, root, "init", "-q")
+	runGit(t, root, "add", "-A")
+	runGit(t, root, "commit", "-q", "-m", "initial")
+
+	writeTree(t, root, map[string]string{
+		"secrets/token": "TOPSECRET changed\n",
+		"private/notes": "PRIVATE changed\n",
+		"public.txt":    "hello changed\n",
+	})
+	runGit(t, root, "mv", "renamed/old.md", "secrets/moved.md")
+
+	for _, staged := range []bool{false, true} {
+		if staged {
+			runGit(t, root, "add", "-A")
+		}
+		result := gitDiff(context.Background(), map[string]any{"staged": staged}, tc)
+		if !result.OK {
+			t.Fatalf("staged=%v: git_diff failed: %s", staged, result.Error.Message)
+		}
+		diff := result.Data["diff"].(string)
+		if !strings.Contains(diff, "+hello changed") {
+			t.Errorf("staged=%v: diff is missing the visible change:\n%s", staged, diff)
+		}
+		for _, hidden := range []string{"TOPSECRET", "PRIVATE", "secrets/moved.md"} {
+			if strings.Contains(diff, hidden) {
+				t.Errorf("staged=%v: diff shows %q:\n%s", staged, hidden, diff)
+			}
+		}
+		// secrets/token and private/notes, plus, once the rename is staged,
+		// its new path; the deletion of its old path stays visible
+		want := 2
+		if staged {
+			want = 3
+		}
+		if got := result.Data["withheld_files"]; got != want {
+			t.Errorf("staged=%v: withheld_files = %v, want %d", staged, got, want)
+		}
+	}
+}
+
+// gitOutput runs git in dir and returns its trimmed output, failing the test
+// if it fails.
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	files := map[string]string{
+		".agentignore":  ".env\n",
+		".env":          "SECRET=1\n",
+		"private/notes": "PRIVATE\n",
+		"public.txt":    "hello\n",
+		"gone.txt":      "bye\n",
+	}
+	tests := []struct {
+		name      string
+		change    map[string]string // Files written after the initial commit
+		remove    string            // File deleted after the initial commit
+		stage     string            // File the user staged before the call
+		args      map[string]any
+		dryRun    bool
+		wantFiles string // Files in the new commit, comma-separated
+		wantError string // Error code; "" for success
+		withheld  int
+	}{
+		{
+			name:      "every change",
+			change:    map[string]string{"public.txt": "changed\n", "new.txt": "new\n"},
+			remove:    "gone.txt",
+			wantFiles: "gone.txt,new.txt,public.txt",
+		},
+		{
+			name:      "leaves out agentignored and denied files",
+			change:    map[string]string{"public.txt": "changed\n", ".env": "SECRET=2\n", "private/notes": "changed\n", "private/new": "new\n"},
+			wantFiles: "public.txt",
+			withheld:  3,
+		},
+		{
+			name:      "named paths",
+			change:    map[string]string{"public.txt": "changed\n", "new.txt": "new\n"},
+			args:      map[string]any{"paths": []any{"new.txt"}},
+			wantFiles: "new.txt",
+		},
+		{
+			name:      "includes what the user staged",
+			change:    map[string]string{"new.txt": "new\n"},
+			stage:     "new.txt",
+			wantFiles: "new.txt",
+		},
+		{
+			name:      "refuses a named agentignored file",
+			change:    map[string]string{".env": "SECRET=2\n"},
+			args:      map[string]any{"paths": []any{".env"}},
+			wantError: "permission_denied",
+		},
+		{
+			name:      "refuses when nothing is staged",
+			wantError: "invalid_argument",
+		},
+		{
+			name:      "refuses when only hidden files changed",
+			change:    map[string]string{".env": "SECRET=2\n"},
+			wantError: "invalid_argument",
+		},
+		{
+			name:   "dry run",
+			change: map[string]string{"public.txt": "changed\n"},
+			dryRun: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, files, func(s *PathSandbox) { s.Deny = []string{".git", "private"} })
+			tc.AllowedCommands = []string{"git"}
+			tc.DryRun = tt.dryRun
+			root := tc.Sandbox.Root
+			runGit(t, root, "init", "-q")
+			runGit(t, root, "add", "-A")
+			runGit(t, root, "commit", "-q", "-m", "initial")
+			initial := gitOutput(t, root, "rev-parse", "HEAD")
+
+			writeTree(t, root, tt.change)
+			if tt.remove != "" {
+				if err := os.Remove(filepath.Join(root, tt.remove)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.stage != "" {
+				runGit(t, root, "add", tt.stage)
+			}
+
+			args := map[string]any{"message": "update"}
+			for k, v := range tt.args {
+				args[k] = v
+			}
+			// The tool runs git without -c flags, so give it an identity
+			t.Setenv("GIT_AUTHOR_NAME", "Test")
+			t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+			t.Setenv("GIT_COMMITTER_NAME", "Test")
+			t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+			result := gitCommit(context.Background(), args, tc)
+
+			head := gitOutput(t, root, "rev-parse", "HEAD")
+			if tt.wantError != "" || tt.dryRun {
+				if tt.wantError != "" && (result.OK || result.Error.Code != tt.wantError) {
+					t.Errorf("result = %s, want error %s", resultJSON(t, result), tt.wantError)
+				}
+				if tt.dryRun && !result.OK {
+					t.Errorf("dry run failed: %s", result.Error.Message)
+				}
+				if head != initial {
+					t.Errorf("a commit was made")
+				}
+				return
+			}
+			if !result.OK {
+				t.Fatalf("git_commit failed: %s", result.Error.Message)
+			}
+			if result.Data["commit"] != head {
+				t.Errorf("commit = %v, want HEAD %s", result.Data["commit"], head)
+			}
+			committed := strings.ReplaceAll(gitOutput(t, root, "show", "--name-only", "--format=", "HEAD"), "\n", ",")
+			if committed != tt.wantFiles {
+				t.Errorf("committed files = %s, want %s", committed, tt.wantFiles)
+			}
+			if got := strings.Join(result.Data["files"].([]string), ","); got != tt.wantFiles {
+				t.Errorf("reported files = %s, want %s", got, tt.wantFiles)
+			}
+			if got := result.Data["withheld_files"]; got != tt.withheld {
+				t.Errorf("withheld_files = %v, want %d", got, tt.withheld)
+			}
+			if staged := gitOutput(t, root, "diff", "--cached", "--name-only"); staged != "" {
+				t.Errorf("left staged after the commit: %s", staged)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// maxTestOutputBytes caps the output kept for each failing test; the end of
+// the output, where the failure usually is, is kept.
+const maxTestOutputBytes = 4 << 10
+
+// testEvent is one line of `go test -json` output.
+type testEvent struct {
+	Action     string
+	Package    string
+	ImportPath string // Set on build-output events
+	Test       string
+	Output     string
+}
+
+// testFailure is a failing test or package and what it printed.
+type testFailure struct {
+	pkg    string
+	test   string
+	output strings.Builder
+}
+
+// testSummary is what a go test run reported.
+type testSummary struct {
+	passed, failed, skipped int
+	failures                []*testFailure
+	buildOutput             strings.Builder // Compiler errors reported by build-output events
+	other                   strings.Builder // Lines that were not JSON events
+}
+
+// parseTestEvents reads `go test -json` output into a summary. Package-level
+// failures (such as a panic in TestMain) are reported with an empty test name.
+func parseTestEvents(output string) *testSummary {
+	summary := &testSummary{}
+	running := map[[2]string]*testFailure{} // Output of tests that have not finished
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var ev testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || ev.Action == "" {
+			summary.other.WriteString(scanner.Text() + "\n")
+			continue
+		}
+
+		key := [2]string{ev.Package, ev.Test}
+		switch ev.Action {
+		case "build-output":
+			summary.buildOutput.WriteString(ev.Output)
+		case "output":
+			if running[key] == nil {
+				running[key] = &testFailure{pkg: ev.Package, test: ev.Test}
+			}
+			running[key].output.WriteString(ev.Output)
+		case "pass", "skip":
+			if ev.Test != "" {
+				if ev.Action == "pass" {
+					summary.passed++
+				} else {
+					summary.skipped++
+				}
+			}
+			delete(running, key)
+		case "fail":
+			if ev.Test != "" {
+				summary.failed++
+			}
+			f := running[key]
+			if f == nil {
+				f = &testFailure{pkg: ev.Package, test: ev.Test}
+			}
+			delete(running, key)
+			// A package fails whenever one of its tests does; only report it
+			// separately when no test explains the failure
+			if ev.Test == "" && summary.packageHasFailedTest(ev.Package) {
+				continue
+			}
+			summary.failures = append(summary.failures, f)
+		}
+	}
+	return summary
+}
+
+// packageHasFailedTest reports whether a test in pkg has already failed.
+func (s *testSummary) packageHasFailedTest(pkg string) bool {
+	for _, f := range s.failures {
+		if f.pkg == pkg && f.test != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// tailBytes returns the last n bytes of s, cut at a line start, marked when cut.
+func tailBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := len(s) - n
+	if i := strings.IndexByte(s[cut:], '\n'); i >= 0 {
+		cut += i + 1
+	}
+	return fmt.Sprintf("... (%d earlier bytes omitted)\n%s", cut, s[cut:])
+}
+
+// runTests runs go test on a package pattern and summarizes the results.
+func runTests(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getOptionalStringArg(args, "path", "./...")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	run, err := getOptionalStringArg(args, "run", "")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	// Resolve the directory part of the pattern through the sandbox so tests
+	// only ever run inside the root
+	dir, recursive := strings.CutSuffix(filepath.ToSlash(path), "/...")
+	if dir == "..." {
+		dir, recursive = ".", true
+	}
+	resolvedPath, err := tc.Sandbox.Resolve(dir, AccessListDir)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+	rel, err := filepath.Rel(tc.Sandbox.Root, resolvedPath)
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+	pattern := "./" + filepath.ToSlash(rel)
+	if rel == "." {
+		pattern = "."
+	}
+	if recursive {
+		pattern = strings.TrimSuffix(pattern, "/.") + "/..."
+	}
+
+	testArgs := []string{"test", "-json"}
+	if run != "" {
+		testArgs = append(testArgs, "-run", run)
+	}
+	out, failure := execAllowed(ctx, tc, "go", append(testArgs, pattern)...)
+	if failure != nil {
+		return failure
+	}
+
+	summary := parseTestEvents(out.stdout)
+	failures := make([]map[string]any, len(summary.failures))
+	for i, f := range summary.failures {
+		failures[i] = map[string]any{
+			"package": f.pkg,
+			"test":    f.test,
+			"output":  tailBytes(f.output.String(), maxTestOutputBytes),
+		}
+	}
+
+	data := map[string]any{
+		"package":    pattern,
+		"passed":     summary.passed,
+		"failed":     summary.failed,
+		"skipped":    summary.skipped,
+		"failures":   failures,
+		"exit_code":  out.exitCode,
+		"all_passed": out.exitCode == 0,
+	}
+	// Build errors arrive as build-output events or, from older toolchains
+	// and for setup failures, on stderr
+	if errs := summary.buildOutput.String() + summary.other.String() + out.stderr; strings.TrimSpace(errs) != "" {
+		data["errors"] = tailBytes(errs, maxTestOutputBytes)
+	}
+	return NewSuccessResult(data)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestParseTestEvents(t *testing.T) {
+	tests := []struct {
+		name                    string
+		output                  string
+		passed, failed, skipped int
+		failures                []string // "package test: output" for each failure
+		buildOutput             string
+		other                   string
+	}{
+		{
+			name: "pass",
+			output: `{"Action":"run","Package":"ex","Test":"TestA"}
+{"Action":"output","Package":"ex","Test":"TestA","Output":"=== RUN   TestA\n"}
+{"Action":"pass","Package":"ex","Test":"TestA"}
+{"Action":"output","Package":"ex","Output":"ok  \tex\t0.01s\n"}
+{"Action":"pass","Package":"ex"}
+`,
+			passed: 1,
+		},
+		{
+			name: "fail",
+			output: `{"Action":"run","Package":"ex","Test":"TestA"}
+{"Action":"output","Package":"ex","Test":"TestA","Output":"    a_test.go:5: got 1, want 2\n"}
+{"Action":"fail","Package":"ex","Test":"TestA"}
+{"Action":"pass","Package":"ex","Test":"TestB"}
+{"Action":"output","Package":"ex","Output":"FAIL\n"}
+{"Action":"fail","Package":"ex"}
+`,
+			passed:   1,
+			failed:   1,
+			failures: []string{"ex TestA:     a_test.go:5: got 1, want 2\n"},
+		},
+		{
+			name: "skip",
+			output: `{"Action":"output","Package":"ex","Test":"TestA","Output":"    a_test.go:5: needs a network\n"}
+{"Action":"skip","Package":"ex","Test":"TestA"}
+{"Action":"skip","Package":"ex/empty"}
+`,
+			skipped: 1,
+		},
+		{
+			name: "package-level fail",
+			output: `{"Action":"pass","Package":"ex","Test":"TestA"}
+{"Action":"output","Package":"ex","Output":"panic: setup failed\n"}
+{"Action":"fail","Package":"ex"}
+`,
+			passed:   1,
+			failures: []string{"ex : panic: setup failed\n"},
+		},
+		{
+			name: "build-output",
+			output: `{"ImportPath":"ex","Action":"build-output","Output":"# ex\n"}
+{"ImportPath":"ex","Action":"build-output","Output":"./a.go:3:1: syntax error\n"}
+{"ImportPath":"ex","Action":"build-fail"}
+{"Action":"output","Package":"ex","Output":"FAIL\tex [build failed]\n"}
+{"Action":"fail","Package":"ex"}
+`,
+			failures:    []string{"ex : FAIL\tex [build failed]\n"},
+			buildOutput: "# ex\n./a.go:3:1: syntax error\n",
+		},
+		{
+			name:   "not json",
+			output: "go: cannot find main module\n{}\n",
+			other:  "go: cannot find main module\n{}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := parseTestEvents(tt.output)
+			if summary.passed != tt.passed || summary.failed != tt.failed || summary.skipped != tt.skipped {
+				t.Errorf("passed, failed, skipped = %d, %d, %d; want %d, %d, %d",
+					summary.passed, summary.failed, summary.skipped, tt.passed, tt.failed, tt.skipped)
+			}
+			var failures []string
+			for _, f := range summary.failures {
+				failures = append(failures, f.pkg+" "+f.test+": "+f.output.String())
+			}
+			if strings.Join(failures, "|") != strings.Join(tt.failures, "|") {
+				t.Errorf("failures = %q, want %q", failures, tt.failures)
+			}
+			if got := summary.buildOutput.String(); got != tt.buildOutput {
+				t.Errorf("build output = %q, want %q", got, tt.buildOutput)
+			}
+			if got := summary.other.String(); got != tt.other {
+				t.Errorf("other output = %q, want %q", got, tt.other)
+			}
+		})
+	}
+}
+
+func TestTailBytes(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short\n", 10, "short\n"},
+		{"one\ntwo\nthree\n", 8, "... (8 earlier bytes omitted)\nthree\n"},
+		{"one\ntwo\nthree\n", 12, "... (4 earlier bytes omitted)\ntwo\nthree\n"},
+	}
+	for _, tt := range tests {
+		if got := tailBytes(tt.in, tt.n); got != tt.want {
+			t.Errorf("tailBytes(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not installed")
+	}
+	tc := newTestToolContext(t, map[string]string{
+		"go.mod":       "module example.com/demo\n\ngo 1.21\n",
+		"demo.go":      "package demo\n\nfunc Add(a, b int) int { return a + b }\n",
+		"demo_test.go": "package demo\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {\n\tif Add(1, 2) != 3 {\n\t\tt.Fatal(\"wrong sum\")\n\t}\n}\n\nfunc TestBroken(t *testing.T) {\n\tt.Fatal(\"broken on purpose\")\n}\n",
+	})
+	tc.AllowedCommands = []string{"go"}
+
+	result := runTests(context.Background(), map[string]any{}, tc)
+	if !result.OK {
+		t.Fatalf("run_tests failed: %s", resultJSON(t, result))
+	}
+	data := result.Data
+	if data["passed"] != 1 || data["failed"] != 1 || data["all_passed"] != false || data["package"] != "./..." {
+		t.Errorf("result = %s, want one pass and one failure in ./...", resultJSON(t, result))
+	}
+	failures := data["failures"].([]map[string]any)
+	if len(failures) != 1 || failures[0]["test"] != "TestBroken" || !strings.Contains(failures[0]["output"].(string), "broken on purpose") {
+		t.Errorf("failures = %v, want TestBroken with its output", failures)
+	}
+
+	result = runTests(context.Background(), map[string]any{"run": "TestAdd"}, tc)
+	if !result.OK || result.Data["passed"] != 1 || result.Data["failed"] != 0 || result.Data["all_passed"] != true {
+		t.Errorf("run TestAdd = %s, want one pass", resultJSON(t, result))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/genai"
+)
+
+// repairHistory checks that every model function call in history is answered
+// by a function response in the entry right after it, and that every function
+// response answers such a call. A response matches a call with the same ID,
+// or with the same name when either has no ID. Unanswered calls get a
+// synthetic error response, and orphaned responses are dropped, since the API
+// rejects either with an opaque error. It returns the repaired history and a
+// description of each repair; history itself is not modified.
+func repairHistory(history []*genai.Content) ([]*genai.Content, []string) {
+	var repaired []*genai.Content
+	var problems []string
+
+	for i := 0; i < len(history); i++ {
+		content := history[i]
+		calls := functionCalls(content)
+		if content.Role != genai.RoleModel || len(calls) == 0 {
+			if hasFunctionResponse(content) {
+				// Responses not directly after a model's calls answer nothing
+				var kept []*genai.Part
+				for _, part := range content.Parts {
+					if part.FunctionResponse != nil {
+						problems = append(problems, fmt.Sprintf("dropped response to %s at entry %d with no matching call", part.FunctionResponse.Name, i))
+						continue
+					}
+					kept = append(kept, part)
+				}
+				if len(kept) == 0 {
+					continue
+				}
+				content = &genai.Content{Role: content.Role, Parts: kept}
+			}
+			repaired = append(repaired, content)
+			continue
+		}
+
+		repaired = append(repaired, content)
+		callIndex := i
+
+		var responses, others []*genai.Part
+		if i+1 < len(history) && history[i+1].Role == genai.RoleUser && hasFunctionResponse(history[i+1]) {
+			i++
+			for _, part := range history[i].Parts {
+				if part.FunctionResponse != nil {
+					responses = append(responses, part)
+				} else {
+					others = append(others, part)
+				}
+			}
+		}
+
+		// Answer the calls in order, taking each call's matching response
+		used := make([]bool, len(responses))
+		parts := make([]*genai.Part, 0, len(calls)+len(others))
+		for _, call := range calls {
+			match := -1
+			for j, part := range responses {
+				if !used[j] && responseMatches(call, part.FunctionResponse) {
+					match = j
+					break
+				}
+			}
+			if match < 0 {
+				problems = append(problems, fmt.Sprintf("added missing response to %s called at entry %d", call.Name, callIndex))
+				parts = append(parts, missingResponse(call))
+				continue
+			}
+			used[match] = true
+			parts = append(parts, responses[match])
+		}
+		for j, part := range responses {
+			if !used[j] {
+				problems = append(problems, fmt.Sprintf("dropped response to %s after entry %d with no matching call", part.FunctionResponse.Name, callIndex))
+			}
+		}
+		repaired = append(repaired, &genai.Content{Role: genai.RoleUser, Parts: append(parts, others...)})
+	}
+
+	if len(problems) == 0 {
+		return history, nil
+	}
+	return repaired, problems
+}
+
+// functionCalls returns the function calls in content.
+func functionCalls(content *genai.Content) []*genai.FunctionCall {
+	var calls []*genai.FunctionCall
+	for _, part := range content.Parts {
+		if part.FunctionCall != nil {
+			calls = append(calls, part.FunctionCall)
+		}
+	}
+	return calls
+}
+
+// responseMatches reports whether response answers call.
+func responseMatches(call *genai.FunctionCall, response *genai.FunctionResponse) bool {
+	if call.ID != "" && response.ID != "" {
+		return call.ID == response.ID
+	}
+	return call.Name == response.Name
+}
+
+// missingResponse is the error response standing in for a call whose result
+// was never recorded.
+func missingResponse(call *genai.FunctionCall) *genai.Part {
+	result := NewErrorResult("missing_result", fmt.Sprintf("no result was recorded for this %s call; it may not have run", call.Name), []string{
+		"Check the current state before calling the tool again",
+	})
+	return &genai.Part{
+		FunctionResponse: &genai.FunctionResponse{
+			ID:       call.ID,
+			Name:     call.Name,
+			Response: result.AsMap(),
+		},
+	}
+}
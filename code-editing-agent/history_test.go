@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// callWithID is a model entry calling each name in turn, giving each call
+// the matching ID ("" for none).
+func callWithID(names, ids []string) *genai.Content {
+	content := &genai.Content{Role: genai.RoleModel}
+	for i, name := range names {
+		part := genai.NewPartFromFunctionCall(name, map[string]any{})
+		part.FunctionCall.ID = ids[i]
+		content.Parts = append(content.Parts, part)
+	}
+	return content
+}
+
+// responseWithID is a user entry answering each name in turn with the
+// matching ID ("" for none).
+func responseWithID(names, ids []string) *genai.Content {
+	content := &genai.Content{Role: genai.RoleUser}
+	for i, name := range names {
+		part := genai.NewPartFromFunctionResponse(name, map[string]any{"ok": true})
+		part.FunctionResponse.ID = ids[i]
+		content.Parts = append(content.Parts, part)
+	}
+	return content
+}
+
+// describeHistory summarizes each entry as role:part,part for comparison.
+// Calls are call(name#id), recorded responses resp(name#id), synthetic ones
+// missing(name#id), and text is its content.
+func describeHistory(history []*genai.Content) []string {
+	var entries []string
+	for _, content := range history {
+		var parts []string
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				parts = append(parts, "call("+part.FunctionCall.Name+"#"+part.FunctionCall.ID+")")
+			case part.FunctionResponse != nil:
+				kind := "resp"
+				if errMap, ok := part.FunctionResponse.Response["error"].(map[string]any); ok && errMap["code"] == "missing_result" {
+					kind = "missing"
+				}
+				parts = append(parts, kind+"("+part.FunctionResponse.Name+"#"+part.FunctionResponse.ID+")")
+			default:
+				parts = append(parts, part.Text)
+			}
+		}
+		entries = append(entries, content.Role+":"+strings.Join(parts, ","))
+	}
+	return entries
+}
+
+func TestRepairHistory(t *testing.T) {
+	user := func(text string) *genai.Content { return genai.NewContentFromText(text, genai.RoleUser) }
+	model := func(text string) *genai.Content { return genai.NewContentFromText(text, genai.RoleModel) }
+	withText := responseWithID([]string{"read_file"}, []string{""})
+	withText.Parts = append(withText.Parts, genai.NewPartFromText("note"))
+
+	tests := []struct {
+		name         string
+		history      []*genai.Content
+		want         []string
+		wantProblems []string // Substrings, one per problem
+	}{
+		{name: "well formed",
+			history: []*genai.Content{user("a"), callWithID([]string{"read_file"}, []string{"1"}), responseWithID([]string{"read_file"}, []string{"1"}), model("done")},
+			want:    []string{"user:a", "model:call(read_file#1)", "user:resp(read_file#1)", "model:done"}},
+		{name: "missing response at the end",
+			history:      []*genai.Content{user("a"), callWithID([]string{"read_file"}, []string{"1"})},
+			want:         []string{"user:a", "model:call(read_file#1)", "user:missing(read_file#1)"},
+			wantProblems: []string{"added missing response to read_file called at entry 1"}},
+		{name: "missing response before the next prompt",
+			history:      []*genai.Content{user("a"), callWithID([]string{"list_files"}, []string{""}), user("b")},
+			want:         []string{"user:a", "model:call(list_files#)", "user:missing(list_files#)", "user:b"},
+			wantProblems: []string{"added missing response to list_files"}},
+		{name: "one of two parallel responses missing",
+			history: []*genai.Content{user("a"), callWithID([]string{"read_file", "list_files"}, []string{"1", "2"}),
+				responseWithID([]string{"list_files"}, []string{"2"})},
+			want:         []string{"user:a", "model:call(read_file#1),call(list_files#2)", "user:missing(read_file#1),resp(list_files#2)"},
+			wantProblems: []string{"added missing response to read_file"}},
+		{name: "responses in another order match by ID",
+			history: []*genai.Content{user("a"), callWithID([]string{"read_file", "list_files"}, []string{"1", "2"}),
+				responseWithID([]string{"list_files", "read_file"}, []string{"2", "1"})},
+			want: []string{"user:a", "model:call(read_file#1),call(list_files#2)", "user:resp(list_files#2),resp(read_file#1)"}},
+		{name: "orphan response at the start",
+			history:      []*genai.Content{responseWithID([]string{"read_file"}, []string{"1"}), user("a"), model("b")},
+			want:         []string{"user:a", "model:b"},
+			wantProblems: []string{"dropped response to read_file at entry 0 with no matching call"}},
+		{name: "orphan response keeps its text",
+			history:      []*genai.Content{user("a"), model("b"), withText},
+			want:         []string{"user:a", "model:b", "user:note"},
+			wantProblems: []string{"dropped response to read_file at entry 2"}},
+		{name: "response with the wrong ID",
+			history:      []*genai.Content{user("a"), callWithID([]string{"read_file"}, []string{"1"}), responseWithID([]string{"read_file"}, []string{"9"})},
+			want:         []string{"user:a", "model:call(read_file#1)", "user:missing(read_file#1)"},
+			wantProblems: []string{"added missing response to read_file", "dropped response to read_file after entry 1"}},
+		{name: "name matches when IDs are absent",
+			history: []*genai.Content{user("a"), callWithID([]string{"read_file"}, []string{""}), responseWithID([]string{"read_file"}, []string{"7"})},
+			want:    []string{"user:a", "model:call(read_file#)", "user:resp(read_file#7)"}},
+		{name: "extra response",
+			history: []*genai.Content{user("a"), callWithID([]string{"read_file"}, []string{""}),
+				responseWithID([]string{"read_file", "read_file"}, []string{"", ""})},
+			want:         []string{"user:a", "model:call(read_file#)", "user:resp(read_file#)"},
+			wantProblems: []string{"dropped response to read_file after entry 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := describeHistory(tt.history)
+			repaired, problems := repairHistory(tt.history)
+			if got := describeHistory(repaired); !slices.Equal(got, tt.want) {
+				t.Errorf("repaired history = %q, want %q", got, tt.want)
+			}
+			if len(problems) != len(tt.wantProblems) {
+				t.Fatalf("problems = %q, want %d", problems, len(tt.wantProblems))
+			}
+			for i, want := range tt.wantProblems {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("problem %d = %q, want %q", i, problems[i], want)
+				}
+			}
+			if !slices.Equal(describeHistory(tt.history), before) {
+				t.Error("repairHistory modified its input")
+			}
+			checkWellFormed(t, repaired)
+		})
+	}
+}
+
+// historyClient records the history sent with every Stream request.
+type historyClient struct {
+	*ReplayClient
+	sent [][]*genai.Content
+}
+
+func (c *historyClient) Stream(ctx context.Context, model string, history []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	c.sent = append(c.sent, slices.Clone(history))
+	return c.ReplayClient.Stream(ctx, model, history, config)
+}
+
+func TestRequestsSendRepairedHistory(t *testing.T) {
+	client := &historyClient{ReplayClient: NewScriptedClient(genai.NewContentFromText("ok", genai.RoleModel))}
+	agent := newTestAgent(t, client, nil)
+	// A call whose result was never recorded, as after a crash mid-turn
+	agent.history = []*genai.Content{
+		genai.NewContentFromText("a", genai.RoleUser),
+		callWithID([]string{"list_files"}, []string{"1"}),
+	}
+	if _, err := agent.runTurn(context.Background(), "b"); err != nil {
+		t.Fatalf("runTurn: %v", err)
+	}
+	want := []string{"user:a", "model:call(list_files#1)", "user:missing(list_files#1)", "user:b"}
+	if len(client.sent) != 1 || !slices.Equal(describeHistory(client.sent[0]), want) {
+		t.Fatalf("sent histories = %d, first %q; want %q", len(client.sent), describeHistory(client.sent[0]), want)
+	}
+	if got := describeHistory(agent.history[:len(want)]); !slices.Equal(got, want) {
+		t.Errorf("agent history = %q, want the repair kept", got)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// alwaysIgnored lists names that are skipped even without a .gitignore entry.
+var alwaysIgnored = []string{".git", trashDir}
+
+// agentIgnoreFile names the file in the root listing paths the agent may never
+// read, list, or search, in .gitignore syntax.
+const agentIgnoreFile = ".agentignore"
+
+// ignoreRule is a single parsed .gitignore pattern.
+type ignoreRule struct {
+	pattern  string // Glob pattern, slash-separated
+	negate   bool   // Pattern started with '!'
+	dirOnly  bool   // Pattern ended with '/'
+	anchored bool   // Pattern contains '/' and matches relative to its .gitignore
+}
+
+// IgnoreMatcher reports whether paths under a root are excluded by .gitignore files.
+// Rules are read lazily from each directory's .gitignore and cached.
+type IgnoreMatcher struct {
+	fsys     FileSystem // Where the ignore files are read from
+	root     string
+	file     string                  // Name of the ignore file read from each directory
+	rootOnly bool                    // Only the root's ignore file applies
+	builtins []string                // Names ignored without any rule
+	rules    map[string][]ignoreRule // Keyed by slash-separated dir relative to root
+}
+
+// NewIgnoreMatcher creates a matcher for the given absolute root in fsys.
+func NewIgnoreMatcher(fsys FileSystem, root string) *IgnoreMatcher {
+	return &IgnoreMatcher{
+		fsys:     fsys,
+		root:     root,
+		file:     ".gitignore",
+		builtins: alwaysIgnored,
+		rules:    make(map[string][]ignoreRule),
+	}
+}
+
+// NewAgentIgnoreMatcher creates a matcher for the root's .agentignore file, or
+// returns nil if it has no rules. The file is read once, up front, so the
+// matcher is safe for concurrent use.
+func NewAgentIgnoreMatcher(fsys FileSystem, root string) *IgnoreMatcher {
+	rules := parseIgnoreFile(fsys, filepath.Join(root, agentIgnoreFile))
+	if len(rules) == 0 {
+		return nil
+	}
+	return &IgnoreMatcher{
+		fsys:     fsys,
+		root:     root,
+		file:     agentIgnoreFile,
+		rootOnly: true,
+		rules:    map[string][]ignoreRule{"": rules},
+	}
+}
+
+// Match reports whether relPath (relative to the root) is ignored.
+// A path is ignored when it or any of its parent directories is ignored.
+func (m *IgnoreMatcher) Match(relPath string, isDir bool) bool {
+	rel := filepath.ToSlash(filepath.Clean(relPath))
+	if rel == "." || rel == "" {
+		return false
+	}
+
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.matchSelf(parts[:i], true) {
+			return true
+		}
+	}
+	return m.matchSelf(parts, isDir)
+}
+
+// matchSelf applies rules from every .gitignore between the root and the path.
+// Later rules and deeper files take precedence, as in git.
+func (m *IgnoreMatcher) matchSelf(parts []string, isDir bool) bool {
+	name := parts[len(parts)-1]
+	for _, ignored := range m.builtins {
+		if name == ignored {
+			return true
+		}
+	}
+
+	ignored := false
+	for depth := 0; depth < len(parts); depth++ {
+		dir := strings.Join(parts[:depth], "/")
+		target := strings.Join(parts[depth:], "/")
+		for _, rule := range m.loadRules(dir) {
+			if rule.matches(target, name, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// loadRules returns the rules from dir's ignore file, reading it on first use.
+func (m *IgnoreMatcher) loadRules(dir string) []ignoreRule {
+	if rules, ok := m.rules[dir]; ok || m.rootOnly {
+		return rules
+	}
+	rules := parseIgnoreFile(m.fsys, filepath.Join(m.root, filepath.FromSlash(dir), m.file))
+	m.rules[dir] = rules
+	return rules
+}
+
+// parseIgnoreFile reads a .gitignore file. Missing or unreadable files yield no rules.
+func parseIgnoreFile(fsys FileSystem, file string) []ignoreRule {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// Escaped leading '#' or '!'
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// matches reports whether the rule applies to target (relative to the rule's
+// .gitignore directory) whose final component is name.
+func (r ignoreRule) matches(target, name string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.pattern, name)
+		return ok
+	}
+	return matchSegments(strings.Split(r.pattern, "/"), strings.Split(target, "/"))
+}
+
+// matchSegments matches slash-separated glob segments, where "**" matches
+// zero or more whole segments.
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
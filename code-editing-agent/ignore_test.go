@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":            "# build output\nbuild/\n*.log\n!keep.log\n/top.txt\ndocs/*.tmp\n**/cache\n\\#hash\n",
+		"src/.gitignore":        "generated.go\n!important.log\n",
+		"src/deep/.gitignore":   "*.go\n",
+		"src/deep/file.go":      "",
+		"src/generated.go":      "",
+		"src/main.go":           "",
+		"src/important.log":     "",
+		"other/generated.go":    "",
+		"build/out.bin":         "",
+		"top.txt":               "",
+		"sub/top.txt":           "",
+		"docs/a.tmp":            "",
+		"docs/nested/b.tmp":     "",
+		"a/b/cache/x":           "",
+		"#hash":                 "",
+		"keep.log":              "",
+		"debug.log":             "",
+		"src/build":             "",
+		"node_modules/x/y.js":   "",
+		"vendor/.git/HEAD":      "",
+		"vendor/.agent-trash/x": "",
+	})
+	m := NewIgnoreMatcher(osFileSystem{}, root)
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"src/main.go", false, false},
+		{"build", true, true},
+		{"build/out.bin", false, true},
+		{"src/build", false, false}, // dir-only rule, and this is a file
+		{"debug.log", false, true},
+		{"keep.log", false, false},          // Negated
+		{"src/important.log", false, false}, // Negated by a deeper file
+		{"top.txt", false, true},
+		{"sub/top.txt", false, false}, // Anchored to the root
+		{"docs/a.tmp", false, true},
+		{"docs/nested/b.tmp", false, false}, // "*" stays within one segment
+		{"a/b/cache", true, true},
+		{"a/b/cache/x", false, true},
+		{"#hash", false, true}, // Escaped, not a comment
+		{"src/generated.go", false, true},
+		{"other/generated.go", false, false}, // Rule lives in src/.gitignore
+		{"src/deep/file.go", false, true},
+		{".git", true, true}, // Always ignored
+		{"vendor/.git/HEAD", false, true},
+		{"vendor/.agent-trash/x", false, true},
+		{"node_modules/x/y.js", false, false}, // No rule for it here
+		{".", true, false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestListFilesRespectsGitignore(t *testing.T) {
+	files := map[string]string{
+		".gitignore":        "build/\n*.log\n",
+		"main.go":           "",
+		"debug.log":         "",
+		"build/out.bin":     "",
+		"src/app.go":        "",
+		"src/app.log":       "",
+		"node_modules/x.js": "",
+		".git/HEAD":         "",
+	}
+	tests := []struct {
+		name string
+		args map[string]any
+		want []string
+		hide []string
+	}{
+		{"top level", map[string]any{"path": "."}, []string{"main.go", "src/"}, []string{"debug.log", `"build/"`, `".git/"`}},
+		{"recursive", map[string]any{"path": ".", "recursive": true}, []string{"src/app.go", "node_modules/x.js"}, []string{"app.log", "out.bin", "HEAD"}},
+		{"include ignored", map[string]any{"path": ".", "recursive": true, "include_ignored": true}, []string{"debug.log", "build/out.bin", "src/app.log", ".git/HEAD"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, files)
+			result := listFiles(context.Background(), tt.args, tc)
+			if !result.OK {
+				t.Fatalf("list_files failed: %s", resultJSON(t, result))
+			}
+			encoded := resultJSON(t, result)
+			for _, want := range tt.want {
+				if !strings.Contains(encoded, `"`+want+`"`) {
+					t.Errorf("listing is missing %s: %s", want, encoded)
+				}
+			}
+			for _, hidden := range tt.hide {
+				if strings.Contains(encoded, hidden) {
+					t.Errorf("listing shows %s: %s", hidden, encoded)
+				}
+			}
+		})
+	}
+}
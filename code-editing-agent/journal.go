@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// defaultJournalSize is how many operations undo_last_edit can revert.
+const defaultJournalSize = 20
+
+// journalEntry records enough state to revert one successful file operation.
+type journalEntry struct {
+	Op       string // "write", "edit", "append", "delete", "move", "patch", "replace", or "format"
+	Path     string // Resolved path that was changed (the source for moves)
+	Display  string // Path as the model supplied it, for messages
+	Existed  bool   // Whether Path existed before a write, edit, or append
+	Previous []byte // Prior contents of Path for writes and edits
+	Size     int64  // Prior size of Path for appends, which keep no prior contents
+	Appended []byte // Data an append added after Size
+
+	TrashPath string // Where a deleted file was moved
+
+	Destination  string      // Resolved destination of a move
+	DestExisted  bool        // Whether a move overwrote an existing destination
+	DestPrevious []byte      // Prior contents of an overwritten destination
+	DestMode     os.FileMode // Permissions of an overwritten destination
+
+	Group []journalEntry // Per-file edits made by one patch, replace, or format
+}
+
+// WriteJournal is a bounded stack of recent file operations.
+// When full, the oldest entry is dropped.
+type WriteJournal struct {
+	mu      sync.Mutex
+	entries []journalEntry
+	limit   int
+}
+
+// NewWriteJournal creates a journal holding at most limit entries.
+func NewWriteJournal(limit int) *WriteJournal {
+	return &WriteJournal{limit: limit}
+}
+
+// record pushes an entry, evicting the oldest when the journal is full.
+func (j *WriteJournal) record(entry journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.limit <= 0 {
+		return
+	}
+	if len(j.entries) >= j.limit {
+		j.entries = j.entries[1:]
+	}
+	j.entries = append(j.entries, entry)
+}
+
+// peek returns the most recent entry without removing it.
+func (j *WriteJournal) peek() (journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.entries) == 0 {
+		return journalEntry{}, false
+	}
+	return j.entries[len(j.entries)-1], true
+}
+
+// pop removes the most recent entry.
+func (j *WriteJournal) pop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.entries) > 0 {
+		j.entries = j.entries[:len(j.entries)-1]
+	}
+}
+
+// revert undoes the operation described by the entry.
+func (e journalEntry) revert(fsys FileSystem) error {
+	switch e.Op {
+	case "write", "edit":
+		if !e.Existed {
+			return fsys.Remove(e.Path)
+		}
+		return fsys.WriteFile(e.Path, e.Previous, 0644)
+
+	case "append":
+		// Cut the file back to its old length in place, but only while it
+		// still ends with exactly what was appended, so later changes are
+		// never thrown away
+		if err := e.checkAppended(fsys); err != nil {
+			return err
+		}
+		if !e.Existed {
+			return fsys.Remove(e.Path)
+		}
+		return fsys.Truncate(e.Path, e.Size)
+
+	case "delete":
+		if _, err := fsys.Lstat(e.Path); err == nil {
+			return fmt.Errorf("%s has been recreated since it was deleted", e.Display)
+		}
+		if err := fsys.MkdirAll(filepath.Dir(e.Path), 0755); err != nil {
+			return err
+		}
+		return fsys.Rename(e.TrashPath, e.Path)
+
+	case "move":
+		if _, err := fsys.Lstat(e.Path); err == nil {
+			return fmt.Errorf("%s has been recreated since it was moved", e.Display)
+		}
+		if err := fsys.Rename(e.Destination, e.Path); err != nil {
+			return err
+		}
+		if e.DestExisted {
+			return fsys.WriteFile(e.Destination, e.DestPrevious, e.DestMode)
+		}
+		return nil
+
+	case "patch", "replace", "format":
+		for i := len(e.Group) - 1; i >= 0; i-- {
+			if err := e.Group[i].revert(fsys); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown journal operation: %s", e.Op)
+	}
+}
+
+// checkAppended returns an error unless the file an append entry describes
+// is exactly its old size plus the appended data, reading only that data.
+func (e journalEntry) checkAppended(fsys FileSystem) error {
+	f, err := fsys.Open(e.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	switch want := e.Size + int64(len(e.Appended)); {
+	case info.Size() < want:
+		return fmt.Errorf("%s has shrunk since the append", e.Display)
+	case info.Size() > want:
+		return fmt.Errorf("%s has grown since the append", e.Display)
+	}
+
+	tail := make([]byte, len(e.Appended))
+	if _, err := f.Seek(e.Size, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(f, tail); err != nil {
+		return err
+	}
+	if !bytes.Equal(tail, e.Appended) {
+		return fmt.Errorf("%s has changed since the append", e.Display)
+	}
+	return nil
+}
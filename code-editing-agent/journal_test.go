@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUndoAppend(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		tamper   string // Content written over the file after the append; "" leaves it
+		wantErr  string
+		wantFile string // Content afterwards; "-" means the file is gone
+	}{
+		{"existing file", "log.txt", "", "", "one\n"},
+		{"new file", "new.txt", "", "", "-"},
+		{"shrunk", "log.txt", "one\n", "has shrunk since the append", "one\n"},
+		{"shrunk below the old size", "log.txt", "o", "has shrunk since the append", "o"},
+		{"grown", "log.txt", "one\ntwo\nthree\n", "has grown since the append", "one\ntwo\nthree\n"},
+		{"changed", "log.txt", "one\nTWO\n", "has changed since the append", "one\nTWO\n"},
+		{"new file changed", "new.txt", "TWO\n", "has changed since the append", "TWO\n"},
+	}
+	// The root-confined file system is the default; the plain one backs the
+	// allow symlink policy
+	policies := map[string]SymlinkPolicy{"root": SymlinkWithinRoot, "os": SymlinkAllow}
+	for fsName, policy := range policies {
+		for _, tt := range tests {
+			t.Run(fsName+"/"+tt.name, func(t *testing.T) {
+				tc := newTestToolContext(t, map[string]string{"log.txt": "one\n"}, WithSymlinkPolicy(policy))
+				ctx := context.Background()
+				if result := appendToFile(ctx, map[string]any{"path": tt.path, "content": "two\n"}, tc); !result.OK {
+					t.Fatalf("append_to_file failed: %s", result.Error.Message)
+				}
+				if tt.tamper != "" {
+					writeTree(t, tc.Sandbox.Root, map[string]string{tt.path: tt.tamper})
+				}
+
+				result := undoLastEdit(ctx, map[string]any{}, tc)
+				if tt.wantErr != "" {
+					if result.OK || !strings.Contains(result.Error.Message, tt.wantErr) {
+						t.Errorf("undo_last_edit = %s, want an error containing %q", resultJSON(t, result), tt.wantErr)
+					}
+					if _, ok := tc.Journal.peek(); !ok {
+						t.Error("failed undo dropped the journal entry")
+					}
+				} else if !result.OK {
+					t.Errorf("undo_last_edit failed: %s", result.Error.Message)
+				}
+
+				got, ok := readTestFile(t, tc, tt.path)
+				if tt.wantFile == "-" {
+					if ok {
+						t.Errorf("%s = %q, want it removed", tt.path, got)
+					}
+				} else if got != tt.wantFile {
+					t.Errorf("%s = %q, want %q", tt.path, got, tt.wantFile)
+				}
+			})
+		}
+	}
+}
+
+func TestUndoAppendKeepsMode(t *testing.T) {
+	tc := newTestToolContext(t, map[string]string{"run.sh": "#!/bin/sh\n"})
+	path := filepath.Join(tc.Sandbox.Root, "run.sh")
+	if err := os.Chmod(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	appendToFile(ctx, map[string]any{"path": "run.sh", "content": "echo hi\n"}, tc)
+	if result := undoLastEdit(ctx, map[string]any{}, tc); !result.OK {
+		t.Fatalf("undo_last_edit failed: %s", result.Error.Message)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("mode after undo = %v, want 0755", info.Mode().Perm())
+	}
+}
+
+// toolCall is one tool invocation in a scripted sequence.
+type toolCall struct {
+	run  func(context.Context, map[string]any, *ToolContext) *ToolResult
+	args map[string]any
+}
+
+func TestUndoLastEdit(t *testing.T) {
+	files := map[string]string{"a.txt": "one\r\ntwo\x00\xff", "dir/b.txt": "bee\n"}
+	tests := []struct {
+		name    string
+		calls   []toolCall
+		undos   int
+		wantErr string            // Error code of the last undo; "" for success
+		want    map[string]string // Contents afterwards, or absent
+	}{
+		{"nothing to undo", nil, 1, "not_found", map[string]string{"a.txt": "one\r\ntwo\x00\xff"}},
+		{"overwrite restored byte for byte", []toolCall{
+			{writeFile, map[string]any{"path": "a.txt", "content": "replaced"}},
+		}, 1, "", map[string]string{"a.txt": "one\r\ntwo\x00\xff"}},
+		{"created file removed", []toolCall{
+			{writeFile, map[string]any{"path": "new/c.txt", "content": "sea"}},
+		}, 1, "", map[string]string{"new/c.txt": absent}},
+		{"edit reverted", []toolCall{
+			{editFile, map[string]any{"path": "dir/b.txt", "old_str": "bee", "new_str": "wasp"}},
+		}, 1, "", map[string]string{"dir/b.txt": "bee\n"}},
+		{"deleted file restored", []toolCall{
+			{deleteFile, map[string]any{"path": "a.txt"}},
+		}, 1, "", map[string]string{"a.txt": "one\r\ntwo\x00\xff"}},
+		{"deleted directory restored", []toolCall{
+			{deleteFile, map[string]any{"path": "dir", "recursive": true}},
+		}, 1, "", map[string]string{"dir/b.txt": "bee\n"}},
+		{"move reverted", []toolCall{
+			{moveFile, map[string]any{"source": "a.txt", "destination": "dir/b.txt", "overwrite": true}},
+		}, 1, "", map[string]string{"a.txt": "one\r\ntwo\x00\xff", "dir/b.txt": "bee\n"}},
+		{"most recent first", []toolCall{
+			{writeFile, map[string]any{"path": "a.txt", "content": "first"}},
+			{writeFile, map[string]any{"path": "a.txt", "content": "second"}},
+		}, 1, "", map[string]string{"a.txt": "first"}},
+		{"undone in reverse order", []toolCall{
+			{writeFile, map[string]any{"path": "a.txt", "content": "first"}},
+			{deleteFile, map[string]any{"path": "a.txt"}},
+			{writeFile, map[string]any{"path": "a.txt", "content": "again"}},
+		}, 3, "", map[string]string{"a.txt": "one\r\ntwo\x00\xff"}},
+		{"recreated after delete", []toolCall{
+			{deleteFile, map[string]any{"path": "dir/b.txt"}},
+			{makeDirectory, map[string]any{"path": "dir/b.txt"}},
+		}, 1, "io_error", nil},
+		{"failed calls are not journaled", []toolCall{
+			{writeFile, map[string]any{"path": "a.txt", "content": "kept"}},
+			{deleteFile, map[string]any{"path": "missing.txt"}},
+		}, 1, "", map[string]string{"a.txt": "one\r\ntwo\x00\xff"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, files)
+			ctx := context.Background()
+			for _, call := range tt.calls {
+				call.run(ctx, call.args, tc)
+			}
+			var result *ToolResult
+			for range tt.undos {
+				result = undoLastEdit(ctx, map[string]any{}, tc)
+			}
+			if tt.wantErr != "" {
+				if result.OK || result.Error.Code != tt.wantErr {
+					t.Errorf("undo_last_edit = %s, want error %s", resultJSON(t, result), tt.wantErr)
+				}
+			} else if !result.OK {
+				t.Errorf("undo_last_edit failed: %s", resultJSON(t, result))
+			}
+			for name, want := range tt.want {
+				got, ok := readTestFile(t, tc, name)
+				switch {
+				case want == absent && ok:
+					t.Errorf("%s exists with %q, want it absent", name, got)
+				case want != absent && (!ok || got != want):
+					t.Errorf("%s = %q (exists %v), want %q", name, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteJournalLimit(t *testing.T) {
+	tests := []struct {
+		limit     int
+		writes    int
+		wantUndos int // Undos that succeed before the journal is empty
+	}{
+		{0, 3, 0},
+		{1, 3, 1},
+		{2, 3, 2},
+		{5, 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("limit %d", tt.limit), func(t *testing.T) {
+			tc := newTestToolContext(t, map[string]string{"a.txt": "v0"})
+			tc.Journal = NewWriteJournal(tt.limit)
+			ctx := context.Background()
+			for i := range tt.writes {
+				writeFile(ctx, map[string]any{"path": "a.txt", "content": fmt.Sprintf("v%d", i+1)}, tc)
+			}
+
+			undos := 0
+			for undoLastEdit(ctx, map[string]any{}, tc).OK {
+				undos++
+			}
+			if undos != tt.wantUndos {
+				t.Errorf("undid %d writes, want %d", undos, tt.wantUndos)
+			}
+			// The oldest writes were dropped, so undo stops short of them
+			want := fmt.Sprintf("v%d", tt.writes-tt.wantUndos)
+			if got, _ := readTestFile(t, tc, "a.txt"); got != want {
+				t.Errorf("a.txt = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Control keys understood by LineEditor.
+const (
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlH     = 8
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// errInterrupted is returned by ReadLine when ctrl-c is pressed.
+var errInterrupted = errors.New("interrupted")
+
+// LineEditor reads prompts from a terminal with minimal line editing: left
+// and right, home and end, backspace and delete, ctrl-u, and up/down recall
+// from a PromptHistory. The terminal is in raw mode only while a line is
+// being read. Where raw mode is unavailable it reads plain lines.
+type LineEditor struct {
+	in      *os.File
+	reader  *bufio.Reader
+	out     io.Writer
+	history *PromptHistory
+
+	mu      sync.Mutex
+	restore func() error // Leaves raw mode; nil outside ReadLine
+}
+
+// NewLineEditor creates a LineEditor reading from the terminal in and
+// echoing to out.
+func NewLineEditor(in *os.File, out io.Writer, history *PromptHistory) *LineEditor {
+	return &LineEditor{in: in, reader: bufio.NewReader(in), out: out, history: history}
+}
+
+// ReadLine reads one line. It returns io.EOF for ctrl-d on an empty line and
+// errInterrupted for ctrl-c. The line is not added to the history.
+func (e *LineEditor) ReadLine() (string, error) {
+	restore, err := makeRaw(e.in)
+	if err != nil {
+		line, err := e.reader.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	e.mu.Lock()
+	e.restore = restore
+	e.mu.Unlock()
+	defer e.Restore()
+
+	var line []rune
+	pos, shown := 0, 0 // Cursor position in line, and where it is on screen
+
+	// recall indexes the history entry shown; len(entries) is the line being
+	// typed, saved in draft while older entries are shown
+	entries := e.history.entries
+	recall, draft := len(entries), ""
+	show := func(s string) {
+		line = []rune(s)
+		pos = len(line)
+	}
+
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = slices.Delete(line, pos, pos+1)
+			}
+		case keyBackspace, keyCtrlH:
+			if pos > 0 {
+				line = slices.Delete(line, pos-1, pos)
+				pos--
+			}
+		case keyCtrlA:
+			pos = 0
+		case keyCtrlE:
+			pos = len(line)
+		case keyCtrlU:
+			line = slices.Delete(line, 0, pos)
+			pos = 0
+		case keyEscape:
+			switch e.readEscape() {
+			case "[A", "OA": // Up
+				if recall > 0 {
+					if recall == len(entries) {
+						draft = string(line)
+					}
+					recall--
+					show(entries[recall])
+				}
+			case "[B", "OB": // Down
+				if recall < len(entries) {
+					recall++
+					if recall == len(entries) {
+						show(draft)
+					} else {
+						show(entries[recall])
+					}
+				}
+			case "[C", "OC": // Right
+				pos = min(pos+1, len(line))
+			case "[D", "OD": // Left
+				pos = max(pos-1, 0)
+			case "[H", "OH", "[1~", "[7~": // Home
+				pos = 0
+			case "[F", "OF", "[4~", "[8~": // End
+				pos = len(line)
+			case "[3~": // Delete
+				if pos < len(line) {
+					line = slices.Delete(line, pos, pos+1)
+				}
+			}
+		default:
+			if !unicode.IsPrint(r) {
+				continue
+			}
+			line = slices.Insert(line, pos, r)
+			pos++
+		}
+
+		// Redraw from the start of the input, then put the cursor back
+		if shown > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", shown)
+		}
+		fmt.Fprint(e.out, string(line), "\x1b[K")
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+		shown = pos
+	}
+}
+
+// readEscape reads the rest of an escape sequence after ESC and returns it,
+// e.g. "[A" for the up arrow. Unknown sequences are consumed and ignored.
+func (e *LineEditor) readEscape() string {
+	first, _, err := e.reader.ReadRune()
+	if err != nil || (first != '[' && first != 'O') {
+		return ""
+	}
+	seq := []rune{first}
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, r)
+		// A final byte in @ through ~ ends the sequence
+		if r >= '@' && r <= '~' {
+			return string(seq)
+		}
+	}
+}
+
+// Restore leaves raw mode if a line is being read, so the terminal is usable
+// after the program exits mid-prompt. It is safe to call at any time.
+func (e *LineEditor) Restore() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.restore != nil {
+		e.restore()
+		e.restore = nil
+	}
+}
+
+// Readers returns functions reading a prompt and a confirmation answer from
+// e. Reads happen in the background so ctx can interrupt the wait, and only
+// start when asked for, so the terminal is never in raw mode while the agent
+// is working. Prompts are added to the history; answers are not. Ctrl-c
+// raises SIGINT, interrupting the session as it does outside raw mode.
+func (e *LineEditor) Readers(ctx context.Context) (prompt, answer func() (string, bool)) {
+	requests := make(chan bool) // Whether to record the line
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		for record := range requests {
+			line, err := e.ReadLine()
+			if errors.Is(err, errInterrupted) {
+				interruptSelf()
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if record {
+				if err := e.history.Add(line); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+			}
+			lines <- line
+		}
+	}()
+
+	read := func(record bool) (string, bool) {
+		select {
+		case requests <- record:
+		case <-lines: // Closed once input ends
+			return "", false
+		case <-ctx.Done():
+			return "", false
+		}
+		select {
+		case line, ok := <-lines:
+			return line, ok
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+	return func() (string, bool) { return read(true) }, func() (string, bool) { return read(false) }
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY returns the terminal end of a new pseudo-terminal, in raw mode so
+// keys written to the controlling end arrive unprocessed, and the
+// controlling end to write them to. It skips the test when no pseudo-terminal
+// can be opened.
+func openPTY(t *testing.T) (tty, control *os.File) {
+	t.Helper()
+	control, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminal: %v", err)
+	}
+	t.Cleanup(func() { control.Close() })
+	if err := unix.IoctlSetPointerInt(int(control.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Skipf("no pseudo-terminal: %v", err)
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_IOCTL, control.Fd(), unix.TIOCGPTPEER, unix.O_RDWR|unix.O_NOCTTY)
+	if errno != 0 {
+		t.Skipf("no pseudo-terminal: %v", errno)
+	}
+	tty = os.NewFile(fd, "pty")
+	t.Cleanup(func() { tty.Close() })
+	if _, err := makeRaw(tty); err != nil {
+		t.Fatal(err)
+	}
+	return tty, control
+}
+
+func TestLineEditorKeys(t *testing.T) {
+	const (
+		up    = "\x1b[A"
+		down  = "\x1b[B"
+		right = "\x1b[C"
+		left  = "\x1b[D"
+	)
+	tests := []struct {
+		name    string
+		keys    string
+		want    string
+		wantErr error
+	}{
+		{name: "plain line", keys: "hello\r", want: "hello"},
+		{name: "newline ends the line", keys: "hello\n", want: "hello"},
+		{name: "up recalls the newest", keys: up + "\r", want: "second"},
+		{name: "up twice recalls older", keys: up + up + "\r", want: "first"},
+		{name: "up stops at the oldest", keys: up + up + up + up + "\r", want: "first"},
+		{name: "down returns to newer", keys: up + up + down + "\r", want: "second"},
+		{name: "down restores the draft", keys: "dra" + up + up + down + down + "ft\r", want: "draft"},
+		{name: "down without recall does nothing", keys: "x" + down + "\r", want: "x"},
+		{name: "recalled line can be edited", keys: up + "!\r", want: "second!"},
+		{name: "left and insert", keys: "helo" + left + "l\r", want: "hello"},
+		{name: "left stops at the start", keys: "bc" + left + left + left + "a\r", want: "abc"},
+		{name: "right stops at the end", keys: "ab" + left + right + right + "c\r", want: "abc"},
+		{name: "backspace", keys: "abcd\x7f\r", want: "abc"},
+		{name: "ctrl-h", keys: "abcd\x08\r", want: "abc"},
+		{name: "backspace at the start", keys: "ab\x01\x7f\r", want: "ab"},
+		{name: "home and end", keys: "b\x1b[Ha\x1b[Fc\r", want: "abc"},
+		{name: "ctrl-a and ctrl-e", keys: "b\x01a\x05c\r", want: "abc"},
+		{name: "delete", keys: "abc\x01\x1b[3~\r", want: "bc"},
+		{name: "ctrl-d deletes under the cursor", keys: "abc\x01\x04\r", want: "bc"},
+		{name: "ctrl-u clears before the cursor", keys: "junk ok" + left + left + "\x15\r", want: "ok"},
+		{name: "multibyte runes", keys: "héllo" + left + left + "\x7f\r", want: "hélo"},
+		{name: "unknown escapes ignored", keys: "a\x1b[5~\x1b[1;5Cb\r", want: "ab"},
+		{name: "control characters ignored", keys: "a\x02\x07b\r", want: "ab"},
+		{name: "ctrl-d on an empty line", keys: "\x04", wantErr: io.EOF},
+		{name: "ctrl-c", keys: "partial\x03", wantErr: errInterrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tty, control := openPTY(t)
+			before, err := unix.IoctlGetTermios(int(tty.Fd()), ioctlReadTermios)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := control.WriteString(tt.keys); err != nil {
+				t.Fatal(err)
+			}
+
+			history := &PromptHistory{entries: []string{"first", "second"}}
+			editor := NewLineEditor(tty, io.Discard, history)
+			got, err := editor.ReadLine()
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("ReadLine() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+			if !slices.Equal(history.entries, []string{"first", "second"}) {
+				t.Errorf("ReadLine changed the history to %q", history.entries)
+			}
+
+			after, err := unix.IoctlGetTermios(int(tty.Fd()), ioctlReadTermios)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *after != *before {
+				t.Error("terminal mode not restored after ReadLine")
+			}
+		})
+	}
+}
+
+func TestLineEditorReaders(t *testing.T) {
+	tty, control := openPTY(t)
+	if _, err := control.WriteString("prompt one\ryes\rprompt two\r\x04"); err != nil {
+		t.Fatal(err)
+	}
+	history := &PromptHistory{}
+	prompt, answer := NewLineEditor(tty, io.Discard, history).Readers(context.Background())
+
+	steps := []struct {
+		read   func() (string, bool)
+		want   string
+		wantOK bool
+	}{
+		{prompt, "prompt one", true},
+		{answer, "yes", true},
+		{prompt, "prompt two", true},
+		{prompt, "", false},
+		{answer, "", false},
+	}
+	for i, step := range steps {
+		if got, ok := step.read(); got != step.want || ok != step.wantOK {
+			t.Errorf("read %d = %q, %t, want %q, %t", i, got, ok, step.want, step.wantOK)
+		}
+	}
+	// Prompts are recalled later; answers are not
+	if want := []string{"prompt one", "prompt two"}; !slices.Equal(history.entries, want) {
+		t.Errorf("history = %q, want %q", history.entries, want)
+	}
+}
+
+func TestLineEditorReadersCancelled(t *testing.T) {
+	tty, _ := openPTY(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	prompt, _ := NewLineEditor(tty, io.Discard, &PromptHistory{}).Readers(ctx)
+	cancel()
+	if got, ok := prompt(); ok {
+		t.Errorf("prompt() after cancelling = %q, want no line", got)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestLineEditorWithoutTerminal(t *testing.T) {
+	// Raw mode is unavailable on a pipe, so lines are read as they are
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		io.WriteString(w, "first line\nwindows line\r\nlast without newline")
+		w.Close()
+	}()
+
+	history, _ := LoadPromptHistory("")
+	editor := NewLineEditor(r, io.Discard, history)
+	for _, want := range []string{"first line", "windows line", "last without newline"} {
+		got, err := editor.ReadLine()
+		if err != nil || got != want {
+			t.Fatalf("ReadLine() = %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := editor.ReadLine(); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadLine() at the end = %v, want EOF", err)
+	}
+	// Restore outside ReadLine does nothing
+	editor.Restore()
+}
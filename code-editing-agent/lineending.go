@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// Line ending modes accepted by write_file and edit_file.
+const (
+	lineEndingLF       = "lf"
+	lineEndingCRLF     = "crlf"
+	lineEndingPreserve = "preserve"
+)
+
+// lineEndingSchema is the line_ending parameter shared by the write tools.
+var lineEndingSchema = &genai.Schema{
+	Type:        genai.TypeString,
+	Description: "Line endings to write: 'lf', 'crlf', or 'preserve' (default) to keep the existing file's dominant ending. New files default to lf.",
+	Enum:        []string{lineEndingLF, lineEndingCRLF, lineEndingPreserve},
+}
+
+// getLineEndingArg retrieves and validates the optional line_ending argument.
+func getLineEndingArg(args map[string]any) (string, error) {
+	mode, err := getOptionalStringArg(args, "line_ending", lineEndingPreserve)
+	if err != nil {
+		return "", err
+	}
+	switch mode {
+	case lineEndingLF, lineEndingCRLF, lineEndingPreserve:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("argument line_ending must be one of lf, crlf, or preserve")
+	}
+}
+
+// detectLineEnding returns the dominant line ending of text. Text with no
+// line breaks, or as many LF as CRLF endings, is treated as lf.
+func detectLineEnding(text string) string {
+	crlf := strings.Count(text, "\r\n")
+	lf := strings.Count(text, "\n") - crlf
+	if crlf > lf {
+		return lineEndingCRLF
+	}
+	return lineEndingLF
+}
+
+// resolveLineEnding picks the ending to write for mode. preserve keeps the
+// ending of existing, or uses lf when the file is new.
+func resolveLineEnding(mode string, existing string, existed bool) string {
+	if mode != lineEndingPreserve {
+		return mode
+	}
+	if existed {
+		return detectLineEnding(existing)
+	}
+	return lineEndingLF
+}
+
+// convertLineEndings rewrites every line break in text to ending.
+func convertLineEndings(text, ending string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if ending == lineEndingCRLF {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	return text
+}
@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+func TestDetectLineEnding(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", lineEndingLF},
+		{"no breaks", lineEndingLF},
+		{"a\nb\n", lineEndingLF},
+		{"a\r\nb\r\n", lineEndingCRLF},
+		{"a\r\nb\r\nc\n", lineEndingCRLF},
+		{"a\r\nb\nc\n", lineEndingLF},
+		{"a\r\nb\n", lineEndingLF},
+	}
+	for _, tt := range tests {
+		if got := detectLineEnding(tt.text); got != tt.want {
+			t.Errorf("detectLineEnding(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestResolveLineEnding(t *testing.T) {
+	tests := []struct {
+		mode     string
+		existing string
+		existed  bool
+		want     string
+	}{
+		{lineEndingPreserve, "a\r\nb\r\n", true, lineEndingCRLF},
+		{lineEndingPreserve, "a\nb\n", true, lineEndingLF},
+		{lineEndingPreserve, "", false, lineEndingLF},
+		{lineEndingLF, "a\r\nb\r\n", true, lineEndingLF},
+		{lineEndingCRLF, "a\nb\n", true, lineEndingCRLF},
+		{lineEndingCRLF, "", false, lineEndingCRLF},
+	}
+	for _, tt := range tests {
+		if got := resolveLineEnding(tt.mode, tt.existing, tt.existed); got != tt.want {
+			t.Errorf("resolveLineEnding(%s, %q, %v) = %s, want %s", tt.mode, tt.existing, tt.existed, got, tt.want)
+		}
+	}
+}
+
+func TestConvertLineEndings(t *testing.T) {
+	tests := []struct {
+		text, ending, want string
+	}{
+		{"a\nb\n", lineEndingCRLF, "a\r\nb\r\n"},
+		{"a\r\nb\n", lineEndingCRLF, "a\r\nb\r\n"},
+		{"a\r\nb\r\n", lineEndingLF, "a\nb\n"},
+		{"a\r\nb\n", lineEndingLF, "a\nb\n"},
+		{"lone\rreturn\n", lineEndingLF, "lone\rreturn\n"},
+		{"", lineEndingCRLF, ""},
+	}
+	for _, tt := range tests {
+		if got := convertLineEndings(tt.text, tt.ending); got != tt.want {
+			t.Errorf("convertLineEndings(%q, %s) = %q, want %q", tt.text, tt.ending, got, tt.want)
+		}
+	}
+}
+
+func TestLineEndingTools(t *testing.T) {
+	files := map[string]string{"win.txt": "one\r\ntwo\r\nthree\r\n", "unix.txt": "one\ntwo\n"}
+	runToolCases(t, editFile, files, []toolCase{
+		{name: "crlf file stays crlf after an edit", args: map[string]any{"path": "win.txt", "old_str": "two\nthree", "new_str": "2\n3"},
+			want: map[string]string{"win.txt": "one\r\n2\r\n3\r\n"}},
+		{name: "crlf old_str matches a crlf file", args: map[string]any{"path": "win.txt", "old_str": "one\r\ntwo", "new_str": "1\n2"},
+			want: map[string]string{"win.txt": "1\r\n2\r\nthree\r\n"}},
+		{name: "edit converts to lf", args: map[string]any{"path": "win.txt", "old_str": "one", "new_str": "1", "line_ending": "lf"},
+			want: map[string]string{"win.txt": "1\ntwo\nthree\n"}},
+		{name: "edit converts to crlf", args: map[string]any{"path": "unix.txt", "old_str": "one", "new_str": "1", "line_ending": "crlf"},
+			want: map[string]string{"unix.txt": "1\r\ntwo\r\n"}},
+		{name: "created file defaults to lf", args: map[string]any{"path": "new.txt", "old_str": "", "new_str": "a\r\nb\r\n"},
+			want: map[string]string{"new.txt": "a\nb\n"}},
+		{name: "invalid mode", args: map[string]any{"path": "win.txt", "old_str": "one", "new_str": "1", "line_ending": "cr"},
+			wantErr: "invalid_argument", want: map[string]string{"win.txt": files["win.txt"]}},
+	})
+	runToolCases(t, writeFile, files, []toolCase{
+		{name: "overwrite keeps crlf", args: map[string]any{"path": "win.txt", "content": "a\nb\n"},
+			want: map[string]string{"win.txt": "a\r\nb\r\n"}},
+		{name: "overwrite keeps lf", args: map[string]any{"path": "unix.txt", "content": "a\r\nb\r\n"},
+			want: map[string]string{"unix.txt": "a\nb\n"}},
+		{name: "new file defaults to lf", args: map[string]any{"path": "new.txt", "content": "a\r\nb\r\n"},
+			want: map[string]string{"new.txt": "a\nb\n"}},
+		{name: "new file as crlf", args: map[string]any{"path": "new.txt", "content": "a\nb\n", "line_ending": "crlf"},
+			want: map[string]string{"new.txt": "a\r\nb\r\n"}},
+	})
+}
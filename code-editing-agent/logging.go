@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// newLogger returns the logger for debug and trace output.
+// jsonOutput emits JSON lines for log analysis; debug emits human-readable
+// [DEBUG] lines. With neither set, all output is discarded.
+func newLogger(w io.Writer, debug, jsonOutput bool) *slog.Logger {
+	switch {
+	case jsonOutput:
+		return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	case debug:
+		return slog.New(&debugTextHandler{mu: &sync.Mutex{}, w: w})
+	default:
+		return slog.New(slog.DiscardHandler)
+	}
+}
+
+// debugTextHandler writes records as "[LEVEL] message key=value ..." lines.
+// Groups are flattened, since the agent never uses them.
+type debugTextHandler struct {
+	mu    *sync.Mutex // Shared across WithAttrs copies so lines never interleave
+	w     io.Writer
+	attrs []slog.Attr
+}
+
+// Enabled reports true for every level.
+func (h *debugTextHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle formats and writes a single record.
+func (h *debugTextHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] ", r.Level)
+	b.WriteString(r.Message)
+
+	writeAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value.Resolve())
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// WithAttrs returns a handler that prefixes every record with attrs.
+func (h *debugTextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &debugTextHandler{mu: h.mu, w: h.w, attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+// WithGroup returns h unchanged; group names are not rendered.
+func (h *debugTextHandler) WithGroup(string) slog.Handler {
+	return h
+}
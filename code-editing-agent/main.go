@@ -3,65 +3,518 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"maps"
+	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"google.golang.org/genai"
 )
 
 func main() {
-	// Parse CLI flags
-	model := flag.String("model", "gemini-3-flash-preview", "Model to use")
-	root := flag.String("root", "", "Project root (default: current working directory)")
-	debug := flag.Bool("debug", false, "Enable debug logging")
-	flag.Parse()
-
-	// Resolve root path
-	rootPath := *root
-	if rootPath == "" {
-		var err error
-		rootPath, err = os.Getwd()
+	os.Exit(run(os.Args, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run is the whole program behind main: it parses args, the program name
+// followed by its arguments, sets up the client, sandbox, and agent, and runs
+// an interactive session or a one-shot prompt, returning the process exit
+// code. All input and output, the conversation included, goes through stdin,
+// stdout, and stderr; line editing, color, and wrapping turn on only when
+// those are terminals.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	// Parse CLI flags; their defaults are the built-in settings
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	cfg := DefaultConfig()
+	backend := flags.String("backend", "", "API backend: gemini or vertex (default: vertex if $GOOGLE_GENAI_USE_VERTEXAI is true, else gemini)")
+	apiKey := flags.String("api-key", "", "Gemini API key (default: $GOOGLE_API_KEY, then $GEMINI_API_KEY; prefer the environment, since flags are visible to other local users)")
+	project := flags.String("project", "", "Google Cloud project for the vertex backend (default: $GOOGLE_CLOUD_PROJECT)")
+	location := flags.String("location", "", "Google Cloud location for the vertex backend (default: $GOOGLE_CLOUD_LOCATION)")
+	model := flags.String("model", cfg.Model, "Model to use (overrides $GEMINI_MODEL)")
+	temperature := flags.Float64("temperature", 0, "Sampling temperature, 0-2 (overrides $GEMINI_TEMPERATURE; default: model default)")
+	topP := flags.Float64("top-p", 0, "Nucleus sampling probability, 0-1 (overrides $GEMINI_TOP_P; default: model default)")
+	maxOutputTokens := flags.Int("max-output-tokens", 0, "Maximum tokens per response (overrides $GEMINI_MAX_OUTPUT_TOKENS; 0 = model default)")
+	root := flags.String("root", "", "Project root (default: $AGENT_ROOT, then the current working directory)")
+	debug := flags.Bool("debug", false, "Enable debug logging")
+	verbose := flags.Bool("verbose", false, "Print each tool result to stderr as the JSON sent back to the model")
+	logJSON := flags.Bool("log-json", false, "Write debug logging to stderr as JSON lines")
+	noColor := flags.Bool("no-color", false, "Disable colored output (also off when $NO_COLOR is set or stdout is not a terminal)")
+	wrap := flags.Bool("wrap", true, "Soft-wrap model output to the terminal width (only when stdout is a terminal)")
+	renderMarkdown := flags.Bool("render-markdown", false, "Show each complete response with Markdown styling and highlighted code instead of streaming raw text")
+	systemPrompt := flags.String("system-prompt", "", "System instruction (overrides $SYSTEM_PROMPT and AGENT.md)")
+	autoContext := flags.Bool("auto-context", false, "Start the session with a short summary of the project (build files, languages, layout, README) in the system instruction")
+	showUsage := flags.Bool("show-usage", false, "Print running token usage after each turn")
+	showStats := flags.Bool("stats", false, "Print per-tool call counts and latency when the session ends")
+	turnTimeout := flags.Duration("turn-timeout", 0, "Maximum time for one turn, including all model requests and tool calls (0 = unlimited)")
+	maxToolRounds := flags.Int("max-tool-rounds", cfg.MaxToolRounds, "Rounds of tool calls allowed per turn before the model must answer (0 = unlimited)")
+	maxRepeatCalls := flags.Int("max-repeat-calls", cfg.MaxRepeatCalls, "Identical tool calls allowed in a row before repeats are refused (0 = unlimited)")
+	maxResultBytes := flags.Int("max-result-bytes", cfg.MaxResultBytes, "Largest tool result, as encoded JSON, sent to the model before it is truncated (0 = unlimited)")
+	maxTokens := flags.Int("max-tokens", 0, "Stop the session once this many total tokens are used (0 = unlimited)")
+	compactThreshold := flags.Int("compact-threshold", cfg.CompactThreshold, "Estimated history tokens that trigger summarization (0 = never)")
+	compactKeepTurns := flags.Int("compact-keep-turns", cfg.CompactKeepTurns, "Recent turns kept verbatim when summarizing history")
+	maxHistory := flags.Int("max-history-messages", 0, "Drop the oldest history entries beyond this many, keeping tool calls with their responses (0 = unlimited)")
+	maxHistoryBytes := flags.Int("max-history-bytes", cfg.MaxHistoryBytes, "Drop the oldest history entries once the serialized history exceeds this many bytes (0 = unlimited)")
+	listModelsFlag := flags.Bool("list-models", false, "List available models and exit")
+	noPreflight := flags.Bool("no-preflight", false, "Skip the startup check of connectivity, credentials, and the model (e.g. offline or with --replay)")
+	versionFlag := flags.Bool("version", false, "Print version and build information and exit")
+	yes := flags.Bool("yes", false, "Run file-modifying tools and commands without asking for confirmation (required for them with --prompt)")
+	dryRun := flags.Bool("dry-run", false, "Simulate file writes, edits, deletes, and moves without changing anything")
+	allowPaths := flags.String("allow-paths", "", "Comma-separated globs; if set, only matching paths under the root are accessible")
+	denyPaths := flags.String("deny-paths", strings.Join(cfg.DenyPaths, ","), "Comma-separated globs for paths under the root that are never accessible")
+	followSymlinks := flags.String("follow-symlinks", "within-root", "Symlinks the sandbox follows: within-root, deny, or allow")
+	allowAbsolutePaths := flags.Bool("allow-absolute-paths", false, "Accept absolute tool paths under the root (by default only root-relative paths are accepted)")
+	writeQuota := flags.Int64("write-quota", 0, "Maximum total bytes tools may write this session (0 = unlimited)")
+	prompt := flags.String("prompt", "", "Run a single prompt non-interactively and exit (\"-\" reads it from stdin)")
+	traceFile := flags.String("trace-file", "", "Append every model request and response to this JSONL file, with the API key redacted")
+	replay := flags.String("replay", "", "Serve the model responses recorded in this --trace-file instead of calling the API; tools still run")
+	historyFile := flags.String("history-file", defaultHistoryFile(), "File keeping interactive prompts for up/down recall across sessions (\"\" keeps them for this session only)")
+	session := flags.String("session", "", "Session file to load history from and save it to after each turn")
+	enableTools := flags.String("enable-tools", "", "Comma-separated tools to offer the model; all others are disabled (default: all)")
+	disableTools := flags.String("disable-tools", "", "Comma-separated tools to withhold from the model")
+	toolPolicy := flags.String("tool-policy", "", "Comma-separated tool=policy pairs overriding confirmation: always, never, or ask (e.g. write_file=ask,run_command=never)")
+	configPath := flags.String("config", "", "Config file of flag settings (default: agent.toml or agent.json in the working directory)")
+	allowCommands := flags.String("allow-commands", strings.Join(cfg.AllowedCommands, ","), "Comma-separated commands run_command may execute")
+	formatters := flags.String("formatters", "", "JSON file mapping file extensions to format_code commands, overriding the defaults")
+	if err := flags.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	// Needs no config or credentials, so it works even when those are broken
+	if *versionFlag {
+		printVersion(stdout)
+		return exitOK
+	}
+
+	// Fill in flags not given on the command line from the config file
+	configFile, err := loadConfig(*configPath, flags, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error loading config: %v\n", err)
+		return exitUsage
+	}
+
+	// Resolve the backend first so missing credentials fail before anything
+	// else. Replays never call the API, so they need none.
+	clientConfig := &genai.ClientConfig{}
+	if *replay == "" || *listModelsFlag {
+		clientConfig, err = resolveClientConfig(*backend, *apiKey, *project, *location)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error configuring client: %v\n", err)
+			return exitAuth
+		}
+	}
+
+	if *listModelsFlag {
+		if err := listModels(stdout, clientConfig); err != nil {
+			fmt.Fprintf(stderr, "Error listing models: %v\n", err)
+			return exitCodeFor(context.Background(), err)
+		}
+		return exitOK
+	}
+
+	// Resolve model: explicit flag, then $GEMINI_MODEL, then the default
+	cfg.Model = *model
+	if envModel := os.Getenv("GEMINI_MODEL"); envModel != "" && !flagWasSet(flags, "model") {
+		cfg.Model = envModel
+	}
+
+	// Resolve sampling parameters up front so bad values fail fast
+	if err := configureGeneration(cfg, flags, *temperature, *topP, *maxOutputTokens); err != nil {
+		fmt.Fprintf(stderr, "Error configuring generation: %v\n", err)
+		return exitUsage
+	}
+
+	// Resolve root path: explicit flag, then $AGENT_ROOT, then the working directory
+	cfg.Root = *root
+	if cfg.Root == "" {
+		cfg.Root = os.Getenv("AGENT_ROOT")
+	}
+	if cfg.Root == "" {
+		cfg.Root, err = os.Getwd()
+		if err != nil {
+			fmt.Fprintf(stderr, "Error resolving working directory: %v\n", err)
+			return exitError
+		}
+	}
+
+	cfg.FollowSymlinks, err = ParseSymlinkPolicy(*followSymlinks)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error configuring sandbox: %v\n", err)
+		return exitUsage
+	}
+	cfg.WriteQuota = *writeQuota
+	cfg.AllowPaths = parseList(*allowPaths)
+	cfg.DenyPaths = parseList(*denyPaths)
+	cfg.AllowAbsolutePaths = *allowAbsolutePaths
+
+	// --formatters entries override the config file's per extension
+	cfg.Formatters = map[string]string{}
+	if configFile != nil {
+		maps.Copy(cfg.Formatters, configFile.Formatters)
+	}
+	if *formatters != "" {
+		overrides, err := LoadFormatters(*formatters)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error resolving working directory: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(stderr, "Error loading formatters: %v\n", err)
+			return exitUsage
 		}
+		maps.Copy(cfg.Formatters, overrides)
 	}
 
+	// Terminal features need the real terminal behind stdin and stdout
+	terminalIn, terminalOut := terminalFile(stdin), terminalFile(stdout)
+
+	cfg.Debug = *debug
+	cfg.LogJSON = *logJSON
+	cfg.Color = terminalOut != nil && colorEnabled(*noColor, terminalOut)
+	if *wrap && terminalOut != nil {
+		cfg.WrapWidth = terminalWidth(terminalOut)
+	}
+	cfg.RenderMarkdown = *renderMarkdown
+	// The spinner redraws with escape sequences, so it needs what color needs
+	cfg.Spinner = cfg.Color
+	cfg.EnableTools = parseList(*enableTools)
+	cfg.DisableTools = parseList(*disableTools)
+	cfg.ToolPolicies, err = ParseToolPolicies(*toolPolicy)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error configuring tool policy: %v\n", err)
+		return exitUsage
+	}
+	cfg.AllowedCommands = parseList(*allowCommands)
+	cfg.DryRun = *dryRun
+	cfg.TurnTimeout = *turnTimeout
+	cfg.MaxToolRounds = *maxToolRounds
+	cfg.MaxRepeatCalls = *maxRepeatCalls
+	cfg.MaxResultBytes = *maxResultBytes
+	cfg.MaxTokens = *maxTokens
+	cfg.CompactThreshold = *compactThreshold
+	cfg.CompactKeepTurns = *compactKeepTurns
+	cfg.MaxHistory = *maxHistory
+	cfg.MaxHistoryBytes = *maxHistoryBytes
+	cfg.AutoContext = *autoContext
+	cfg.ShowUsage = *showUsage
+	cfg.ShowStats = *showStats
+
 	// Create sandbox
-	sandbox, err := NewPathSandbox(rootPath)
+	sandbox, err := NewPathSandbox(cfg.Root, WithConfig(cfg))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating sandbox: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Error creating sandbox: %v\n", err)
+		return exitUsage
 	}
 
-	if *debug {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Project root: %s\n", sandbox.Root)
+	fmt.Fprintf(stdout, "Project root: %s\n", sandbox.Root)
+
+	// Resolve system instruction
+	cfg.SystemPrompt, err = resolveSystemPrompt(*systemPrompt, sandbox.Root)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error loading system prompt: %v\n", err)
+		return exitError
 	}
 
-	// Create Gemini client
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{})
+	// Read the one-shot prompt before stdin is handed to the input reader
+	oneShot, err := resolvePrompt(*prompt, stdin)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating Gemini client: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Error reading prompt: %v\n", err)
+		return exitError
+	}
+
+	// The first Ctrl-C cancels ctx, which interrupts the current turn and ends
+	// the session cleanly. Default handling is then restored, so a second
+	// Ctrl-C during shutdown exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// Create the Gemini client, or load the recording that stands in for it
+	var client ModelClient
+	if *replay != "" {
+		replayClient, err := NewReplayClient(*replay)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error loading replay: %v\n", err)
+			return exitError
+		}
+		// Replay against the recorded model unless another was asked for
+		if models := replayClient.Models(); len(models) > 0 && !flagWasSet(flags, "model") {
+			cfg.Model = models[0]
+		}
+		client = replayClient
+	} else {
+		genaiClient, err := genai.NewClient(ctx, clientConfig)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error creating Gemini client: %v\n", err)
+			return exitAuth
+		}
+		client = NewGenaiClient(genaiClient)
 	}
 
-	// Set up input reader
-	scanner := bufio.NewScanner(os.Stdin)
-	getUserMessage := func() (string, bool) {
-		if !scanner.Scan() {
-			return "", false
+	// Check the setup before the banner, rather than on the first message
+	if !*noPreflight {
+		if err := preflight(ctx, client, cfg.Model, clientConfig.Backend == genai.BackendVertexAI); err != nil {
+			fmt.Fprintf(stderr, "Error checking setup: %v\n", err)
+			return exitCodeFor(ctx, err)
 		}
-		return scanner.Text(), true
 	}
 
+	// Set up input reader. Lines are read in the background so that waiting
+	// for input can be interrupted by ctx. An interactive terminal gets line
+	// editing with prompt recall; piped input is read line by line. Prompts
+	// may span lines; confirmation answers are single lines.
+	var getUserMessage, getAnswer func() (string, bool)
+	var editor *LineEditor
+	if oneShot == "" && terminalIn != nil && terminalOut != nil {
+		history, err := LoadPromptHistory(*historyFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "Warning: %v\n", err)
+			history, _ = LoadPromptHistory("")
+		}
+		editor = NewLineEditor(terminalIn, stdout, history)
+		getUserMessage, getAnswer = editor.Readers(ctx)
+	} else {
+		lines := make(chan string)
+		go func() {
+			defer close(lines)
+			scanner := bufio.NewScanner(stdin)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+		}()
+		getUserMessage = func() (string, bool) {
+			select {
+			case line, ok := <-lines:
+				return line, ok
+			case <-ctx.Done():
+				return "", false
+			}
+		}
+		getAnswer = getUserMessage
+	}
+	getUserMessage = multilineReader(getUserMessage, stdout)
+
 	// Create and run agent
-	agent := NewAgent(client, getUserMessage, sandbox, *debug)
-	agent.model = *model // Allow override via flag
+	logger := newLogger(stderr, cfg.Debug, cfg.LogJSON)
+	logger.Debug("client config", clientConfigAttrs(clientConfig)...)
+	agent, err := NewAgent(client, getUserMessage, sandbox, cfg, stdout, logger)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error configuring tools: %v\n", err)
+		return exitUsage
+	}
+	logger.Debug("generation config",
+		"temperature", formatSetting(cfg.Temperature),
+		"top_p", formatSetting(cfg.TopP),
+		"max_output_tokens", cfg.MaxOutputTokens)
+
+	// Interactive sessions ask before modifying anything. One-shot runs have
+	// no one to ask, so calls needing approval are refused unless --yes
+	switch {
+	case *yes:
+		agent.confirm = ApproveAll
+	case oneShot == "":
+		agent.confirm = NewTerminalConfirm(stdout, getAnswer, agent.style)
+	}
+
+	if *verbose {
+		sink := NewTerminalSink(stdout, agent.style, cfg.WrapWidth)
+		sink.Markdown = cfg.RenderMarkdown
+		sink.Spinner = cfg.Spinner
+		sink.Verbose = stderr
+		agent.events = sink
+	}
+
+	if *traceFile != "" {
+		agent.tracer, err = NewTracer(*traceFile, clientConfig.APIKey)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error starting trace: %v\n", err)
+			return exitError
+		}
+	}
+
+	if *session != "" {
+		if err := agent.LoadHistory(*session); err != nil {
+			fmt.Fprintf(stderr, "Error loading session: %v\n", err)
+			return exitError
+		}
+		agent.sessionPath = *session
+	}
+
+	if oneShot != "" {
+		_, err = agent.RunOnce(ctx, oneShot)
+	} else {
+		err = agent.Run(ctx)
+	}
+	// A session interrupted at the prompt may leave the terminal in raw mode
+	if editor != nil {
+		editor.Restore()
+	}
+	// Flush the trace before reporting how the run ended
+	if traceErr := agent.tracer.Close(); traceErr != nil {
+		fmt.Fprintf(stderr, "Error writing trace: %v\n", traceErr)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error running agent: %v\n", err)
+		return exitCodeFor(ctx, err)
+	}
+	return exitOK
+}
+
+// resolveSystemPrompt picks the system instruction from, in order of precedence:
+// the --system-prompt flag, $SYSTEM_PROMPT, then AGENT.md in the project root.
+func resolveSystemPrompt(flagValue, root string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if env := os.Getenv("SYSTEM_PROMPT"); env != "" {
+		return env, nil
+	}
+
+	content, err := os.ReadFile(filepath.Join(root, "AGENT.md"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// configureGeneration sets the sampling parameters on cfg. Each comes from
+// its flag, then its environment variable; unset parameters keep the model's
+// defaults.
+func configureGeneration(cfg *Config, flags *flag.FlagSet, temperature, topP float64, maxOutputTokens int) error {
+	temp, err := floatSetting(flags, "temperature", "GEMINI_TEMPERATURE", temperature, 0, 2)
+	if err != nil {
+		return err
+	}
+	p, err := floatSetting(flags, "top-p", "GEMINI_TOP_P", topP, 0, 1)
+	if err != nil {
+		return err
+	}
+	cfg.Temperature = temp
+	cfg.TopP = p
+
+	if !flagWasSet(flags, "max-output-tokens") {
+		if env := os.Getenv("GEMINI_MAX_OUTPUT_TOKENS"); env != "" {
+			n, err := strconv.Atoi(env)
+			if err != nil {
+				return fmt.Errorf("invalid $GEMINI_MAX_OUTPUT_TOKENS %q: %w", env, err)
+			}
+			maxOutputTokens = n
+		}
+	}
+	if maxOutputTokens < 0 || maxOutputTokens > math.MaxInt32 {
+		return fmt.Errorf("max output tokens must be between 0 and %d, got %d", math.MaxInt32, maxOutputTokens)
+	}
+	cfg.MaxOutputTokens = int32(maxOutputTokens)
+	return nil
+}
+
+// floatSetting resolves a float parameter from its flag, then its environment
+// variable, and checks it lies in [lo, hi]. It returns nil when neither is set.
+func floatSetting(flags *flag.FlagSet, flagName, envName string, flagValue, lo, hi float64) (*float32, error) {
+	value := flagValue
+	switch {
+	case flagWasSet(flags, flagName):
+	case os.Getenv(envName) != "":
+		v, err := strconv.ParseFloat(os.Getenv(envName), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid $%s %q: %w", envName, os.Getenv(envName), err)
+		}
+		value = v
+	default:
+		return nil, nil
+	}
+	if value < lo || value > hi {
+		return nil, fmt.Errorf("%s must be between %g and %g, got %g", flagName, lo, hi, value)
+	}
+	return genai.Ptr(float32(value)), nil
+}
+
+// formatSetting renders an optional parameter for logging.
+func formatSetting(v *float32) string {
+	if v == nil {
+		return "default"
+	}
+	return strconv.FormatFloat(float64(*v), 'g', -1, 32)
+}
+
+// resolvePrompt returns the one-shot prompt, reading it from stdin when
+// flagValue is "-".
+func resolvePrompt(flagValue string, stdin io.Reader) (string, error) {
+	if flagValue != "-" {
+		return flagValue, nil
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", err
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return "", fmt.Errorf("empty prompt on stdin")
+	}
+	return text, nil
+}
+
+// parseList splits a comma-separated list, dropping empty entries.
+func parseList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// flagWasSet reports whether the named flag was passed on the command line.
+func flagWasSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// loadConfig loads the config file named by --config, or agent.toml or
+// agent.json from the working directory, and applies it to flags, warning on
+// warn about unknown and refused settings. It returns nil when there is no
+// config file.
+func loadConfig(explicit string, flags *flag.FlagSet, warn io.Writer) (*ConfigFile, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	path, err := findConfigFile(explicit, dir)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Discovered = explicit == ""
+	if err := cfg.Apply(flags, warn); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	if err := agent.Run(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "Error running agent: %v\n", err)
-		os.Exit(1)
+// terminalFile returns v as a file when it is a terminal, and nil otherwise.
+func terminalFile(v any) *os.File {
+	if f, ok := v.(*os.File); ok && isTerminal(f) {
+		return f
 	}
+	return nil
 }
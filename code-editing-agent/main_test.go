@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+	}{
+		{"version", []string{"--version"}, exitOK, []string{"agent " + version, "commit:", "go:", "default model: " + defaultModel}, ""},
+		{"help", []string{"--help"}, exitOK, nil, "Usage of agent:"},
+		{"unknown flag", []string{"--no-such-flag"}, exitUsage, nil, "flag provided but not defined: -no-such-flag"},
+		{"bad flag value", []string{"--max-tokens", "lots"}, exitUsage, nil, "invalid value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(append([]string{"agent"}, tt.args...), strings.NewReader(""), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Errorf("run exit code = %d, want %d (stderr: %s)", code, tt.wantCode, stderr.String())
+			}
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("stdout = %q, want it to contain %q", stdout.String(), want)
+				}
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestRunReplayWritesToStdout(t *testing.T) {
+	dir := t.TempDir()
+	replay := filepath.Join(dir, "trace.jsonl")
+	record := `{"type":"response","content":{"role":"model","parts":[{"text":"Hello from the replay"}]}}` + "\n"
+	if err := os.WriteFile(replay, []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"agent", "--replay", replay, "--root", dir, "--no-preflight", "--history-file", "", "--prompt", "hi"}
+	if code := run(args, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+		t.Fatalf("run exit code = %d, want %d (stderr: %s)", code, exitOK, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "Hello from the replay") {
+		t.Errorf("stdout = %q, want the model's answer", stdout.String())
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("stderr = %q, want nothing", stderr.String())
+	}
+}
+
+// TestBuildSmoke builds the agent binary, as a release would, and runs it.
+func TestBuildSmoke(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the binary")
+	}
+	goTool := filepath.Join(runtime.GOROOT(), "bin", "go")
+	if _, err := os.Stat(goTool); err != nil {
+		t.Skipf("go tool not found: %v", err)
+	}
+	binary := filepath.Join(t.TempDir(), "agent")
+	ldflags := "-X main.version=v9.9.9 -X main.commit=0123abc -X main.buildDate=2026-01-02T03:04:05Z"
+	if out, err := exec.Command(goTool, "build", "-ldflags", ldflags, "-o", binary, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	out, err := exec.Command(binary, "--version").CombinedOutput()
+	if err != nil {
+		t.Fatalf("agent --version: %v\n%s", err, out)
+	}
+	for _, want := range []string{"agent v9.9.9\n", "commit: 0123abc\n", "built: 2026-01-02T03:04:05Z\n"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("agent --version = %q, want it to contain %q", out, want)
+		}
+	}
+}
+
+// TestScriptedSessionSmoke wires an agent the way run does, with the default
+// config and registry, and takes it through a one-shot turn that uses tools.
+func TestScriptedSessionSmoke(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"go.mod": "module demo\n", "main.go": "package main\n\nfunc main() {}\n"})
+	cfg := DefaultConfig()
+	cfg.Root = root
+	cfg.Color = false
+	sandbox, err := NewPathSandbox(cfg.Root, WithConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewScriptedClient(
+		functionCallContent("project_overview", map[string]any{}),
+		&genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{
+			genai.NewPartFromFunctionCall("read_file", map[string]any{"path": "main.go"}),
+			genai.NewPartFromFunctionCall("search_files", map[string]any{"pattern": "func main"}),
+		}},
+		genai.NewContentFromText("This is a Go module with an empty main.", genai.RoleModel),
+	)
+	var out bytes.Buffer
+	agent, err := NewAgent(client, func() (string, bool) { return "", false }, sandbox, cfg, &out, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer, err := agent.RunOnce(context.Background(), "what is this project?")
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if answer != "This is a Go module with an empty main." {
+		t.Errorf("answer = %q", answer)
+	}
+	if !strings.Contains(out.String(), answer) {
+		t.Errorf("output is missing the answer:\n%s", out.String())
+	}
+	for _, response := range toolResponses(agent.history) {
+		if response.Response["ok"] != true {
+			t.Errorf("%s failed: %v", response.Name, response.Response)
+		}
+	}
+	if n := len(toolResponses(agent.history)); n != 3 {
+		t.Errorf("got %d tool responses, want 3", n)
+	}
+}
+
+func TestResolveSystemPrompt(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    string
+		env     string
+		agentMD string // Content of AGENT.md; "" leaves it out
+		want    string
+	}{
+		{"nothing", "", "", "", ""},
+		{"AGENT.md", "", "", "Use gofmt.", "Use gofmt."},
+		{"env beats AGENT.md", "", "From env.", "Use gofmt.", "From env."},
+		{"flag beats env", "From flag.", "From env.", "Use gofmt.", "From flag."},
+		{"flag alone", "From flag.", "", "", "From flag."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SYSTEM_PROMPT", tt.env)
+			root := t.TempDir()
+			if tt.agentMD != "" {
+				writeTree(t, root, map[string]string{"AGENT.md": tt.agentMD})
+			}
+			got, err := resolveSystemPrompt(tt.flag, root)
+			if err != nil || got != tt.want {
+				t.Errorf("resolveSystemPrompt = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
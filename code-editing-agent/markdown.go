@@ -0,0 +1,82 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// codeKeywords are highlighted in fenced code blocks. The set covers Go and
+// the keywords most C-like and scripting languages share; the renderer is
+// deliberately lightweight rather than language-aware.
+var codeKeywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "class": true, "const": true,
+	"continue": true, "def": true, "default": true, "defer": true, "elif": true,
+	"else": true, "false": true, "fn": true, "for": true, "func": true,
+	"function": true, "go": true, "if": true, "import": true, "interface": true,
+	"let": true, "map": true, "nil": true, "null": true, "package": true,
+	"range": true, "return": true, "select": true, "struct": true, "switch": true,
+	"true": true, "type": true, "var": true, "while": true,
+}
+
+var (
+	// codeToken matches, in order of precedence, a line comment, a string
+	// literal, or a word.
+	codeToken = regexp.MustCompile("//.*$|#.*$|\"(?:[^\"\\\\]|\\\\.)*\"|'(?:[^'\\\\]|\\\\.)*'|`[^`]*`|[A-Za-z_][A-Za-z0-9_]*")
+
+	// inlineToken matches `code` spans and **bold** text in prose.
+	inlineToken = regexp.MustCompile("`[^`]+`|\\*\\*[^*]+\\*\\*")
+)
+
+// renderMarkdown renders a complete Markdown message for the terminal:
+// headings are bold, fences are dimmed, code blocks are highlighted, and
+// inline code and bold text are styled. With color off the text is returned
+// unchanged, so raw Markdown still reaches pipes and files.
+func renderMarkdown(text string, style Styler) string {
+	if !style.Color {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	inCode := false
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(line), "```"):
+			inCode = !inCode
+			lines[i] = style.Paint(colorGray, line)
+		case inCode:
+			lines[i] = highlightCode(line, style)
+		case strings.HasPrefix(line, "#"):
+			lines[i] = style.Paint(colorBold, line)
+		default:
+			lines[i] = renderInline(line, style)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// highlightCode colors comments, string literals, and keywords in a line of code.
+func highlightCode(line string, style Styler) string {
+	return codeToken.ReplaceAllStringFunc(line, func(token string) string {
+		switch {
+		case strings.HasPrefix(token, "//") || strings.HasPrefix(token, "#"):
+			return style.Paint(colorGray, token)
+		case strings.ContainsRune("\"'`", rune(token[0])):
+			return style.Paint(colorGreen, token)
+		case codeKeywords[token]:
+			return style.Paint(colorMagenta, token)
+		default:
+			return token
+		}
+	})
+}
+
+// renderInline styles `code` spans and **bold** text, dropping the markers
+// from bold text but keeping the backticks around code.
+func renderInline(line string, style Styler) string {
+	return inlineToken.ReplaceAllStringFunc(line, func(token string) string {
+		if strings.HasPrefix(token, "**") {
+			return style.Paint(colorBold, strings.Trim(token, "*"))
+		}
+		return style.Paint(colorCyan, token)
+	})
+}
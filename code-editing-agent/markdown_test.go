@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	on := Styler{Color: true}
+	gray := func(s string) string { return colorGray + s + colorReset }
+	kw := func(s string) string { return colorMagenta + s + colorReset }
+	str := func(s string) string { return colorGreen + s + colorReset }
+
+	sample := strings.Join([]string{
+		"# Fix",
+		"Call `main` once, **not** twice.",
+		"```go",
+		"func main() {",
+		"\tfmt.Println(\"if else\") // for now",
+		"\treturn",
+		"}",
+		"```",
+		"Done.",
+	}, "\n")
+	tests := []struct {
+		name  string
+		text  string
+		style Styler
+		want  string
+	}{
+		{"plain keeps the raw text", sample, Styler{}, sample},
+		{"go code block", sample, on, strings.Join([]string{
+			colorBold + "# Fix" + colorReset,
+			"Call " + colorCyan + "`main`" + colorReset + " once, " + colorBold + "not" + colorReset + " twice.",
+			gray("```go"),
+			kw("func") + " main() {",
+			"\tfmt.Println(" + str(`"if else"`) + ") " + gray("// for now"),
+			"\t" + kw("return"),
+			"}",
+			gray("```"),
+			"Done.",
+		}, "\n")},
+		{"keywords only in code", "if you return, go on", on, "if you return, go on"},
+		{"indented fence", "  ```\n  x := nil\n  ```", on, gray("  ```") + "\n  x := " + kw("nil") + "\n" + gray("  ```")},
+		{"hash comment and single quotes", "```\n# note\necho 'for'\n```", on,
+			gray("```") + "\n" + gray("# note") + "\necho " + str("'for'") + "\n" + gray("```")},
+		{"unclosed fence highlights the rest", "```\nvar x", on, gray("```") + "\n" + kw("var") + " x"},
+		{"lone asterisks", "2 * 3 ** 4", on, "2 * 3 ** 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMarkdown(tt.text, tt.style); got != tt.want {
+				t.Errorf("renderMarkdown =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminalSinkRendersMarkdownWhenDone(t *testing.T) {
+	var out strings.Builder
+	sink := NewTerminalSink(&out, Styler{Color: true}, 0)
+	sink.Markdown = true
+	sink.OnModelText("Use **bold")
+	sink.OnModelText("** here.")
+	if strings.Contains(out.String(), "bold") {
+		t.Errorf("text shown before the response finished: %q", out.String())
+	}
+	sink.OnModelDone()
+	if want := colorBold + "bold" + colorReset + " here."; !strings.Contains(out.String(), want) {
+		t.Errorf("output = %q, want the rendered bold text split across chunks", out.String())
+	}
+}
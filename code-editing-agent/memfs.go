@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFileSystem is a FileSystem held entirely in memory, for tests and
+// virtual roots. It has no symlinks, so EvalSymlinks only checks that a path
+// exists. It is safe for concurrent use.
+type MemFileSystem struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode // Keyed by clean absolute path
+}
+
+// memNode is a file or, when mode says so, a directory.
+type memNode struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+var (
+	errIsDir       = errors.New("is a directory")
+	errNotDir      = errors.New("not a directory")
+	errDirNotEmpty = errors.New("directory not empty")
+)
+
+// NewMemFileSystem returns a MemFileSystem holding the directory root and
+// files under it, keyed by slash-separated path relative to root. Parent
+// directories are created as needed.
+func NewMemFileSystem(root string, files map[string]string) *MemFileSystem {
+	m := &MemFileSystem{nodes: make(map[string]*memNode)}
+	root = filepath.Clean(root)
+	if err := m.MkdirAll(root, 0755); err != nil {
+		panic(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := m.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			panic(err)
+		}
+		if err := m.WriteFile(path, []byte(content), 0644); err != nil {
+			panic(err)
+		}
+	}
+	return m
+}
+
+// lookup returns the node at name. The caller must hold m.mu.
+func (m *MemFileSystem) lookup(op, name string) (string, *memNode, error) {
+	name = filepath.Clean(name)
+	node, ok := m.nodes[name]
+	if !ok {
+		return name, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return name, node, nil
+}
+
+// checkParent fails unless name's parent is an existing directory. The
+// caller must hold m.mu.
+func (m *MemFileSystem) checkParent(op, name string) error {
+	parent, ok := m.nodes[filepath.Dir(name)]
+	if !ok {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	return nil
+}
+
+// children returns the names of the entries directly inside dir, sorted. The
+// caller must hold m.mu.
+func (m *MemFileSystem) children(dir string) []string {
+	var names []string
+	for path := range m.nodes {
+		if path != dir && filepath.Dir(path) == dir {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (m *MemFileSystem) Open(name string) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, node, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{r: bytes.NewReader(slices.Clone(node.data)), info: node.info(name)}, nil
+}
+
+func (m *MemFileSystem) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, node, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	return slices.Clone(node.data), nil
+}
+
+func (m *MemFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.write("write", name, data, perm, false)
+	return err
+}
+
+func (m *MemFileSystem) AppendFile(name string, data []byte, perm os.FileMode) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.write("append", name, data, perm, true)
+}
+
+// write replaces or extends the file name with data, creating it with perm,
+// and returns its new size. The caller must hold m.mu.
+func (m *MemFileSystem) write(op, name string, data []byte, perm os.FileMode, appending bool) (int64, error) {
+	name, node, err := m.lookup(op, name)
+	if err != nil {
+		if err := m.checkParent(op, name); err != nil {
+			return 0, err
+		}
+		node = &memNode{mode: perm.Perm()}
+		m.nodes[name] = node
+	}
+	if node.mode.IsDir() {
+		return 0, &fs.PathError{Op: op, Path: name, Err: errIsDir}
+	}
+	if appending {
+		node.data = append(node.data, data...)
+	} else {
+		node.data = slices.Clone(data)
+	}
+	node.modTime = time.Now()
+	return int64(len(node.data)), nil
+}
+
+func (m *MemFileSystem) Truncate(name string, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, node, err := m.lookup("truncate", name)
+	if err != nil {
+		return err
+	}
+	if node.mode.IsDir() {
+		return &fs.PathError{Op: "truncate", Path: name, Err: errIsDir}
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrInvalid}
+	}
+	if size <= int64(len(node.data)) {
+		node.data = node.data[:size]
+	} else {
+		// Growing pads with zero bytes, as os.Truncate does
+		node.data = append(node.data, make([]byte, size-int64(len(node.data)))...)
+	}
+	node.modTime = time.Now()
+	return nil
+}
+
+func (m *MemFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, node, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	var entries []os.DirEntry
+	for _, child := range m.children(name) {
+		path := filepath.Join(name, child)
+		entries = append(entries, fs.FileInfoToDirEntry(m.nodes[path].info(path)))
+	}
+	return entries, nil
+}
+
+func (m *MemFileSystem) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, node, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(name), nil
+}
+
+func (m *MemFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return m.Stat(name)
+}
+
+func (m *MemFileSystem) EvalSymlinks(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, _, err := m.lookup("lstat", name)
+	return name, err
+}
+
+func (m *MemFileSystem) Mkdir(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := m.nodes[name]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err := m.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	m.nodes[name] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFileSystem) MkdirAll(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	var missing []string
+	for path := name; ; path = filepath.Dir(path) {
+		if node, ok := m.nodes[path]; ok {
+			if !node.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: path, Err: errNotDir}
+			}
+			break
+		}
+		missing = append(missing, path)
+		if filepath.Dir(path) == path {
+			break
+		}
+	}
+	for _, path := range slices.Backward(missing) {
+		m.nodes[path] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *MemFileSystem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, node, err := m.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if node.mode.IsDir() && len(m.children(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errDirNotEmpty}
+	}
+	delete(m.nodes, name)
+	return nil
+}
+
+// Rename moves oldpath, and everything under it if it is a directory, to
+// newpath, replacing a file or empty directory already there.
+func (m *MemFileSystem) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldpath, node, err := m.lookup("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	newpath = filepath.Clean(newpath)
+	if oldpath == newpath {
+		return nil
+	}
+	if err := m.checkParent("rename", newpath); err != nil {
+		return err
+	}
+	prefix := oldpath + string(filepath.Separator)
+	if strings.HasPrefix(newpath, prefix) {
+		return &fs.PathError{Op: "rename", Path: newpath, Err: fs.ErrInvalid}
+	}
+	if existing, ok := m.nodes[newpath]; ok {
+		switch {
+		case existing.mode.IsDir() && !node.mode.IsDir():
+			return &fs.PathError{Op: "rename", Path: newpath, Err: errIsDir}
+		case !existing.mode.IsDir() && node.mode.IsDir():
+			return &fs.PathError{Op: "rename", Path: newpath, Err: errNotDir}
+		case existing.mode.IsDir() && len(m.children(newpath)) > 0:
+			return &fs.PathError{Op: "rename", Path: newpath, Err: errDirNotEmpty}
+		}
+	}
+
+	for path, n := range m.nodes {
+		if strings.HasPrefix(path, prefix) {
+			delete(m.nodes, path)
+			m.nodes[newpath+string(filepath.Separator)+strings.TrimPrefix(path, prefix)] = n
+		}
+	}
+	delete(m.nodes, oldpath)
+	m.nodes[newpath] = node
+	return nil
+}
+
+// info describes the node at path.
+func (n *memNode) info(path string) os.FileInfo {
+	return &memFileInfo{name: filepath.Base(path), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+// memFile is an open MemFileSystem file: a snapshot of its content when it
+// was opened.
+type memFile struct {
+	r    *bytes.Reader
+	info os.FileInfo
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: errIsDir}
+	}
+	return f.r.Read(p)
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// memFileInfo is the os.FileInfo for a MemFileSystem node.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() os.FileMode  { return i.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memFileInfo) Sys() any           { return nil }
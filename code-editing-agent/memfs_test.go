@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// memRoot is where the in-memory trees are rooted; nothing exists there on
+// disk.
+var memRoot = filepath.FromSlash("/virtual/project")
+
+// memPath returns the absolute path of the slash-separated name under memRoot.
+func memPath(name string) string {
+	return filepath.Join(memRoot, filepath.FromSlash(name))
+}
+
+// memTree lists every path under memRoot in fsys, slash-separated, with a
+// trailing slash on directories and the content of files.
+func memTree(t *testing.T, fsys FileSystem) map[string]string {
+	t.Helper()
+	tree := map[string]string{}
+	err := walkDir(fsys, memRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == memRoot {
+			return nil
+		}
+		rel, _ := filepath.Rel(memRoot, p)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			tree[rel+"/"] = ""
+			return nil
+		}
+		data, err := fsys.ReadFile(p)
+		tree[rel] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestMemFileSystem(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func(*MemFileSystem) error
+		wantErr error // Matched with errors.Is; nil for success
+		want    map[string]string
+	}{
+		{
+			name: "write new file",
+			op:   func(m *MemFileSystem) error { return m.WriteFile(memPath("b.txt"), []byte("bee"), 0644) },
+			want: map[string]string{"a.txt": "one\n", "b.txt": "bee", "dir/": "", "dir/c.txt": "sea"},
+		},
+		{
+			name: "replace file",
+			op:   func(m *MemFileSystem) error { return m.WriteFile(memPath("a.txt"), []byte("two\n"), 0644) },
+			want: map[string]string{"a.txt": "two\n", "dir/": "", "dir/c.txt": "sea"},
+		},
+		{
+			name:    "write without parent",
+			op:      func(m *MemFileSystem) error { return m.WriteFile(memPath("nowhere/b.txt"), nil, 0644) },
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			name:    "write over directory",
+			op:      func(m *MemFileSystem) error { return m.WriteFile(memPath("dir"), nil, 0644) },
+			wantErr: errIsDir,
+		},
+		{
+			name: "append",
+			op: func(m *MemFileSystem) error {
+				size, err := m.AppendFile(memPath("a.txt"), []byte("two\n"), 0644)
+				if err == nil && size != 8 {
+					return errors.New("wrong size")
+				}
+				return err
+			},
+			want: map[string]string{"a.txt": "one\ntwo\n", "dir/": "", "dir/c.txt": "sea"},
+		},
+		{
+			name: "truncate",
+			op:   func(m *MemFileSystem) error { return m.Truncate(memPath("a.txt"), 2) },
+			want: map[string]string{"a.txt": "on", "dir/": "", "dir/c.txt": "sea"},
+		},
+		{
+			name: "truncate grows with zeros",
+			op:   func(m *MemFileSystem) error { return m.Truncate(memPath("dir/c.txt"), 5) },
+			want: map[string]string{"a.txt": "one\n", "dir/": "", "dir/c.txt": "sea\x00\x00"},
+		},
+		{
+			name:    "truncate missing",
+			op:      func(m *MemFileSystem) error { return m.Truncate(memPath("b.txt"), 0) },
+			wantErr: fs.ErrNotExist,
+		},
+		{
+			name: "mkdir",
+			op:   func(m *MemFileSystem) error { return m.Mkdir(memPath("new"), 0755) },
+			want: map[string]string{"a.txt": "one\n", "dir/": "", "dir/c.txt": "sea", "new/": ""},
+		},
+		{
+			name:    "mkdir existing",
+			op:      func(m *MemFileSystem) error { return m.Mkdir(memPath("dir"), 0755) },
+			wantErr: fs.ErrExist,
+		},
+		{
+			name: "mkdir all",
+			op:   func(m *MemFileSystem) error { return m.MkdirAll(memPath("x/y"), 0755) },
+			want: map[string]string{"a.txt": "one\n", "dir/": "", "dir/c.txt": "sea", "x/": "", "x/y/": ""},
+		},
+		{
+			name:    "mkdir all through a file",
+			op:      func(m *MemFileSystem) error { return m.MkdirAll(memPath("a.txt/y"), 0755) },
+			wantErr: errNotDir,
+		},
+		{
+			name: "remove file",
+			op:   func(m *MemFileSystem) error { return m.Remove(memPath("a.txt")) },
+			want: map[string]string{"dir/": "", "dir/c.txt": "sea"},
+		},
+		{
+			name:    "remove non-empty directory",
+			op:      func(m *MemFileSystem) error { return m.Remove(memPath("dir")) },
+			wantErr: errDirNotEmpty,
+		},
+		{
+			name: "rename file",
+			op:   func(m *MemFileSystem) error { return m.Rename(memPath("a.txt"), memPath("dir/a.txt")) },
+			want: map[string]string{"dir/": "", "dir/a.txt": "one\n", "dir/c.txt": "sea"},
+		},
+		{
+			name: "rename over file",
+			op:   func(m *MemFileSystem) error { return m.Rename(memPath("dir/c.txt"), memPath("a.txt")) },
+			want: map[string]string{"a.txt": "sea", "dir/": ""},
+		},
+		{
+			name: "rename directory",
+			op:   func(m *MemFileSystem) error { return m.Rename(memPath("dir"), memPath("moved")) },
+			want: map[string]string{"a.txt": "one\n", "moved/": "", "moved/c.txt": "sea"},
+		},
+		{
+			name:    "rename directory into itself",
+			op:      func(m *MemFileSystem) error { return m.Rename(memPath("dir"), memPath("dir/sub")) },
+			wantErr: fs.ErrInvalid,
+		},
+		{
+			name:    "rename file over directory",
+			op:      func(m *MemFileSystem) error { return m.Rename(memPath("a.txt"), memPath("dir")) },
+			wantErr: errIsDir,
+		},
+		{
+			name:    "rename missing",
+			op:      func(m *MemFileSystem) error { return m.Rename(memPath("b.txt"), memPath("c.txt")) },
+			wantErr: fs.ErrNotExist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemFileSystem(memRoot, map[string]string{"a.txt": "one\n", "dir/c.txt": "sea"})
+			err := tt.op(m)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := memTree(t, m); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tree = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemFileSystemReads(t *testing.T) {
+	m := NewMemFileSystem(memRoot, map[string]string{"b.txt": "bee", "a.txt": "hello", "dir/c.txt": ""})
+
+	entries, err := m.ReadDir(memRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"a.txt", "b.txt", "dir"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDir = %v, want %v", names, want)
+	}
+	if !entries[2].IsDir() || entries[0].IsDir() {
+		t.Errorf("ReadDir reported the wrong kinds: %v", entries)
+	}
+
+	f, err := m.Open(memPath("a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(2, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	// The open file is a snapshot, unaffected by later writes
+	if err := m.WriteFile(memPath("a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(f); string(data) != "llo" {
+		t.Errorf("read after seek = %q, want %q", data, "llo")
+	}
+	if info, _ := f.Stat(); info.Size() != 5 || info.Name() != "a.txt" {
+		t.Errorf("Stat = %s, %d bytes; want a.txt, 5 bytes", info.Name(), info.Size())
+	}
+	f.Close()
+
+	if _, err := m.ReadFile(memPath("dir")); !errors.Is(err, errIsDir) {
+		t.Errorf("ReadFile on a directory: error = %v, want %v", err, errIsDir)
+	}
+	if _, err := m.ReadDir(memPath("a.txt")); !errors.Is(err, errNotDir) {
+		t.Errorf("ReadDir on a file: error = %v, want %v", err, errNotDir)
+	}
+	if real, err := m.EvalSymlinks(memPath("dir/../a.txt")); err != nil || real != memPath("a.txt") {
+		t.Errorf("EvalSymlinks = %q, %v; want %q", real, err, memPath("a.txt"))
+	}
+	if _, err := m.EvalSymlinks(memPath("missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("EvalSymlinks on a missing path: error = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+// TestToolsOnMemFileSystem runs the file tools over a sandbox whose root
+// exists only in memory, so every access they and Resolve make has to go
+// through the sandbox's FileSystem.
+func TestToolsOnMemFileSystem(t *testing.T) {
+	if _, err := os.Stat(memRoot); err == nil {
+		t.Skipf("%s exists on disk", memRoot)
+	}
+	mem := NewMemFileSystem(memRoot, map[string]string{
+		".agentignore":  "secrets/\n",
+		".gitignore":    "build/\n",
+		"main.go":       "package main\n// TODO: tidy\n",
+		"lib/util.go":   "package lib\n",
+		"secrets/token": "TOPSECRET",
+		"build/out.go":  "// TODO: generated\n",
+	})
+	sandbox, err := NewPathSandbox(memRoot, WithFileSystem(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := NewToolContext(sandbox, slog.New(slog.DiscardHandler))
+	ctx := context.Background()
+
+	steps := []struct {
+		name     string
+		run      func(context.Context, map[string]any, *ToolContext) *ToolResult
+		args     map[string]any
+		wantErr  string   // Error code; "" for success
+		contains []string // Substrings of the encoded result
+		excludes []string
+	}{
+		{"read", readFile, map[string]any{"path": "main.go"}, "", []string{"TODO: tidy"}, nil},
+		{"read missing", readFile, map[string]any{"path": "nope.go"}, "not_found", nil, nil},
+		{"read ignored", readFile, map[string]any{"path": "secrets/token"}, "permission_denied", nil, []string{"TOPSECRET"}},
+		{"escape", listFiles, map[string]any{"path": ".."}, "permission_denied", nil, nil},
+		{"write", writeFile, map[string]any{"path": "lib/new.go", "content": "package lib\n"}, "", nil, nil},
+		{"edit", editFile, map[string]any{"path": "main.go", "old_str": "tidy", "new_str": "tidy up"}, "", nil, nil},
+		{"append", appendToFile, map[string]any{"path": "lib/util.go", "content": "// more\n"}, "", nil, nil},
+		{"undo append", undoLastEdit, map[string]any{}, "", nil, nil},
+		{"list", listFiles, map[string]any{"path": ".", "recursive": true}, "", []string{"lib/new.go", "main.go"}, []string{"secrets", "build"}},
+		{"search", searchFiles, map[string]any{"pattern": "TODO"}, "", []string{"main.go"}, []string{"build/out.go", "secrets"}},
+		{"make directory", makeDirectory, map[string]any{"path": "docs/api", "parents": true}, "", nil, nil},
+		{"move", moveFile, map[string]any{"source": "lib", "destination": "pkg"}, "", nil, nil},
+		{"move ignored", moveFile, map[string]any{"source": "secrets", "destination": "open"}, "permission_denied", nil, nil},
+		{"delete", deleteFile, map[string]any{"path": "pkg/util.go"}, "", nil, nil},
+		{"stat deleted", statFile, map[string]any{"path": "pkg/util.go"}, "", []string{`"exists":false`}, nil},
+	}
+	for _, step := range steps {
+		result := step.run(ctx, step.args, tc)
+		encoded := resultJSON(t, result)
+		if step.wantErr != "" {
+			if result.OK || result.Error.Code != step.wantErr {
+				t.Errorf("%s = %s, want error %s", step.name, encoded, step.wantErr)
+			}
+		} else if !result.OK {
+			t.Fatalf("%s failed: %s", step.name, encoded)
+		}
+		for _, s := range step.contains {
+			if !strings.Contains(encoded, s) {
+				t.Errorf("%s result is missing %q: %s", step.name, s, encoded)
+			}
+		}
+		for _, s := range step.excludes {
+			if strings.Contains(encoded, s) {
+				t.Errorf("%s result shows %q: %s", step.name, s, encoded)
+			}
+		}
+		sandbox.ClearCache()
+	}
+
+	tree := memTree(t, mem)
+	for name, want := range map[string]string{
+		"main.go":       "package main\n// TODO: tidy up\n",
+		"pkg/new.go":    "package lib\n",
+		"secrets/token": "TOPSECRET",
+		"docs/api/":     "",
+	} {
+		if got, ok := tree[name]; !ok || got != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, ok, want)
+		}
+	}
+	for _, name := range []string{"lib/", "pkg/util.go", "open/"} {
+		if _, ok := tree[name]; ok {
+			t.Errorf("%s still exists", name)
+		}
+	}
+	if _, err := os.Stat(memRoot); err == nil {
+		t.Errorf("the tools created %s on disk", memRoot)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"iter"
+
+	"google.golang.org/genai"
+)
+
+// ModelClient is the part of the Gemini API the agent calls. NewGenaiClient
+// adapts the live SDK; ReplayClient serves recorded or scripted responses
+// instead (--replay, NewScriptedClient), so the agent loop can run without
+// the network.
+type ModelClient interface {
+	// Stream sends history and streams back the response
+	Stream(ctx context.Context, model string, history []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error]
+	GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error)
+	CountTokens(ctx context.Context, model string, contents []*genai.Content, config *genai.CountTokensConfig) (*genai.CountTokensResponse, error)
+	All(ctx context.Context) iter.Seq2[*genai.Model, error]
+}
+
+// genaiClient is the ModelClient backed by the Gemini SDK.
+type genaiClient struct {
+	*genai.Models
+}
+
+// NewGenaiClient returns a ModelClient that calls the API through client.
+func NewGenaiClient(client *genai.Client) ModelClient {
+	return genaiClient{client.Models}
+}
+
+// Stream calls GenerateContentStream.
+func (c genaiClient) Stream(ctx context.Context, model string, history []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return c.GenerateContentStream(ctx, model, history, config)
+}
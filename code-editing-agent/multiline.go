@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// blockDelimiter opens and closes a block of lines sent as one message.
+const blockDelimiter = `"""`
+
+// multilineReader wraps readLine so one message can span several lines: a
+// line ending in a backslash continues on the next line, and the lines
+// between two """ lines are sent together, verbatim. Continuation lines are
+// prompted with "... " on out. A single line is returned as before. If input
+// ends or is interrupted partway through a message, the partial message is
+// dropped.
+func multilineReader(readLine func() (string, bool), out io.Writer) func() (string, bool) {
+	return func() (string, bool) {
+		line, ok := readLine()
+		if !ok {
+			return "", false
+		}
+
+		if strings.TrimSpace(line) == blockDelimiter {
+			var lines []string
+			for {
+				fmt.Fprint(out, "... ")
+				line, ok := readLine()
+				if !ok {
+					return "", false
+				}
+				if strings.TrimSpace(line) == blockDelimiter {
+					return strings.Join(lines, "\n"), true
+				}
+				lines = append(lines, line)
+			}
+		}
+
+		var lines []string
+		for strings.HasSuffix(line, `\`) {
+			lines = append(lines, strings.TrimSuffix(line, `\`))
+			fmt.Fprint(out, "... ")
+			line, ok = readLine()
+			if !ok {
+				return "", false
+			}
+		}
+		return strings.Join(append(lines, line), "\n"), true
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMultilineReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		lines       []string // Lines typed, in order
+		want        []string // Messages read until input ends
+		wantPrompts int      // Continuation prompts shown
+	}{
+		{name: "single lines unchanged", lines: []string{"one", "two"}, want: []string{"one", "two"}},
+		{name: "empty line", lines: []string{""}, want: []string{""}},
+		{name: "backslash continues", lines: []string{`first \`, "second"}, want: []string{"first \nsecond"}, wantPrompts: 1},
+		{name: "several continuations", lines: []string{`a\`, `b\`, "c", "next"}, want: []string{"a\nb\nc", "next"}, wantPrompts: 2},
+		{name: "backslash mid-line is literal", lines: []string{`C:\dir\file.go`}, want: []string{`C:\dir\file.go`}},
+		{name: "continued into an empty line", lines: []string{`a\`, ""}, want: []string{"a\n"}, wantPrompts: 1},
+		{name: "block", lines: []string{`"""`, "func f() {", "\treturn", "}", `"""`}, want: []string{"func f() {\n\treturn\n}"}, wantPrompts: 4},
+		{name: "block keeps backslashes and blank lines", lines: []string{`"""`, `a \`, "", "b", `"""`}, want: []string{"a \\\n\nb"}, wantPrompts: 4},
+		{name: "delimiter with spaces", lines: []string{`  """ `, "x", ` """`}, want: []string{"x"}, wantPrompts: 2},
+		{name: "empty block", lines: []string{`"""`, `"""`}, want: []string{""}, wantPrompts: 1},
+		{name: "quotes inside a line are literal", lines: []string{`say """hi"""`}, want: []string{`say """hi"""`}},
+		{name: "input ends inside a block", lines: []string{"before", `"""`, "partial"}, want: []string{"before"}, wantPrompts: 2},
+		{name: "input ends after a backslash", lines: []string{"before", `partial\`}, want: []string{"before"}, wantPrompts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remaining := tt.lines
+			readLine := func() (string, bool) {
+				if len(remaining) == 0 {
+					return "", false
+				}
+				line := remaining[0]
+				remaining = remaining[1:]
+				return line, true
+			}
+			var out strings.Builder
+			read := multilineReader(readLine, &out)
+
+			var got []string
+			for {
+				message, ok := read()
+				if !ok {
+					break
+				}
+				got = append(got, message)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if prompts := strings.Count(out.String(), "... "); prompts != tt.wantPrompts || out.Len() != 4*prompts {
+				t.Errorf("output = %q, want %d continuation prompts", out.String(), tt.wantPrompts)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errBlockedAddress is returned when a tool tries to reach a non-public address.
+var errBlockedAddress = errors.New("connection to non-public address blocked")
+
+// blockedPrefixes are special-purpose ranges not covered by the netip predicates.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "This network"
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+	netip.MustParsePrefix("198.18.0.0/15"), // Benchmarking
+}
+
+// isPublicAddr reports whether ip is safe for tools to connect to. Loopback,
+// private, link-local (including cloud metadata endpoints such as
+// 169.254.169.254), multicast, and unspecified addresses are not.
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// guardDial is a net.Dialer Control hook. It runs after DNS resolution with
+// the exact address being dialed, so hostnames that resolve to internal
+// addresses are caught too.
+func guardDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(ip) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, ip)
+	}
+	return nil
+}
+
+// newGuardedHTTPClient returns an HTTP client for tools that refuses to
+// connect to non-public addresses. Every connection, including those made
+// while following redirects, goes through the guarded dialer. Proxy settings
+// from the environment are ignored so the check applies to the real target.
+func newGuardedHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: guardDial,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// networkErrorResult converts an outbound request failure into a ToolResult,
+// reporting blocked addresses as permission_denied.
+func networkErrorResult(action string, err error) *ToolResult {
+	if errors.Is(err, errBlockedAddress) {
+		return NewErrorResult("permission_denied", fmt.Sprintf("failed to %s: %v", action, err), []string{
+			"Tools may only reach public internet addresses",
+		})
+	}
+	return NewErrorResult("network_error", fmt.Sprintf("failed to %s: %v", action, err), nil)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"1.1.1.1", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"127.8.9.10", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:8.8.8.8", true},
+	}
+	for _, tt := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if isPublicAddr(netip.Addr{}) {
+		t.Error("isPublicAddr accepted the zero address")
+	}
+}
+
+func TestGuardDial(t *testing.T) {
+	tests := []struct {
+		address     string
+		wantBlocked bool
+		wantErr     bool
+	}{
+		{"93.184.216.34:80", false, false},
+		{"[2606:4700:4700::1111]:443", false, false},
+		{"169.254.169.254:80", true, true},
+		{"127.0.0.1:8080", true, true},
+		{"[::1]:80", true, true},
+		{"no-port", false, true},
+		{"example.com:80", false, true},
+	}
+	for _, tt := range tests {
+		err := guardDial("tcp", tt.address, nil)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("guardDial(%s) = %v, want error %v", tt.address, err, tt.wantErr)
+		}
+		if blocked := errors.Is(err, errBlockedAddress); blocked != tt.wantBlocked {
+			t.Errorf("guardDial(%s) blocked = %v, want %v", tt.address, blocked, tt.wantBlocked)
+		}
+	}
+}
+
+func TestGuardedHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newGuardedHTTPClient(2 * time.Second)
+	guarded := client.Transport
+	// public.example stands in for a public host that redirects inward
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "public.example" {
+			resp := jsonResponse(http.StatusFound, "")
+			resp.Header.Set("Location", req.URL.Query().Get("to"))
+			return resp, nil
+		}
+		return guarded.RoundTrip(req)
+	})
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"cloud metadata", "http://169.254.169.254/latest/meta-data/"},
+		{"localhost", "http://localhost:" + port + "/"},
+		{"loopback", server.URL},
+		{"ipv6 loopback", "http://[::1]:" + port + "/"},
+		{"private", "http://10.0.0.1/"},
+		{"redirect to metadata", "http://public.example/?to=" + url.QueryEscape("http://169.254.169.254/")},
+		{"redirect to localhost", "http://public.example/?to=" + url.QueryEscape(server.URL)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Get(tt.url)
+			if err == nil {
+				resp.Body.Close()
+				t.Fatalf("GET %s succeeded with %s", tt.url, resp.Status)
+			}
+			if !errors.Is(err, errBlockedAddress) {
+				t.Errorf("GET %s = %v, want errBlockedAddress", tt.url, err)
+			}
+		})
+	}
+}
+
+func TestGetWeatherBlocksInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[]}`))
+	}))
+	defer server.Close()
+
+	// Send the weather APIs to the loopback server, as a hostile DNS answer
+	// would, through the guarded transport
+	guarded := newGuardedHTTPClient(2 * time.Second).Transport
+	tc := newTestToolContext(t, nil)
+	tc.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req.URL.Host = server.Listener.Addr().String()
+		return guarded.RoundTrip(req)
+	})}
+
+	result := getWeather(context.Background(), map[string]any{"location": "Paris"}, tc)
+	if result.OK || result.Error.Code != "permission_denied" {
+		t.Errorf("get_weather = %s, want permission_denied", resultJSON(t, result))
+	}
+}
+
+func TestNetworkErrorResult(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errBlockedAddress, "permission_denied"},
+		{&url.Error{Op: "Get", URL: "http://x", Err: errBlockedAddress}, "permission_denied"},
+		{errors.New("connection refused"), "network_error"},
+		{context.DeadlineExceeded, "network_error"},
+	}
+	for _, tt := range tests {
+		if result := networkErrorResult("fetch", tt.err); result.Error.Code != tt.want {
+			t.Errorf("networkErrorResult(%v) = %s, want %s", tt.err, result.Error.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	overviewTreeDepth   = 2       // Levels of the tree project_overview shows
+	overviewTreeNodes   = 150     // Entries of the tree project_overview shows
+	maxOverviewFiles    = 5000    // Files whose lines are counted before the count stops
+	maxOverviewFileSize = 1 << 20 // Larger files are counted as files but not lines
+	overviewReadmeLines = 20      // README lines included
+	overviewReadmeBytes = 2 << 10 // README bytes included
+)
+
+// buildFiles maps build and manifest file names found at the project root to
+// the ecosystem they indicate.
+var buildFiles = map[string]string{
+	"go.mod":           "Go",
+	"package.json":     "JavaScript/TypeScript",
+	"Cargo.toml":       "Rust",
+	"pyproject.toml":   "Python",
+	"setup.py":         "Python",
+	"requirements.txt": "Python",
+	"pom.xml":          "Java",
+	"build.gradle":     "Java/Kotlin",
+	"build.gradle.kts": "Kotlin",
+	"Gemfile":          "Ruby",
+	"composer.json":    "PHP",
+	"CMakeLists.txt":   "C/C++",
+	"Makefile":         "Make",
+	"Dockerfile":       "Docker",
+}
+
+// languages maps file extensions to the language counted for them.
+var languages = map[string]string{
+	".go":    "Go",
+	".js":    "JavaScript",
+	".jsx":   "JavaScript",
+	".mjs":   "JavaScript",
+	".ts":    "TypeScript",
+	".tsx":   "TypeScript",
+	".py":    "Python",
+	".rs":    "Rust",
+	".java":  "Java",
+	".kt":    "Kotlin",
+	".rb":    "Ruby",
+	".php":   "PHP",
+	".c":     "C",
+	".h":     "C",
+	".cc":    "C++",
+	".cpp":   "C++",
+	".hpp":   "C++",
+	".cs":    "C#",
+	".swift": "Swift",
+	".sh":    "Shell",
+}
+
+// projectOverview gathers the facts a session usually starts by looking up:
+// a shallow tree of the root, the build files there, line counts per
+// extension, and the start of the README.
+func projectOverview(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	root := tc.Sandbox.Root
+
+	w := &treeWalker{
+		sandbox:  tc.Sandbox,
+		ignore:   NewIgnoreMatcher(tc.Sandbox.FS, root),
+		maxDepth: overviewTreeDepth,
+		maxNodes: overviewTreeNodes,
+	}
+	tree, err := w.walk(ctx, root, 1)
+	if ctx.Err() != nil {
+		return NewErrorResult("timeout", "the overview was stopped because the turn ended", nil)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to list directory: %v", err), nil)
+	}
+
+	found := []map[string]any{}
+	ecosystems := []string{}
+	for _, node := range tree {
+		name, _ := node["name"].(string)
+		if ecosystem, ok := buildFiles[name]; ok {
+			found = append(found, map[string]any{"file": name, "ecosystem": ecosystem})
+			if !slices.Contains(ecosystems, ecosystem) {
+				ecosystems = append(ecosystems, ecosystem)
+			}
+		}
+	}
+
+	counts, filesSeen, complete, err := countLines(ctx, tc)
+	if ctx.Err() != nil {
+		return NewErrorResult("timeout", "the overview was stopped because the turn ended", nil)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to count lines: %v", err), nil)
+	}
+
+	data := map[string]any{
+		"tree":           tree,
+		"tree_truncated": w.truncated,
+		"build_files":    found,
+		"ecosystems":     ecosystems,
+		"extensions":     counts,
+		"files_counted":  filesSeen,
+		"counts_partial": !complete,
+	}
+	if language := primaryLanguage(counts); language != "" {
+		data["primary_language"] = language
+	}
+	if readme, name := readmeStart(tc, tree); name != "" {
+		data["readme"] = map[string]any{"file": name, "start": readme}
+	}
+	return NewSuccessResult(data)
+}
+
+// countLines counts files and lines per extension under the root, skipping
+// ignored paths, binary files, and files over maxOverviewFileSize (whose
+// files still count). It stops after maxOverviewFiles files and reports the
+// counts as incomplete.
+func countLines(ctx context.Context, tc *ToolContext) (counts []map[string]any, filesSeen int, complete bool, err error) {
+	type tally struct{ files, lines int }
+	byExt := map[string]*tally{}
+	ignore := NewIgnoreMatcher(tc.Sandbox.FS, tc.Sandbox.Root)
+	complete = true
+
+	err = walkDir(tc.Sandbox.FS, tc.Sandbox.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable entries rather than aborting the whole walk
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		rel, err := filepath.Rel(tc.Sandbox.Root, p)
+		if err != nil || rel == "." {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == trashDir || tc.Sandbox.Excluded(rel, true) || ignore.Match(rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || tc.Sandbox.Excluded(rel, false) || ignore.Match(rel, false) {
+			return nil
+		}
+		if filesSeen >= maxOverviewFiles {
+			complete = false
+			return filepath.SkipAll
+		}
+		filesSeen++
+
+		ext := strings.ToLower(filepath.Ext(d.Name()))
+		if ext == "" {
+			ext = "(none)"
+		}
+		t := byExt[ext]
+		if t == nil {
+			t = &tally{}
+			byExt[ext] = t
+		}
+		t.files++
+
+		// Skip anything the sandbox would refuse to read
+		if _, err := tc.Sandbox.Resolve(rel, AccessReadFile); err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxOverviewFileSize {
+			return nil
+		}
+		content, err := tc.Sandbox.FS.ReadFile(p)
+		if err != nil || looksBinary(content[:min(len(content), binarySniffBytes)]) {
+			return nil
+		}
+		t.lines += bytes.Count(content, []byte("\n"))
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			t.lines++
+		}
+		return nil
+	})
+
+	counts = make([]map[string]any, 0, len(byExt))
+	for ext, t := range byExt {
+		entry := map[string]any{"extension": ext, "files": t.files, "lines": t.lines}
+		if language, ok := languages[ext]; ok {
+			entry["language"] = language
+		}
+		counts = append(counts, entry)
+	}
+	// Most lines first, then by extension for stable output
+	sort.Slice(counts, func(i, j int) bool {
+		li, lj := counts[i]["lines"].(int), counts[j]["lines"].(int)
+		if li != lj {
+			return li > lj
+		}
+		return counts[i]["extension"].(string) < counts[j]["extension"].(string)
+	})
+	return counts, filesSeen, complete, err
+}
+
+// primaryLanguage returns the language with the most lines, or "" if no
+// counted extension maps to a language.
+func primaryLanguage(counts []map[string]any) string {
+	lines := map[string]int{}
+	best := ""
+	for _, entry := range counts {
+		language, ok := entry["language"].(string)
+		if !ok {
+			continue
+		}
+		lines[language] += entry["lines"].(int)
+		if best == "" || lines[language] > lines[best] {
+			best = language
+		}
+	}
+	return best
+}
+
+// readmeStart returns the first lines of the README at the root, if the tree
+// shows one, and its name.
+func readmeStart(tc *ToolContext, tree []map[string]any) (string, string) {
+	for _, node := range tree {
+		name, _ := node["name"].(string)
+		base := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+		if base != "readme" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		resolved, err := tc.Sandbox.Resolve(name, AccessReadFile)
+		if err != nil {
+			continue
+		}
+		content, err := tc.Sandbox.FS.ReadFile(resolved)
+		if err != nil || looksBinary(content[:min(len(content), binarySniffBytes)]) {
+			continue
+		}
+		text := string(content[:min(len(content), overviewReadmeBytes)])
+		lines := strings.SplitAfter(text, "\n")
+		if len(lines) > overviewReadmeLines {
+			lines = lines[:overviewReadmeLines]
+		}
+		return strings.TrimRight(strings.Join(lines, ""), "\n"), name
+	}
+	return "", ""
+}
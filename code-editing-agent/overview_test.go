@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProjectOverview(t *testing.T) {
+	longReadme := ""
+	for i := 1; i <= 30; i++ {
+		longReadme += fmt.Sprintf("line %d\n", i)
+	}
+	tests := []struct {
+		name        string
+		files       map[string]string
+		wantBuild   []string // Build files found
+		wantEco     []string
+		wantPrimary string   // "" when none
+		wantCounts  []string // "ext files/lines", in result order
+		wantReadme  string   // Start of the README; "" when absent
+	}{
+		{name: "go module",
+			files: map[string]string{
+				"go.mod":         "module demo\n\ngo 1.23\n",
+				"main.go":        "package main\n\nfunc main() {}\n",
+				"internal/x.go":  "package internal\n",
+				"README.md":      "# Demo\nA small tool.\n",
+				"scripts/run.sh": "#!/bin/sh\necho hi",
+			},
+			wantBuild: []string{"go.mod"}, wantEco: []string{"Go"}, wantPrimary: "Go",
+			wantCounts: []string{".go 2/4", ".mod 1/3", ".md 1/2", ".sh 1/2"},
+			wantReadme: "# Demo\nA small tool."},
+		{name: "node and docker",
+			files: map[string]string{
+				"package.json": "{}\n",
+				"Dockerfile":   "FROM node\n",
+				"src/a.ts":     "export {}\n",
+				"src/b.js":     "1\n2\n3\n",
+			},
+			wantBuild: []string{"Dockerfile", "package.json"}, wantEco: []string{"Docker", "JavaScript/TypeScript"}, wantPrimary: "JavaScript",
+			wantCounts: []string{".js 1/3", "(none) 1/1", ".json 1/1", ".ts 1/1"}},
+		{name: "ignored and binary files",
+			files: map[string]string{
+				".gitignore":     "dist/\n",
+				"app.py":         "print(1)\n",
+				"dist/bundle.py": strings.Repeat("x\n", 100),
+				"logo.png":       "\x89PNG\r\n\x1a\n\x00\x00",
+			},
+			wantBuild: []string{}, wantEco: []string{}, wantPrimary: "Python",
+			wantCounts: []string{".gitignore 1/1", ".py 1/1", ".png 1/0"}},
+		{name: "long readme",
+			files:     map[string]string{"readme.txt": longReadme},
+			wantBuild: []string{}, wantEco: []string{},
+			wantCounts: []string{".txt 1/30"},
+			wantReadme: strings.TrimSuffix(strings.Join(strings.SplitAfter(longReadme, "\n")[:overviewReadmeLines], ""), "\n")},
+		{name: "empty project", files: map[string]string{},
+			wantBuild: []string{}, wantEco: []string{}, wantCounts: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, tt.files)
+			result := projectOverview(context.Background(), map[string]any{}, tc)
+			if !result.OK {
+				t.Fatalf("failed: %s", resultJSON(t, result))
+			}
+
+			var build []string
+			for _, entry := range result.Data["build_files"].([]map[string]any) {
+				build = append(build, entry["file"].(string))
+			}
+			if !slices.Equal(build, tt.wantBuild) && !(len(build) == 0 && len(tt.wantBuild) == 0) {
+				t.Errorf("build files = %q, want %q", build, tt.wantBuild)
+			}
+			if eco := result.Data["ecosystems"].([]string); !slices.Equal(eco, tt.wantEco) {
+				t.Errorf("ecosystems = %q, want %q", eco, tt.wantEco)
+			}
+			if got, _ := result.Data["primary_language"].(string); got != tt.wantPrimary {
+				t.Errorf("primary language = %q, want %q", got, tt.wantPrimary)
+			}
+
+			counts := []string{}
+			for _, entry := range result.Data["extensions"].([]map[string]any) {
+				counts = append(counts, fmt.Sprintf("%s %d/%d", entry["extension"], entry["files"], entry["lines"]))
+			}
+			if !slices.Equal(counts, tt.wantCounts) {
+				t.Errorf("counts = %q, want %q", counts, tt.wantCounts)
+			}
+			if result.Data["counts_partial"] != false {
+				t.Error("counts marked partial for a small project")
+			}
+
+			readme, _ := result.Data["readme"].(map[string]any)
+			if got, _ := readme["start"].(string); got != tt.wantReadme {
+				t.Errorf("readme = %q, want %q", got, tt.wantReadme)
+			}
+		})
+	}
+}
+
+func TestPrimaryLanguage(t *testing.T) {
+	entry := func(ext, language string, lines int) map[string]any {
+		e := map[string]any{"extension": ext, "lines": lines}
+		if language != "" {
+			e["language"] = language
+		}
+		return e
+	}
+	tests := []struct {
+		name   string
+		counts []map[string]any
+		want   string
+	}{
+		{"none", nil, ""},
+		{"no languages", []map[string]any{entry(".md", "", 500)}, ""},
+		{"most lines", []map[string]any{entry(".md", "", 500), entry(".py", "Python", 10), entry(".go", "Go", 20)}, "Go"},
+		{"extensions summed", []map[string]any{entry(".c", "C", 30), entry(".ts", "TypeScript", 25), entry(".tsx", "TypeScript", 25)}, "TypeScript"},
+		{"tie keeps the first", []map[string]any{entry(".rb", "Ruby", 10), entry(".rs", "Rust", 10)}, "Ruby"},
+	}
+	for _, tt := range tests {
+		if got := primaryLanguage(tt.counts); got != tt.want {
+			t.Errorf("%s: primaryLanguage = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// filePatch holds the hunks a unified diff applies to one file.
+type filePatch struct {
+	oldPath string // "" when the file is created
+	newPath string // "" when the file is deleted
+	hunks   []patchHunk
+}
+
+// patchHunk is a single "@@" section of a unified diff.
+type patchHunk struct {
+	oldStart, oldCount int
+	newStart, newCount int
+	ops                []diffOp
+	oldNoEOL           bool // Old side ends without a trailing newline
+	newNoEOL           bool // New side ends without a trailing newline
+}
+
+// hunkHeader matches "@@ -l,s +l,s @@", where the counts are optional.
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parsePatch parses unified diff text into per-file patches.
+// Lines outside file headers and hunks (such as "diff --git" or "index")
+// are ignored.
+func parsePatch(text string) ([]*filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var patches []*filePatch
+	var cur *filePatch
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			cur = &filePatch{
+				oldPath: patchPath(line[4:]),
+				newPath: patchPath(lines[i+1][4:]),
+			}
+			if cur.oldPath == "" && cur.newPath == "" {
+				return nil, fmt.Errorf("line %d: file header names no file", i+1)
+			}
+			patches = append(patches, cur)
+			i++
+
+		case strings.HasPrefix(line, "@@"):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: hunk before any file header", i+1)
+			}
+			hunk, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			cur.hunks = append(cur.hunks, hunk)
+			i = next - 1
+		}
+	}
+
+	for _, p := range patches {
+		if len(p.hunks) == 0 {
+			return nil, fmt.Errorf("%s has a file header but no hunks", p.displayPath())
+		}
+	}
+	return patches, nil
+}
+
+// parseHunk parses the hunk whose header is lines[start] and returns the index
+// of the first line after it.
+func parseHunk(lines []string, start int) (patchHunk, int, error) {
+	m := hunkHeader.FindStringSubmatch(lines[start])
+	if m == nil {
+		return patchHunk{}, 0, fmt.Errorf("line %d: malformed hunk header %q", start+1, lines[start])
+	}
+
+	count := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	var h patchHunk
+	h.oldStart, _ = strconv.Atoi(m[1])
+	h.oldCount = count(m[2])
+	h.newStart, _ = strconv.Atoi(m[3])
+	h.newCount = count(m[4])
+
+	oldSeen, newSeen := 0, 0
+	i := start + 1
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, `\`) {
+			// "\ No newline at end of file" applies to the preceding line
+			if len(h.ops) > 0 {
+				switch h.ops[len(h.ops)-1].kind {
+				case '-':
+					h.oldNoEOL = true
+				case '+':
+					h.newNoEOL = true
+				default:
+					h.oldNoEOL, h.newNoEOL = true, true
+				}
+			}
+			continue
+		}
+		if oldSeen >= h.oldCount && newSeen >= h.newCount {
+			break
+		}
+
+		kind, body := byte(' '), ""
+		if line != "" {
+			// Editors often strip the lone space from empty context lines
+			kind, body = line[0], line[1:]
+		}
+		switch kind {
+		case ' ':
+			oldSeen++
+			newSeen++
+		case '-':
+			oldSeen++
+		case '+':
+			newSeen++
+		default:
+			return patchHunk{}, 0, fmt.Errorf("line %d: unexpected line in hunk: %q", i+1, line)
+		}
+		h.ops = append(h.ops, diffOp{kind, body})
+	}
+
+	if oldSeen != h.oldCount || newSeen != h.newCount {
+		return patchHunk{}, 0, fmt.Errorf("line %d: hunk is truncated (expected -%d +%d lines, got -%d +%d)",
+			start+1, h.oldCount, h.newCount, oldSeen, newSeen)
+	}
+	return h, i, nil
+}
+
+// patchPath extracts the file path from a "---" or "+++" header value,
+// dropping timestamps and git's a/ and b/ prefixes. /dev/null yields "".
+func patchPath(header string) string {
+	name, _, _ := strings.Cut(header, "\t")
+	name = strings.TrimSpace(name)
+	if name == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(name, "a/") || strings.HasPrefix(name, "b/") {
+		name = name[2:]
+	}
+	return name
+}
+
+// displayPath returns the path the patch is best known by.
+func (p *filePatch) displayPath() string {
+	if p.newPath != "" {
+		return p.newPath
+	}
+	return p.oldPath
+}
+
+// hunkConflict reports the first hunk of a file that did not apply.
+type hunkConflict struct {
+	hunk int // 1-based hunk number within the file
+	line int // Line the hunk expected its context at
+}
+
+// applyHunks applies hunks in order to content. Each hunk must match exactly;
+// it is tried at its stated line first and then at the nearest offset, as
+// patch(1) does without fuzz.
+func applyHunks(content string, hunks []patchHunk) (string, *hunkConflict) {
+	lines := splitLines(content)
+	trailingNewline := content == "" || strings.HasSuffix(content, "\n")
+
+	var out []string
+	pos, offset := 0, 0
+	for n, h := range hunks {
+		var oldLines, newLines []string
+		for _, op := range h.ops {
+			if op.kind != '+' {
+				oldLines = append(oldLines, op.line)
+			}
+			if op.kind != '-' {
+				newLines = append(newLines, op.line)
+			}
+		}
+
+		// A hunk with no old lines inserts after line oldStart
+		want := h.oldStart - 1
+		if h.oldCount == 0 {
+			want = h.oldStart
+		}
+		at := findHunk(lines, oldLines, pos, want+offset)
+		if at < 0 {
+			return "", &hunkConflict{hunk: n + 1, line: h.oldStart}
+		}
+
+		out = append(out, lines[pos:at]...)
+		out = append(out, newLines...)
+		pos = at + len(oldLines)
+		offset = at - want
+
+		if pos == len(lines) && (h.oldNoEOL || h.newNoEOL) {
+			trailingNewline = !h.newNoEOL
+		}
+	}
+	out = append(out, lines[pos:]...)
+
+	if len(out) == 0 {
+		return "", nil
+	}
+	result := strings.Join(out, "\n")
+	if trailingNewline {
+		result += "\n"
+	}
+	return result, nil
+}
+
+// findHunk returns the index at or after pos where want occurs in lines,
+// preferring the match closest to hint, or -1 if there is none.
+func findHunk(lines, want []string, pos, hint int) int {
+	last := len(lines) - len(want)
+	if last < pos {
+		return -1
+	}
+	hint = min(max(hint, pos), last)
+
+	matches := func(at int) bool {
+		for i, line := range want {
+			if lines[at+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+	for delta := 0; hint-delta >= pos || hint+delta <= last; delta++ {
+		if at := hint - delta; at >= pos && matches(at) {
+			return at
+		}
+		if at := hint + delta; at <= last && matches(at) {
+			return at
+		}
+	}
+	return -1
+}
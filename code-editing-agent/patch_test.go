@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParsePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		wantErr string // Substring of the error; "" for success
+		want    []string
+	}{
+		{"git headers", "diff --git a/x.go b/x.go\nindex 1..2 100644\n--- a/x.go\n+++ b/x.go\n@@ -1 +1 @@\n-a\n+b\n", "", []string{"x.go -> x.go, 1 hunk"}},
+		{"new file", "--- /dev/null\n+++ b/new.go\n@@ -0,0 +1,2 @@\n+a\n+b\n", "", []string{" -> new.go, 1 hunk"}},
+		{"deleted file", "--- a/old.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-a\n", "", []string{"old.go -> , 1 hunk"}},
+		{"timestamps", "--- x.go\t2024-01-01 00:00:00\n+++ x.go\t2024-01-02 00:00:00\n@@ -1 +1 @@\n-a\n+b\n", "", []string{"x.go -> x.go, 1 hunk"}},
+		{"two files", "--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n--- a/y\n+++ b/y\n@@ -1 +1 @@\n-c\n+d\n", "", []string{"x -> x, 1 hunk", "y -> y, 1 hunk"}},
+		{"two hunks", "--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n@@ -5 +5 @@\n-c\n+d\n", "", []string{"x -> x, 2 hunk"}},
+		{"crlf", "--- a/x\r\n+++ b/x\r\n@@ -1 +1 @@\r\n-a\r\n+b\r\n", "", []string{"x -> x, 1 hunk"}},
+		{"stripped blank context", "--- a/x\n+++ b/x\n@@ -1,3 +1,3 @@\n a\n\n-b\n+c\n", "", []string{"x -> x, 1 hunk"}},
+		{"empty", "", "", nil},
+		{"hunk before header", "@@ -1 +1 @@\n-a\n+b\n", "hunk before any file header", nil},
+		{"header without hunks", "--- a/x\n+++ b/x\n", "has a file header but no hunks", nil},
+		{"both sides /dev/null", "--- /dev/null\n+++ /dev/null\n@@ -0,0 +1 @@\n+a\n", "names no file", nil},
+		{"malformed header", "--- a/x\n+++ b/x\n@@ -a +b @@\n-a\n+b\n", "malformed hunk header", nil},
+		{"truncated hunk", "--- a/x\n+++ b/x\n@@ -1,3 +1,3 @@\n-a\n+b\n", "hunk is truncated", nil},
+		{"unexpected line", "--- a/x\n+++ b/x\n@@ -1,2 +1,2 @@\n-a\n*b\n", "unexpected line in hunk", nil},
+	}
+	for _, tt := range tests {
+		patches, err := parsePatch(tt.patch)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: parsePatch error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parsePatch failed: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, p := range patches {
+			got = append(got, fmt.Sprintf("%s -> %s, %d hunk", p.oldPath, p.newPath, len(p.hunks)))
+		}
+		if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+			t.Errorf("%s: parsePatch = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyHunks(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		patch        string // Hunks only; a file header is added
+		want         string
+		wantConflict int // 1-based hunk that fails; 0 for none
+	}{
+		{"replace", "a\nb\nc\n", "@@ -2 +2 @@\n-b\n+B\n", "a\nB\nc\n", 0},
+		{"with context", "a\nb\nc\n", "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n", "a\nB\nc\n", 0},
+		{"insert after a line", "a\nc\n", "@@ -1,0 +2 @@\n+b\n", "a\nb\nc\n", 0},
+		{"into an empty file", "", "@@ -0,0 +1,2 @@\n+a\n+b\n", "a\nb\n", 0},
+		{"delete everything", "a\n", "@@ -1 +0,0 @@\n-a\n", "", 0},
+		{"offset", "x\nx\na\nb\n", "@@ -1,2 +1,2 @@\n a\n-b\n+B\n", "x\nx\na\nB\n", 0},
+		{"nearest match wins", "b\nq\nq\nq\nb\n", "@@ -4 +4 @@\n-b\n+B\n", "b\nq\nq\nq\nB\n", 0},
+		{"two hunks", "a\nb\nc\nd\n", "@@ -1 +1 @@\n-a\n+A\n@@ -4 +4 @@\n-d\n+D\n", "A\nb\nc\nD\n", 0},
+		{"hunks apply in order", "a\nb\na\n", "@@ -3 +3 @@\n-a\n+A\n@@ -1 +1 @@\n-a\n+A\n", "", 2},
+		{"context mismatch", "a\nb\nc\n", "@@ -1,2 +1,2 @@\n a\n-x\n+y\n", "", 1},
+		{"second hunk mismatches", "a\nb\n", "@@ -1 +1 @@\n-a\n+A\n@@ -2 +2 @@\n-z\n+Z\n", "", 2},
+		{"keeps a missing final newline", "a\nb", "@@ -1 +1 @@\n-a\n+A\n", "A\nb", 0},
+		{"adds a final newline", "a", "@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+a\n", "a\n", 0},
+		{"removes a final newline", "a\n", "@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n", "a", 0},
+	}
+	for _, tt := range tests {
+		patches, err := parsePatch("--- a/x\n+++ b/x\n" + tt.patch)
+		if err != nil {
+			t.Fatalf("%s: parsePatch: %v", tt.name, err)
+		}
+		got, conflict := applyHunks(tt.content, patches[0].hunks)
+		switch {
+		case tt.wantConflict != 0 && (conflict == nil || conflict.hunk != tt.wantConflict):
+			t.Errorf("%s: conflict = %+v, want hunk %d", tt.name, conflict, tt.wantConflict)
+		case tt.wantConflict == 0 && conflict != nil:
+			t.Errorf("%s: conflict at hunk %d", tt.name, conflict.hunk)
+		case tt.wantConflict == 0 && got != tt.want:
+			t.Errorf("%s: applyHunks = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
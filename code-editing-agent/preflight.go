@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// Errors from preflight, for choosing the exit code.
+var (
+	errCredentialsRejected = errors.New("the API rejected the credentials")
+	errUnreachable         = errors.New("could not reach the API")
+)
+
+// preflightTimeout bounds the startup check, so an unreachable API fails
+// fast instead of hanging before the first prompt.
+const preflightTimeout = 15 * time.Second
+
+// preflight checks, before the session starts, that the API is reachable,
+// accepts the credentials, and offers the model, so a bad setup is reported
+// up front with what to fix rather than as a stream error on the first
+// message. vertex selects which credentials the advice points to.
+func preflight(ctx context.Context, client ModelClient, model string, vertex bool) error {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	err := validateModel(ctx, client, model)
+	if err == nil {
+		return nil
+	}
+
+	if apiErr, ok := asAPIError(err); ok && isAuthError(apiErr) {
+		if vertex {
+			return fmt.Errorf("%w: %s\n"+
+				"Run `gcloud auth application-default login`, and check that GOOGLE_CLOUD_PROJECT has the Vertex AI API enabled", errCredentialsRejected, apiErr.Message)
+		}
+		return fmt.Errorf("%w: %s\n"+
+			"Check GEMINI_API_KEY (or GOOGLE_API_KEY), or pass a valid key with --api-key", errCredentialsRejected, apiErr.Message)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w\n"+
+			"Check the network connection, or pass --no-preflight to start anyway", errUnreachable, err)
+	}
+	return err
+}
+
+// asAPIError extracts the API error from err, which the SDK returns either
+// by value or by pointer.
+func asAPIError(err error) (genai.APIError, bool) {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	var apiErrPtr *genai.APIError
+	if errors.As(err, &apiErrPtr) {
+		return *apiErrPtr, true
+	}
+	return genai.APIError{}, false
+}
+
+// isAuthError reports whether apiErr means the credentials were refused. The
+// Gemini API answers an invalid key with 400 rather than 401.
+func isAuthError(apiErr genai.APIError) bool {
+	switch apiErr.Code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	case http.StatusBadRequest:
+		return strings.Contains(apiErr.Message, "API key")
+	}
+	return false
+}
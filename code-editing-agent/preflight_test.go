@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"iter"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// modelsClient lists models, or fails with err, for preflight.
+type modelsClient struct {
+	*ReplayClient
+	models []string
+	err    error
+}
+
+func (c *modelsClient) All(ctx context.Context) iter.Seq2[*genai.Model, error] {
+	return func(yield func(*genai.Model, error) bool) {
+		if c.err != nil {
+			yield(nil, c.err)
+			return
+		}
+		for _, name := range c.models {
+			if !yield(&genai.Model{Name: "models/" + name}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// blockingClient waits for the context to end before listing models, as a
+// request to an unreachable API does.
+type blockingClient struct {
+	*ReplayClient
+}
+
+func (c *blockingClient) All(ctx context.Context) iter.Seq2[*genai.Model, error] {
+	return func(yield func(*genai.Model, error) bool) {
+		<-ctx.Done()
+		yield(nil, ctx.Err())
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	models := []string{"gemini-a", "gemini-b"}
+	dnsErr := &net.DNSError{Err: "no such host", Name: "generativelanguage.googleapis.com", IsNotFound: true}
+	tests := []struct {
+		name     string
+		client   ModelClient
+		model    string
+		vertex   bool
+		wantErr  error    // nil for success; otherwise what the error wraps
+		wantText []string // Advice the message contains
+	}{
+		{name: "model offered", client: &modelsClient{models: models}, model: "gemini-b"},
+		{name: "model with prefix", client: &modelsClient{models: models}, model: "models/gemini-a"},
+		{name: "unknown model", client: &modelsClient{models: models}, model: "gemini-z",
+			wantErr: errUnknownModel, wantText: []string{`"gemini-z"`, "gemini-a", "gemini-b"}},
+		{name: "key rejected",
+			client:  &modelsClient{err: genai.APIError{Code: 401, Message: "Request had invalid authentication credentials."}},
+			wantErr: errCredentialsRejected, wantText: []string{"invalid authentication credentials", "GEMINI_API_KEY", "--api-key"}},
+		{name: "invalid key answered with 400",
+			client:  &modelsClient{err: genai.APIError{Code: 400, Message: "API key not valid. Please pass a valid API key."}},
+			wantErr: errCredentialsRejected, wantText: []string{"API key not valid", "GEMINI_API_KEY"}},
+		{name: "vertex permission denied", vertex: true,
+			client:  &modelsClient{err: &genai.APIError{Code: 403, Message: "Permission denied on resource project."}},
+			wantErr: errCredentialsRejected, wantText: []string{"Permission denied", "gcloud auth application-default login", "GOOGLE_CLOUD_PROJECT"}},
+		{name: "no network", client: &modelsClient{err: dnsErr},
+			wantErr: errUnreachable, wantText: []string{"no such host", "--no-preflight"}},
+		{name: "other bad request",
+			client:  &modelsClient{err: genai.APIError{Code: 400, Message: "Invalid page token."}},
+			wantErr: genai.APIError{}, wantText: []string{"failed to list models"}},
+		{name: "server error",
+			client:  &modelsClient{err: genai.APIError{Code: 500, Message: "Internal error."}},
+			wantErr: genai.APIError{}, wantText: []string{"Internal error."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := preflight(context.Background(), tt.client, tt.model, tt.vertex)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("preflight: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("preflight succeeded, want %v", tt.wantErr)
+			}
+			if _, isAPIErr := tt.wantErr.(genai.APIError); isAPIErr {
+				if errors.Is(err, errCredentialsRejected) || errors.Is(err, errUnreachable) {
+					t.Errorf("err = %v, want the API error unclassified", err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want it to wrap %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantText {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPreflightTimesOut(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := preflight(ctx, &blockingClient{}, "gemini-a", false)
+	if !errors.Is(err, errUnreachable) {
+		t.Errorf("err = %v, want %v", err, errUnreachable)
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	tests := []struct {
+		code    int
+		message string
+		want    bool
+	}{
+		{401, "Unauthenticated", true},
+		{403, "Permission denied", true},
+		{400, "API key not valid", true},
+		{400, "API key expired. Please renew the API key.", true},
+		{400, "Invalid argument", false},
+		{404, "Not found", false},
+		{429, "Quota exceeded", false},
+		{500, "API key backend failure", false},
+	}
+	for _, tt := range tests {
+		if got := isAuthError(genai.APIError{Code: tt.code, Message: tt.message}); got != tt.want {
+			t.Errorf("isAuthError(%d %q) = %t, want %t", tt.code, tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestAsAPIError(t *testing.T) {
+	apiErr := genai.APIError{Code: 403, Message: "denied"}
+	tests := []struct {
+		name   string
+		err    error
+		wantOK bool
+	}{
+		{"value", apiErr, true},
+		{"pointer", &apiErr, true},
+		{"wrapped value", errors.Join(errors.New("listing"), apiErr), true},
+		{"wrapped pointer", errors.Join(errors.New("listing"), &apiErr), true},
+		{"other error", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		got, ok := asAPIError(tt.err)
+		if ok != tt.wantOK || (ok && got.Code != apiErr.Code) {
+			t.Errorf("%s: asAPIError = %v, %t, want ok %t", tt.name, got, ok, tt.wantOK)
+		}
+	}
+}
+
+func TestRunPreflight(t *testing.T) {
+	dir := t.TempDir()
+	replay := filepath.Join(dir, "trace.jsonl")
+	records := `{"type":"request","model":"gemini-recorded","contents":[]}` + "\n" +
+		`{"type":"response","content":{"role":"model","parts":[{"text":"Hello"}]}}` + "\n"
+	if err := os.WriteFile(replay, []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStderr string
+	}{
+		{"recorded model passes", nil, exitOK, ""},
+		{"unknown model fails before the session", []string{"--model", "gemini-other"}, exitUsage, "Error checking setup: unknown model"},
+		{"skipped", []string{"--model", "gemini-other", "--no-preflight"}, exitOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"agent", "--replay", replay, "--root", dir, "--history-file", "", "--prompt", "hi"}, tt.args...)
+			code := run(args, strings.NewReader(""), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Errorf("run exit code = %d, want %d (stderr: %s)", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.wantStderr)
+			}
+			if gotAnswer := strings.Contains(stdout.String(), "Hello"); gotAnswer != (tt.wantCode == exitOK) {
+				t.Errorf("stdout = %q, answered %t", stdout.String(), gotAnswer)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxPromptHistory bounds how many prompts are kept for recall.
+const maxPromptHistory = 1000
+
+// PromptHistory holds the prompts typed in this and earlier sessions, oldest
+// first, for up/down recall. They are kept in a file one prompt per line
+// (--history-file), outside the project and the sandbox.
+type PromptHistory struct {
+	path    string // Empty keeps the history in memory only
+	entries []string
+}
+
+// defaultHistoryFile returns ~/.agent_history, or "" when there is no home
+// directory.
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".agent_history")
+}
+
+// LoadPromptHistory reads the history file at path. A missing file is an
+// empty history. A file that has outgrown maxPromptHistory is rewritten with
+// only the newest prompts. An empty path gives a history that is not saved.
+func LoadPromptHistory(path string) (*PromptHistory, error) {
+	h := &PromptHistory{path: path}
+	if path == "" {
+		return h, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt history: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+
+	if len(h.entries) > maxPromptHistory {
+		h.entries = h.entries[len(h.entries)-maxPromptHistory:]
+		if err := os.WriteFile(path, []byte(strings.Join(h.entries, "\n")+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("failed to trim prompt history: %w", err)
+		}
+	}
+	return h, nil
+}
+
+// Add records prompt and appends it to the history file. Blank prompts and
+// repeats of the previous prompt are not recorded.
+func (h *PromptHistory) Add(prompt string) error {
+	if strings.TrimSpace(prompt) == "" || strings.ContainsAny(prompt, "\r\n") {
+		return nil
+	}
+	if len(h.entries) > 0 && h.entries[len(h.entries)-1] == prompt {
+		return nil
+	}
+
+	h.entries = append(h.entries, prompt)
+	if len(h.entries) > maxPromptHistory {
+		h.entries = h.entries[1:]
+	}
+	if h.path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to save prompt history: %w", err)
+	}
+	if _, err := file.WriteString(prompt + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to save prompt history: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadPromptHistory(t *testing.T) {
+	var long []string
+	for i := range maxPromptHistory + 5 {
+		long = append(long, fmt.Sprintf("prompt %d", i))
+	}
+	tests := []struct {
+		name     string
+		missing  bool     // No history file at all
+		file     string   // History file contents
+		want     []string // Entries loaded
+		wantFile string   // File contents afterwards; "" to leave unchecked
+	}{
+		{name: "missing file", missing: true, want: nil},
+		{name: "empty file", file: "", want: nil},
+		{name: "blank lines skipped", file: "one\n\n  \ntwo\n", want: []string{"one", "two"}},
+		{name: "no trailing newline", file: "one\ntwo", want: []string{"one", "two"}},
+		{name: "oversized file trimmed", file: strings.Join(long, "\n") + "\n",
+			want: long[5:], wantFile: strings.Join(long[5:], "\n") + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".agent_history")
+			if !tt.missing {
+				if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h, err := LoadPromptHistory(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(h.entries, tt.want) {
+				t.Errorf("entries = %d %q..., want %d", len(h.entries), h.entries[:min(len(h.entries), 3)], len(tt.want))
+			}
+			if tt.wantFile != "" {
+				data, _ := os.ReadFile(path)
+				if string(data) != tt.wantFile {
+					t.Errorf("file has %d lines after loading, want %d", strings.Count(string(data), "\n"), strings.Count(tt.wantFile, "\n"))
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPromptHistoryUnreadable(t *testing.T) {
+	// A directory where the file should be cannot be read as one
+	if _, err := LoadPromptHistory(t.TempDir()); err == nil || !strings.Contains(err.Error(), "failed to read prompt history") {
+		t.Errorf("err = %v, want a read failure", err)
+	}
+}
+
+func TestPromptHistoryAdd(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial string   // History file contents before loading
+		add     []string // Prompts added in order
+		want    []string // Entries afterwards, in memory and after reloading
+	}{
+		{name: "appends", add: []string{"one", "two"}, want: []string{"one", "two"}},
+		{name: "after earlier sessions", initial: "old\n", add: []string{"new"}, want: []string{"old", "new"}},
+		{name: "blank prompts skipped", add: []string{"", "   ", "one"}, want: []string{"one"}},
+		{name: "repeat of the last skipped", add: []string{"one", "one", "two", "one"}, want: []string{"one", "two", "one"}},
+		{name: "repeat across sessions skipped", initial: "one\n", add: []string{"one"}, want: []string{"one"}},
+		{name: "multi-line prompts skipped", add: []string{"a\nb", "c\r\nd", "e"}, want: []string{"e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".agent_history")
+			if tt.initial != "" {
+				if err := os.WriteFile(path, []byte(tt.initial), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h, err := LoadPromptHistory(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, prompt := range tt.add {
+				if err := h.Add(prompt); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !slices.Equal(h.entries, tt.want) {
+				t.Errorf("entries = %q, want %q", h.entries, tt.want)
+			}
+
+			reloaded, err := LoadPromptHistory(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(reloaded.entries, tt.want) {
+				t.Errorf("reloaded entries = %q, want %q", reloaded.entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".agent_history")
+	h, err := LoadPromptHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Add("secret project plans"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("history file mode = %v, want 0600", perm)
+	}
+
+	// Adding past the limit drops the oldest prompt from memory
+	for i := range maxPromptHistory {
+		h.Add(fmt.Sprintf("prompt %d", i))
+	}
+	if len(h.entries) != maxPromptHistory || h.entries[0] != "prompt 0" {
+		t.Errorf("kept %d entries starting %q, want %d starting %q", len(h.entries), h.entries[0], maxPromptHistory, "prompt 0")
+	}
+}
+
+func TestPromptHistoryInMemory(t *testing.T) {
+	h, err := LoadPromptHistory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Add("one"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(h.entries, []string{"one"}) {
+		t.Errorf("entries = %q", h.entries)
+	}
+}
+
+func TestDefaultHistoryFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if got, want := defaultHistoryFile(), filepath.Join(home, ".agent_history"); got != want {
+		t.Errorf("defaultHistoryFile() = %q, want %q", got, want)
+	}
+}
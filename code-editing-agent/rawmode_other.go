@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw is unsupported on this platform; LineEditor falls back to reading
+// plain lines.
+func makeRaw(f *os.File) (func() error, error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+// interruptSelf is never needed here, since raw mode is never entered.
+func interruptSelf() {}
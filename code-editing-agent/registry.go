@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// Tool is a capability the model can call.
+type Tool interface {
+	// Declaration describes the tool's name and parameters to the model.
+	Declaration() *genai.FunctionDeclaration
+	// Execute runs the tool with the model-supplied arguments.
+	Execute(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult
+}
+
+// FuncTool adapts a declaration and a handler function into a Tool.
+type FuncTool struct {
+	Decl     *genai.FunctionDeclaration
+	Run      func(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult
+	ReadOnly bool // Never modifies anything, so it may run concurrently with other read-only tools
+	DryRun   bool // Only reports what it would change when ToolContext.DryRun is set
+}
+
+// Declaration returns the tool's declaration.
+func (t *FuncTool) Declaration() *genai.FunctionDeclaration {
+	return t.Decl
+}
+
+// Execute calls the tool's handler.
+func (t *FuncTool) Execute(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	return t.Run(ctx, args, tc)
+}
+
+// IsReadOnly reports whether the tool is read-only.
+func (t *FuncTool) IsReadOnly() bool {
+	return t.ReadOnly
+}
+
+// SimulatesDryRun reports whether the tool only reports its changes in a dry run.
+func (t *FuncTool) SimulatesDryRun() bool {
+	return t.DryRun
+}
+
+// Registry holds the tools available to the agent and dispatches calls to them.
+type Registry struct {
+	tools    map[string]Tool
+	order    []string        // Registration order, so declarations are stable
+	disabled map[string]bool // Tools hidden from the model and refused at dispatch
+}
+
+// NewRegistry creates a registry holding tools.
+func NewRegistry(tools ...Tool) *Registry {
+	r := &Registry{tools: make(map[string]Tool), disabled: make(map[string]bool)}
+	for _, tool := range tools {
+		r.Register(tool)
+	}
+	return r
+}
+
+// NewDefaultRegistry creates a registry holding the built-in tools.
+func NewDefaultRegistry() *Registry {
+	return NewRegistry(builtinTools()...)
+}
+
+// Register adds a tool, replacing any existing tool with the same name.
+func (r *Registry) Register(tool Tool) {
+	name := tool.Declaration().Name
+	if _, exists := r.tools[name]; !exists {
+		r.order = append(r.order, name)
+	}
+	r.tools[name] = tool
+}
+
+// Lookup returns the named tool.
+func (r *Registry) Lookup(name string) (Tool, bool) {
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// Names returns the registered tool names in registration order.
+func (r *Registry) Names() []string {
+	return append([]string(nil), r.order...)
+}
+
+// Restrict limits which tools are enabled. A non-empty enable list disables
+// every tool not in it; names in disable are then disabled as well. Unknown
+// names are an error so typos do not silently leave a tool enabled.
+func (r *Registry) Restrict(enable, disable []string) error {
+	for _, name := range append(append([]string(nil), enable...), disable...) {
+		if _, ok := r.tools[name]; !ok {
+			return fmt.Errorf("unknown tool: %s", name)
+		}
+	}
+
+	if len(enable) > 0 {
+		for _, name := range r.order {
+			r.disabled[name] = !slices.Contains(enable, name)
+		}
+	}
+	for _, name := range disable {
+		r.disabled[name] = true
+	}
+	return nil
+}
+
+// GenaiTools returns the declarations of every enabled tool for the model
+// config, or nil when no tools are enabled.
+func (r *Registry) GenaiTools() []*genai.Tool {
+	var decls []*genai.FunctionDeclaration
+	for _, name := range r.order {
+		if !r.disabled[name] {
+			decls = append(decls, r.tools[name].Declaration())
+		}
+	}
+	if len(decls) == 0 {
+		return nil
+	}
+	return []*genai.Tool{{FunctionDeclarations: decls}}
+}
+
+// Enabled reports whether the named tool is registered and not disabled.
+func (r *Registry) Enabled(name string) bool {
+	_, ok := r.tools[name]
+	return ok && !r.disabled[name]
+}
+
+// IsReadOnly reports whether the named tool is registered and declares itself
+// read-only. Read-only tools may run concurrently.
+func (r *Registry) IsReadOnly(name string) bool {
+	tool, ok := r.tools[name].(interface{ IsReadOnly() bool })
+	return ok && tool.IsReadOnly()
+}
+
+// SimulatesDryRun reports whether the named tool is registered and changes
+// nothing in a dry run, reporting what it would do instead. Tools that run
+// commands do not: a dry run cannot know what a command would change.
+func (r *Registry) SimulatesDryRun(name string) bool {
+	tool, ok := r.tools[name].(interface{ SimulatesDryRun() bool })
+	return ok && tool.SimulatesDryRun()
+}
+
+// Execute runs a function call against the registered tools and returns a ToolResult.
+// Arguments are checked against the tool's declared schema first.
+func (r *Registry) Execute(ctx context.Context, fc *genai.FunctionCall, tc *ToolContext) *ToolResult {
+	tc.Logger.Debug("tool call", "tool", fc.Name, "args", fc.Args)
+	start := time.Now()
+
+	var result *ToolResult
+	var violations []string
+	tool, ok := r.tools[fc.Name]
+	if ok {
+		violations = validateArgs(tool.Declaration().Parameters, fc.Args)
+	}
+	switch {
+	case !ok:
+		result = NewErrorResult("invalid_argument", fmt.Sprintf("unknown tool: %s", fc.Name), nil)
+	case r.disabled[fc.Name]:
+		result = NewErrorResult("permission_denied", fmt.Sprintf("tool is disabled in this session: %s", fc.Name), nil)
+	case len(violations) > 0:
+		result = NewErrorResult("invalid_argument", fmt.Sprintf("invalid arguments for %s: %s", fc.Name, strings.Join(violations, "; ")), []string{
+			"Fix every listed argument and call the tool again",
+		})
+	default:
+		result = tool.Execute(ctx, fc.Args, tc)
+	}
+
+	attrs := []any{
+		"tool", fc.Name,
+		"duration_ms", time.Since(start).Milliseconds(),
+		"ok", result.OK,
+	}
+	if result.Error != nil {
+		attrs = append(attrs, "error_code", result.Error.Code)
+	}
+	tc.Logger.Debug("tool result", append(attrs, "result", result.AsMap())...)
+
+	return result
+}
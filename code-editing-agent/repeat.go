@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/genai"
+)
+
+// defaultMaxRepeatCalls is how many times in a row the same call may run
+// before further repeats are short-circuited.
+const defaultMaxRepeatCalls = 3
+
+// repeatTracker detects a model calling the same tool with the same
+// arguments over and over within a turn.
+type repeatTracker struct {
+	limit   int // Consecutive identical calls allowed; 0 disables the check
+	lastKey string
+	count   int
+}
+
+// newRepeatTracker creates a tracker allowing limit identical calls in a row.
+func newRepeatTracker(limit int) *repeatTracker {
+	return &repeatTracker{limit: limit}
+}
+
+// observe records call and reports whether it exceeds the repeat limit.
+// Calls must be observed in the order the model made them.
+func (t *repeatTracker) observe(call *genai.FunctionCall) bool {
+	args, _ := json.Marshal(call.Args) // Map keys are sorted, so equal args encode equally
+	key := call.Name + "\x00" + string(args)
+	if key == t.lastKey {
+		t.count++
+	} else {
+		t.lastKey = key
+		t.count = 1
+	}
+	return t.limit > 0 && t.count > t.limit
+}
+
+// repeatedCallResult is returned instead of running a call that has already
+// been made too many times in a row.
+func repeatedCallResult(call *genai.FunctionCall, limit int) *ToolResult {
+	return NewErrorResult("repeated_call", fmt.Sprintf("you already made this exact %s call %d times in a row and the result was the same; it was not run again", call.Name, limit), []string{
+		"Change the arguments, try a different tool, or answer with what you have",
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/genai"
+)
+
+// ReplayClient is a ModelClient that serves the responses recorded in a
+// --trace-file, in order, instead of calling the API. Requests are not
+// compared with the recording, so a session replays faithfully only while
+// the agent makes the same requests: the same prompts, with tools behaving
+// as they did.
+type ReplayClient struct {
+	mu        sync.Mutex
+	path      string
+	responses []traceResponse
+	models    []string // Models named in recorded requests, in first-use order
+	next      int
+}
+
+// NewReplayClient loads the requests and responses recorded in path.
+func NewReplayClient(path string) (*ReplayClient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay file: %w", err)
+	}
+	defer file.Close()
+
+	r := &ReplayClient{path: path}
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20) // Requests carry the whole history
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var record struct {
+			Type  string `json:"type"`
+			Model string `json:"model"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		switch record.Type {
+		case "request":
+			if record.Model != "" && !seen[record.Model] {
+				seen[record.Model] = true
+				r.models = append(r.models, record.Model)
+			}
+		case "response":
+			var response traceResponse
+			if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+			}
+			r.responses = append(r.responses, response)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read replay file: %w", err)
+	}
+	if len(r.responses) == 0 {
+		return nil, fmt.Errorf("%s: no recorded responses", path)
+	}
+	return r, nil
+}
+
+// NewScriptedClient returns a ReplayClient that answers with responses in
+// order, as if they had been recorded. A response may be model text, function
+// calls, or both; build them with genai.NewContentFromText and
+// genai.NewPartFromFunctionCall.
+func NewScriptedClient(responses ...*genai.Content) *ReplayClient {
+	r := &ReplayClient{path: "script"}
+	for _, content := range responses {
+		r.responses = append(r.responses, traceResponse{Type: "response", Content: content})
+	}
+	return r
+}
+
+// Models returns the models the recorded requests were sent to.
+func (r *ReplayClient) Models() []string {
+	return r.models
+}
+
+// take returns the next recorded response as the API would have: the
+// aggregated content as a single response, or the recorded error.
+func (r *ReplayClient) take() (*genai.GenerateContentResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next >= len(r.responses) {
+		return nil, fmt.Errorf("replay of %s exhausted: all %d recorded responses were used", r.path, len(r.responses))
+	}
+	recorded := r.responses[r.next]
+	r.next++
+
+	if recorded.Error != "" {
+		if recorded.ErrorCode != 0 {
+			// Keep the API error's code so retries happen as they did live
+			return nil, genai.APIError{Code: recorded.ErrorCode, Message: recorded.Error}
+		}
+		return nil, fmt.Errorf("%s", recorded.Error)
+	}
+	resp := &genai.GenerateContentResponse{UsageMetadata: recorded.Usage}
+	if recorded.Content != nil {
+		resp.Candidates = []*genai.Candidate{{Content: recorded.Content}}
+	}
+	return resp, nil
+}
+
+// Stream serves the next recorded response as a one-chunk stream.
+func (r *ReplayClient) Stream(ctx context.Context, model string, history []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		if err := ctx.Err(); err != nil {
+			yield(nil, err)
+			return
+		}
+		yield(r.take())
+	}
+}
+
+// GenerateContent serves the next recorded response.
+func (r *ReplayClient) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.take()
+}
+
+// CountTokens estimates the count, since token counts were not recorded.
+func (r *ReplayClient) CountTokens(ctx context.Context, model string, contents []*genai.Content, config *genai.CountTokensConfig) (*genai.CountTokensResponse, error) {
+	return &genai.CountTokensResponse{TotalTokens: int32(estimateTokens(contents))}, nil
+}
+
+// All lists the models named in the recording.
+func (r *ReplayClient) All(ctx context.Context) iter.Seq2[*genai.Model, error] {
+	return func(yield func(*genai.Model, error) bool) {
+		for _, name := range r.models {
+			if !yield(&genai.Model{Name: "models/" + strings.TrimPrefix(name, "models/")}, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestNewReplayClient(t *testing.T) {
+	request := `{"type":"request","model":"gemini-a","contents":[]}`
+	response := `{"type":"response","content":{"role":"model","parts":[{"text":"hi"}]}}`
+	tests := []struct {
+		name          string
+		content       string // absent for a missing file
+		wantResponses int
+		wantModels    []string
+		wantErr       string
+	}{
+		{"two turns", strings.Join([]string{request, response, `{"type":"request","model":"gemini-b"}`, response, request, response}, "\n"), 3,
+			[]string{"gemini-a", "gemini-b"}, ""},
+		{"other records skipped", strings.Join([]string{`{"type":"error","error":"x"}`, response}, "\n"), 1, nil, ""},
+		{"no responses", request, 0, nil, "no recorded responses"},
+		{"bad line", request + "\nnot json\n", 0, nil, ":2:"},
+		{"missing", absent, 0, nil, "failed to open replay file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "trace.jsonl")
+			if tt.content != absent {
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			client, err := NewReplayClient(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("NewReplayClient error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewReplayClient: %v", err)
+			}
+			if len(client.responses) != tt.wantResponses || !slices.Equal(client.Models(), tt.wantModels) {
+				t.Errorf("loaded %d responses for models %q, want %d for %q", len(client.responses), client.Models(), tt.wantResponses, tt.wantModels)
+			}
+		})
+	}
+}
+
+func TestReplayClientResponses(t *testing.T) {
+	client := &ReplayClient{path: "trace.jsonl", responses: []traceResponse{
+		{Type: "response", Content: genai.NewContentFromText("first", genai.RoleModel), Usage: &genai.GenerateContentResponseUsageMetadata{TotalTokenCount: 9}},
+		{Type: "response", Error: "overloaded", ErrorCode: 503},
+		{Type: "response", Error: "connection reset"},
+	}}
+	ctx := context.Background()
+
+	resp, err := client.GenerateContent(ctx, "m", nil, nil)
+	if err != nil || contentText(resp.Candidates[0].Content) != "first" || resp.UsageMetadata.TotalTokenCount != 9 {
+		t.Errorf("first response = %+v, %v; want the recorded content and usage", resp, err)
+	}
+	var apiErr genai.APIError
+	for resp, err := range client.Stream(ctx, "m", nil, nil) {
+		if !errors.As(err, &apiErr) || apiErr.Code != 503 {
+			t.Errorf("second response = %v, %v; want the API error with its status", resp, err)
+		}
+	}
+	if _, err := client.GenerateContent(ctx, "m", nil, nil); err == nil || errors.As(err, &apiErr) || err.Error() != "connection reset" {
+		t.Errorf("third response error = %v, want the plain recorded error", err)
+	}
+	if _, err := client.GenerateContent(ctx, "m", nil, nil); err == nil || !strings.Contains(err.Error(), "exhausted: all 3 recorded responses were used") {
+		t.Errorf("past the end error = %v, want exhausted", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := NewScriptedClient(genai.NewContentFromText("x", genai.RoleModel)).GenerateContent(cancelled, "m", nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled request error = %v, want context.Canceled", err)
+	}
+}
+
+// callRecorder is an EventSink remembering each tool call as name plus JSON
+// arguments.
+type callRecorder struct {
+	calls []string
+}
+
+func (r *callRecorder) OnModelWaiting()                               {}
+func (r *callRecorder) OnModelText(string)                            {}
+func (r *callRecorder) OnModelDone()                                  {}
+func (r *callRecorder) OnToolResult(*genai.FunctionCall, *ToolResult) {}
+func (r *callRecorder) OnToolCall(call *genai.FunctionCall) {
+	args, _ := json.Marshal(call.Args)
+	r.calls = append(r.calls, call.Name+" "+string(args))
+}
+
+func TestReplayReproducesToolCalls(t *testing.T) {
+	files := map[string]string{"main.go": "package main\n", "notes.txt": "todo\n"}
+	session := func(t *testing.T, client ModelClient, tracer *Tracer) ([]string, []string) {
+		t.Helper()
+		agent := newTestAgent(t, client, nil)
+		writeTree(t, agent.sandbox.Root, files)
+		recorder := &callRecorder{}
+		agent.events = recorder
+		agent.tracer = tracer
+		var answers []string
+		for _, prompt := range []string{"What is here?", "Read the notes."} {
+			answer, err := agent.runTurn(context.Background(), prompt)
+			if err != nil {
+				t.Fatalf("runTurn(%q): %v", prompt, err)
+			}
+			answers = append(answers, answer)
+		}
+		return recorder.calls, answers
+	}
+
+	// Record two turns against scripted responses
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	tracer, err := NewTracer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := NewScriptedClient(
+		functionCallContent("list_files", map[string]any{"path": "."}),
+		genai.NewContentFromText("main.go and notes.txt.", genai.RoleModel),
+		functionCallContent("read_file", map[string]any{"path": "notes.txt"}),
+		genai.NewContentFromText("It says todo.", genai.RoleModel),
+	)
+	liveCalls, liveAnswers := session(t, live, tracer)
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay them in a fresh session
+	replay, err := NewReplayClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayCalls, replayAnswers := session(t, replay, nil)
+
+	if len(liveCalls) != 2 || !slices.Equal(replayCalls, liveCalls) {
+		t.Errorf("replayed calls = %q, want %q", replayCalls, liveCalls)
+	}
+	if !slices.Equal(replayAnswers, liveAnswers) {
+		t.Errorf("replayed answers = %q, want %q", replayAnswers, liveAnswers)
+	}
+	if got := replay.Models(); !slices.Equal(got, []string{defaultModel}) {
+		t.Errorf("replay models = %q, want the recorded model", got)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+const (
+	defaultMaxRetries = 3                      // Retries after the first attempt
+	retryBaseDelay    = 500 * time.Millisecond // Delay before the first retry
+	retryMaxDelay     = 8 * time.Second        // Upper bound on any single delay
+)
+
+// isRetryableError reports whether a stream error is likely transient:
+// rate limits, server errors, and network failures. Cancellation of ctx
+// itself is never retried.
+func isRetryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	var apiErrPtr *genai.APIError
+	if errors.As(err, &apiErrPtr) {
+		return apiErrPtr.Code == http.StatusTooManyRequests || apiErrPtr.Code >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoffDelay returns the delay before retry number attempt (starting at 0),
+// doubling each time up to retryMaxDelay, with full jitter.
+func backoffDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return time.Duration(rand.Int64N(int64(delay))) + 1
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"rate limited", context.Background(), genai.APIError{Code: 429}, true},
+		{"server error", context.Background(), genai.APIError{Code: 503}, true},
+		{"server error pointer", context.Background(), &genai.APIError{Code: 500}, true},
+		{"wrapped server error", context.Background(), fmt.Errorf("stream: %w", genai.APIError{Code: 502}), true},
+		{"bad request", context.Background(), genai.APIError{Code: 400}, false},
+		{"not found", context.Background(), &genai.APIError{Code: 404}, false},
+		{"network", context.Background(), &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"deadline", context.Background(), context.DeadlineExceeded, true},
+		{"cut off", context.Background(), io.ErrUnexpectedEOF, true},
+		{"other", context.Background(), errors.New("invalid argument"), false},
+		{"cancelled turn", cancelled, genai.APIError{Code: 503}, false},
+	}
+	for _, tt := range tests {
+		if got := isRetryableError(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("%s: isRetryableError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := range 40 {
+		limit := min(retryBaseDelay<<attempt, retryMaxDelay)
+		if attempt >= 31 {
+			limit = retryMaxDelay // The shift overflows
+		}
+		for range 50 {
+			if d := backoffDelay(attempt); d <= 0 || d > limit {
+				t.Fatalf("backoffDelay(%d) = %v, want within (0, %v]", attempt, d, limit)
+			}
+		}
+	}
+}
+
+func TestSleepContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := sleepContext(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("sleepContext after cancel = %v, want context.Canceled", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("sleepContext did not return when cancelled")
+	}
+	if err := sleepContext(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("sleepContext = %v, want nil", err)
+	}
+}
+
+// partialClient streams some text and then fails, as a connection dropped
+// mid-response would.
+type partialClient struct {
+	*ReplayClient
+	calls int
+}
+
+func (c *partialClient) Stream(ctx context.Context, model string, history []*genai.Content, config *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	c.calls++
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		partial := &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+			Content: genai.NewContentFromText("Partial answer", genai.RoleModel),
+		}}}
+		if yield(partial, nil) {
+			yield(nil, genai.APIError{Code: 503, Message: "dropped"})
+		}
+	}
+}
+
+func TestStreamRetries(t *testing.T) {
+	failure := func(code int) traceResponse {
+		return traceResponse{Type: "response", Error: fmt.Sprintf("status %d", code), ErrorCode: code}
+	}
+	answer := traceResponse{Type: "response", Content: genai.NewContentFromText("Done.", genai.RoleModel)}
+
+	tests := []struct {
+		name         string
+		responses    []traceResponse
+		maxRetries   int
+		wantErr      string // "" for success
+		wantRequests int
+	}{
+		{"no errors", []traceResponse{answer}, 2, "", 1},
+		{"transient error", []traceResponse{failure(503), answer}, 2, "", 2},
+		{"rate limit then server error", []traceResponse{failure(429), failure(500), answer}, 2, "", 3},
+		{"retries exhausted", []traceResponse{failure(503), failure(503), answer}, 1, "status 503", 2},
+		{"fatal error", []traceResponse{failure(400), answer}, 2, "status 400", 1},
+		{"retries disabled", []traceResponse{failure(503), answer}, 0, "status 503", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // Retries really back off
+			client := &ReplayClient{path: "script", responses: tt.responses}
+			agent := newTestAgent(t, client, nil)
+			agent.maxRetries = tt.maxRetries
+
+			answer, err := agent.runTurn(context.Background(), "hi")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("runTurn error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil || answer != "Done." {
+				t.Errorf("runTurn = %q, %v; want the answer", answer, err)
+			}
+			if client.next != tt.wantRequests {
+				t.Errorf("sent %d requests, want %d", client.next, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestStreamDoesNotRetryAfterOutput(t *testing.T) {
+	client := &partialClient{ReplayClient: NewScriptedClient()}
+	var out strings.Builder
+	agent := newTestAgentWithOutput(t, client, &out, nil)
+
+	if _, err := agent.runTurn(context.Background(), "hi"); err == nil {
+		t.Fatal("runTurn succeeded after the stream dropped")
+	}
+	if client.calls != 1 {
+		t.Errorf("sent %d requests, want 1: output had already been shown", client.calls)
+	}
+	if n := strings.Count(out.String(), "Partial answer"); n != 1 {
+		t.Errorf("partial output shown %d times, want once:\n%s", n, out.String())
+	}
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io/fs"
+	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // PathAccess represents the type of access requested for a path.
@@ -13,29 +18,137 @@ const (
 	AccessReadFile PathAccess = iota
 	AccessWriteFile
 	AccessListDir
+	AccessDeleteFile
+	AccessCreateDir
+	AccessStat
+	AccessMoveFile
 )
 
+// SymlinkPolicy controls whether Resolve follows symlinks in a path.
+type SymlinkPolicy int
+
+const (
+	SymlinkWithinRoot SymlinkPolicy = iota // Follow links whose target stays inside the root
+	SymlinkDeny                            // Refuse any path that goes through a symlink
+	SymlinkAllow                           // Follow links wherever they point
+)
+
+// ParseSymlinkPolicy parses "within-root", "deny", or "allow".
+func ParseSymlinkPolicy(name string) (SymlinkPolicy, error) {
+	switch name {
+	case "within-root":
+		return SymlinkWithinRoot, nil
+	case "deny":
+		return SymlinkDeny, nil
+	case "allow":
+		return SymlinkAllow, nil
+	default:
+		return 0, fmt.Errorf("unknown symlink policy %q (want within-root, deny, or allow)", name)
+	}
+}
+
 // PathSandbox enforces filesystem access within a configured root.
 type PathSandbox struct {
-	Root string // Resolved absolute path to the root
+	Root               string        // Resolved absolute path to the root
+	Allow              []string      // If non-empty, only paths matching one of these globs are accessible
+	Deny               []string      // Paths matching any of these globs are never accessible
+	WriteQuota         int64         // Maximum total bytes written per session; 0 means unlimited
+	FollowSymlinks     SymlinkPolicy // Which symlinks Resolve may follow
+	AllowAbsolutePaths bool          // Accept absolute paths under the root; otherwise only root-relative paths
+	FS                 FileSystem    // File access for resolved paths; defaults to the local disk, confined to the root
+
+	mu      sync.Mutex
+	written int64 // Bytes written so far against WriteQuota
+
+	agentIgnore *IgnoreMatcher // Rules from the root's .agentignore; nil if there are none
+
+	cache fsCache // Lookups for the current turn; see ClearCache
+}
+
+// SandboxOption configures a PathSandbox at construction.
+type SandboxOption func(*PathSandbox)
+
+// WithWriteQuota limits the total bytes the sandbox lets tools write.
+func WithWriteQuota(bytes int64) SandboxOption {
+	return func(s *PathSandbox) {
+		s.WriteQuota = bytes
+	}
+}
+
+// WithSymlinkPolicy sets which symlinks the sandbox follows.
+func WithSymlinkPolicy(policy SymlinkPolicy) SandboxOption {
+	return func(s *PathSandbox) {
+		s.FollowSymlinks = policy
+	}
+}
+
+// WithConfig applies the sandbox settings from cfg: the write quota, symlink
+// and absolute path policies, and allow and deny rules. NewPathSandbox
+// validates the rules.
+func WithConfig(cfg *Config) SandboxOption {
+	return func(s *PathSandbox) {
+		s.WriteQuota = cfg.WriteQuota
+		s.FollowSymlinks = cfg.FollowSymlinks
+		s.AllowAbsolutePaths = cfg.AllowAbsolutePaths
+		s.Allow = cfg.AllowPaths
+		s.Deny = cfg.DenyPaths
+	}
+}
+
+// WithFileSystem sets the FileSystem tools use for resolved paths.
+func WithFileSystem(fsys FileSystem) SandboxOption {
+	return func(s *PathSandbox) {
+		s.FS = fsys
+	}
 }
 
 // NewPathSandbox creates a new sandbox with the given root.
-// It resolves the root to an absolute path and evaluates symlinks.
-func NewPathSandbox(root string) (*PathSandbox, error) {
+// It resolves the root to an absolute path and evaluates symlinks, in the
+// FileSystem set by WithFileSystem if there is one.
+func NewPathSandbox(root string, opts ...SandboxOption) (*PathSandbox, error) {
+	s := &PathSandbox{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	fsys := s.FS
+	if fsys == nil {
+		fsys = osFileSystem{}
+	}
+
 	rootAbs, err := filepath.Abs(root)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve root: %w", err)
 	}
 
-	rootReal, err := filepath.EvalSymlinks(rootAbs)
+	rootReal, err := fsys.EvalSymlinks(rootAbs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to evaluate root symlinks: %w", err)
 	}
 
-	return &PathSandbox{
-		Root: rootReal,
-	}, nil
+	info, err := fsys.Stat(rootReal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat root: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("root is not a directory: %s", rootAbs)
+	}
+
+	s.Root = rootReal
+	if s.FS == nil {
+		// Confine every open to the root, so a symlink swapped in after
+		// Resolve cannot lead outside it. Only the allow policy follows
+		// links out of the root, and it needs plain disk access.
+		if s.FollowSymlinks == SymlinkAllow {
+			s.FS = osFileSystem{}
+		} else if s.FS, err = newRootFileSystem(rootReal); err != nil {
+			return nil, fmt.Errorf("failed to open root: %w", err)
+		}
+	}
+	s.agentIgnore = NewAgentIgnoreMatcher(s.FS, rootReal)
+	if err := s.SetRules(s.Allow, s.Deny); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 // Resolve validates and resolves a user-provided path within the sandbox.
@@ -52,9 +165,13 @@ func (s *PathSandbox) Resolve(userPath string, access PathAccess) (string, error
 	// 2. Normalize and validate
 	clean := filepath.Clean(userPath)
 
-	// Optionally reject absolute paths; for now, we allow them but still validate.
+	// Absolute paths are refused unless allowed, and even then must land
+	// under the root
 	var candidate string
 	if filepath.IsAbs(clean) {
+		if !s.AllowAbsolutePaths {
+			return "", s.absolutePathError(userPath, clean)
+		}
 		candidate = clean
 	} else {
 		candidate = filepath.Join(s.Root, clean)
@@ -68,47 +185,74 @@ func (s *PathSandbox) Resolve(userPath string, access PathAccess) (string, error
 		}
 	}
 
+	// 3. Symlink policy: under deny, refuse any link that would be followed.
+	// Stat, delete, and move act on a final-component link itself.
+	if s.FollowSymlinks == SymlinkDeny {
+		followLast := access != AccessStat && access != AccessDeleteFile && access != AccessMoveFile
+		if link, ok := s.findSymlink(candidateAbs, followLast); ok {
+			return "", &SandboxError{
+				Code:    "permission_denied",
+				Message: fmt.Sprintf("path goes through a symlink, which this session does not follow: %s", link),
+			}
+		}
+	}
+
 	// 4. Symlink protection
 	var candidateReal string
 	switch access {
 	case AccessReadFile, AccessListDir:
 		// For read/list: must evaluate symlinks successfully
-		real, err := filepath.EvalSymlinks(candidateAbs)
+		real, err := s.evalSymlinks(candidateAbs)
 		if err != nil {
-			// Suggest alternatives
-			suggestions := s.suggestFiles(candidateAbs)
-			return "", &SandboxError{
-				Code:        "not_found",
-				Message:     fmt.Sprintf("path not found: %s", userPath),
-				Suggestions: suggestions,
-			}
+			return "", s.notFoundError(fmt.Sprintf("path not found: %s", userPath), candidateAbs)
 		}
 		candidateReal = real
 
 	case AccessWriteFile:
 		// For write: if it exists, use evaluated path; otherwise eval parent.
-		real, err := filepath.EvalSymlinks(candidateAbs)
+		real, err := s.evalSymlinks(candidateAbs)
 		if err == nil {
 			candidateReal = real
 		} else {
 			// File doesn't exist; eval parent to detect symlinked parent escapes
 			parentAbs := filepath.Dir(candidateAbs)
-			parentReal, err := filepath.EvalSymlinks(parentAbs)
+			parentReal, err := s.evalSymlinks(parentAbs)
 			if err != nil {
 				// Parent doesn't exist either
-				suggestions := s.suggestFiles(parentAbs)
-				return "", &SandboxError{
-					Code:        "not_found",
-					Message:     fmt.Sprintf("parent directory not found: %s", filepath.Dir(userPath)),
-					Suggestions: suggestions,
-				}
+				return "", s.notFoundError(fmt.Sprintf("parent directory not found: %s", filepath.Dir(userPath)), parentAbs)
 			}
 			candidateReal = filepath.Join(parentReal, filepath.Base(candidateAbs))
 		}
+
+	case AccessStat, AccessDeleteFile, AccessMoveFile:
+		// For stat, delete, and move: eval the parent only, so a symlink in
+		// the final component is the link itself rather than its target.
+		// Stat reports the link; delete and move act on the link and never
+		// touch what it points to, inside or outside the root. Only stat
+		// accepts a path that does not exist.
+		parentAbs := filepath.Dir(candidateAbs)
+		parentReal, err := s.evalSymlinks(parentAbs)
+		if err != nil {
+			return "", &SandboxError{
+				Code:    "not_found",
+				Message: fmt.Sprintf("parent directory not found: %s", filepath.Dir(userPath)),
+			}
+		}
+		candidateReal = filepath.Join(parentReal, filepath.Base(candidateAbs))
+
+	case AccessCreateDir:
+		// For directory creation: eval the deepest existing ancestor so
+		// symlinked ancestors are caught, then append the missing components.
+		candidateReal = s.resolveExistingPrefix(candidateAbs)
 	}
 
-	// 5. Root check
-	rel, err := filepath.Rel(s.Root, candidateReal)
+	// 5. Root check. Under allow, only the path as written must be inside
+	// the root; the links it goes through may lead anywhere.
+	checked := candidateReal
+	if s.FollowSymlinks == SymlinkAllow {
+		checked = candidateAbs
+	}
+	rel, err := filepath.Rel(s.Root, checked)
 	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
 		return "", &SandboxError{
 			Code:    "permission_denied",
@@ -119,15 +263,261 @@ func (s *PathSandbox) Resolve(userPath string, access PathAccess) (string, error
 		}
 	}
 
+	// Delete and move need the path itself to exist. This is checked once the
+	// path is known to be inside the root, where FS can look at it.
+	if access == AccessDeleteFile || access == AccessMoveFile {
+		if _, err := s.FS.Lstat(candidateReal); err != nil {
+			return "", s.notFoundError(fmt.Sprintf("path not found: %s", userPath), candidateAbs)
+		}
+	}
+
+	// 6. Allow/deny rules
+	if err := s.checkRules(rel, userPath); err != nil {
+		return "", err
+	}
+
+	// 7. .agentignore, for every kind of access: an excluded path may not be
+	// read, listed, stat'ed, written, created, deleted, or moved onto. A
+	// directory holding excluded paths may not be moved or deleted either,
+	// since a move could carry them somewhere the rules no longer match.
+	if s.agentIgnore != nil {
+		isDir := access == AccessCreateDir
+		info, err := s.FS.Lstat(candidateReal)
+		if err == nil {
+			if access == AccessReadFile || access == AccessListDir || access == AccessWriteFile {
+				info, err = s.FS.Stat(candidateReal)
+			}
+			isDir = err == nil && info.IsDir()
+		}
+		if s.AgentIgnored(rel, isDir) {
+			return "", &SandboxError{
+				Code:    "permission_denied",
+				Message: fmt.Sprintf("path is excluded by %s: %s", agentIgnoreFile, userPath),
+			}
+		}
+		if isDir && (access == AccessMoveFile || access == AccessDeleteFile) && s.holdsAgentIgnored(candidateReal) {
+			return "", &SandboxError{
+				Code:    "permission_denied",
+				Message: fmt.Sprintf("directory holds paths excluded by %s: %s", agentIgnoreFile, userPath),
+			}
+		}
+	}
+
 	return candidateReal, nil
 }
 
-// suggestFiles returns up to 3 file/dir name suggestions from the parent directory.
+// holdsAgentIgnored reports whether anything under the directory dir, an
+// absolute path inside the root, is excluded by .agentignore.
+func (s *PathSandbox) holdsAgentIgnored(dir string) bool {
+	found := false
+	walkDir(s.FS, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Root, p)
+		if err == nil && s.AgentIgnored(rel, d.IsDir()) {
+			found = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// AgentIgnored reports whether the root-relative path is excluded by the
+// root's .agentignore file.
+func (s *PathSandbox) AgentIgnored(rel string, isDir bool) bool {
+	return s.agentIgnore != nil && s.agentIgnore.Match(rel, isDir)
+}
+
+// Excluded reports whether the root-relative path is kept from tools by
+// .agentignore or a deny rule. Walks skip excluded entries, and everything
+// beneath an excluded directory, so their names are never shown.
+func (s *PathSandbox) Excluded(rel string, isDir bool) bool {
+	return s.AgentIgnored(rel, isDir) || s.denied(rel)
+}
+
+// denied reports whether a deny rule matches the root-relative path.
+func (s *PathSandbox) denied(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range s.Deny {
+		if matchPathRule(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReserveWrite charges n bytes against the write quota before a write.
+// It fails with quota_exceeded, without charging, if the write would cross it.
+func (s *PathSandbox) ReserveWrite(n int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.WriteQuota > 0 && s.written+n > s.WriteQuota {
+		return &SandboxError{
+			Code:    "quota_exceeded",
+			Message: fmt.Sprintf("writing %d bytes would exceed the session write quota (%d of %d bytes used)", n, s.written, s.WriteQuota),
+		}
+	}
+	s.written += n
+	return nil
+}
+
+// ReleaseWrite refunds bytes reserved for a write that did not happen.
+func (s *PathSandbox) ReleaseWrite(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written -= n
+}
+
+// SetRules configures the allow and deny glob patterns. Patterns are matched
+// against slash-separated paths relative to the root and apply to everything
+// beneath a matching directory; "**" matches any number of path segments.
+func (s *PathSandbox) SetRules(allow, deny []string) error {
+	for _, pattern := range append(append([]string{}, allow...), deny...) {
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid path rule %q: %w", pattern, err)
+			}
+		}
+	}
+	s.Allow = allow
+	s.Deny = deny
+	return nil
+}
+
+// checkRules applies the deny and allow rules to a root-relative path.
+// The root itself is always accessible so it can be listed.
+func (s *PathSandbox) checkRules(rel, userPath string) error {
+	if rel == "." {
+		return nil
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, pattern := range s.Deny {
+		if matchPathRule(pattern, rel) {
+			return &SandboxError{
+				Code:    "permission_denied",
+				Message: fmt.Sprintf("path denied by rule %q: %s", pattern, userPath),
+			}
+		}
+	}
+
+	if len(s.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range s.Allow {
+		if matchPathRule(pattern, rel) {
+			return nil
+		}
+	}
+	return &SandboxError{
+		Code:    "permission_denied",
+		Message: fmt.Sprintf("path not matched by any allow rule: %s", userPath),
+		Suggestions: []string{
+			fmt.Sprintf("Allowed paths: %s", strings.Join(s.Allow, ", ")),
+		},
+	}
+}
+
+// matchPathRule reports whether pattern matches rel or any of its parent directories.
+func matchPathRule(pattern, rel string) bool {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	parts := strings.Split(rel, "/")
+	for i := 1; i <= len(parts); i++ {
+		if matchSegments(patternParts, parts[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// findSymlink returns the first existing component of path below the root
+// that is a symlink, relative to the root. The final component is only
+// checked when includeLast is set. Paths outside the root are left to the
+// root check.
+func (s *PathSandbox) findSymlink(path string, includeLast bool) (string, bool) {
+	rel, err := filepath.Rel(s.Root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	parts := strings.Split(rel, string(filepath.Separator))
+	if !includeLast {
+		parts = parts[:len(parts)-1]
+	}
+	current := s.Root
+	for i, part := range parts {
+		current = filepath.Join(current, part)
+		info, err := s.FS.Lstat(current)
+		if err != nil {
+			return "", false
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return filepath.Join(parts[:i+1]...), true
+		}
+	}
+	return "", false
+}
+
+// resolveExistingPrefix evaluates symlinks in the longest existing prefix of
+// path and appends the remaining, not-yet-existing components unchanged.
+func (s *PathSandbox) resolveExistingPrefix(path string) string {
+	existing := path
+	var missing []string
+	for {
+		real, err := s.evalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(append([]string{real}, missing...)...)
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return path
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = parent
+	}
+}
+
+// absolutePathError refuses an absolute path, suggesting its root-relative
+// form when it lies under the root.
+func (s *PathSandbox) absolutePathError(userPath, clean string) *SandboxError {
+	suggestions := []string{"Use a path relative to the project root (e.g., 'src/main.go')"}
+	if rel, err := filepath.Rel(s.Root, clean); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		suggestions = []string{fmt.Sprintf("Use the relative path '%s'", filepath.ToSlash(rel))}
+	}
+	return &SandboxError{
+		Code:        "invalid_argument",
+		Message:     fmt.Sprintf("absolute paths are not allowed: %s", userPath),
+		Suggestions: suggestions,
+	}
+}
+
+// notFoundError builds a not_found error for missing, suggesting similarly
+// named entries from its parent directory.
+func (s *PathSandbox) notFoundError(message, missing string) *SandboxError {
+	candidates := s.suggestFiles(missing)
+	return &SandboxError{
+		Code:        "not_found",
+		Message:     message,
+		Suggestions: formatSuggestions(candidates),
+		Candidates:  candidates,
+	}
+}
+
+// suggestFiles returns up to 3 similarly named files or dirs from the parent
+// directory. Only names the sandbox would let a tool reach are suggested:
+// nothing outside the root, excluded by .agentignore, or refused by the
+// allow and deny rules.
 func (s *PathSandbox) suggestFiles(path string) []string {
 	parentDir := filepath.Dir(path)
 	baseName := filepath.Base(path)
 
-	entries, err := filepath.Glob(filepath.Join(parentDir, "*"))
+	parentRel, err := filepath.Rel(s.Root, parentDir)
+	if err != nil || parentRel == ".." || strings.HasPrefix(parentRel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	entries, err := s.ReadDir(parentDir)
 	if err != nil {
 		return nil
 	}
@@ -135,11 +525,14 @@ func (s *PathSandbox) suggestFiles(path string) []string {
 	// Extract just the names
 	var names []string
 	for _, entry := range entries {
-		name := filepath.Base(entry)
-		names = append(names, name)
+		rel := filepath.Join(parentRel, entry.Name())
+		if s.AgentIgnored(rel, entry.IsDir()) || s.checkRules(rel, rel) != nil {
+			continue
+		}
+		names = append(names, entry.Name())
 	}
 
-	// Simple matching: prefix match, then substring, then none
+	// Simple matching: prefix match, then substring, then edit distance
 	var matches []string
 
 	// Case-insensitive prefix match
@@ -147,7 +540,7 @@ func (s *PathSandbox) suggestFiles(path string) []string {
 		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(baseName)) {
 			matches = append(matches, name)
 			if len(matches) >= 3 {
-				return formatSuggestions(matches)
+				return matches
 			}
 		}
 	}
@@ -166,16 +559,66 @@ func (s *PathSandbox) suggestFiles(path string) []string {
 			if !found {
 				matches = append(matches, name)
 				if len(matches) >= 3 {
-					return formatSuggestions(matches)
+					return matches
 				}
 			}
 		}
 	}
 
 	if len(matches) > 0 {
-		return formatSuggestions(matches)
+		return matches
 	}
-	return nil
+	return closestNames(names, baseName, 3)
+}
+
+// closestNames returns up to limit names within a small edit distance of
+// target, closest first, so typos like "maine.go" still find "main.go".
+func closestNames(names []string, target string, limit int) []string {
+	target = strings.ToLower(target)
+	maxDistance := max(2, len([]rune(target))/3)
+
+	type scored struct {
+		name     string
+		distance int
+	}
+	var nearby []scored
+	for _, name := range names {
+		if d := levenshtein(strings.ToLower(name), target); d <= maxDistance {
+			nearby = append(nearby, scored{name, d})
+		}
+	}
+	sort.SliceStable(nearby, func(i, j int) bool {
+		return nearby[i].distance < nearby[j].distance
+	})
+
+	var matches []string
+	for i := 0; i < len(nearby) && i < limit; i++ {
+		matches = append(matches, nearby[i].name)
+	}
+	return matches
+}
+
+// levenshtein returns the number of single-rune insertions, deletions, and
+// substitutions needed to turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
 }
 
 // formatSuggestions wraps suggestions in "Did you mean..." messages.
@@ -192,9 +635,10 @@ func formatSuggestions(names []string) []string {
 
 // SandboxError is a structured error for sandbox violations.
 type SandboxError struct {
-	Code        string   // not_found, invalid_argument, permission_denied, io_error
+	Code        string // not_found, invalid_argument, permission_denied, io_error
 	Message     string
 	Suggestions []string
+	Candidates  []string // Raw names behind "Did you mean" suggestions
 }
 
 func (e *SandboxError) Error() string {
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "project")
+	outside := filepath.Join(base, "outside")
+	writeTree(t, root, map[string]string{"src/main.go": "package main\n", "docs/readme.md": "docs\n"})
+	writeTree(t, outside, map[string]string{"secret.txt": "TOPSECRET"})
+	for link, target := range map[string]string{
+		"escape_file": filepath.Join(outside, "secret.txt"),
+		"escape_dir":  outside,
+		"inner_link":  filepath.Join(root, "src", "main.go"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+	outsideSecret := filepath.Join(outside, "secret.txt")
+	insideMain := filepath.Join(root, "src", "main.go")
+
+	tests := []struct {
+		name     string
+		path     string
+		access   PathAccess
+		policy   SymlinkPolicy
+		absolute bool   // AllowAbsolutePaths
+		wantCode string // SandboxError code; "" for success
+		want     string // Resolved path on success, relative to root
+	}{
+		// Plain paths
+		{"relative file", "src/main.go", AccessReadFile, SymlinkWithinRoot, false, "", "src/main.go"},
+		{"dot slash", "./src/main.go", AccessReadFile, SymlinkWithinRoot, false, "", "src/main.go"},
+		{"root itself", ".", AccessListDir, SymlinkWithinRoot, false, "", "."},
+		{"traversal that stays inside", "src/../docs/readme.md", AccessReadFile, SymlinkWithinRoot, false, "", "docs/readme.md"},
+
+		// Traversal
+		{"parent", "..", AccessListDir, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"traversal to a sibling", "../outside/secret.txt", AccessReadFile, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"traversal through a subdirectory", "src/../../outside/secret.txt", AccessReadFile, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"traversal to write", "../outside/new.txt", AccessWriteFile, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"traversal to create a directory", "../outside/newdir", AccessCreateDir, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"traversal to delete", "../outside/secret.txt", AccessDeleteFile, SymlinkWithinRoot, false, "permission_denied", ""},
+
+		// Absolute paths
+		{"absolute inside, not allowed", insideMain, AccessReadFile, SymlinkWithinRoot, false, "invalid_argument", ""},
+		{"absolute outside, not allowed", outsideSecret, AccessReadFile, SymlinkWithinRoot, false, "invalid_argument", ""},
+		{"absolute inside, allowed", insideMain, AccessReadFile, SymlinkWithinRoot, true, "", "src/main.go"},
+		{"absolute outside, allowed", outsideSecret, AccessReadFile, SymlinkWithinRoot, true, "permission_denied", ""},
+		{"absolute root, allowed", root, AccessListDir, SymlinkWithinRoot, true, "", "."},
+
+		// A link to a file outside the root, for each access mode. Stat,
+		// delete, and move act on the link itself, which is inside.
+		{"outside file link, read", "escape_file", AccessReadFile, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"outside file link, write", "escape_file", AccessWriteFile, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"outside file link, list", "escape_file", AccessListDir, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"outside file link, stat", "escape_file", AccessStat, SymlinkWithinRoot, false, "", "escape_file"},
+		{"outside file link, delete", "escape_file", AccessDeleteFile, SymlinkWithinRoot, false, "", "escape_file"},
+		{"outside file link, move", "escape_file", AccessMoveFile, SymlinkWithinRoot, false, "", "escape_file"},
+		{"outside file link, create directory", "escape_file", AccessCreateDir, SymlinkWithinRoot, false, "permission_denied", ""},
+
+		// A link to a directory outside the root, used as a parent
+		{"outside dir link, list", "escape_dir", AccessListDir, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"through outside dir link, read", "escape_dir/secret.txt", AccessReadFile, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"through outside dir link, write", "escape_dir/new.txt", AccessWriteFile, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"through outside dir link, stat", "escape_dir/secret.txt", AccessStat, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"through outside dir link, delete", "escape_dir/secret.txt", AccessDeleteFile, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"through outside dir link, move", "escape_dir/secret.txt", AccessMoveFile, SymlinkWithinRoot, false, "permission_denied", ""},
+		{"through outside dir link, create directory", "escape_dir/sub/dir", AccessCreateDir, SymlinkWithinRoot, false, "permission_denied", ""},
+
+		// Symlink policies
+		{"inside link, within-root", "inner_link", AccessReadFile, SymlinkWithinRoot, false, "", "src/main.go"},
+		{"inside link, deny", "inner_link", AccessReadFile, SymlinkDeny, false, "permission_denied", ""},
+		{"inside link, deny, stat", "inner_link", AccessStat, SymlinkDeny, false, "", "inner_link"},
+		{"through outside dir link, deny", "escape_dir/secret.txt", AccessReadFile, SymlinkDeny, false, "permission_denied", ""},
+		{"outside file link, allow", "escape_file", AccessReadFile, SymlinkAllow, false, "", "../outside/secret.txt"},
+		{"traversal, allow", "../outside/secret.txt", AccessReadFile, SymlinkAllow, false, "permission_denied", ""},
+
+		// Missing paths
+		{"missing file, read", "src/missing.go", AccessReadFile, SymlinkWithinRoot, false, "not_found", ""},
+		{"missing file, list", "missing", AccessListDir, SymlinkWithinRoot, false, "not_found", ""},
+		{"missing file, write", "src/missing.go", AccessWriteFile, SymlinkWithinRoot, false, "", "src/missing.go"},
+		{"missing parent, write", "nowhere/missing.go", AccessWriteFile, SymlinkWithinRoot, false, "not_found", ""},
+		{"missing file, stat", "src/missing.go", AccessStat, SymlinkWithinRoot, false, "", "src/missing.go"},
+		{"missing file, delete", "src/missing.go", AccessDeleteFile, SymlinkWithinRoot, false, "not_found", ""},
+		{"missing directories, create", "a/b/c", AccessCreateDir, SymlinkWithinRoot, false, "", "a/b/c"},
+
+		// Empty input
+		{"empty", "", AccessReadFile, SymlinkWithinRoot, false, "invalid_argument", ""},
+		{"whitespace", "  \t\n", AccessReadFile, SymlinkWithinRoot, false, "invalid_argument", ""},
+		{"whitespace, write", " ", AccessWriteFile, SymlinkWithinRoot, false, "invalid_argument", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sandbox, err := NewPathSandbox(root, WithSymlinkPolicy(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sandbox.AllowAbsolutePaths = tt.absolute
+
+			got, err := sandbox.Resolve(tt.path, tt.access)
+			if tt.wantCode != "" {
+				var sandboxErr *SandboxError
+				if !errors.As(err, &sandboxErr) {
+					t.Fatalf("Resolve(%q) = %q, %v; want a SandboxError %s", tt.path, got, err, tt.wantCode)
+				}
+				if sandboxErr.Code != tt.wantCode {
+					t.Errorf("Resolve(%q) error code = %s (%s), want %s", tt.path, sandboxErr.Code, sandboxErr.Message, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%q) failed: %v", tt.path, err)
+			}
+			if want := filepath.Join(sandbox.Root, filepath.FromSlash(tt.want)); got != want {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.path, got, want)
+			}
+		})
+	}
+}
+
+func TestAbsolutePathSuggestions(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	writeTree(t, root, map[string]string{"src/main.go": "package main\n"})
+	sandbox, err := NewPathSandbox(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+		want string // Expected suggestion
+	}{
+		{"under the root", filepath.Join(sandbox.Root, "src", "main.go"), "Use the relative path 'src/main.go'"},
+		{"missing under the root", filepath.Join(sandbox.Root, "new.go"), "Use the relative path 'new.go'"},
+		{"the root", sandbox.Root, "Use the relative path '.'"},
+		{"outside", filepath.Join(outside, "x.go"), "Use a path relative to the project root (e.g., 'src/main.go')"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sandbox.Resolve(tt.path, AccessReadFile)
+			var sandboxErr *SandboxError
+			if !errors.As(err, &sandboxErr) || sandboxErr.Code != "invalid_argument" {
+				t.Fatalf("Resolve(%s) = %v, want invalid_argument", tt.path, err)
+			}
+			if !slices.Contains(sandboxErr.Suggestions, tt.want) {
+				t.Errorf("suggestions = %q, want %q", sandboxErr.Suggestions, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolsHonorAbsolutePathSetting(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		t.Run(fmt.Sprintf("allowed %v", allow), func(t *testing.T) {
+			tc := newTestToolContext(t, map[string]string{"a.txt": "alpha"})
+			tc.Sandbox.AllowAbsolutePaths = allow
+			path := filepath.Join(tc.Sandbox.Root, "a.txt")
+
+			read := readFile(context.Background(), map[string]any{"path": path}, tc)
+			write := writeFile(context.Background(), map[string]any{"path": path, "content": "beta"}, tc)
+			if allow != read.OK || allow != write.OK {
+				t.Errorf("read_file = %s, write_file = %s; want success %v", resultJSON(t, read), resultJSON(t, write), allow)
+			}
+			if !allow && (read.Error.Code != "invalid_argument" || write.Error.Code != "invalid_argument") {
+				t.Errorf("error codes = %s, %s; want invalid_argument", read.Error.Code, write.Error.Code)
+			}
+			want := map[bool]string{false: "alpha", true: "beta"}[allow]
+			if got, _ := readTestFile(t, tc, "a.txt"); got != want {
+				t.Errorf("a.txt = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestResolveRules(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"src/main.go": "", "src/secret.key": "", "vendor/lib.go": "", "README.md": "", ".git/config": ""})
+	if err := os.Symlink(".git", filepath.Join(root, "gitdir")); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		allow    []string
+		deny     []string
+		path     string
+		wantCode string
+	}{
+		{"no rules", nil, nil, "src/secret.key", ""},
+		{"denied file", nil, []string{"**/*.key"}, "src/secret.key", "permission_denied"},
+		{"rules are anchored at the root", nil, []string{"*.key"}, "src/secret.key", ""},
+		{"denied directory", nil, []string{"vendor"}, "vendor/lib.go", "permission_denied"},
+		{"not denied", nil, []string{"*.key"}, "src/main.go", ""},
+		{"allowed", []string{"src"}, nil, "src/main.go", ""},
+		{"not allowed", []string{"src"}, nil, "README.md", "permission_denied"},
+		{"deny beats allow", []string{"src"}, []string{"src/*.key"}, "src/secret.key", "permission_denied"},
+		{"root stays listable", []string{"src"}, nil, ".", ""},
+		{".git blocked", nil, []string{".git", "secrets"}, ".git/config", "permission_denied"},
+		{"source allowed beside .git", nil, []string{".git", "secrets"}, "src/main.go", ""},
+		{"denied directory through a link", nil, []string{".git"}, "gitdir/config", "permission_denied"},
+		{"missing path in a denied directory", nil, []string{".git"}, ".git/new", "permission_denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sandbox, err := NewPathSandbox(root, func(s *PathSandbox) { s.Allow, s.Deny = tt.allow, tt.deny })
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = sandbox.Resolve(tt.path, AccessStat)
+			var sandboxErr *SandboxError
+			switch {
+			case tt.wantCode == "" && err != nil:
+				t.Errorf("Resolve(%q) failed: %v", tt.path, err)
+			case tt.wantCode != "" && (!errors.As(err, &sandboxErr) || sandboxErr.Code != tt.wantCode):
+				t.Errorf("Resolve(%q) error = %v, want %s", tt.path, err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestResolveRuleMessages(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{".git/config": "", "README.md": ""})
+	sandbox, err := NewPathSandbox(root, func(s *PathSandbox) { s.Allow, s.Deny = []string{"src", "docs"}, []string{".git"} })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path        string
+		wantMessage string
+		wantSuggest string
+	}{
+		{".git/config", `path denied by rule ".git": .git/config`, ""},
+		{"README.md", "path not matched by any allow rule: README.md", "Allowed paths: src, docs"},
+	}
+	for _, tt := range tests {
+		_, err := sandbox.Resolve(tt.path, AccessReadFile)
+		var sandboxErr *SandboxError
+		if !errors.As(err, &sandboxErr) {
+			t.Fatalf("Resolve(%q) = %v, want a SandboxError", tt.path, err)
+		}
+		if sandboxErr.Message != tt.wantMessage {
+			t.Errorf("Resolve(%q) message = %q, want %q", tt.path, sandboxErr.Message, tt.wantMessage)
+		}
+		if tt.wantSuggest != "" && !slices.Contains(sandboxErr.Suggestions, tt.wantSuggest) {
+			t.Errorf("Resolve(%q) suggestions = %q, want %q", tt.path, sandboxErr.Suggestions, tt.wantSuggest)
+		}
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	tests := []struct {
+		allow, deny []string
+		wantErr     bool
+	}{
+		{nil, nil, false},
+		{[]string{"src", "docs/**/*.md"}, []string{".git", "**/*.key"}, false},
+		{nil, []string{"[unclosed"}, true},
+		{[]string{"src/[a-"}, nil, true},
+	}
+	for _, tt := range tests {
+		sandbox, err := NewPathSandbox(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = sandbox.SetRules(tt.allow, tt.deny)
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("SetRules(%q, %q) error = %v, want error %v", tt.allow, tt.deny, err, tt.wantErr)
+		}
+		if err != nil && (sandbox.Allow != nil || sandbox.Deny != nil) {
+			t.Errorf("SetRules(%q, %q) kept invalid rules", tt.allow, tt.deny)
+		}
+	}
+}
+
+func TestMatchPathRule(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{".git", ".git", true},
+		{".git", ".git/config", true},
+		{".git", "src/.git/config", false},
+		{"**/.git", "src/.git/config", true},
+		{"*.key", "secret.key", true},
+		{"*.key", "src/secret.key", false},
+		{"**/*.key", "src/deep/secret.key", true},
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "src/sub/main.go", false},
+		{"src/**", "src/sub/main.go", true},
+		{"/src/", "src/main.go", true},
+		{"src", "srcs/main.go", false},
+	}
+	for _, tt := range tests {
+		if got := matchPathRule(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("matchPathRule(%q, %q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestReserveWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		quota    int64
+		writes   []int64 // Reserved in order
+		wantFail int     // Index of the first write refused; -1 for none
+	}{
+		{"unlimited", 0, []int64{1 << 30, 1 << 30}, -1},
+		{"under the quota", 10, []int64{3, 3, 3}, -1},
+		{"exactly the quota", 10, []int64{4, 6}, -1},
+		{"crossing the quota", 10, []int64{4, 4, 4}, 2},
+		{"single write over the quota", 10, []int64{11}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sandbox, err := NewPathSandbox(t.TempDir(), WithWriteQuota(tt.quota))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, n := range tt.writes {
+				err := sandbox.ReserveWrite(n)
+				if i != tt.wantFail {
+					if err != nil {
+						t.Fatalf("write %d of %d bytes failed: %v", i, n, err)
+					}
+					continue
+				}
+				var sandboxErr *SandboxError
+				if !errors.As(err, &sandboxErr) || sandboxErr.Code != "quota_exceeded" {
+					t.Fatalf("write %d of %d bytes = %v, want quota_exceeded", i, n, err)
+				}
+				// A refused write is not charged, so a smaller one still fits
+				if room := tt.quota - sandbox.written; room > 0 {
+					if err := sandbox.ReserveWrite(room); err != nil {
+						t.Errorf("reserving the remaining %d bytes failed: %v", room, err)
+					}
+				}
+				return
+			}
+		})
+	}
+}
+
+func TestReleaseWrite(t *testing.T) {
+	sandbox, err := NewPathSandbox(t.TempDir(), WithConfig(&Config{WriteQuota: 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sandbox.WriteQuota != 10 {
+		t.Fatalf("WriteQuota = %d, want 10 from the config", sandbox.WriteQuota)
+	}
+	if err := sandbox.ReserveWrite(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := sandbox.ReserveWrite(8); err == nil {
+		t.Fatal("second reservation fit a 10-byte quota")
+	}
+	sandbox.ReleaseWrite(8)
+	if err := sandbox.ReserveWrite(8); err != nil {
+		t.Errorf("reservation after a refund failed: %v", err)
+	}
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    SymlinkPolicy
+		wantErr bool
+	}{
+		{"within-root", SymlinkWithinRoot, false},
+		{"deny", SymlinkDeny, false},
+		{"allow", SymlinkAllow, false},
+		{"", 0, true},
+		{"Allow", 0, true},
+		{"follow", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSymlinkPolicy(tt.name)
+		if gotErr := err != nil; gotErr != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSymlinkPolicy(%q) = %v, %v; want %v, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSymlinkPolicies(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "project")
+	outside := filepath.Join(base, "outside")
+	writeTree(t, root, map[string]string{"src/main.go": "package main\n"})
+	writeTree(t, outside, map[string]string{"secret.txt": "TOPSECRET"})
+	for link, target := range map[string]string{
+		"in_file":    "src/main.go",
+		"in_dir":     "src",
+		"chain":      "in_file", // A link to a link that stays inside
+		"out_file":   filepath.Join(outside, "secret.txt"),
+		"out_dir":    outside,
+		"out_chain":  "out_file", // An inside link to a link that escapes
+		"src/up_dir": "..",       // A relative link that stays inside
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+
+	// Each path is read under every policy; "ok" means it resolves
+	tests := []struct {
+		path                    string
+		deny, withinRoot, allow string
+	}{
+		{"src/main.go", "ok", "ok", "ok"},
+		{"in_file", "permission_denied", "ok", "ok"},
+		{"in_dir/main.go", "permission_denied", "ok", "ok"},
+		{"chain", "permission_denied", "ok", "ok"},
+		{"src/up_dir/src/main.go", "permission_denied", "ok", "ok"},
+		{"out_file", "permission_denied", "permission_denied", "ok"},
+		{"out_dir/secret.txt", "permission_denied", "permission_denied", "ok"},
+		{"out_chain", "permission_denied", "permission_denied", "ok"},
+	}
+	policies := []struct {
+		name   string
+		policy SymlinkPolicy
+	}{
+		{"deny", SymlinkDeny},
+		{"within-root", SymlinkWithinRoot},
+		{"allow", SymlinkAllow},
+	}
+	for _, p := range policies {
+		sandbox, err := NewPathSandbox(root, WithSymlinkPolicy(p.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			want := map[SymlinkPolicy]string{SymlinkDeny: tt.deny, SymlinkWithinRoot: tt.withinRoot, SymlinkAllow: tt.allow}[p.policy]
+			_, err := sandbox.Resolve(tt.path, AccessReadFile)
+			got := "ok"
+			var sandboxErr *SandboxError
+			if errors.As(err, &sandboxErr) {
+				got = sandboxErr.Code
+			} else if err != nil {
+				got = err.Error()
+			}
+			if got != want {
+				t.Errorf("%s: Resolve(%q) = %s, want %s", p.name, tt.path, got, want)
+			}
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"main.go", "main.go", 0},
+		{"maine.go", "main.go", 1},
+		{"mian.go", "main.go", 2},
+		{"kitten", "sitting", 3},
+		{"héllo", "hello", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"main.go": "", "main_test.go": "", "README.md": "", "Makefile": "",
+		"config.yaml": "", "src/server.go": "", "src/handler.go": "",
+		"a1": "", "a2": "", "a3": "", "a4": "",
+	})
+	sandbox, err := NewPathSandbox(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want []string
+	}{
+		{"prefix", "mai", []string{"main.go", "main_test.go"}},
+		{"prefix ignores case", "readme", []string{"README.md"}},
+		{"substring", "test", []string{"main_test.go"}},
+		{"extra letter", "maine.go", []string{"main.go"}},
+		{"missing letter", "man.go", []string{"main.go"}},
+		{"swapped letters", "mian.go", []string{"main.go"}},
+		{"wrong letter", "confug.yaml", []string{"config.yaml"}},
+		{"typo ignores case", "makefil", []string{"Makefile"}},
+		{"in a subdirectory", "src/servr.go", []string{"server.go"}},
+		{"at most three", "a", []string{"a1", "a2", "a3"}},
+		{"nothing close", "zzzzzz.txt", nil},
+		{"missing directory", "nowhere/main.go", nil},
+	}
+	for _, tt := range tests {
+		got := sandbox.suggestFiles(filepath.Join(root, filepath.FromSlash(tt.path)))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: suggestFiles(%q) = %q, want %q", tt.name, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestionsOnlyNameReachablePaths(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "project")
+	writeTree(t, dir, map[string]string{"sibling.txt": ""})
+	writeTree(t, root, map[string]string{
+		".agentignore":         ".env\n",
+		".env":                 "SECRET=1",
+		".envrc":               "",
+		"secrets/prod-key.pem": "",
+		"cert.pem":             "",
+		"cert.txt":             "",
+		"src/main.go":          "",
+		"README.md":            "",
+	})
+
+	tests := []struct {
+		name        string
+		allow, deny []string
+		policy      SymlinkPolicy
+		path        string
+		want        []string
+	}{
+		{name: "nothing excluded", path: "cert", want: []string{"cert.pem", "cert.txt"}},
+		{name: "agentignored file", path: ".en", want: []string{".envrc"}},
+		{name: "outside the root", path: "../sibling", want: nil},
+		// Under allow, files are read with the operating system, not os.Root
+		{name: "outside the root following symlinks", policy: SymlinkAllow, path: "../sibling", want: nil},
+		{name: "inside a denied directory", deny: []string{"secrets"}, path: "secrets/prod", want: nil},
+		{name: "denied file", deny: []string{"*.pem"}, path: "cert", want: []string{"cert.txt"}},
+		{name: "denied directory", deny: []string{"src"}, path: "sr", want: nil},
+		{name: "allowed directory", allow: []string{"src"}, path: "sr", want: []string{"src"}},
+		{name: "not allowed", allow: []string{"src"}, path: "READ", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sandbox, err := NewPathSandbox(root, WithSymlinkPolicy(tt.policy), func(s *PathSandbox) {
+				s.Allow, s.Deny = tt.allow, tt.deny
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = sandbox.Resolve(tt.path, AccessReadFile)
+			var sandboxErr *SandboxError
+			if !errors.As(err, &sandboxErr) {
+				t.Fatalf("Resolve(%q) = %v, want a SandboxError", tt.path, err)
+			}
+			if !slices.Equal(sandboxErr.Candidates, tt.want) {
+				t.Errorf("Resolve(%q) candidates = %q, want %q", tt.path, sandboxErr.Candidates, tt.want)
+			}
+			if len(tt.want) == 0 && len(sandboxErr.Suggestions) > 0 {
+				t.Errorf("Resolve(%q) suggestions = %q, want none", tt.path, sandboxErr.Suggestions)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"google.golang.org/genai"
+)
+
+// sessionVersion is bumped whenever the session file format changes.
+const sessionVersion = 1
+
+// sessionFile is the on-disk format for a saved conversation.
+type sessionFile struct {
+	Version int              `json:"version"`
+	History []*genai.Content `json:"history"`
+}
+
+// SaveHistory writes the conversation history to path as JSON.
+func (a *Agent) SaveHistory(path string) error {
+	data, err := json.MarshalIndent(sessionFile{
+		Version: sessionVersion,
+		History: a.history,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	return nil
+}
+
+// LoadHistory replaces the conversation history with the contents of path.
+// A missing file is not an error; the agent simply starts fresh.
+func (a *Agent) LoadHistory(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read session: %w", err)
+	}
+
+	var session sessionFile
+	if err := json.Unmarshal(data, &session); err != nil {
+		return fmt.Errorf("failed to decode session: %w", err)
+	}
+	if session.Version != sessionVersion {
+		return fmt.Errorf("unsupported session version %d (expected %d)", session.Version, sessionVersion)
+	}
+
+	a.history = session.History
+	if a.history == nil {
+		a.history = []*genai.Content{}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// ToolStats accumulates per-tool call counts and latency.
+// It is safe for concurrent use, since read-only tools run in parallel.
+type ToolStats struct {
+	mu    sync.Mutex
+	tools map[string]*toolTiming
+}
+
+// toolTiming holds the totals for a single tool name.
+type toolTiming struct {
+	calls int
+	total time.Duration
+}
+
+// NewToolStats creates an empty ToolStats.
+func NewToolStats() *ToolStats {
+	return &ToolStats{tools: make(map[string]*toolTiming)}
+}
+
+// Record adds one call of the named tool that took d.
+func (s *ToolStats) Record(name string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tools[name]
+	if !ok {
+		t = &toolTiming{}
+		s.tools[name] = t
+	}
+	t.calls++
+	t.total += d
+}
+
+// WriteTable writes a table of calls, average, and total duration per tool,
+// slowest total first.
+func (s *ToolStats) WriteTable(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.tools) == 0 {
+		fmt.Fprintln(w, "No tools were called.")
+		return
+	}
+
+	names := make([]string, 0, len(s.tools))
+	for name := range s.tools {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(cmp.Compare(s.tools[b].total, s.tools[a].total), strings.Compare(a, b))
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOOL\tCALLS\tAVG\tTOTAL")
+	for _, name := range names {
+		t := s.tools[name]
+		avg := t.total / time.Duration(t.calls)
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\n", name, t.calls, avg.Round(time.Microsecond), t.total.Round(time.Microsecond))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the number of columns of the terminal f is connected
+// to, falling back to $COLUMNS. It returns 0 when the width is unknown.
+func terminalWidth(f *os.File) int {
+	if cols := ttyColumns(f); cols > 0 {
+		return cols
+	}
+	cols, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || cols < 0 {
+		return 0
+	}
+	return cols
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// ttyColumns is unsupported on this platform; terminalWidth falls back to $COLUMNS.
+func ttyColumns(f *os.File) int {
+	return 0
+}
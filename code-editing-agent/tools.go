@@ -1,121 +1,630 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
 	"google.golang.org/genai"
 )
 
-// getTools returns the tool definitions for the agent.
-func getTools() []*genai.Tool {
-	return []*genai.Tool{
-		{
-			FunctionDeclarations: []*genai.FunctionDeclaration{
-				{
-					Name:        "read_file",
-					Description: "Read the contents of a file. Workspace-relative path under the project root.",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"path": {
-								Type:        genai.TypeString,
-								Description: "Workspace-relative path under the project root.",
-							},
+// defaultAllowedCommands is the run_command allowlist used when none is configured.
+var defaultAllowedCommands = []string{"go", "git", "ls", "gofmt", "goimports"}
+
+// defaultCommandTimeout bounds how long run_command waits for a process.
+const defaultCommandTimeout = 30 * time.Second
+
+// defaultMaxReadBytes is the largest file read_file returns by default (1 MiB).
+const defaultMaxReadBytes = 1 << 20
+
+// defaultHTTPTimeout bounds outbound HTTP requests made by tools.
+const defaultHTTPTimeout = 10 * time.Second
+
+// TokenCounter counts how many tokens text costs for the session's model.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, text string) (int, error)
+}
+
+// ToolContext carries the state and settings tool handlers need.
+type ToolContext struct {
+	Sandbox         *PathSandbox
+	Logger          *slog.Logger      // Debug and trace output
+	AllowedCommands []string          // Commands run_command may execute
+	CommandTimeout  time.Duration     // Per-command timeout for run_command
+	HTTPClient      *http.Client      // Client for network tools such as get_weather; blocks non-public addresses
+	MaxReadBytes    int64             // Largest file read_file will return
+	Formatters      map[string]string // Per-extension format_code commands overriding the defaults
+	DryRun          bool              // Report what write tools would do without changing files
+	Journal         *WriteJournal     // Recent file operations for undo_last_edit
+	TokenCounter    TokenCounter      // Backs count_tokens; nil disables it
+}
+
+// NewToolContext creates a ToolContext with default settings.
+func NewToolContext(sandbox *PathSandbox, logger *slog.Logger) *ToolContext {
+	return &ToolContext{
+		Sandbox:         sandbox,
+		Logger:          logger,
+		AllowedCommands: defaultAllowedCommands,
+		CommandTimeout:  defaultCommandTimeout,
+		HTTPClient:      newGuardedHTTPClient(defaultHTTPTimeout),
+		MaxReadBytes:    defaultMaxReadBytes,
+		Journal:         NewWriteJournal(defaultJournalSize),
+	}
+}
+
+// builtinTools returns the tools every agent starts with.
+func builtinTools() []Tool {
+	return []Tool{
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "read_file",
+				Description: "Read the contents of a file. Workspace-relative path under the project root. Use start_line and line_count to read part of a large file.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Workspace-relative path under the project root.",
+						},
+						"start_line": {
+							Type:        genai.TypeInteger,
+							Description: "Optional 1-based line to start reading from.",
+						},
+						"line_count": {
+							Type:        genai.TypeInteger,
+							Description: "Optional maximum number of lines to return.",
+						},
+						"force": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to return a binary file's full contents base64-encoded.",
 						},
-						Required: []string{"path"},
 					},
+					Required: []string{"path"},
 				},
-				{
-					Name:        "write_file",
-					Description: "Write content to a file. Workspace-relative path under the project root.",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"path": {
-								Type:        genai.TypeString,
-								Description: "Workspace-relative path under the project root.",
-							},
-							"content": {
-								Type:        genai.TypeString,
-								Description: "Content to write to the file.",
-							},
+			},
+			Run:      readFile,
+			ReadOnly: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "write_file",
+				Description: "Write content to a file. Workspace-relative path under the project root.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Workspace-relative path under the project root.",
+						},
+						"content": {
+							Type:        genai.TypeString,
+							Description: "Content to write to the file.",
+						},
+						"create_dirs": {
+							Type:        genai.TypeBoolean,
+							Description: "Create missing parent directories (default true).",
+						},
+						"line_ending": lineEndingSchema,
+					},
+					Required: []string{"path", "content"},
+				},
+			},
+			Run:    writeFile,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "append_to_file",
+				Description: "Append content to the end of a file, creating it if it does not exist. Cheaper than write_file for logs and other files that only grow.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Workspace-relative path under the project root.",
+						},
+						"content": {
+							Type:        genai.TypeString,
+							Description: "Content to add to the end of the file. Include a trailing newline if the file is line-based.",
+						},
+					},
+					Required: []string{"path", "content"},
+				},
+			},
+			Run:    appendToFile,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "edit_file",
+				Description: "Edit a file by replacing exactly one occurrence of old_str with new_str. If old_str is empty and the file does not exist, it is created with new_str as its content.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Workspace-relative path under the project root.",
+						},
+						"old_str": {
+							Type:        genai.TypeString,
+							Description: "Text to search for. Must match exactly once in the file. Leave empty to create a new file.",
+						},
+						"new_str": {
+							Type:        genai.TypeString,
+							Description: "Text to replace old_str with.",
+						},
+						"line_ending": lineEndingSchema,
+					},
+					Required: []string{"path", "old_str", "new_str"},
+				},
+			},
+			Run:    editFile,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "apply_patch",
+				Description: "Apply a unified diff (as produced by diff -u or git diff) to one or more files. Either every hunk applies cleanly or nothing is changed. Use /dev/null as the old path to create a file.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"patch": {
+							Type:        genai.TypeString,
+							Description: "Unified diff text with ---/+++ file headers and @@ hunks. Paths are workspace-relative under the project root.",
+						},
+					},
+					Required: []string{"patch"},
+				},
+			},
+			Run:    applyPatch,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "replace_in_files",
+				Description: "Replace every match of a regular expression in all files under a directory, e.g. for a project-wide rename. Files excluded by .gitignore are skipped. Returns how many replacements were made in each file and a diff.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"pattern": {
+							Type:        genai.TypeString,
+							Description: "Regular expression (Go RE2 syntax) to replace.",
+						},
+						"replacement": {
+							Type:        genai.TypeString,
+							Description: "Replacement text; $1 or ${name} expand to capture groups.",
+						},
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Directory under the project root to change (default '.').",
+						},
+						"glob": {
+							Type:        genai.TypeString,
+							Description: "Optional file name filter, e.g. '*.go'.",
+						},
+						"dry_run": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to return the diff without changing any file.",
+						},
+					},
+					Required: []string{"pattern", "replacement"},
+				},
+			},
+			Run:    replaceInFiles,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "delete_file",
+				Description: "Delete a file by moving it into the .agent-trash/ directory under the project root. Directories require recursive=true.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Workspace-relative path under the project root.",
+						},
+						"recursive": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to delete a directory and its contents.",
+						},
+					},
+					Required: []string{"path"},
+				},
+			},
+			Run:    deleteFile,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "move_file",
+				Description: "Move or rename a file. Both source and destination are workspace-relative paths under the project root.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"source": {
+							Type:        genai.TypeString,
+							Description: "Existing path under the project root.",
+						},
+						"destination": {
+							Type:        genai.TypeString,
+							Description: "New path under the project root.",
+						},
+						"overwrite": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to replace an existing destination.",
+						},
+					},
+					Required: []string{"source", "destination"},
+				},
+			},
+			Run:    moveFile,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "make_directory",
+				Description: "Create a directory. Workspace-relative path under the project root.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Workspace-relative path under the project root.",
+						},
+						"parents": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to create missing parent directories and succeed if the directory already exists.",
+						},
+					},
+					Required: []string{"path"},
+				},
+			},
+			Run:    makeDirectory,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "undo_last_edit",
+				Description: "Revert the most recent write_file, edit_file, append_to_file, apply_patch, delete_file, or move_file operation.",
+				Parameters: &genai.Schema{
+					Type:       genai.TypeObject,
+					Properties: map[string]*genai.Schema{},
+				},
+			},
+			Run:    undoLastEdit,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "stat_file",
+				Description: "Get metadata for a path: whether it exists, is a directory or symlink, size, mode, and modification time.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Workspace-relative path under the project root.",
+						},
+					},
+					Required: []string{"path"},
+				},
+			},
+			Run:      statFile,
+			ReadOnly: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "list_files",
+				Description: "List files in a directory. Use '.' for the project root.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Directory under the project root (use '.' for root).",
+						},
+						"include_ignored": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to include entries excluded by .gitignore.",
+						},
+						"recursive": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to list subdirectories recursively.",
+						},
+						"max_depth": {
+							Type:        genai.TypeInteger,
+							Description: "With recursive, the maximum depth to descend (1 lists only the directory itself).",
+						},
+						"details": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to return objects with name, is_dir, size, and mod_time instead of plain names.",
+						},
+						"pattern": {
+							Type:        genai.TypeString,
+							Description: "Glob matched against entry names, e.g. '*.go' or 'test_*'. Only matching entries are listed.",
+						},
+						"page": {
+							Type:        genai.TypeInteger,
+							Description: "Page of the sorted listing to return, starting at 1 (default 1). Request the next page while has_more is true.",
+						},
+						"page_size": {
+							Type:        genai.TypeInteger,
+							Description: "Entries per page (default 500, at most 1000).",
+						},
+					},
+					Required: []string{"path"},
+				},
+			},
+			Run:      listFiles,
+			ReadOnly: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "tree",
+				Description: "Show the directory hierarchy under a path as a nested tree, for getting oriented in a project. Respects .gitignore unless include_ignored is set.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Directory under the project root (default '.').",
+						},
+						"max_depth": {
+							Type:        genai.TypeInteger,
+							Description: "Levels to descend (default 3); deeper directories are marked \"more\": true.",
+						},
+						"max_nodes": {
+							Type:        genai.TypeInteger,
+							Description: "Maximum entries to return (default and upper limit 1000); the result is marked truncated when reached.",
+						},
+						"include_ignored": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to include entries excluded by .gitignore.",
+						},
+					},
+				},
+			},
+			Run:      treeFiles,
+			ReadOnly: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "project_overview",
+				Description: "Summarize the project for orientation in one call: a shallow directory tree, build files found at the root and the ecosystems they indicate, file and line counts per extension with the primary language, and the first lines of the README.",
+				Parameters: &genai.Schema{
+					Type:       genai.TypeObject,
+					Properties: map[string]*genai.Schema{},
+				},
+			},
+			Run:      projectOverview,
+			ReadOnly: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "search_files",
+				Description: "Search file contents under a directory for lines matching a regular expression. Binary files are skipped.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"pattern": {
+							Type:        genai.TypeString,
+							Description: "Regular expression (Go RE2 syntax) to match against each line.",
+						},
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Directory under the project root to search (default '.').",
+						},
+						"glob": {
+							Type:        genai.TypeString,
+							Description: "Optional file name filter, e.g. '*.go'.",
+						},
+						"include_ignored": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to also search files excluded by .gitignore.",
 						},
-						Required: []string{"path", "content"},
 					},
+					Required: []string{"pattern"},
 				},
-				{
-					Name:        "list_files",
-					Description: "List files in a directory. Use '.' for the project root.",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"path": {
-								Type:        genai.TypeString,
-								Description: "Directory under the project root (use '.' for root).",
+			},
+			Run:      searchFiles,
+			ReadOnly: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "run_command",
+				Description: "Run an allowlisted command (e.g. go, git, ls) in the project root and return stdout, stderr, and exit code.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"command": {
+							Type:        genai.TypeString,
+							Description: "Command name, e.g. 'go'. Must be on the allowlist.",
+						},
+						"args": {
+							Type:        genai.TypeArray,
+							Description: "Arguments passed to the command.",
+							Items: &genai.Schema{
+								Type: genai.TypeString,
 							},
 						},
-						Required: []string{"path"},
 					},
+					Required: []string{"command"},
+				},
+			},
+			Run: runCommand,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "count_tokens",
+				Description: "Count how many tokens a file or piece of text would use in the conversation. Use it before reading a large file to judge whether it is worth the context.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"text": {
+							Type:        genai.TypeString,
+							Description: "Text to count. Provide either text or path.",
+						},
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Workspace-relative path of a file to count. Provide either text or path.",
+						},
+					},
+				},
+			},
+			Run:      countTokens,
+			ReadOnly: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "get_weather",
+				Description: "Get the current weather for a given location (e.g., 'Houston' or 'Houston, TX').",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"location": {
+							Type:        genai.TypeString,
+							Description: "The city name, optionally followed by state or country.",
+						},
+					},
+					Required: []string{"location"},
 				},
-				{
-					Name:        "get_weather",
-					Description: "Get the current weather for a given location (e.g., '[REDACTED]' or 'Houston, TX').",
-					Parameters: &genai.Schema{
-						Type: genai.TypeObject,
-						Properties: map[string]*genai.Schema{
-							"location": {
-								Type:        genai.TypeString,
-								Description: "The city and state, or zip code.",
+			},
+			Run:      getWeather,
+			ReadOnly: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "git_diff",
+				Description: "Show uncommitted changes under the project root as a git patch. Use it to review your own edits. Files the sandbox hides (denied or excluded by .agentignore) are left out and counted in withheld_files.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"staged": {
+							Type:        genai.TypeBoolean,
+							Description: "Set to true to show staged changes instead of unstaged ones.",
+						},
+					},
+				},
+			},
+			Run:      gitDiff,
+			ReadOnly: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "git_commit",
+				Description: "Stage changes under the project root and commit them to git, returning the new commit hash. Use it to checkpoint finished work. Files the sandbox hides (denied or excluded by .agentignore) are never staged and are counted in withheld_files.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"message": {
+							Type:        genai.TypeString,
+							Description: "Commit message.",
+						},
+						"paths": {
+							Type:        genai.TypeArray,
+							Description: "Workspace-relative paths to stage. Omit to stage every change under the project root.",
+							Items: &genai.Schema{
+								Type: genai.TypeString,
 							},
 						},
-						Required: []string{"location"},
 					},
+					Required: []string{"message"},
 				},
 			},
+			Run:    gitCommit,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "format_code",
+				Description: "Format source files in place with the formatter configured for their extension (gofmt or goimports for Go, black for Python, prettier for JavaScript and TypeScript, and so on). Files with no formatter are left alone. Reports which files changed and a diff.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "File or directory under the project root. A directory is formatted recursively.",
+						},
+					},
+					Required: []string{"path"},
+				},
+			},
+			Run:    formatCode,
+			DryRun: true,
+		},
+		&FuncTool{
+			Decl: &genai.FunctionDeclaration{
+				Name:        "run_tests",
+				Description: "Run Go tests with 'go test' and return a summary: counts of passed, failed, and skipped tests, each failure with its output, and any build errors.",
+				Parameters: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"path": {
+							Type:        genai.TypeString,
+							Description: "Package directory under the project root, optionally ending in '/...' to include subpackages (default './...').",
+						},
+						"run": {
+							Type:        genai.TypeString,
+							Description: "Optional regular expression selecting which tests to run, as for 'go test -run'.",
+						},
+					},
+				},
+			},
+			Run: runTests,
 		},
 	}
 }
 
-// executeTool executes a function call and returns a ToolResult.
-func executeTool(fc *genai.FunctionCall, sandbox *PathSandbox, debugMode bool) *ToolResult {
-	if debugMode {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Tool call: %s with args: %v\n", fc.Name, fc.Args)
+// readFile reads and returns file contents.
+func readFile(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getStringArg(args, "path")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
 	}
 
-	var result *ToolResult
-
-	switch fc.Name {
-	case "read_file":
-		result = readFile(fc, sandbox)
-	case "write_file":
-		result = writeFile(fc, sandbox)
-	case "list_files":
-		result = listFiles(fc, sandbox)
-	case "get_weather":
-		result = getWeather(fc, sandbox)
-	default:
-		result = NewErrorResult("invalid_argument", fmt.Sprintf("unknown tool: %s", fc.Name), nil)
+	startLine, hasStart, err := getOptionalIntArg(args, "start_line")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
 	}
-
-	if debugMode {
-		fmt.Fprintf(os.Stderr, "[DEBUG] Tool response: %v\n", result.AsMap())
+	lineCount, hasCount, err := getOptionalIntArg(args, "line_count")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if hasStart && startLine < 1 {
+		return NewErrorResult("invalid_argument", "start_line must be at least 1", nil)
+	}
+	if hasCount && lineCount < 1 {
+		return NewErrorResult("invalid_argument", "line_count must be at least 1", nil)
 	}
 
-	return result
-}
-
-// readFile reads and returns file contents.
-func readFile(fc *genai.FunctionCall, sandbox *PathSandbox) *ToolResult {
-	path, err := getStringArg(fc, "path")
+	force, err := getOptionalBoolArg(args, "force", false)
 	if err != nil {
 		return NewErrorResult("invalid_argument", err.Error(), nil)
 	}
 
-	resolvedPath, err := sandbox.Resolve(path, AccessReadFile)
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessReadFile)
 	if sandboxErr, ok := err.(*SandboxError); ok {
 		return NewErrorResultFromSandbox(sandboxErr)
 	}
@@ -123,29 +632,165 @@ func readFile(fc *genai.FunctionCall, sandbox *PathSandbox) *ToolResult {
 		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
 	}
 
-	content, err := os.ReadFile(resolvedPath)
+	f, err := tc.Sandbox.FS.Open(resolvedPath)
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to read file: %v", err), nil)
+	}
+	defer f.Close()
+
+	// Sniff the start of the file to keep binary data out of the context
+	head := make([]byte, binarySniffBytes)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to read file: %v", err), nil)
+	}
+	head = head[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to read file: %v", err), nil)
+	}
+	binary := looksBinary(head)
+	if binary && !force {
+		info, err := f.Stat()
+		if err != nil {
+			return NewErrorResult("io_error", fmt.Sprintf("failed to stat file: %v", err), nil)
+		}
+		return NewErrorResult("binary_file", fmt.Sprintf("%s appears to be binary (%d bytes, %s)", path, info.Size(), http.DetectContentType(head)), []string{
+			"Set force=true to return the contents base64-encoded",
+		})
+	}
+
+	if (hasStart || hasCount) && !binary {
+		if !hasStart {
+			startLine = 1
+		}
+		return readFileLines(f, path, startLine, lineCount, tc.MaxReadBytes)
+	}
+
+	// Stat first so oversized files are rejected without reading them
+	info, err := f.Stat()
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to stat file: %v", err), nil)
+	}
+	if info.Size() > tc.MaxReadBytes {
+		return tooLargeResult(path, info.Size(), tc.MaxReadBytes)
+	}
+
+	// Limit the read in case the file grew after the stat
+	content, err := io.ReadAll(io.LimitReader(f, tc.MaxReadBytes+1))
 	if err != nil {
 		return NewErrorResult("io_error", fmt.Sprintf("failed to read file: %v", err), nil)
 	}
+	if int64(len(content)) > tc.MaxReadBytes {
+		return tooLargeResult(path, int64(len(content)), tc.MaxReadBytes)
+	}
+
+	if binary {
+		return NewSuccessResult(map[string]any{
+			"content":   base64.StdEncoding.EncodeToString(content),
+			"encoding":  "base64",
+			"mime_type": http.DetectContentType(head),
+		})
+	}
 
 	return NewSuccessResult(map[string]any{
 		"content": string(content),
 	})
 }
 
+// binarySniffBytes is how much of a file is inspected for binary content.
+const binarySniffBytes = 8 << 10
+
+// looksBinary reports whether data contains a NUL byte or invalid UTF-8.
+// A multi-byte rune cut off at the end of the sample is not counted as invalid.
+func looksBinary(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+	// Forgive only a multibyte rune cut short by the end of the window, not
+	// stray invalid bytes
+	for i := len(data) - 1; i >= 0 && i >= len(data)-(utf8.UTFMax-1); i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				data = data[:i]
+			}
+			break
+		}
+	}
+	return !utf8.Valid(data)
+}
+
+// readFileLines returns up to lineCount lines starting at startLine (1-based),
+// or all remaining lines when lineCount is 0. Out-of-range starts yield no lines.
+// The file is streamed so ranged reads work on files larger than the read limit.
+func readFileLines(f io.Reader, path string, startLine, lineCount int, limit int64) *ToolResult {
+	reader := bufio.NewReader(f)
+	var content strings.Builder
+	totalLines := 0
+
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			totalLines++
+			inRange := totalLines >= startLine && (lineCount == 0 || totalLines < startLine+lineCount)
+			if inRange {
+				content.WriteString(line)
+				if int64(content.Len()) > limit {
+					return tooLargeResult(path, int64(content.Len()), limit)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return NewErrorResult("io_error", fmt.Sprintf("failed to read file: %v", err), nil)
+		}
+	}
+
+	return NewSuccessResult(map[string]any{
+		"content":     content.String(),
+		"start_line":  startLine,
+		"total_lines": totalLines,
+	})
+}
+
+// tooLargeResult reports a file that exceeds the read_file size limit.
+func tooLargeResult(path string, size, limit int64) *ToolResult {
+	return NewErrorResult("too_large", fmt.Sprintf("%s is %d bytes, which exceeds the %d byte read limit", path, size, limit), []string{
+		"Pass start_line and line_count to read part of the file",
+		"Use search_files to find the relevant lines instead of reading the whole file",
+	})
+}
+
 // writeFile writes content to a file.
-func writeFile(fc *genai.FunctionCall, sandbox *PathSandbox) *ToolResult {
-	path, err := getStringArg(fc, "path")
+func writeFile(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getStringArg(args, "path")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	content, err := getStringArg(args, "content")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	createDirs, err := getOptionalBoolArg(args, "create_dirs", true)
 	if err != nil {
 		return NewErrorResult("invalid_argument", err.Error(), nil)
 	}
 
-	content, err := getStringArg(fc, "content")
+	lineEnding, err := getLineEndingArg(args)
 	if err != nil {
 		return NewErrorResult("invalid_argument", err.Error(), nil)
 	}
 
-	resolvedPath, err := sandbox.Resolve(path, AccessWriteFile)
+	// AccessCreateDir tolerates missing parents but still checks that the
+	// deepest existing ancestor resolves inside the root.
+	access := AccessWriteFile
+	if createDirs {
+		access = AccessCreateDir
+	}
+	resolvedPath, err := tc.Sandbox.Resolve(path, access)
 	if sandboxErr, ok := err.(*SandboxError); ok {
 		return NewErrorResultFromSandbox(sandboxErr)
 	}
@@ -153,24 +798,45 @@ func writeFile(fc *genai.FunctionCall, sandbox *PathSandbox) *ToolResult {
 		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
 	}
 
-	err = os.WriteFile(resolvedPath, []byte(content), 0644)
+	// Previous contents (empty for a new file) are kept for the diff and journal
+	previous, readErr := tc.Sandbox.FS.ReadFile(resolvedPath)
+	content = convertLineEndings(content, resolveLineEnding(lineEnding, string(previous), readErr == nil))
+
+	if tc.DryRun {
+		return simulatedResult(map[string]any{
+			"message": fmt.Sprintf("would write %d bytes to %s", len(content), path),
+			"diff":    truncatedDiff(path, string(previous), content),
+		})
+	}
+
+	err = writeWithQuota(tc.Sandbox, resolvedPath, []byte(content), createDirs)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
 	if err != nil {
 		return NewErrorResult("io_error", fmt.Sprintf("failed to write file: %v", err), nil)
 	}
+	tc.Journal.record(journalEntry{Op: "write", Path: resolvedPath, Display: path, Existed: readErr == nil, Previous: previous})
 
 	return NewSuccessResult(map[string]any{
 		"message": fmt.Sprintf("wrote %d bytes to %s", len(content), path),
+		"diff":    truncatedDiff(path, string(previous), content),
 	})
 }
 
-// listFiles lists the contents of a directory.
-func listFiles(fc *genai.FunctionCall, sandbox *PathSandbox) *ToolResult {
-	path, err := getStringArg(fc, "path")
+// appendToFile appends content to a file without reading or rewriting it.
+func appendToFile(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getStringArg(args, "path")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	content, err := getStringArg(args, "content")
 	if err != nil {
 		return NewErrorResult("invalid_argument", err.Error(), nil)
 	}
 
-	resolvedPath, err := sandbox.Resolve(path, AccessListDir)
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessWriteFile)
 	if sandboxErr, ok := err.(*SandboxError); ok {
 		return NewErrorResultFromSandbox(sandboxErr)
 	}
@@ -178,69 +844,1578 @@ func listFiles(fc *genai.FunctionCall, sandbox *PathSandbox) *ToolResult {
 		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
 	}
 
-	entries, err := os.ReadDir(resolvedPath)
-	if err != nil {
-		return NewErrorResult("io_error", fmt.Sprintf("failed to list directory: %v", err), nil)
+	// Only the size and the appended data are kept for the journal; undo
+	// checks the data is still at the end and truncates back to the size
+	var previousSize int64
+	info, statErr := tc.Sandbox.FS.Stat(resolvedPath)
+	if statErr == nil {
+		if info.IsDir() {
+			return NewErrorResult("invalid_argument", fmt.Sprintf("%s is a directory", path), nil)
+		}
+		previousSize = info.Size()
 	}
 
-	var files []string
-	for _, entry := range entries {
-		name := entry.Name()
-		if entry.IsDir() {
-			name += "/"
-		}
-		files = append(files, name)
+	if tc.DryRun {
+		return simulatedResult(map[string]any{
+			"message": fmt.Sprintf("would append %d bytes to %s", len(content), path),
+			"size":    previousSize + int64(len(content)),
+		})
 	}
 
-	// Sort for consistent output
-	sort.Strings(files)
+	if err := tc.Sandbox.ReserveWrite(int64(len(content))); err != nil {
+		if sandboxErr, ok := err.(*SandboxError); ok {
+			return NewErrorResultFromSandbox(sandboxErr)
+		}
+		return NewErrorResult("io_error", fmt.Sprintf("failed to append to file: %v", err), nil)
+	}
+	size, err := tc.Sandbox.FS.AppendFile(resolvedPath, []byte(content), 0644)
+	if err != nil {
+		tc.Sandbox.ReleaseWrite(int64(len(content)))
+		return NewErrorResult("io_error", fmt.Sprintf("failed to append to file: %v", err), nil)
+	}
+	tc.Journal.record(journalEntry{Op: "append", Path: resolvedPath, Display: path, Existed: statErr == nil, Size: previousSize, Appended: []byte(content)})
 
 	return NewSuccessResult(map[string]any{
-		"files": files,
+		"message": fmt.Sprintf("appended %d bytes to %s", len(content), path),
+		"size":    size,
 	})
 }
 
-// getWeather fetches the weather for a location.
-func getWeather(fc *genai.FunctionCall, sandbox *PathSandbox) *ToolResult {
-	location, err := getStringArg(fc, "location")
+// writeWithQuota charges data against the sandbox write quota and writes it,
+// optionally creating parent directories first. The charge is refunded if the
+// write fails; a quota failure leaves the filesystem untouched.
+func writeWithQuota(sandbox *PathSandbox, resolvedPath string, data []byte, createDirs bool) error {
+	if err := sandbox.ReserveWrite(int64(len(data))); err != nil {
+		return err
+	}
+
+	var err error
+	if createDirs {
+		err = sandbox.FS.MkdirAll(filepath.Dir(resolvedPath), 0755)
+	}
+	if err == nil {
+		err = sandbox.FS.WriteFile(resolvedPath, data, 0644)
+	}
 	if err != nil {
-		return NewErrorResult("invalid_argument", err.Error(), nil)
+		sandbox.ReleaseWrite(int64(len(data)))
 	}
+	return err
+}
 
-	// This is a simplified implementation using Open-Meteo.
-	// In a real-world scenario, you would first geocode the location to lat/long.
-	// For this tool, we'll use a hardcoded lookup for common zip codes or just use a default for demo.
-	
-	lat, lon := "[REDACTED]", "[REDACTED]" // Coordinates for [REDACTED], TX ([REDACTED])
-	if location != "[REDACTED]" {
-		// In a real tool, we would call a geocoding API here.
-		// For now, let's just use these coordinates as a placeholder if not [REDACTED].
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so a failed or interrupted write never leaves path
+// truncated. An existing file keeps its permissions; new files get perm.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	if info, statErr := os.Stat(path); statErr == nil {
+		perm = info.Mode().Perm()
 	}
 
-	url := fmt.Sprintf("https://api.open-meteo.com/v1/forecast?latitude=%s&longitude=%s&current_weather=true", lat, lon)
-	resp, err := http.Get(url)
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
-		return NewErrorResult("network_error", fmt.Sprintf("failed to fetch weather: %v", err), nil)
+		return err
 	}
-	defer resp.Body.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
 
-	var data map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return NewErrorResult("parse_error", fmt.Sprintf("failed to parse weather response: %v", err), nil)
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
 	}
-
-	return NewSuccessResult(data)
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
-// getStringArg retrieves a string argument from a function call.
-func getStringArg(fc *genai.FunctionCall, key string) (string, error) {
-	raw, ok := fc.Args[key]
-	if !ok {
-		return "", fmt.Errorf("missing argument: %s", key)
+// editFile replaces a single occurrence of old_str with new_str in a file.
+func editFile(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getStringArg(args, "path")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
 	}
-	val, ok := raw.(string)
-	if !ok {
-		return "", fmt.Errorf("argument %s must be a string", key)
+
+	oldStr, err := getStringArg(args, "old_str")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	newStr, err := getStringArg(args, "new_str")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	if oldStr == newStr {
+		return NewErrorResult("invalid_argument", "old_str and new_str must be different", nil)
+	}
+
+	lineEnding, err := getLineEndingArg(args)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessWriteFile)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	content, err := tc.Sandbox.FS.ReadFile(resolvedPath)
+	if os.IsNotExist(err) && oldStr == "" {
+		// Empty old_str on a missing file creates it with new_str as the content.
+		newStr = convertLineEndings(newStr, resolveLineEnding(lineEnding, "", false))
+		if tc.DryRun {
+			return simulatedResult(map[string]any{
+				"message": fmt.Sprintf("would create %s", path),
+				"diff":    truncatedDiff(path, "", newStr),
+			})
+		}
+		err = writeWithQuota(tc.Sandbox, resolvedPath, []byte(newStr), false)
+		if sandboxErr, ok := err.(*SandboxError); ok {
+			return NewErrorResultFromSandbox(sandboxErr)
+		}
+		if err != nil {
+			return NewErrorResult("io_error", fmt.Sprintf("failed to write file: %v", err), nil)
+		}
+		tc.Journal.record(journalEntry{Op: "edit", Path: resolvedPath, Display: path})
+		return NewSuccessResult(map[string]any{
+			"message":       fmt.Sprintf("created %s", path),
+			"bytes_written": len(newStr),
+			"diff":          truncatedDiff(path, "", newStr),
+		})
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to read file: %v", err), nil)
+	}
+
+	if oldStr == "" {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("old_str is empty but %s already exists", path), []string{
+			"Provide old_str to edit an existing file, or use write_file to replace it entirely",
+		})
+	}
+
+	// Match on LF-normalized text so old_str need not reproduce CRLF endings,
+	// then write the result with the chosen ending.
+	ending := resolveLineEnding(lineEnding, string(content), true)
+	text := convertLineEndings(string(content), lineEndingLF)
+	oldStr = convertLineEndings(oldStr, lineEndingLF)
+	newStr = convertLineEndings(newStr, lineEndingLF)
+
+	count := strings.Count(text, oldStr)
+	if count == 0 {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("old_str not found in %s", path), []string{
+			"Read the file first and copy old_str exactly, including whitespace",
+		})
+	}
+	if count > 1 {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("old_str matches %d times in %s; it must match exactly once", count, path), []string{
+			"Include more surrounding lines in old_str to make it unique",
+		})
+	}
+
+	edited := convertLineEndings(strings.Replace(text, oldStr, newStr, 1), ending)
+	if tc.DryRun {
+		return simulatedResult(map[string]any{
+			"message": fmt.Sprintf("would edit %s", path),
+			"diff":    truncatedDiff(path, string(content), edited),
+		})
+	}
+
+	err = writeWithQuota(tc.Sandbox, resolvedPath, []byte(edited), false)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to write file: %v", err), nil)
+	}
+	tc.Journal.record(journalEntry{Op: "edit", Path: resolvedPath, Display: path, Existed: true, Previous: content})
+
+	return NewSuccessResult(map[string]any{
+		"message":       fmt.Sprintf("edited %s", path),
+		"bytes_written": len(edited),
+		"diff":          truncatedDiff(path, string(content), edited),
+	})
+}
+
+// patchedFile is the planned result of applying a patch to one file.
+type patchedFile struct {
+	display  string
+	resolved string
+	existed  bool
+	previous []byte
+	updated  string
+}
+
+// applyPatch applies a unified diff atomically: every file is patched in
+// memory first, and nothing is written unless all hunks apply cleanly.
+func applyPatch(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	patchText, err := getStringArg(args, "patch")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	patches, err := parsePatch(patchText)
+	if err != nil {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("invalid patch: %v", err), []string{
+			"Provide a unified diff with ---/+++ headers and @@ hunk headers whose line counts match the hunk body",
+		})
+	}
+	if len(patches) == 0 {
+		return NewErrorResult("invalid_argument", "patch contains no file changes", []string{
+			"Each file needs a --- old and +++ new header followed by @@ hunks",
+		})
+	}
+
+	var planned []patchedFile
+	seen := make(map[string]bool)
+	for _, p := range patches {
+		path := p.displayPath()
+		if p.newPath == "" {
+			return NewErrorResult("invalid_argument", fmt.Sprintf("patch deletes %s; deletions are not supported", path), []string{
+				"Use delete_file to remove files",
+			})
+		}
+		if p.oldPath != "" && p.oldPath != p.newPath {
+			return NewErrorResult("invalid_argument", fmt.Sprintf("patch renames %s to %s; renames are not supported", p.oldPath, p.newPath), []string{
+				"Use move_file to rename, then patch the file at its new path",
+			})
+		}
+
+		creating := p.oldPath == ""
+		access := AccessWriteFile
+		if creating {
+			access = AccessCreateDir
+		}
+		resolvedPath, err := tc.Sandbox.Resolve(path, access)
+		if sandboxErr, ok := err.(*SandboxError); ok {
+			return NewErrorResultFromSandbox(sandboxErr)
+		}
+		if err != nil {
+			return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+		}
+		if seen[resolvedPath] {
+			return NewErrorResult("invalid_argument", fmt.Sprintf("patch modifies %s more than once", path), []string{
+				"Combine all hunks for a file under a single ---/+++ header",
+			})
+		}
+		seen[resolvedPath] = true
+
+		content, err := tc.Sandbox.FS.ReadFile(resolvedPath)
+		existed := err == nil
+		switch {
+		case creating && existed:
+			return NewErrorResult("conflict", fmt.Sprintf("patch creates %s, but it already exists", path), []string{
+				"Diff against the existing file instead of /dev/null",
+			})
+		case !creating && os.IsNotExist(err):
+			return NewErrorResult("not_found", fmt.Sprintf("path not found: %s", path), []string{
+				"Use /dev/null as the old path to create a new file",
+			})
+		case err != nil && !os.IsNotExist(err):
+			return NewErrorResult("io_error", fmt.Sprintf("failed to read file: %v", err), nil)
+		}
+
+		updated, conflict := applyHunks(string(content), p.hunks)
+		if conflict != nil {
+			return NewErrorResult("conflict", fmt.Sprintf("hunk %d of %s does not apply: expected context near line %d does not match", conflict.hunk, path, conflict.line), []string{
+				"Read the file again and regenerate the patch against its current contents",
+				"No files were changed",
+			})
+		}
+
+		planned = append(planned, patchedFile{
+			display:  path,
+			resolved: resolvedPath,
+			existed:  existed,
+			previous: content,
+			updated:  updated,
+		})
+	}
+
+	var diff strings.Builder
+	for _, f := range planned {
+		diff.WriteString(unifiedDiff(f.display, string(f.previous), f.updated))
+	}
+	diffText := truncateDiff(diff.String())
+
+	if tc.DryRun {
+		return simulatedResult(map[string]any{
+			"message": fmt.Sprintf("would patch %d file(s)", len(planned)),
+			"diff":    diffText,
+		})
+	}
+
+	// Write every file, restoring those already written if one fails
+	var written []journalEntry
+	for _, f := range planned {
+		err := writeWithQuota(tc.Sandbox, f.resolved, []byte(f.updated), !f.existed)
+		if err != nil {
+			for i := len(written) - 1; i >= 0; i-- {
+				written[i].revert(tc.Sandbox.FS)
+			}
+			if sandboxErr, ok := err.(*SandboxError); ok {
+				return NewErrorResultFromSandbox(sandboxErr)
+			}
+			return NewErrorResult("io_error", fmt.Sprintf("failed to write %s: %v", f.display, err), nil)
+		}
+		written = append(written, journalEntry{Op: "edit", Path: f.resolved, Display: f.display, Existed: f.existed, Previous: f.previous})
+	}
+
+	files := make([]string, len(planned))
+	for i, f := range planned {
+		files[i] = f.display
+	}
+	tc.Journal.record(journalEntry{Op: "patch", Display: strings.Join(files, ", "), Group: written})
+
+	return NewSuccessResult(map[string]any{
+		"message": fmt.Sprintf("patched %d file(s)", len(planned)),
+		"files":   files,
+		"diff":    diffText,
+	})
+}
+
+// maxReplaceFiles caps how many files one replace_in_files call may change.
+const maxReplaceFiles = 200
+
+// replaceInFiles applies a regexp replacement to every matching file under a
+// directory. All changes are computed before anything is written; if a write
+// fails, the files already written are reported and kept.
+func replaceInFiles(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	pattern, err := getStringArg(args, "pattern")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	replacement, err := getStringArg(args, "replacement")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	path, err := getOptionalStringArg(args, "path", ".")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	glob, err := getOptionalStringArg(args, "glob", "")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if glob != "" {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return NewErrorResult("invalid_argument", fmt.Sprintf("invalid glob: %v", err), nil)
+		}
+	}
+
+	dryRun, err := getOptionalBoolArg(args, "dry_run", false)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("invalid pattern: %v", err), nil)
+	}
+
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessListDir)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	// Plan every replacement before writing anything
+	var planned []patchedFile
+	var counts []int
+	ignore := NewIgnoreMatcher(tc.Sandbox.FS, tc.Sandbox.Root)
+	err = walkDir(tc.Sandbox.FS, resolvedPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable entries rather than aborting the whole walk
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		rel, err := filepath.Rel(tc.Sandbox.Root, p)
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == trashDir || tc.Sandbox.Excluded(rel, true) || ignore.Match(rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignore.Match(rel, false) {
+			return nil
+		}
+		if glob != "" {
+			if ok, _ := filepath.Match(glob, d.Name()); !ok {
+				return nil
+			}
+		}
+
+		// Skip anything the sandbox would refuse to read or write
+		if _, err := tc.Sandbox.Resolve(rel, AccessReadFile); err != nil {
+			return nil
+		}
+		realPath, err := tc.Sandbox.Resolve(rel, AccessWriteFile)
+		if err != nil {
+			return nil
+		}
+
+		info, err := tc.Sandbox.FS.Stat(realPath)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > tc.MaxReadBytes {
+			return nil
+		}
+		content, err := tc.Sandbox.FS.ReadFile(realPath)
+		if err != nil || looksBinary(content[:min(len(content), binarySniffBytes)]) {
+			return nil
+		}
+
+		matches := len(re.FindAllIndex(content, -1))
+		if matches == 0 {
+			return nil
+		}
+		updated := re.ReplaceAll(content, []byte(replacement))
+		if string(updated) == string(content) {
+			return nil
+		}
+		if len(planned) >= maxReplaceFiles {
+			return fmt.Errorf("pattern matches more than %d files", maxReplaceFiles)
+		}
+		planned = append(planned, patchedFile{
+			display:  filepath.ToSlash(rel),
+			resolved: realPath,
+			existed:  true,
+			previous: content,
+			updated:  string(updated),
+		})
+		counts = append(counts, matches)
+		return nil
+	})
+	if ctx.Err() != nil {
+		return NewErrorResult("timeout", "replacement was stopped because the turn ended", nil)
+	}
+	if err != nil {
+		return NewErrorResult("too_large", err.Error(), []string{
+			"Narrow the change with path or glob, or make the pattern more specific",
+		})
+	}
+	if len(planned) == 0 {
+		return NewErrorResult("not_found", fmt.Sprintf("no files under %s match %s", path, pattern), nil)
+	}
+
+	var diff strings.Builder
+	files := make([]map[string]any, len(planned))
+	total := 0
+	for i, f := range planned {
+		diff.WriteString(unifiedDiff(f.display, string(f.previous), f.updated))
+		files[i] = map[string]any{"file": f.display, "replacements": counts[i]}
+		total += counts[i]
+	}
+	diffText := truncateDiff(diff.String())
+
+	if dryRun || tc.DryRun {
+		return simulatedResult(map[string]any{
+			"message":            fmt.Sprintf("would make %d replacement(s) in %d file(s)", total, len(planned)),
+			"files":              files,
+			"total_replacements": total,
+			"diff":               diffText,
+		})
+	}
+
+	var written []journalEntry
+	for _, f := range planned {
+		err := writeWithQuota(tc.Sandbox, f.resolved, []byte(f.updated), false)
+		if err == nil {
+			written = append(written, journalEntry{Op: "edit", Path: f.resolved, Display: f.display, Existed: true, Previous: f.previous})
+			continue
+		}
+
+		done := make([]string, len(written))
+		for i, w := range written {
+			done[i] = w.Display
+		}
+		if len(written) > 0 {
+			tc.Journal.record(journalEntry{Op: "replace", Display: strings.Join(done, ", "), Group: written})
+		}
+		message := fmt.Sprintf("failed to write %s: %v", f.display, err)
+		var suggestions []string
+		if len(done) > 0 {
+			message += fmt.Sprintf("; already updated: %s", strings.Join(done, ", "))
+			suggestions = []string{"Use undo_last_edit to revert the files that were already updated"}
+		}
+		code := "io_error"
+		if sandboxErr, ok := err.(*SandboxError); ok {
+			code = sandboxErr.Code
+		}
+		return NewErrorResult(code, message, suggestions)
+	}
+
+	names := make([]string, len(planned))
+	for i, f := range planned {
+		names[i] = f.display
+	}
+	tc.Journal.record(journalEntry{Op: "replace", Display: strings.Join(names, ", "), Group: written})
+
+	return NewSuccessResult(map[string]any{
+		"message":            fmt.Sprintf("made %d replacement(s) in %d file(s)", total, len(planned)),
+		"files":              files,
+		"total_replacements": total,
+		"diff":               diffText,
+	})
+}
+
+// trashDir is the directory under the sandbox root that receives deleted files.
+const trashDir = ".agent-trash"
+
+// deleteFile moves a file or directory into the trash directory.
+func deleteFile(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getStringArg(args, "path")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	recursive, err := getOptionalBoolArg(args, "recursive", false)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessDeleteFile)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	trashRoot := filepath.Join(tc.Sandbox.Root, trashDir)
+	if resolvedPath == tc.Sandbox.Root || resolvedPath == trashRoot || strings.HasPrefix(resolvedPath, trashRoot+string(filepath.Separator)) {
+		return NewErrorResult("permission_denied", fmt.Sprintf("cannot delete %s", path), nil)
+	}
+
+	// Lstat so a symlink to a directory is deleted as a plain link
+	info, err := tc.Sandbox.FS.Lstat(resolvedPath)
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to stat path: %v", err), nil)
+	}
+	if info.IsDir() && !recursive {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("%s is a directory", path), []string{
+			"Set recursive=true to delete a directory and its contents",
+		})
+	}
+
+	if tc.DryRun {
+		return simulatedResult(map[string]any{
+			"message": fmt.Sprintf("would delete %s", path),
+		})
+	}
+
+	if err := tc.Sandbox.FS.MkdirAll(trashRoot, 0755); err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to create trash directory: %v", err), nil)
+	}
+
+	trashName := fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405.000000000"), filepath.Base(resolvedPath))
+	trashPath := filepath.Join(trashRoot, trashName)
+	if err := tc.Sandbox.FS.Rename(resolvedPath, trashPath); err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to move file to trash: %v", err), nil)
+	}
+	tc.Journal.record(journalEntry{Op: "delete", Path: resolvedPath, Display: path, TrashPath: trashPath})
+
+	return NewSuccessResult(map[string]any{
+		"message":    fmt.Sprintf("deleted %s", path),
+		"trash_path": filepath.Join(trashDir, trashName),
+	})
+}
+
+// moveFile moves or renames a file within the sandbox.
+func moveFile(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	source, err := getStringArg(args, "source")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	destination, err := getStringArg(args, "destination")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	overwrite, err := getOptionalBoolArg(args, "overwrite", false)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	resolvedSource, err := tc.Sandbox.Resolve(source, AccessMoveFile)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	resolvedDestination, err := tc.Sandbox.Resolve(destination, AccessWriteFile)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	if resolvedSource == tc.Sandbox.Root {
+		return NewErrorResult("permission_denied", "cannot move the project root", nil)
+	}
+
+	destInfo, err := tc.Sandbox.FS.Stat(resolvedDestination)
+	destExisted := err == nil
+	if destExisted && !overwrite {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("destination already exists: %s", destination), []string{
+			"Set overwrite=true to replace the existing destination",
+		})
+	}
+
+	// Keep an overwritten destination's contents and mode so the move can be undone
+	var destPrevious []byte
+	destMode := os.FileMode(0644)
+	if destExisted && destInfo.Mode().IsRegular() {
+		destPrevious, _ = tc.Sandbox.FS.ReadFile(resolvedDestination)
+		destMode = destInfo.Mode().Perm()
+	}
+
+	if tc.DryRun {
+		return simulatedResult(map[string]any{
+			"message": fmt.Sprintf("would move %s to %s", source, destination),
+		})
+	}
+
+	err = tc.Sandbox.FS.Rename(resolvedSource, resolvedDestination)
+	if errors.Is(err, syscall.EXDEV) {
+		// Rename cannot cross devices; fall back to copy + delete.
+		err = copyFile(tc.Sandbox.FS, resolvedSource, resolvedDestination)
+		if err == nil {
+			err = tc.Sandbox.FS.Remove(resolvedSource)
+		}
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to move file: %v", err), nil)
+	}
+	tc.Journal.record(journalEntry{
+		Op:           "move",
+		Path:         resolvedSource,
+		Display:      source,
+		Destination:  resolvedDestination,
+		DestExisted:  destExisted,
+		DestPrevious: destPrevious,
+		DestMode:     destMode,
+	})
+
+	return NewSuccessResult(map[string]any{
+		"message": fmt.Sprintf("moved %s to %s", source, destination),
+	})
+}
+
+// makeDirectory creates a directory, optionally with its missing parents.
+func makeDirectory(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getStringArg(args, "path")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	parents, err := getOptionalBoolArg(args, "parents", false)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessCreateDir)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	info, err := tc.Sandbox.FS.Stat(resolvedPath)
+	if err == nil && !info.IsDir() {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("%s already exists and is not a directory", path), nil)
+	}
+	if err == nil && !parents {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("directory already exists: %s", path), nil)
+	}
+
+	if tc.DryRun {
+		return simulatedResult(map[string]any{
+			"message": fmt.Sprintf("would create directory %s", path),
+		})
+	}
+
+	if parents {
+		err = tc.Sandbox.FS.MkdirAll(resolvedPath, 0755)
+	} else {
+		err = tc.Sandbox.FS.Mkdir(resolvedPath, 0755)
+	}
+	if os.IsNotExist(err) {
+		return NewErrorResult("not_found", fmt.Sprintf("parent directory not found: %s", filepath.Dir(path)), []string{
+			"Set parents=true to create missing parent directories",
+		})
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to create directory: %v", err), nil)
+	}
+
+	return NewSuccessResult(map[string]any{
+		"message": fmt.Sprintf("created directory %s", path),
+	})
+}
+
+// undoLastEdit reverts the most recent journaled file operation.
+func undoLastEdit(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	entry, ok := tc.Journal.peek()
+	if !ok {
+		return NewErrorResult("not_found", "no edits to undo", nil)
+	}
+
+	if tc.DryRun {
+		return simulatedResult(map[string]any{
+			"message": fmt.Sprintf("would undo %s of %s", entry.Op, entry.Display),
+		})
+	}
+
+	if err := entry.revert(tc.Sandbox.FS); err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to undo %s of %s: %v", entry.Op, entry.Display, err), nil)
+	}
+	tc.Journal.pop()
+
+	return NewSuccessResult(map[string]any{
+		"message": fmt.Sprintf("undid %s of %s", entry.Op, entry.Display),
+	})
+}
+
+// copyFile copies a regular file's contents and permissions from src to dst.
+func copyFile(fsys FileSystem, src, dst string) error {
+	in, err := fsys.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("cannot copy non-regular file across devices: %s", src)
+	}
+
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(dst, data, info.Mode().Perm())
+}
+
+// statFile reports metadata for a path without following a final symlink.
+// A missing path is a successful result with exists=false.
+func statFile(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getStringArg(args, "path")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessStat)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		if sandboxErr.Code == "not_found" {
+			return NewSuccessResult(map[string]any{"exists": false})
+		}
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	info, err := tc.Sandbox.FS.Lstat(resolvedPath)
+	if os.IsNotExist(err) {
+		return NewSuccessResult(map[string]any{"exists": false})
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to stat path: %v", err), nil)
+	}
+
+	return NewSuccessResult(map[string]any{
+		"exists":     true,
+		"is_dir":     info.IsDir(),
+		"is_symlink": info.Mode()&os.ModeSymlink != 0,
+		"size":       info.Size(),
+		"mode":       info.Mode().String(),
+		"mod_time":   info.ModTime().Format(time.RFC3339),
+	})
+}
+
+// listFiles lists the contents of a directory.
+func listFiles(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getStringArg(args, "path")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	includeIgnored, err := getOptionalBoolArg(args, "include_ignored", false)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	recursive, err := getOptionalBoolArg(args, "recursive", false)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	maxDepth, hasDepth, err := getOptionalIntArg(args, "max_depth")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if hasDepth && maxDepth < 1 {
+		return NewErrorResult("invalid_argument", "max_depth must be at least 1", nil)
+	}
+
+	details, err := getOptionalBoolArg(args, "details", false)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	pattern, err := getOptionalStringArg(args, "pattern", "")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if pattern != "" {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return NewErrorResult("invalid_argument", fmt.Sprintf("invalid pattern: %v", err), nil)
+		}
+	}
+
+	page, hasPage, err := getOptionalIntArg(args, "page")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if !hasPage {
+		page = 1
+	}
+	if page < 1 {
+		return NewErrorResult("invalid_argument", "page must be at least 1", nil)
+	}
+
+	pageSize, hasPageSize, err := getOptionalIntArg(args, "page_size")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if !hasPageSize {
+		pageSize = defaultListPageSize
+	}
+	if pageSize < 1 || pageSize > maxListPageSize {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("page_size must be between 1 and %d", maxListPageSize), nil)
+	}
+
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessListDir)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	var entries []listEntry
+	var truncated bool
+	if recursive {
+		entries, truncated, err = listFilesRecursive(tc.Sandbox, resolvedPath, maxDepth, pattern, includeIgnored)
+	} else {
+		entries, truncated, err = listDir(tc.Sandbox, resolvedPath, pattern, includeIgnored)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to list directory: %v", err), nil)
+	}
+
+	// Sort before slicing so every page comes from the same order
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	total := len(entries)
+	start := min((page-1)*pageSize, total)
+	end := min(start+pageSize, total)
+
+	// Only the returned page is statted in details mode
+	var files any
+	if details {
+		files = entryDetails(entries[start:end])
+	} else {
+		names := make([]string, 0, end-start)
+		for _, entry := range entries[start:end] {
+			names = append(names, entry.name)
+		}
+		files = names
+	}
+
+	return NewSuccessResult(map[string]any{
+		"files":     files,
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+		"has_more":  end < total,
+		"truncated": truncated,
+	})
+}
+
+const (
+	defaultListPageSize = 500   // Entries per list_files page unless page_size is given
+	maxListPageSize     = 1000  // Largest page_size list_files accepts
+	maxListEntries      = 50000 // Entries list_files collects before it stops and reports truncated
+)
+
+// listEntry is one list_files entry: its name as listed, with a trailing slash
+// on directories, and the directory entry it came from.
+type listEntry struct {
+	name  string
+	entry fs.DirEntry
+}
+
+// entryDetails describes entries for list_files in details mode. Entries
+// whose info cannot be read, say because they were removed after the
+// listing, carry an error instead of a size and modification time.
+func entryDetails(entries []listEntry) []map[string]any {
+	details := make([]map[string]any, 0, len(entries))
+	for _, e := range entries {
+		detail := map[string]any{"name": e.name, "is_dir": e.entry.IsDir()}
+		if info, err := e.entry.Info(); err != nil {
+			// Report only the cause; the error's path is absolute
+			var pathErr *fs.PathError
+			if errors.As(err, &pathErr) {
+				err = pathErr.Err
+			}
+			detail["error"] = fmt.Sprintf("failed to read entry info: %v", err)
+		} else {
+			detail["size"] = info.Size()
+			detail["mod_time"] = info.ModTime().Format(time.RFC3339)
+		}
+		details = append(details, detail)
+	}
+	return details
+}
+
+// listDir returns the entries of dir, skipping ignored ones and, when pattern
+// is set, those whose name does not match it.
+func listDir(sandbox *PathSandbox, dir, pattern string, includeIgnored bool) ([]listEntry, bool, error) {
+	entries, err := sandbox.ReadDir(dir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	relDir, err := filepath.Rel(sandbox.Root, dir)
+	if err != nil {
+		return nil, false, err
+	}
+	ignore := NewIgnoreMatcher(sandbox.FS, sandbox.Root)
+
+	files := []listEntry{}
+	for _, entry := range entries {
+		name := entry.Name()
+		entryRel := filepath.Join(relDir, name)
+		if sandbox.Excluded(entryRel, entry.IsDir()) || (!includeIgnored && ignore.Match(entryRel, entry.IsDir())) {
+			continue
+		}
+		if pattern != "" {
+			if ok, _ := filepath.Match(pattern, name); !ok {
+				continue
+			}
+		}
+		if len(files) >= maxListEntries {
+			return files, true, nil
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		files = append(files, listEntry{name: name, entry: entry})
+	}
+	return files, false, nil
+}
+
+// listFilesRecursive walks dir and returns entries relative to it, descending
+// at most maxDepth levels (0 means unlimited). When pattern is set, only
+// entries whose name matches it are returned, but every directory is still
+// descended into.
+func listFilesRecursive(sandbox *PathSandbox, dir string, maxDepth int, pattern string, includeIgnored bool) ([]listEntry, bool, error) {
+	ignore := NewIgnoreMatcher(sandbox.FS, sandbox.Root)
+	files := []listEntry{}
+	truncated := false
+
+	err := walkDir(sandbox.FS, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == dir {
+			// Skip unreadable entries rather than aborting the whole listing
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil
+		}
+		rootRel, err := filepath.Rel(sandbox.Root, p)
+		if err != nil {
+			return nil
+		}
+
+		if sandbox.Excluded(rootRel, d.IsDir()) || (!includeIgnored && ignore.Match(rootRel, d.IsDir())) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Skip symlinks the sandbox would refuse to follow (e.g. pointing outside the root)
+		if d.Type()&fs.ModeSymlink != 0 {
+			if _, err := sandbox.Resolve(rootRel, AccessReadFile); err != nil {
+				return nil
+			}
+		}
+
+		name := filepath.ToSlash(rel)
+		if d.IsDir() {
+			name += "/"
+		}
+		matched := true
+		if pattern != "" {
+			matched, _ = filepath.Match(pattern, d.Name())
+		}
+		if matched {
+			if len(files) >= maxListEntries {
+				truncated = true
+				return filepath.SkipAll
+			}
+			files = append(files, listEntry{name: name, entry: d})
+		}
+
+		if d.IsDir() && maxDepth > 0 && strings.Count(name, "/") >= maxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return files, truncated, err
+}
+
+// maxSearchMatches caps the number of matches returned by search_files.
+const maxSearchMatches = 200
+
+// searchFiles walks a directory tree and returns lines matching a regexp.
+func searchFiles(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	pattern, err := getStringArg(args, "pattern")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	path, err := getOptionalStringArg(args, "path", ".")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	glob, err := getOptionalStringArg(args, "glob", "")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if glob != "" {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return NewErrorResult("invalid_argument", fmt.Sprintf("invalid glob: %v", err), nil)
+		}
+	}
+
+	includeIgnored, err := getOptionalBoolArg(args, "include_ignored", false)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("invalid pattern: %v", err), nil)
+	}
+
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessListDir)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	matches := []map[string]any{}
+	truncated := false
+	ignore := NewIgnoreMatcher(tc.Sandbox.FS, tc.Sandbox.Root)
+
+	err = walkDir(tc.Sandbox.FS, resolvedPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable entries rather than aborting the whole search
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		rel, err := filepath.Rel(tc.Sandbox.Root, p)
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == trashDir || tc.Sandbox.Excluded(rel, true) || (!includeIgnored && ignore.Match(rel, true)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !includeIgnored && ignore.Match(rel, false) {
+			return nil
+		}
+		if glob != "" {
+			if ok, _ := filepath.Match(glob, d.Name()); !ok {
+				return nil
+			}
+		}
+
+		// Skip anything the sandbox would refuse to read (e.g. escaping symlinks)
+		realPath, err := tc.Sandbox.Resolve(rel, AccessReadFile)
+		if err != nil {
+			return nil
+		}
+
+		f, err := tc.Sandbox.FS.Open(realPath)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+
+		// Binary files have no lines worth showing
+		reader := bufio.NewReader(f)
+		if head, _ := reader.Peek(binarySniffBytes); looksBinary(head) {
+			return nil
+		}
+
+		scanner := bufio.NewScanner(reader)
+		lineNumber := 0
+		for scanner.Scan() {
+			lineNumber++
+			if lineNumber%1024 == 0 && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			line := scanner.Text()
+			if !re.MatchString(line) {
+				continue
+			}
+			if len(matches) >= maxSearchMatches {
+				truncated = true
+				return filepath.SkipAll
+			}
+			matches = append(matches, map[string]any{
+				"file":        rel,
+				"line_number": lineNumber,
+				"line":        line,
+			})
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return NewErrorResult("timeout", "search was stopped because the turn ended", nil)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to search files: %v", err), nil)
+	}
+
+	return NewSuccessResult(map[string]any{
+		"matches":   matches,
+		"truncated": truncated,
+	})
+}
+
+// runCommand runs an allowlisted command with the sandbox root as working directory.
+func runCommand(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	command, err := getStringArg(args, "command")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	cmdArgs, err := getOptionalStringSliceArg(args, "args")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	out, failure := execAllowed(ctx, tc, command, cmdArgs...)
+	if failure != nil {
+		return failure
+	}
+
+	return NewSuccessResult(map[string]any{
+		"stdout":    out.stdout,
+		"stderr":    out.stderr,
+		"exit_code": out.exitCode,
+	})
+}
+
+// commandOutput is what a finished command wrote and how it exited.
+type commandOutput struct {
+	stdout   string
+	stderr   string
+	exitCode int
+}
+
+// execAllowed runs an allowlisted command in the sandbox root, bounded by the
+// command timeout. Tools that shell out use it so every command goes through
+// the same allowlist. A non-zero exit is not a failure; the returned
+// ToolResult is set only when the command could not run to completion.
+func execAllowed(ctx context.Context, tc *ToolContext, command string, args ...string) (*commandOutput, *ToolResult) {
+	return execAllowedInput(ctx, tc, nil, command, args...)
+}
+
+// execAllowedInput is execAllowed with stdin fed from input.
+func execAllowedInput(ctx context.Context, tc *ToolContext, input []byte, command string, args ...string) (*commandOutput, *ToolResult) {
+	if !slices.Contains(tc.AllowedCommands, command) {
+		return nil, NewErrorResult("permission_denied", fmt.Sprintf("command not allowed: %s", command), []string{
+			fmt.Sprintf("Allowed commands: %s", strings.Join(tc.AllowedCommands, ", ")),
+		})
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, tc.CommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(cmdCtx, command, args...)
+	cmd.Dir = tc.Sandbox.Root
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, NewErrorResult("timeout", fmt.Sprintf("command was stopped because the turn ended: %v", ctx.Err()), nil)
+	}
+	if cmdCtx.Err() == context.DeadlineExceeded {
+		return nil, NewErrorResult("timeout", fmt.Sprintf("command timed out after %s", tc.CommandTimeout), nil)
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, NewErrorResult("io_error", fmt.Sprintf("failed to run command: %v", err), nil)
+	}
+
+	return &commandOutput{
+		stdout:   stdout.String(),
+		stderr:   stderr.String(),
+		exitCode: cmd.ProcessState.ExitCode(),
+	}, nil
+}
+
+// geocodeResult is a single match from the Open-Meteo geocoding API.
+type geocodeResult struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Country   string  `json:"country"`
+	Admin1    string  `json:"admin1"`
+}
+
+// countTokens reports the token cost of text or of a file's contents.
+func countTokens(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	text, err := getOptionalStringArg(args, "text", "")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	path, err := getOptionalStringArg(args, "path", "")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if (text == "") == (path == "") {
+		return NewErrorResult("invalid_argument", "provide exactly one of text or path", nil)
+	}
+
+	if tc.TokenCounter == nil {
+		return NewErrorResult("invalid_argument", "token counting is not available in this session", nil)
+	}
+
+	data := map[string]any{}
+	if path != "" {
+		resolvedPath, err := tc.Sandbox.Resolve(path, AccessReadFile)
+		if sandboxErr, ok := err.(*SandboxError); ok {
+			return NewErrorResultFromSandbox(sandboxErr)
+		}
+		if err != nil {
+			return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+		}
+
+		info, err := tc.Sandbox.FS.Stat(resolvedPath)
+		if err != nil {
+			return NewErrorResult("io_error", fmt.Sprintf("failed to stat file: %v", err), nil)
+		}
+		if info.IsDir() {
+			return NewErrorResult("invalid_argument", fmt.Sprintf("%s is a directory", path), nil)
+		}
+		if info.Size() > tc.MaxReadBytes {
+			return NewErrorResult("too_large", fmt.Sprintf("%s is %d bytes, which exceeds the %d byte read limit", path, info.Size(), tc.MaxReadBytes), nil)
+		}
+
+		content, err := tc.Sandbox.FS.ReadFile(resolvedPath)
+		if err != nil {
+			return NewErrorResult("io_error", fmt.Sprintf("failed to read file: %v", err), nil)
+		}
+		if looksBinary(content[:min(len(content), binarySniffBytes)]) {
+			return NewErrorResult("binary_file", fmt.Sprintf("%s appears to be a binary file", path), nil)
+		}
+		text = string(content)
+		data["path"] = path
+		data["bytes"] = len(content)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultHTTPTimeout)
+	defer cancel()
+	count, err := tc.TokenCounter.CountTokens(ctx, text)
+	if err != nil {
+		return NewErrorResult("network_error", fmt.Sprintf("failed to count tokens: %v", err), nil)
+	}
+
+	data["tokens"] = count
+	return NewSuccessResult(data)
+}
+
+// getWeather geocodes a location and fetches its current weather from Open-Meteo.
+func getWeather(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	location, err := getStringArg(args, "location")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if strings.TrimSpace(location) == "" {
+		return NewErrorResult("invalid_argument", "location cannot be empty", nil)
+	}
+
+	place, err := geocode(ctx, tc.HTTPClient, location)
+	if err != nil {
+		return networkErrorResult("geocode location", err)
+	}
+	if place == nil {
+		return NewErrorResult("not_found", fmt.Sprintf("location not found: %s", location), []string{
+			"Try a city name such as 'Houston' or 'Paris, France'",
+		})
+	}
+
+	forecastURL := fmt.Sprintf("https://api.open-meteo.com/v1/forecast?latitude=%f&longitude=%f&current_weather=true", place.Latitude, place.Longitude)
+	resp, err := httpGet(ctx, tc.HTTPClient, forecastURL)
+	if err != nil {
+		return networkErrorResult("fetch weather", err)
+	}
+	defer resp.Body.Close()
+
+	var data map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return NewErrorResult("parse_error", fmt.Sprintf("failed to parse weather response: %v", err), nil)
+	}
+
+	data["location"] = map[string]any{
+		"name":    place.Name,
+		"admin1":  place.Admin1,
+		"country": place.Country,
+	}
+	return NewSuccessResult(data)
+}
+
+// httpGet issues a GET request that is abandoned when ctx is done.
+func httpGet(ctx context.Context, client *http.Client, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// geocode resolves a location to coordinates, returning the first match or nil if none.
+// Open-Meteo searches by place name only, so "City, Region" falls back to "City".
+func geocode(ctx context.Context, client *http.Client, location string) (*geocodeResult, error) {
+	place, err := geocodeName(ctx, client, location)
+	if err != nil || place != nil {
+		return place, err
+	}
+	if name, _, ok := strings.Cut(location, ","); ok {
+		return geocodeName(ctx, client, strings.TrimSpace(name))
+	}
+	return nil, nil
+}
+
+// geocodeName queries the Open-Meteo geocoding API for a single name.
+func geocodeName(ctx context.Context, client *http.Client, name string) (*geocodeResult, error) {
+	geocodeURL := "https://geocoding-api.open-meteo.com/v1/search?count=1&name=" + url.QueryEscape(name)
+	resp, err := httpGet(ctx, client, geocodeURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("geocoding API returned %s", resp.Status)
+	}
+
+	var body struct {
+		Results []geocodeResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to parse geocoding response: %w", err)
+	}
+	if len(body.Results) == 0 {
+		return nil, nil
+	}
+	return &body.Results[0], nil
+}
+
+// getStringArg retrieves a string argument from a function call.
+func getStringArg(args map[string]any, key string) (string, error) {
+	raw, ok := args[key]
+	if !ok {
+		return "", fmt.Errorf("missing argument: %s", key)
+	}
+	val, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %s must be a string", key)
+	}
+	return val, nil
+}
+
+// getOptionalStringArg retrieves an optional string argument, returning def when absent.
+func getOptionalStringArg(args map[string]any, key, def string) (string, error) {
+	raw, ok := args[key]
+	if !ok {
+		return def, nil
+	}
+	val, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %s must be a string", key)
+	}
+	return val, nil
+}
+
+// getStringSliceArg retrieves a required list of strings.
+func getStringSliceArg(args map[string]any, key string) ([]string, error) {
+	raw, ok := args[key]
+	if !ok {
+		return nil, fmt.Errorf("missing argument: %s", key)
+	}
+	vals, ok := toStringSlice(raw)
+	if !ok {
+		return nil, fmt.Errorf("argument %s must be an array of strings", key)
+	}
+	return vals, nil
+}
+
+// getOptionalStringSliceArg retrieves an optional list of strings, defaulting to nil.
+func getOptionalStringSliceArg(args map[string]any, key string) ([]string, error) {
+	if _, ok := args[key]; !ok {
+		return nil, nil
+	}
+	return getStringSliceArg(args, key)
+}
+
+// getIntArg retrieves a required integer argument.
+func getIntArg(args map[string]any, key string) (int, error) {
+	raw, ok := args[key]
+	if !ok {
+		return 0, fmt.Errorf("missing argument: %s", key)
+	}
+	val, ok := toInt(raw)
+	if !ok {
+		return 0, fmt.Errorf("argument %s must be an integer, got %v", key, raw)
+	}
+	return val, nil
+}
+
+// getOptionalIntArg retrieves an optional integer argument and whether it was present.
+func getOptionalIntArg(args map[string]any, key string) (int, bool, error) {
+	if _, ok := args[key]; !ok {
+		return 0, false, nil
+	}
+	val, err := getIntArg(args, key)
+	if err != nil {
+		return 0, false, err
+	}
+	return val, true, nil
+}
+
+// getBoolArg retrieves a required boolean argument.
+func getBoolArg(args map[string]any, key string) (bool, error) {
+	raw, ok := args[key]
+	if !ok {
+		return false, fmt.Errorf("missing argument: %s", key)
+	}
+	val, ok := toBool(raw)
+	if !ok {
+		return false, fmt.Errorf("argument %s must be a boolean, got %v", key, raw)
+	}
+	return val, nil
+}
+
+// getOptionalBoolArg retrieves an optional boolean argument, returning def when absent.
+func getOptionalBoolArg(args map[string]any, key string, def bool) (bool, error) {
+	if _, ok := args[key]; !ok {
+		return def, nil
+	}
+	return getBoolArg(args, key)
+}
+
+// toInt coerces a decoded argument to an int. JSON numbers arrive as float64,
+// so whole-valued floats are accepted, as are strings holding an integer.
+func toInt(raw any) (int, bool) {
+	switch val := raw.(type) {
+	case int:
+		return val, true
+	case int64:
+		return int(val), true
+	case float64:
+		if val != math.Trunc(val) || math.Abs(val) > 1<<53 {
+			return 0, false
+		}
+		return int(val), true
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(val))
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// toBool coerces a decoded argument to a bool, accepting "true" and "false"
+// strings in any case as well as real booleans.
+func toBool(raw any) (bool, bool) {
+	switch val := raw.(type) {
+	case bool:
+		return val, true
+	case string:
+		switch strings.ToLower(strings.TrimSpace(val)) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// toStringSlice coerces a decoded argument to a list of strings.
+func toStringSlice(raw any) ([]string, bool) {
+	switch val := raw.(type) {
+	case []string:
+		return val, true
+	case []any:
+		vals := make([]string, len(val))
+		for i, item := range val {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			vals[i] = s
+		}
+		return vals, true
+	default:
+		return nil, false
 	}
-	return val, nil
 }
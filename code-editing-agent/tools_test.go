@@ -0,0 +1,1341 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// writeTree creates files under root, keyed by slash-separated path.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestToolContext returns a ToolContext over a sandbox in a temporary
+// directory holding files, keyed by slash-separated path.
+func newTestToolContext(t *testing.T, files map[string]string, opts ...SandboxOption) *ToolContext {
+	t.Helper()
+	root := t.TempDir()
+	writeTree(t, root, files)
+	sandbox, err := NewPathSandbox(root, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewToolContext(sandbox, slog.New(slog.DiscardHandler))
+}
+
+// readTestFile returns the content of the slash-separated path under the
+// sandbox root, or "" with ok false if it cannot be read.
+func readTestFile(t *testing.T, tc *ToolContext, name string) (string, bool) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(tc.Sandbox.Root, filepath.FromSlash(name)))
+	return string(data), err == nil
+}
+
+// resultJSON encodes result as the model would see it.
+func resultJSON(t *testing.T, result *ToolResult) string {
+	t.Helper()
+	data, err := json.Marshal(result.AsMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// absent, as a toolCase.want value, means the file must not exist.
+const absent = "\x00absent"
+
+// toolCase is one call of a tool against a fresh sandbox.
+type toolCase struct {
+	name    string
+	args    map[string]any
+	dryRun  bool
+	wantErr string            // Error code; "" for success
+	want    map[string]string // Contents of files afterwards, or absent
+	check   func(t *testing.T, result *ToolResult, tc *ToolContext)
+}
+
+// runToolCases runs each case against a new sandbox holding files.
+func runToolCases(t *testing.T, run func(context.Context, map[string]any, *ToolContext) *ToolResult, files map[string]string, tests []toolCase, opts ...SandboxOption) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, files, opts...)
+			tc.DryRun = tt.dryRun
+			result := run(context.Background(), tt.args, tc)
+			if tt.wantErr != "" {
+				if result.OK || result.Error.Code != tt.wantErr {
+					t.Errorf("result = %s, want error %s", resultJSON(t, result), tt.wantErr)
+				}
+			} else if !result.OK {
+				t.Errorf("failed: %s", resultJSON(t, result))
+			}
+			for name, want := range tt.want {
+				got, ok := readTestFile(t, tc, name)
+				switch {
+				case want == absent && ok:
+					t.Errorf("%s exists with %q, want it absent", name, got)
+				case want != absent && (!ok || got != want):
+					t.Errorf("%s = %q (exists %v), want %q", name, got, ok, want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, result, tc)
+			}
+		})
+	}
+}
+
+func TestAgentIgnoreBypasses(t *testing.T) {
+	files := map[string]string{
+		".agentignore":     "secrets/\n*.key\n",
+		"secrets/token":    "TOPSECRET",
+		"config/prod.key":  "TOPSECRET",
+		"config/app.toml":  "name = 'app'\n",
+		"public.txt":       "hello\n",
+		"docs/readme.md":   "docs\n",
+		"docs/more/a.md":   "a\n",
+		"notes/ignored.md": "fine\n",
+	}
+	patch := "--- a/secrets/token\n+++ b/secrets/token\n@@ -1 +1 @@\n-TOPSECRET\n+changed\n"
+	tests := []struct {
+		name string
+		run  func(context.Context, map[string]any, *ToolContext) *ToolResult
+		args map[string]any
+	}{
+		{"read", readFile, map[string]any{"path": "secrets/token"}},
+		{"stat", statFile, map[string]any{"path": "secrets/token"}},
+		{"list", listFiles, map[string]any{"path": "secrets"}},
+		{"move source out", moveFile, map[string]any{"source": "secrets/token", "destination": "public-token.txt"}},
+		{"move ignored dir", moveFile, map[string]any{"source": "secrets", "destination": "exposed"}},
+		{"move dir holding an ignored file", moveFile, map[string]any{"source": "config", "destination": "settings"}},
+		{"move destination in", moveFile, map[string]any{"source": "public.txt", "destination": "secrets/public.txt"}},
+		{"write over", writeFile, map[string]any{"path": "secrets/token", "content": "changed"}},
+		{"write new", writeFile, map[string]any{"path": "new.key", "content": "changed"}},
+		{"write into new ignored dir", writeFile, map[string]any{"path": "secrets/sub/file", "content": "changed"}},
+		{"edit", editFile, map[string]any{"path": "secrets/token", "old_str": "TOPSECRET", "new_str": "changed"}},
+		{"edit creates", editFile, map[string]any{"path": "secrets/created", "old_str": "", "new_str": "changed"}},
+		{"append", appendToFile, map[string]any{"path": "secrets/token", "content": "more"}},
+		{"patch", applyPatch, map[string]any{"patch": patch}},
+		{"delete", deleteFile, map[string]any{"path": "secrets/token"}},
+		{"delete dir holding an ignored file", deleteFile, map[string]any{"path": "config", "recursive": true}},
+		{"make directory", makeDirectory, map[string]any{"path": "secrets/sub"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, files)
+			result := tt.run(context.Background(), tt.args, tc)
+			if result.OK {
+				t.Fatalf("%s succeeded: %s", tt.name, resultJSON(t, result))
+			}
+			if result.Error.Code != "permission_denied" {
+				t.Errorf("error code = %s (%s), want permission_denied", result.Error.Code, result.Error.Message)
+			}
+			if encoded := resultJSON(t, result); strings.Contains(encoded, "TOPSECRET") {
+				t.Errorf("result leaks the ignored content: %s", encoded)
+			}
+			for _, name := range []string{"secrets/token", "config/prod.key"} {
+				if got, ok := readTestFile(t, tc, name); !ok || got != "TOPSECRET" {
+					t.Errorf("%s = %q, %v after the call; want it untouched", name, got, ok)
+				}
+			}
+			for _, name := range []string{"public-token.txt", "exposed/token", "settings/prod.key", "new.key", "secrets/created", "secrets/public.txt"} {
+				if _, ok := readTestFile(t, tc, name); ok {
+					t.Errorf("%s was created", name)
+				}
+			}
+		})
+	}
+}
+
+func TestAgentIgnoreLeavesOtherPathsAlone(t *testing.T) {
+	tc := newTestToolContext(t, map[string]string{
+		".agentignore":   "secrets/\n",
+		"docs/readme.md": "docs\n",
+	})
+	ctx := context.Background()
+	if result := moveFile(ctx, map[string]any{"source": "docs", "destination": "manual"}, tc); !result.OK {
+		t.Errorf("moving a directory with nothing ignored failed: %s", result.Error.Message)
+	}
+	if result := writeFile(ctx, map[string]any{"path": "secretsfile.txt", "content": "x"}, tc); !result.OK {
+		t.Errorf("writing a path the rules do not match failed: %s", result.Error.Message)
+	}
+}
+
+func TestWalksSkipDeniedPaths(t *testing.T) {
+	files := map[string]string{
+		"secrets/prod-key.pem": "TODO: rotate\n",
+		"secrets/sub/token":    "TODO: revoke\n",
+		"docs/cert.pem":        "TODO: renew\n",
+		"main.go":              "package main\n// TODO: tidy\n",
+	}
+	tests := []struct {
+		name string
+		run  func(context.Context, map[string]any, *ToolContext) *ToolResult
+		args map[string]any
+	}{
+		{"list", listFiles, map[string]any{"path": "."}},
+		{"list recursive", listFiles, map[string]any{"path": ".", "recursive": true}},
+		{"list recursive with ignored", listFiles, map[string]any{"path": ".", "recursive": true, "include_ignored": true}},
+		{"tree", treeFiles, map[string]any{"max_depth": 4}},
+		{"overview", projectOverview, map[string]any{}},
+		{"search", searchFiles, map[string]any{"pattern": "TODO", "include_ignored": true}},
+		{"replace", replaceInFiles, map[string]any{"pattern": "TODO", "replacement": "DONE"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, files, func(s *PathSandbox) { s.Deny = []string{"secrets", "**/*.pem"} })
+			result := tt.run(context.Background(), tt.args, tc)
+			if !result.OK {
+				t.Fatalf("%s failed: %s", tt.name, resultJSON(t, result))
+			}
+			encoded := resultJSON(t, result)
+			for _, hidden := range []string{"secrets", "prod-key", "token", "cert.pem"} {
+				if strings.Contains(encoded, hidden) {
+					t.Errorf("result names %s under a deny rule: %s", hidden, encoded)
+				}
+			}
+			if !strings.Contains(encoded, "main.go") {
+				t.Errorf("result is missing main.go: %s", encoded)
+			}
+			for _, name := range []string{"secrets/prod-key.pem", "secrets/sub/token", "docs/cert.pem"} {
+				if got, _ := readTestFile(t, tc, name); !strings.HasPrefix(got, "TODO") {
+					t.Errorf("%s = %q after the call; want it untouched", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchFiles(t *testing.T) {
+	files := map[string]string{
+		".gitignore":     "build/\n",
+		"main.go":        "package main\n// TODO: tidy\n",
+		"lib/util.go":    "package lib\n// TODO: test\n",
+		"lib/notes.txt":  "TODO: docs\n",
+		"data.bin":       "TODO\x00\x01\x02",
+		"build/out.go":   "// TODO: generated\n",
+		"lib/latin1.txt": "TODO caf\xe9\n",
+	}
+	tests := []struct {
+		name string
+		args map[string]any
+		want []string // Matching files, in walk order
+	}{
+		{"whole tree", map[string]any{"pattern": "TODO"}, []string{"lib/notes.txt", "lib/util.go", "main.go"}},
+		{"glob", map[string]any{"pattern": "TODO", "glob": "*.go"}, []string{"lib/util.go", "main.go"}},
+		{"subdirectory", map[string]any{"pattern": "TODO", "path": "lib"}, []string{"lib/notes.txt", "lib/util.go"}},
+		{"ignored included", map[string]any{"pattern": "TODO", "glob": "*.go", "include_ignored": true}, []string{"build/out.go", "lib/util.go", "main.go"}},
+		{"no matches", map[string]any{"pattern": "FIXME"}, nil},
+	}
+	tc := newTestToolContext(t, files)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := searchFiles(context.Background(), tt.args, tc)
+			if !result.OK {
+				t.Fatalf("search_files failed: %s", result.Error.Message)
+			}
+			var got []string
+			for _, match := range result.Data["matches"].([]map[string]any) {
+				got = append(got, filepath.ToSlash(match["file"].(string)))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("matching files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchFilesStopsWhenCancelled(t *testing.T) {
+	tc := newTestToolContext(t, map[string]string{"a.txt": "TODO\n", "b/c.txt": "TODO\n"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := searchFiles(ctx, map[string]any{"pattern": "TODO"}, tc)
+	if result.OK || result.Error.Code != "timeout" {
+		t.Errorf("search_files with a cancelled context = %s, want a timeout error", resultJSON(t, result))
+	}
+}
+
+func TestLooksBinary(t *testing.T) {
+	// A multibyte rune cut off by the end of the sniffed window
+	split := strings.Repeat("a", binarySniffBytes-1) + "é"
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"empty", nil, false},
+		{"ascii", []byte("hello\nworld\n"), false},
+		{"utf-8", []byte("héllo wörld ✓\n"), false},
+		{"rune cut off at the end", []byte(split[:binarySniffBytes]), false},
+		{"nul byte", []byte("hello\x00world"), true},
+		{"png header", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), true},
+		{"latin-1", []byte("caf\xe9 au lait"), true},
+		{"invalid byte at the end", []byte("hello\xff"), true},
+		{"stray continuation bytes at the end", []byte("hello\x80\x80"), true},
+		{"rune start cut off", []byte("hello\xe2\x9c"), false},
+	}
+	for _, tt := range tests {
+		if got := looksBinary(tt.data); got != tt.want {
+			t.Errorf("%s: looksBinary = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadFileBinary(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01"
+	files := map[string]string{"image.png": png, "notes.txt": "line one\nline two\n", "empty.txt": ""}
+	wantData := func(key, want string) func(*testing.T, *ToolResult, *ToolContext) {
+		return func(t *testing.T, result *ToolResult, tc *ToolContext) {
+			if got, _ := result.Data[key].(string); got != want {
+				t.Errorf("%s = %q, want %q", key, got, want)
+			}
+		}
+	}
+	runToolCases(t, readFile, files, []toolCase{
+		{name: "text", args: map[string]any{"path": "notes.txt"}, check: wantData("content", files["notes.txt"])},
+		{name: "empty", args: map[string]any{"path": "empty.txt"}, check: wantData("content", "")},
+		{name: "png refused", args: map[string]any{"path": "image.png"}, wantErr: "binary_file",
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				for _, want := range []string{"20 bytes", "image/png"} {
+					if !strings.Contains(result.Error.Message, want) {
+						t.Errorf("message %q does not mention %q", result.Error.Message, want)
+					}
+				}
+			}},
+		{name: "png forced", args: map[string]any{"path": "image.png", "force": true},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				wantData("content", base64.StdEncoding.EncodeToString([]byte(png)))(t, result, tc)
+				wantData("encoding", "base64")(t, result, tc)
+				wantData("mime_type", "image/png")(t, result, tc)
+			}},
+		{name: "text forced is still text", args: map[string]any{"path": "notes.txt", "force": true},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				wantData("content", files["notes.txt"])(t, result, tc)
+				if _, ok := result.Data["encoding"]; ok {
+					t.Error("text was encoded")
+				}
+			}},
+		{name: "png with a line range", args: map[string]any{"path": "image.png", "start_line": 1}, wantErr: "binary_file"},
+		{name: "force not a boolean", args: map[string]any{"path": "image.png", "force": "yes"}, wantErr: "invalid_argument"},
+	})
+}
+
+func TestWriteFile(t *testing.T) {
+	files := map[string]string{"a.txt": "alpha", "dir/b.txt": "beta"}
+	runToolCases(t, writeFile, files, []toolCase{
+		{name: "overwrite", args: map[string]any{"path": "a.txt", "content": "new"},
+			want: map[string]string{"a.txt": "new"}},
+		{name: "new file", args: map[string]any{"path": "dir/c.txt", "content": "gamma"},
+			want: map[string]string{"dir/c.txt": "gamma"}},
+		{name: "creates intermediate directories", args: map[string]any{"path": "a/b/c.txt", "content": "deep"},
+			want: map[string]string{"a/b/c.txt": "deep"}},
+		{name: "create_dirs false with a missing parent", args: map[string]any{"path": "a/b/c.txt", "content": "deep", "create_dirs": false},
+			wantErr: "not_found", want: map[string]string{"a/b/c.txt": absent}},
+		{name: "create_dirs false with an existing parent", args: map[string]any{"path": "dir/c.txt", "content": "gamma", "create_dirs": false},
+			want: map[string]string{"dir/c.txt": "gamma"}},
+		{name: "parent outside the root", args: map[string]any{"path": "../escape/c.txt", "content": "x"},
+			wantErr: "permission_denied"},
+		{name: "missing parent that climbs out", args: map[string]any{"path": "a/../../escape/c.txt", "content": "x"},
+			wantErr: "permission_denied", want: map[string]string{"a": absent}},
+		{name: "dry run creates nothing", args: map[string]any{"path": "a/b/c.txt", "content": "deep"}, dryRun: true,
+			want: map[string]string{"a/b/c.txt": absent, "a": absent}},
+		{name: "create_dirs not a boolean", args: map[string]any{"path": "a/b/c.txt", "content": "x", "create_dirs": "yes"},
+			wantErr: "invalid_argument", want: map[string]string{"a/b/c.txt": absent}},
+	})
+}
+
+// listedNames returns the names in a plain list_files result.
+func listedNames(t *testing.T, result *ToolResult) []string {
+	t.Helper()
+	names, ok := result.Data["files"].([]string)
+	if !ok {
+		t.Fatalf("files is %T, want []string", result.Data["files"])
+	}
+	return names
+}
+
+func TestListFilesPagination(t *testing.T) {
+	files := map[string]string{}
+	for i := range 25 {
+		files[fmt.Sprintf("f%02d.txt", i)] = "x"
+	}
+	page := func(names []string, total int, hasMore bool) func(*testing.T, *ToolResult, *ToolContext) {
+		return func(t *testing.T, result *ToolResult, tc *ToolContext) {
+			if got := listedNames(t, result); !slices.Equal(got, names) {
+				t.Errorf("files = %q, want %q", got, names)
+			}
+			if result.Data["total"] != total || result.Data["has_more"] != hasMore {
+				t.Errorf("total = %v, has_more = %v, want %d, %t", result.Data["total"], result.Data["has_more"], total, hasMore)
+			}
+		}
+	}
+	runToolCases(t, listFiles, files, []toolCase{
+		{name: "default page holds everything", args: map[string]any{"path": "."},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				if got := len(listedNames(t, result)); got != 25 {
+					t.Errorf("got %d files, want 25", got)
+				}
+				if result.Data["page"] != 1 || result.Data["page_size"] != defaultListPageSize || result.Data["has_more"] != false {
+					t.Errorf("page = %v, page_size = %v, has_more = %v", result.Data["page"], result.Data["page_size"], result.Data["has_more"])
+				}
+			}},
+		{name: "first page", args: map[string]any{"path": ".", "page_size": 10},
+			check: page([]string{"f00.txt", "f01.txt", "f02.txt", "f03.txt", "f04.txt", "f05.txt", "f06.txt", "f07.txt", "f08.txt", "f09.txt"}, 25, true)},
+		{name: "middle page", args: map[string]any{"path": ".", "page": 2, "page_size": 10},
+			check: page([]string{"f10.txt", "f11.txt", "f12.txt", "f13.txt", "f14.txt", "f15.txt", "f16.txt", "f17.txt", "f18.txt", "f19.txt"}, 25, true)},
+		{name: "last page", args: map[string]any{"path": ".", "page": 3, "page_size": 10},
+			check: page([]string{"f20.txt", "f21.txt", "f22.txt", "f23.txt", "f24.txt"}, 25, false)},
+		{name: "exactly full last page", args: map[string]any{"path": ".", "page": 5, "page_size": 5},
+			check: page([]string{"f20.txt", "f21.txt", "f22.txt", "f23.txt", "f24.txt"}, 25, false)},
+		{name: "past the end", args: map[string]any{"path": ".", "page": 4, "page_size": 10},
+			check: page([]string{}, 25, false)},
+		{name: "page zero", args: map[string]any{"path": ".", "page": 0}, wantErr: "invalid_argument"},
+		{name: "page size zero", args: map[string]any{"path": ".", "page_size": 0}, wantErr: "invalid_argument"},
+		{name: "page size too large", args: map[string]any{"path": ".", "page_size": maxListPageSize + 1}, wantErr: "invalid_argument"},
+	})
+}
+
+func TestListFilesPattern(t *testing.T) {
+	files := map[string]string{
+		"main.go":          "",
+		"main_test.go":     "",
+		"test_data.json":   "",
+		"README.md":        "",
+		"tests/test_a.py":  "",
+		"pkg/util.go":      "",
+		"pkg/deep/more.go": "",
+	}
+	names := func(want ...string) func(*testing.T, *ToolResult, *ToolContext) {
+		return func(t *testing.T, result *ToolResult, tc *ToolContext) {
+			if got := listedNames(t, result); !slices.Equal(got, want) {
+				t.Errorf("files = %q, want %q", got, want)
+			}
+		}
+	}
+	runToolCases(t, listFiles, files, []toolCase{
+		{name: "no pattern", args: map[string]any{"path": "."},
+			check: names("README.md", "main.go", "main_test.go", "pkg/", "test_data.json", "tests/")},
+		{name: "extension", args: map[string]any{"path": ".", "pattern": "*.go"},
+			check: names("main.go", "main_test.go")},
+		{name: "prefix keeps directories' slash", args: map[string]any{"path": ".", "pattern": "test*"},
+			check: names("test_data.json", "tests/")},
+		{name: "recursive matches base names", args: map[string]any{"path": ".", "pattern": "*.go", "recursive": true},
+			check: names("main.go", "main_test.go", "pkg/deep/more.go", "pkg/util.go")},
+		{name: "recursive descends into unmatched directories", args: map[string]any{"path": ".", "pattern": "test_*", "recursive": true},
+			check: names("test_data.json", "tests/test_a.py")},
+		{name: "no matches", args: map[string]any{"path": ".", "pattern": "*.rs"},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				if got := listedNames(t, result); len(got) != 0 || result.Data["total"] != 0 {
+					t.Errorf("files = %q, total = %v, want none", got, result.Data["total"])
+				}
+			}},
+		{name: "pages after filtering", args: map[string]any{"path": ".", "pattern": "*.go", "recursive": true, "page": 2, "page_size": 2},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				names("pkg/deep/more.go", "pkg/util.go")(t, result, tc)
+				if result.Data["total"] != 4 || result.Data["has_more"] != false {
+					t.Errorf("total = %v, has_more = %v, want 4, false", result.Data["total"], result.Data["has_more"])
+				}
+			}},
+		{name: "invalid pattern", args: map[string]any{"path": ".", "pattern": "[a-"}, wantErr: "invalid_argument"},
+	})
+}
+
+func TestListFilesDetails(t *testing.T) {
+	files := map[string]string{"a.txt": "hello", "sub/b.txt": "hi"}
+	runToolCases(t, listFiles, files, []toolCase{
+		{name: "plain names by default", args: map[string]any{"path": "."},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				if got := listedNames(t, result); !slices.Equal(got, []string{"a.txt", "sub/"}) {
+					t.Errorf("files = %q", got)
+				}
+			}},
+		{name: "details", args: map[string]any{"path": ".", "details": true},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				details, ok := result.Data["files"].([]map[string]any)
+				if !ok || len(details) != 2 {
+					t.Fatalf("files = %#v, want two detail objects", result.Data["files"])
+				}
+				want := []struct {
+					name  string
+					isDir bool
+				}{{"a.txt", false}, {"sub/", true}}
+				for i, d := range details {
+					if d["name"] != want[i].name || d["is_dir"] != want[i].isDir {
+						t.Errorf("entry %d = %v, want %s (dir %t)", i, d, want[i].name, want[i].isDir)
+					}
+					if _, err := time.Parse(time.RFC3339, d["mod_time"].(string)); err != nil {
+						t.Errorf("entry %d mod_time: %v", i, err)
+					}
+					if _, hasErr := d["error"]; hasErr {
+						t.Errorf("entry %d has error %v", i, d["error"])
+					}
+				}
+				if details[0]["size"] != int64(5) {
+					t.Errorf("a.txt size = %v, want 5", details[0]["size"])
+				}
+			}},
+		{name: "details recursive", args: map[string]any{"path": ".", "details": true, "recursive": true, "pattern": "b.txt"},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				details := result.Data["files"].([]map[string]any)
+				if len(details) != 1 || details[0]["name"] != "sub/b.txt" || details[0]["size"] != int64(2) {
+					t.Errorf("files = %v, want sub/b.txt of size 2", details)
+				}
+			}},
+		{name: "details not a bool", args: map[string]any{"path": ".", "details": "yes"}, wantErr: "invalid_argument"},
+	})
+}
+
+func TestEntryDetailsReportsUnreadableInfo(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"kept.txt": "abc", "gone.txt": "x"})
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The entry's info is read lazily, so removing the file now makes it fail
+	if err := os.Remove(filepath.Join(dir, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	var list []listEntry
+	for _, e := range entries {
+		list = append(list, listEntry{name: e.Name(), entry: e})
+	}
+
+	details := entryDetails(list)
+	if len(details) != 2 {
+		t.Fatalf("got %d entries, want 2", len(details))
+	}
+	gone, kept := details[0], details[1]
+	if gone["name"] != "gone.txt" || kept["name"] != "kept.txt" {
+		t.Fatalf("entries = %v", details)
+	}
+	msg, _ := gone["error"].(string)
+	if !strings.HasPrefix(msg, "failed to read entry info") || strings.Contains(msg, dir) {
+		t.Errorf("error = %q, want the cause without the absolute path", msg)
+	}
+	if _, hasSize := gone["size"]; hasSize {
+		t.Error("unreadable entry reports a size")
+	}
+	if kept["size"] != int64(3) || kept["error"] != nil {
+		t.Errorf("readable entry = %v", kept)
+	}
+}
+
+func TestStatFile(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "alpha", "dir/b.txt": "beta"})
+	if err := os.Symlink("a.txt", filepath.Join(root, "link")); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+	sandbox, err := NewPathSandbox(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := NewToolContext(sandbox, slog.New(slog.DiscardHandler))
+
+	tests := []struct {
+		path    string
+		wantErr string
+		want    map[string]any // Expected fields; mod_time is only checked for presence
+	}{
+		{"a.txt", "", map[string]any{"exists": true, "is_dir": false, "is_symlink": false, "size": int64(5), "mode": "-rw-r--r--"}},
+		{"dir", "", map[string]any{"exists": true, "is_dir": true, "is_symlink": false}},
+		{"link", "", map[string]any{"exists": true, "is_dir": false, "is_symlink": true}},
+		{"missing.txt", "", map[string]any{"exists": false}},
+		{"nowhere/missing.txt", "", map[string]any{"exists": false}},
+		{"../outside", "permission_denied", nil},
+		{"", "invalid_argument", nil},
+	}
+	for _, tt := range tests {
+		result := statFile(context.Background(), map[string]any{"path": tt.path}, tc)
+		if tt.wantErr != "" {
+			if result.OK || result.Error.Code != tt.wantErr {
+				t.Errorf("stat_file %q = %s, want error %s", tt.path, resultJSON(t, result), tt.wantErr)
+			}
+			continue
+		}
+		if !result.OK {
+			t.Errorf("stat_file %q failed: %s", tt.path, resultJSON(t, result))
+			continue
+		}
+		for key, want := range tt.want {
+			if got := result.Data[key]; got != want {
+				t.Errorf("stat_file %q: %s = %v, want %v", tt.path, key, got, want)
+			}
+		}
+		if _, ok := result.Data["mod_time"]; ok != tt.want["exists"] {
+			t.Errorf("stat_file %q: mod_time present = %v, want %v", tt.path, ok, tt.want["exists"])
+		}
+	}
+}
+
+func TestWritesKeepMode(t *testing.T) {
+	patch := "--- a/run.sh\n+++ b/run.sh\n@@ -1 +1 @@\n-#!/bin/sh\n+#!/bin/bash\n"
+	tests := []struct {
+		name     string
+		run      func(context.Context, map[string]any, *ToolContext) *ToolResult
+		args     map[string]any
+		path     string
+		wantMode os.FileMode
+	}{
+		{"write executable", writeFile, map[string]any{"path": "run.sh", "content": "#!/bin/bash\n"}, "run.sh", 0755},
+		{"write private", writeFile, map[string]any{"path": "secret.txt", "content": "new"}, "secret.txt", 0600},
+		{"write new", writeFile, map[string]any{"path": "new.txt", "content": "new"}, "new.txt", 0644},
+		{"edit executable", editFile, map[string]any{"path": "run.sh", "old_str": "sh", "new_str": "bash"}, "run.sh", 0755},
+		{"edit creates", editFile, map[string]any{"path": "new.txt", "old_str": "", "new_str": "new"}, "new.txt", 0644},
+		{"patch executable", applyPatch, map[string]any{"patch": patch}, "run.sh", 0755},
+	}
+	policies := map[string]SymlinkPolicy{"root": SymlinkWithinRoot, "os": SymlinkAllow}
+	for fsName, policy := range policies {
+		for _, tt := range tests {
+			t.Run(fsName+"/"+tt.name, func(t *testing.T) {
+				tc := newTestToolContext(t, map[string]string{"run.sh": "#!/bin/sh\n", "secret.txt": "old"}, WithSymlinkPolicy(policy))
+				for name, mode := range map[string]os.FileMode{"run.sh": 0755, "secret.txt": 0600} {
+					if err := os.Chmod(filepath.Join(tc.Sandbox.Root, name), mode); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if result := tt.run(context.Background(), tt.args, tc); !result.OK {
+					t.Fatalf("failed: %s", resultJSON(t, result))
+				}
+				info, err := os.Stat(filepath.Join(tc.Sandbox.Root, tt.path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := info.Mode().Perm(); got != tt.wantMode {
+					t.Errorf("%s mode = %v, want %v", tt.path, got, tt.wantMode)
+				}
+			})
+		}
+	}
+}
+
+func TestWriteQuota(t *testing.T) {
+	tc := newTestToolContext(t, map[string]string{"a.txt": "alpha"}, WithWriteQuota(20))
+	ctx := context.Background()
+	steps := []struct {
+		name     string
+		run      func(context.Context, map[string]any, *ToolContext) *ToolResult
+		args     map[string]any
+		wantErr  string
+		wantFile string // Content of a.txt afterwards
+	}{
+		{"write under the quota", writeFile, map[string]any{"path": "a.txt", "content": "0123456789"}, "", "0123456789"},
+		{"edit under the quota", editFile, map[string]any{"path": "a.txt", "old_str": "0123", "new_str": "abc"}, "", "abc456789"},
+		{"write crossing the quota", writeFile, map[string]any{"path": "a.txt", "content": "0123456789"}, "quota_exceeded", "abc456789"},
+		{"edit crossing the quota", editFile, map[string]any{"path": "a.txt", "old_str": "abc", "new_str": "xyz"}, "quota_exceeded", "abc456789"},
+		{"dry runs are free", writeFile, map[string]any{"path": "a.txt", "content": "0123456789"}, "", "abc456789"},
+		{"write that still fits", writeFile, map[string]any{"path": "a.txt", "content": "z"}, "", "z"},
+		{"quota used up", writeFile, map[string]any{"path": "a.txt", "content": "y"}, "quota_exceeded", "z"},
+	}
+	for _, step := range steps {
+		tc.DryRun = step.name == "dry runs are free"
+		result := step.run(ctx, step.args, tc)
+		if step.wantErr != "" {
+			if result.OK || result.Error.Code != step.wantErr {
+				t.Errorf("%s: result = %s, want error %s", step.name, resultJSON(t, result), step.wantErr)
+			}
+		} else if !result.OK {
+			t.Errorf("%s: failed: %s", step.name, resultJSON(t, result))
+		}
+		if got, _ := readTestFile(t, tc, "a.txt"); got != step.wantFile {
+			t.Errorf("%s: a.txt = %q, want %q", step.name, got, step.wantFile)
+		}
+	}
+}
+
+func TestAppendToFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     map[string]any
+		quota    int64
+		dryRun   bool
+		wantErr  string // Error code; "" for success
+		wantFile string // Content of log.txt afterwards
+	}{
+		{"existing file", map[string]any{"path": "log.txt", "content": "two\n"}, 0, false, "", "one\ntwo\n"},
+		{"new file", map[string]any{"path": "new.txt", "content": "fresh\n"}, 0, false, "", "one\n"},
+		{"directory", map[string]any{"path": "dir", "content": "x"}, 0, false, "invalid_argument", "one\n"},
+		{"missing parent", map[string]any{"path": "nowhere/log.txt", "content": "x"}, 0, false, "not_found", "one\n"},
+		{"dry run", map[string]any{"path": "log.txt", "content": "two\n"}, 0, true, "", "one\n"},
+		{"over quota", map[string]any{"path": "log.txt", "content": "two\n"}, 2, false, "quota_exceeded", "one\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, map[string]string{"log.txt": "one\n", "dir/keep": ""}, WithWriteQuota(tt.quota))
+			tc.DryRun = tt.dryRun
+			result := appendToFile(context.Background(), tt.args, tc)
+			if tt.wantErr != "" {
+				if result.OK || result.Error.Code != tt.wantErr {
+					t.Errorf("append_to_file = %s, want error %s", resultJSON(t, result), tt.wantErr)
+				}
+			} else if !result.OK {
+				t.Errorf("append_to_file failed: %s", result.Error.Message)
+			}
+			if got, _ := readTestFile(t, tc, "log.txt"); got != tt.wantFile {
+				t.Errorf("log.txt = %q, want %q", got, tt.wantFile)
+			}
+			if tt.name == "new file" {
+				if got, _ := readTestFile(t, tc, "new.txt"); got != "fresh\n" {
+					t.Errorf("new.txt = %q, want %q", got, "fresh\n")
+				}
+			}
+			if _, recorded := tc.Journal.peek(); recorded != (result.OK && !tt.dryRun) {
+				t.Errorf("journal entry recorded = %v, want %v", recorded, result.OK && !tt.dryRun)
+			}
+		})
+	}
+}
+
+func TestEditFile(t *testing.T) {
+	files := map[string]string{"main.go": "package main\n\nfunc main() {}\n", "dup.txt": "a\na\n"}
+	runToolCases(t, editFile, files, []toolCase{
+		{name: "edit", args: map[string]any{"path": "main.go", "old_str": "func main() {}", "new_str": "func main() { run() }"},
+			want: map[string]string{"main.go": "package main\n\nfunc main() { run() }\n"}},
+		{name: "empty old_str creates a missing file", args: map[string]any{"path": "new.go", "old_str": "", "new_str": "package main\n"},
+			want: map[string]string{"new.go": "package main\n"}},
+		{name: "empty old_str creates in a subdirectory", args: map[string]any{"path": "nested/new.go", "old_str": "", "new_str": "x"},
+			wantErr: "not_found", want: map[string]string{"nested/new.go": absent}},
+		{name: "empty old_str on an existing file", args: map[string]any{"path": "main.go", "old_str": "", "new_str": "x"},
+			wantErr: "invalid_argument", want: map[string]string{"main.go": files["main.go"]}},
+		{name: "empty old_str outside the root", args: map[string]any{"path": "../escape.go", "old_str": "", "new_str": "x"},
+			wantErr: "permission_denied"},
+		{name: "old_str missing", args: map[string]any{"path": "main.go", "old_str": "func other()", "new_str": "x"},
+			wantErr: "invalid_argument"},
+		{name: "old_str ambiguous", args: map[string]any{"path": "dup.txt", "old_str": "a", "new_str": "b"},
+			wantErr: "invalid_argument", want: map[string]string{"dup.txt": "a\na\n"}},
+		{name: "no change", args: map[string]any{"path": "main.go", "old_str": "main", "new_str": "main"},
+			wantErr: "invalid_argument"},
+		{name: "missing file with old_str", args: map[string]any{"path": "none.go", "old_str": "x", "new_str": "y"},
+			wantErr: "io_error", want: map[string]string{"none.go": absent}},
+		{name: "dry run create", args: map[string]any{"path": "new.go", "old_str": "", "new_str": "x"}, dryRun: true,
+			want: map[string]string{"new.go": absent}},
+		{name: "undo removes a created file", args: map[string]any{"path": "new.go", "old_str": "", "new_str": "x"},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				if undo := undoLastEdit(context.Background(), map[string]any{}, tc); !undo.OK {
+					t.Fatalf("undo failed: %s", resultJSON(t, undo))
+				}
+				if _, ok := readTestFile(t, tc, "new.go"); ok {
+					t.Error("new.go survived the undo")
+				}
+			}},
+	})
+}
+
+func TestApplyPatch(t *testing.T) {
+	files := map[string]string{"a.txt": "one\ntwo\nthree\n", "b.txt": "alpha\nbeta\n"}
+	editA := "--- a/a.txt\n+++ b/a.txt\n@@ -2 +2 @@\n-two\n+TWO\n"
+	editB := "--- a/b.txt\n+++ b/b.txt\n@@ -1,2 +1,2 @@\n alpha\n-beta\n+BETA\n"
+	badB := "--- a/b.txt\n+++ b/b.txt\n@@ -1,2 +1,2 @@\n alpha\n-gamma\n+GAMMA\n"
+	create := "--- /dev/null\n+++ b/new/c.txt\n@@ -0,0 +1,2 @@\n+see\n+sea\n"
+	unchanged := map[string]string{"a.txt": files["a.txt"], "b.txt": files["b.txt"], "new/c.txt": absent}
+	runToolCases(t, applyPatch, files, []toolCase{
+		{name: "clean apply", args: map[string]any{"patch": editA + editB},
+			want: map[string]string{"a.txt": "one\nTWO\nthree\n", "b.txt": "alpha\nBETA\n"}},
+		{name: "new file", args: map[string]any{"patch": create},
+			want: map[string]string{"new/c.txt": "see\nsea\n"}},
+		{name: "context mismatch rejects every file", args: map[string]any{"patch": editA + create + badB},
+			wantErr: "conflict", want: unchanged,
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				if !strings.Contains(result.Error.Message, "hunk 1 of b.txt") {
+					t.Errorf("message %q does not name the failed hunk", result.Error.Message)
+				}
+			}},
+		{name: "creating an existing file", args: map[string]any{"patch": "--- /dev/null\n+++ b/a.txt\n@@ -0,0 +1 @@\n+x\n"},
+			wantErr: "conflict", want: unchanged},
+		{name: "missing file", args: map[string]any{"patch": "--- a/none.txt\n+++ b/none.txt\n@@ -1 +1 @@\n-a\n+b\n"},
+			wantErr: "not_found"},
+		{name: "deletion", args: map[string]any{"patch": "--- a/a.txt\n+++ /dev/null\n@@ -1,3 +0,0 @@\n-one\n-two\n-three\n"},
+			wantErr: "invalid_argument", want: unchanged},
+		{name: "rename", args: map[string]any{"patch": "--- a/a.txt\n+++ b/z.txt\n@@ -2 +2 @@\n-two\n+TWO\n"},
+			wantErr: "invalid_argument", want: unchanged},
+		{name: "same file twice", args: map[string]any{"patch": editA + editA},
+			wantErr: "invalid_argument", want: unchanged},
+		{name: "outside the root", args: map[string]any{"patch": "--- /dev/null\n+++ b/../escape.txt\n@@ -0,0 +1 @@\n+x\n"},
+			wantErr: "permission_denied"},
+		{name: "malformed", args: map[string]any{"patch": "--- a/a.txt\n+++ b/a.txt\n@@ -2,2 +2,2 @@\n-two\n"},
+			wantErr: "invalid_argument", want: unchanged},
+		{name: "no files", args: map[string]any{"patch": "just some text\n"},
+			wantErr: "invalid_argument"},
+		{name: "dry run", args: map[string]any{"patch": editA + create}, dryRun: true, want: unchanged,
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				if diff, _ := result.Data["diff"].(string); !strings.Contains(diff, "+TWO") {
+					t.Errorf("dry run diff = %q, want the change", diff)
+				}
+			}},
+		{name: "undo reverts every file", args: map[string]any{"patch": editA + create + editB},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				if undo := undoLastEdit(context.Background(), map[string]any{}, tc); !undo.OK {
+					t.Fatalf("undo failed: %s", resultJSON(t, undo))
+				}
+				for name, want := range unchanged {
+					if got, ok := readTestFile(t, tc, name); ok != (want != absent) || (ok && got != want) {
+						t.Errorf("%s = %q (exists %v) after undo, want %q", name, got, ok, want)
+					}
+				}
+			}},
+	})
+}
+
+func TestApplyPatchRestoresOnWriteFailure(t *testing.T) {
+	files := map[string]string{"a.txt": "one\ntwo\n", "b.txt": "alpha\nbeta\n"}
+	patch := "--- a/a.txt\n+++ b/a.txt\n@@ -2 +2 @@\n-two\n+TWO\n" +
+		"--- /dev/null\n+++ b/c.txt\n@@ -0,0 +1 @@\n+see\n" +
+		"--- a/b.txt\n+++ b/b.txt\n@@ -2 +2 @@\n-beta\n+BETA\n"
+	// The quota covers the first two files but not the third
+	tc := newTestToolContext(t, files, WithWriteQuota(int64(len("one\nTWO\n")+len("see\n")+1)))
+	result := applyPatch(context.Background(), map[string]any{"patch": patch}, tc)
+	if result.OK || result.Error.Code != "quota_exceeded" {
+		t.Fatalf("apply_patch = %s, want quota_exceeded", resultJSON(t, result))
+	}
+	for name, want := range map[string]string{"a.txt": files["a.txt"], "b.txt": files["b.txt"], "c.txt": absent} {
+		got, ok := readTestFile(t, tc, name)
+		switch {
+		case want == absent && ok:
+			t.Errorf("%s exists with %q after the failed patch", name, got)
+		case want != absent && got != want:
+			t.Errorf("%s = %q after the failed patch, want %q", name, got, want)
+		}
+	}
+	if _, ok := tc.Journal.peek(); ok {
+		t.Error("failed patch was journaled")
+	}
+}
+
+func TestReplaceInFiles(t *testing.T) {
+	files := map[string]string{
+		".gitignore":     "build/\n",
+		"a.go":           "oldName(oldName)\n",
+		"b.txt":          "oldName\n",
+		"sub/c.go":       "x := oldName\n",
+		"build/gen.go":   "oldName\n",
+		"image.bin":      "oldName\x00\x01",
+		"sub/nomatch.go": "other\n",
+	}
+	unchanged := map[string]string{"a.go": files["a.go"], "b.txt": files["b.txt"], "sub/c.go": files["sub/c.go"]}
+	// counts returns the per-file replacement counts in the result.
+	counts := func(t *testing.T, result *ToolResult) map[string]int {
+		t.Helper()
+		entries, _ := result.Data["files"].([]map[string]any)
+		got := make(map[string]int)
+		for _, entry := range entries {
+			got[entry["file"].(string)] = entry["replacements"].(int)
+		}
+		return got
+	}
+	wantCounts := func(want map[string]int, total int) func(*testing.T, *ToolResult, *ToolContext) {
+		return func(t *testing.T, result *ToolResult, tc *ToolContext) {
+			if got := counts(t, result); !maps.Equal(got, want) {
+				t.Errorf("files = %v, want %v", got, want)
+			}
+			if got := result.Data["total_replacements"]; got != total {
+				t.Errorf("total_replacements = %v, want %d", got, total)
+			}
+		}
+	}
+	runToolCases(t, replaceInFiles, files, []toolCase{
+		{name: "whole tree", args: map[string]any{"pattern": "oldName", "replacement": "newName"},
+			want: map[string]string{"a.go": "newName(newName)\n", "b.txt": "newName\n", "sub/c.go": "x := newName\n",
+				"build/gen.go": "oldName\n", "image.bin": "oldName\x00\x01", "sub/nomatch.go": "other\n"},
+			check: wantCounts(map[string]int{"a.go": 2, "b.txt": 1, "sub/c.go": 1}, 4)},
+		{name: "glob", args: map[string]any{"pattern": "oldName", "replacement": "newName", "glob": "*.go"},
+			want:  map[string]string{"a.go": "newName(newName)\n", "b.txt": "oldName\n", "sub/c.go": "x := newName\n"},
+			check: wantCounts(map[string]int{"a.go": 2, "sub/c.go": 1}, 3)},
+		{name: "path", args: map[string]any{"pattern": "oldName", "replacement": "newName", "path": "sub"},
+			want:  map[string]string{"a.go": files["a.go"], "sub/c.go": "x := newName\n"},
+			check: wantCounts(map[string]int{"sub/c.go": 1}, 1)},
+		{name: "submatches", args: map[string]any{"pattern": `old(\w+)\(`, "replacement": "new${1}("},
+			want: map[string]string{"a.go": "newName(oldName)\n", "b.txt": "oldName\n"}},
+		{name: "dry run option", args: map[string]any{"pattern": "oldName", "replacement": "newName", "dry_run": true},
+			want: unchanged,
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				diff, _ := result.Data["diff"].(string)
+				if !strings.Contains(diff, "+newName(newName)") || !strings.Contains(diff, "-x := oldName") {
+					t.Errorf("dry run diff = %q, want every change", diff)
+				}
+				if result.Data["simulated"] != true {
+					t.Error("dry run result is not marked simulated")
+				}
+				if _, ok := tc.Journal.peek(); ok {
+					t.Error("dry run was journaled")
+				}
+			}},
+		{name: "session dry run", args: map[string]any{"pattern": "oldName", "replacement": "newName"}, dryRun: true,
+			want: unchanged},
+		{name: "undo reverts every file", args: map[string]any{"pattern": "oldName", "replacement": "newName"},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				if undo := undoLastEdit(context.Background(), map[string]any{}, tc); !undo.OK {
+					t.Fatalf("undo failed: %s", resultJSON(t, undo))
+				}
+				for name, want := range unchanged {
+					if got, _ := readTestFile(t, tc, name); got != want {
+						t.Errorf("%s = %q after undo, want %q", name, got, want)
+					}
+				}
+			}},
+		{name: "no matches", args: map[string]any{"pattern": "missing", "replacement": "x"},
+			wantErr: "not_found", want: unchanged},
+		{name: "invalid pattern", args: map[string]any{"pattern": "(", "replacement": "x"},
+			wantErr: "invalid_argument", want: unchanged},
+		{name: "invalid glob", args: map[string]any{"pattern": "oldName", "replacement": "x", "glob": "["},
+			wantErr: "invalid_argument", want: unchanged},
+		{name: "missing replacement", args: map[string]any{"pattern": "oldName"},
+			wantErr: "invalid_argument", want: unchanged},
+		{name: "outside the root", args: map[string]any{"pattern": "oldName", "replacement": "x", "path": ".."},
+			wantErr: "permission_denied"},
+	})
+}
+
+func TestReplaceInFilesReportsPartialWrites(t *testing.T) {
+	files := map[string]string{"a.txt": "old\n", "b.txt": "old\n", "c.txt": "old\n"}
+	// The quota covers the first file but not the second
+	tc := newTestToolContext(t, files, WithWriteQuota(int64(len("new\n")+1)))
+	result := replaceInFiles(context.Background(), map[string]any{"pattern": "old", "replacement": "new"}, tc)
+	if result.OK || result.Error.Code != "quota_exceeded" {
+		t.Fatalf("replace_in_files = %s, want quota_exceeded", resultJSON(t, result))
+	}
+	if msg := result.Error.Message; !strings.Contains(msg, "failed to write b.txt") || !strings.Contains(msg, "already updated: a.txt") {
+		t.Errorf("message %q does not say which files were written", msg)
+	}
+	if len(result.Error.Suggestions) == 0 {
+		t.Error("no suggestion to undo the written files")
+	}
+	for name, want := range map[string]string{"a.txt": "new\n", "b.txt": "old\n", "c.txt": "old\n"} {
+		if got, _ := readTestFile(t, tc, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if undo := undoLastEdit(context.Background(), map[string]any{}, tc); !undo.OK {
+		t.Fatalf("undo failed: %s", resultJSON(t, undo))
+	}
+	if got, _ := readTestFile(t, tc, "a.txt"); got != "old\n" {
+		t.Errorf("a.txt = %q after undo, want the original", got)
+	}
+}
+
+// fixedCountClient answers every CountTokens call with the same count and
+// records the text it was asked about.
+type fixedCountClient struct {
+	*ReplayClient
+	count int32
+	err   error
+	texts []string
+}
+
+func (c *fixedCountClient) CountTokens(ctx context.Context, model string, contents []*genai.Content, config *genai.CountTokensConfig) (*genai.CountTokensResponse, error) {
+	for _, content := range contents {
+		c.texts = append(c.texts, contentText(content))
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &genai.CountTokensResponse{TotalTokens: c.count}, nil
+}
+
+func TestCountTokens(t *testing.T) {
+	files := map[string]string{"main.go": "package main\n", "image.png": "\x89PNG\r\n\x1a\n\x00\x00", "dir/keep": ""}
+	tests := []struct {
+		name      string
+		args      map[string]any
+		counter   bool  // Whether the session can count tokens
+		clientErr error // Returned by the client
+		maxRead   int64 // Read limit; 0 keeps the default
+		wantErr   string
+		wantText  string // Text sent to the client
+		want      map[string]any
+	}{
+		{"text", map[string]any{"text": "hello world"}, true, nil, 0, "", "hello world", map[string]any{"tokens": 42}},
+		{"path", map[string]any{"path": "main.go"}, true, nil, 0, "", "package main\n", map[string]any{"tokens": 42, "path": "main.go", "bytes": 13}},
+		{"neither", map[string]any{}, true, nil, 0, "invalid_argument", "", nil},
+		{"both", map[string]any{"text": "x", "path": "main.go"}, true, nil, 0, "invalid_argument", "", nil},
+		{"no counter", map[string]any{"text": "x"}, false, nil, 0, "invalid_argument", "", nil},
+		{"missing file", map[string]any{"path": "none.go"}, true, nil, 0, "not_found", "", nil},
+		{"outside the root", map[string]any{"path": ".."}, true, nil, 0, "permission_denied", "", nil},
+		{"directory", map[string]any{"path": "dir"}, true, nil, 0, "invalid_argument", "", nil},
+		{"binary", map[string]any{"path": "image.png"}, true, nil, 0, "binary_file", "", nil},
+		{"too large", map[string]any{"path": "main.go"}, true, nil, 4, "too_large", "", nil},
+		{"client fails", map[string]any{"text": "x"}, true, errors.New("unavailable"), 0, "network_error", "x", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, files)
+			client := &fixedCountClient{ReplayClient: NewScriptedClient(), count: 42, err: tt.clientErr}
+			if tt.counter {
+				tc.TokenCounter = newTestAgent(t, client, nil)
+			}
+			if tt.maxRead > 0 {
+				tc.MaxReadBytes = tt.maxRead
+			}
+
+			result := countTokens(context.Background(), tt.args, tc)
+			if tt.wantErr != "" {
+				if result.OK || result.Error.Code != tt.wantErr {
+					t.Errorf("count_tokens = %s, want error %s", resultJSON(t, result), tt.wantErr)
+				}
+			} else if !result.OK {
+				t.Fatalf("count_tokens failed: %s", resultJSON(t, result))
+			}
+			for key, want := range tt.want {
+				if got := result.Data[key]; got != want {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+			var wantTexts []string
+			if tt.wantText != "" {
+				wantTexts = []string{tt.wantText}
+			}
+			if !slices.Equal(client.texts, wantTexts) {
+				t.Errorf("client counted %q, want %q", client.texts, wantTexts)
+			}
+		})
+	}
+}
+
+// crossDeviceFS is the local disk with every rename failing as it does
+// across devices.
+type crossDeviceFS struct{ osFileSystem }
+
+func (crossDeviceFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+}
+
+func TestMoveFile(t *testing.T) {
+	files := map[string]string{"a.txt": "alpha", "b.txt": "beta", "dir/c.txt": "gamma"}
+	tests := []toolCase{
+		{name: "rename", args: map[string]any{"source": "a.txt", "destination": "renamed.txt"},
+			want: map[string]string{"a.txt": absent, "renamed.txt": "alpha"}},
+		{name: "into a directory", args: map[string]any{"source": "a.txt", "destination": "dir/a.txt"},
+			want: map[string]string{"a.txt": absent, "dir/a.txt": "alpha"}},
+		{name: "directory", args: map[string]any{"source": "dir", "destination": "moved"},
+			want: map[string]string{"dir/c.txt": absent, "moved/c.txt": "gamma"}},
+		{name: "existing destination", args: map[string]any{"source": "a.txt", "destination": "b.txt"},
+			wantErr: "invalid_argument", want: map[string]string{"a.txt": "alpha", "b.txt": "beta"}},
+		{name: "overwrite", args: map[string]any{"source": "a.txt", "destination": "b.txt", "overwrite": true},
+			want: map[string]string{"a.txt": absent, "b.txt": "alpha"}},
+		{name: "missing source", args: map[string]any{"source": "none.txt", "destination": "x.txt"},
+			wantErr: "not_found"},
+		{name: "missing destination parent", args: map[string]any{"source": "a.txt", "destination": "nowhere/a.txt"},
+			wantErr: "not_found", want: map[string]string{"a.txt": "alpha"}},
+		{name: "source outside the root", args: map[string]any{"source": "../a.txt", "destination": "x.txt"},
+			wantErr: "permission_denied"},
+		{name: "destination outside the root", args: map[string]any{"source": "a.txt", "destination": "../a.txt"},
+			wantErr: "permission_denied", want: map[string]string{"a.txt": "alpha"}},
+		{name: "the root", args: map[string]any{"source": ".", "destination": "elsewhere"},
+			wantErr: "permission_denied"},
+		{name: "dry run", args: map[string]any{"source": "a.txt", "destination": "renamed.txt"}, dryRun: true,
+			want: map[string]string{"a.txt": "alpha", "renamed.txt": absent}},
+		{name: "undo restores an overwritten destination", args: map[string]any{"source": "a.txt", "destination": "b.txt", "overwrite": true},
+			check: func(t *testing.T, result *ToolResult, tc *ToolContext) {
+				if undo := undoLastEdit(context.Background(), map[string]any{}, tc); !undo.OK {
+					t.Fatalf("undo failed: %s", resultJSON(t, undo))
+				}
+				for name, want := range map[string]string{"a.txt": "alpha", "b.txt": "beta"} {
+					if got, _ := readTestFile(t, tc, name); got != want {
+						t.Errorf("%s after undo = %q, want %q", name, got, want)
+					}
+				}
+			}},
+	}
+	runToolCases(t, moveFile, files, tests)
+
+	t.Run("across devices", func(t *testing.T) {
+		runToolCases(t, moveFile, files, []toolCase{
+			{name: "copies and deletes", args: map[string]any{"source": "a.txt", "destination": "dir/a.txt"},
+				want: map[string]string{"a.txt": absent, "dir/a.txt": "alpha"}},
+		}, WithFileSystem(crossDeviceFS{}))
+	})
+}
+
+// roundTripFunc is an http.RoundTripper answering requests with a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// jsonResponse is a response with the given status and body.
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestDeleteAndMoveSymlinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		run     func(context.Context, map[string]any, *ToolContext) *ToolResult
+		args    map[string]any
+		wantErr string
+		gone    string // Path under the root that no longer exists, if any
+	}{
+		{"delete a link to an outside file", deleteFile, map[string]any{"path": "escape_file"}, "", "escape_file"},
+		{"delete a link to an outside directory", deleteFile, map[string]any{"path": "escape_dir"}, "", "escape_dir"},
+		{"delete a link to an outside directory, recursive", deleteFile, map[string]any{"path": "escape_dir", "recursive": true}, "", "escape_dir"},
+		{"delete through a link to an outside directory", deleteFile, map[string]any{"path": "escape_dir/secret.txt"}, "permission_denied", ""},
+		{"delete a directory holding a link", deleteFile, map[string]any{"path": "links", "recursive": true}, "", "links"},
+		{"move a link to an outside file", moveFile, map[string]any{"source": "escape_file", "destination": "moved"}, "", "escape_file"},
+		{"move through a link to an outside directory", moveFile, map[string]any{"source": "escape_dir/secret.txt", "destination": "stolen.txt"}, "permission_denied", ""},
+		{"move onto a link to an outside file", moveFile, map[string]any{"source": "inside.txt", "destination": "escape_file", "overwrite": true}, "permission_denied", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			root := filepath.Join(base, "project")
+			outside := filepath.Join(base, "outside")
+			writeTree(t, root, map[string]string{"inside.txt": "inside", "links/keep": ""})
+			writeTree(t, outside, map[string]string{"secret.txt": "TOPSECRET"})
+			for link, target := range map[string]string{
+				"escape_file":     filepath.Join(outside, "secret.txt"),
+				"escape_dir":      outside,
+				"links/to_secret": filepath.Join(outside, "secret.txt"),
+			} {
+				if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+					t.Skipf("cannot create symlinks: %v", err)
+				}
+			}
+			sandbox, err := NewPathSandbox(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc := NewToolContext(sandbox, slog.New(slog.DiscardHandler))
+
+			result := tt.run(context.Background(), tt.args, tc)
+			if tt.wantErr != "" {
+				if result.OK || result.Error.Code != tt.wantErr {
+					t.Errorf("result = %s, want error %s", resultJSON(t, result), tt.wantErr)
+				}
+			} else if !result.OK {
+				t.Errorf("failed: %s", resultJSON(t, result))
+			}
+
+			// The outside target is never touched
+			if got, err := os.ReadFile(filepath.Join(outside, "secret.txt")); err != nil || string(got) != "TOPSECRET" {
+				t.Errorf("outside secret.txt = %q, %v; want it untouched", got, err)
+			}
+			if tt.gone != "" {
+				if _, err := os.Lstat(filepath.Join(root, tt.gone)); !os.IsNotExist(err) {
+					t.Errorf("%s still exists (%v)", tt.gone, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetWeather(t *testing.T) {
+	places := map[string]string{
+		"Paris":   `{"results":[{"name":"Paris","latitude":48.85,"longitude":2.35,"country":"France","admin1":"Île-de-France"}]}`,
+		"Houston": `{"results":[{"name":"Houston","latitude":29.76,"longitude":-95.36,"country":"United States","admin1":"Texas"}]}`,
+	}
+	tests := []struct {
+		name         string
+		location     string
+		geocodeError int // Status the geocoding API answers with; 0 for 200
+		wantErr      string
+		wantPlace    string
+		wantForecast string // Coordinates expected in the forecast request
+	}{
+		{"city", "Paris", 0, "", "Paris", "latitude=48.850000&longitude=2.350000"},
+		{"city and region falls back to the city", "Houston, TX", 0, "", "Houston", "latitude=29.760000&longitude=-95.360000"},
+		{"unknown place", "Atlantis", 0, "not_found", "", ""},
+		{"unknown place with region", "Atlantis, Sea", 0, "not_found", "", ""},
+		{"geocoding fails", "Paris", http.StatusServiceUnavailable, "network_error", "", ""},
+		{"empty", "  ", 0, "invalid_argument", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var forecastQuery string
+			tc := newTestToolContext(t, nil)
+			tc.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				switch req.URL.Host {
+				case "geocoding-api.open-meteo.com":
+					if tt.geocodeError != 0 {
+						return jsonResponse(tt.geocodeError, ""), nil
+					}
+					if body, ok := places[req.URL.Query().Get("name")]; ok {
+						return jsonResponse(http.StatusOK, body), nil
+					}
+					return jsonResponse(http.StatusOK, `{}`), nil
+				case "api.open-meteo.com":
+					forecastQuery = req.URL.RawQuery
+					return jsonResponse(http.StatusOK, `{"current_weather":{"temperature":21.5}}`), nil
+				}
+				return nil, fmt.Errorf("unexpected request to %s", req.URL)
+			})}
+
+			result := getWeather(context.Background(), map[string]any{"location": tt.location}, tc)
+			if tt.wantErr != "" {
+				if result.OK || result.Error.Code != tt.wantErr {
+					t.Errorf("get_weather = %s, want error %s", resultJSON(t, result), tt.wantErr)
+				}
+				return
+			}
+			if !result.OK {
+				t.Fatalf("get_weather failed: %s", resultJSON(t, result))
+			}
+			if !strings.Contains(forecastQuery, tt.wantForecast) {
+				t.Errorf("forecast query = %q, want the coordinates %s", forecastQuery, tt.wantForecast)
+			}
+			if name := result.Data["location"].(map[string]any)["name"]; name != tt.wantPlace {
+				t.Errorf("location name = %v, want %s", name, tt.wantPlace)
+			}
+			if _, ok := result.Data["current_weather"]; !ok {
+				t.Errorf("result is missing the forecast: %s", resultJSON(t, result))
+			}
+		})
+	}
+}
+
+func TestGetIntArg(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     any // absent to leave the argument out
+		want    int
+		wantErr string // Substring of the error; "" for success
+	}{
+		{"int", 7, 7, ""},
+		{"int64", int64(-3), -3, ""},
+		{"whole float", float64(42), 42, ""},
+		{"negative float", float64(-1), -1, ""},
+		{"string", " 12 ", 12, ""},
+		{"fraction", 1.5, 0, "must be an integer, got 1.5"},
+		{"huge float", 1e300, 0, "must be an integer"},
+		{"word", "ten", 0, "must be an integer, got ten"},
+		{"bool", true, 0, "must be an integer"},
+		{"missing", absent, 0, "missing argument: n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]any{"n": tt.raw}
+			if tt.raw == absent {
+				args = map[string]any{}
+			}
+			got, err := getIntArg(args, "n")
+			checkArgResult(t, got, err, tt.want, tt.wantErr)
+
+			opt, present, err := getOptionalIntArg(args, "n")
+			switch {
+			case tt.raw == absent && (err != nil || present || opt != 0):
+				t.Errorf("getOptionalIntArg = %d, %v, %v; want 0, false, nil", opt, present, err)
+			case tt.raw != absent && tt.wantErr == "" && (err != nil || !present || opt != tt.want):
+				t.Errorf("getOptionalIntArg = %d, %v, %v; want %d, true, nil", opt, present, err, tt.want)
+			case tt.raw != absent && tt.wantErr != "" && (err == nil || present):
+				t.Errorf("getOptionalIntArg = %d, %v, %v; want an error", opt, present, err)
+			}
+		})
+	}
+}
+
+func TestGetBoolArg(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     any // absent to leave the argument out
+		want    bool
+		wantErr string // Substring of the error; "" for success
+	}{
+		{"true", true, true, ""},
+		{"false", false, false, ""},
+		{"string true", "true", true, ""},
+		{"string any case", " FALSE ", false, ""},
+		{"yes", "yes", false, "must be a boolean, got yes"},
+		{"number", float64(1), false, "must be a boolean, got 1"},
+		{"missing", absent, false, "missing argument: b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]any{"b": tt.raw}
+			if tt.raw == absent {
+				args = map[string]any{}
+			}
+			got, err := getBoolArg(args, "b")
+			checkArgResult(t, got, err, tt.want, tt.wantErr)
+
+			opt, err := getOptionalBoolArg(args, "b", true)
+			switch {
+			case tt.raw == absent && (err != nil || !opt):
+				t.Errorf("getOptionalBoolArg = %v, %v; want the default", opt, err)
+			case tt.raw != absent && tt.wantErr == "" && (err != nil || opt != tt.want):
+				t.Errorf("getOptionalBoolArg = %v, %v; want %v", opt, err, tt.want)
+			case tt.raw != absent && tt.wantErr != "" && err == nil:
+				t.Errorf("getOptionalBoolArg = %v, want an error", opt)
+			}
+		})
+	}
+}
+
+func TestGetStringSliceArg(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     any // absent to leave the argument out
+		want    []string
+		wantErr string // Substring of the error; "" for success
+	}{
+		{"decoded list", []any{"a", "b"}, []string{"a", "b"}, ""},
+		{"string slice", []string{"c"}, []string{"c"}, ""},
+		{"empty", []any{}, []string{}, ""},
+		{"mixed items", []any{"a", float64(1)}, nil, "must be an array of strings"},
+		{"single string", "a", nil, "must be an array of strings"},
+		{"missing", absent, nil, "missing argument: list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := map[string]any{"list": tt.raw}
+			if tt.raw == absent {
+				args = map[string]any{}
+			}
+			got, err := getStringSliceArg(args, "list")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("getStringSliceArg = %q, %v; want error %q", got, err, tt.wantErr)
+				}
+			} else if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("getStringSliceArg = %q, %v; want %q", got, err, tt.want)
+			}
+
+			opt, err := getOptionalStringSliceArg(args, "list")
+			if tt.raw == absent && (err != nil || opt != nil) {
+				t.Errorf("getOptionalStringSliceArg = %q, %v; want nil, nil", opt, err)
+			}
+		})
+	}
+}
+
+// checkArgResult compares what an argument getter returned with the case's
+// expectations.
+func checkArgResult[T comparable](t *testing.T, got T, err error, want T, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("got %v, %v; want error %q", got, err, wantErr)
+		}
+	} else if err != nil || got != want {
+		t.Errorf("got %v, %v; want %v", got, err, want)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// traceQueueSize bounds how many records may wait to be written before
+// tracing starts to hold up the agent.
+const traceQueueSize = 64
+
+// traceRequest is the trace record for one request to the model.
+type traceRequest struct {
+	Time     time.Time                    `json:"time"`
+	Type     string                       `json:"type"` // Always "request"
+	Model    string                       `json:"model"`
+	Contents []*genai.Content             `json:"contents"`
+	Config   *genai.GenerateContentConfig `json:"config,omitempty"`
+}
+
+// traceResponse is the trace record for the aggregated streamed response.
+type traceResponse struct {
+	Time      time.Time                                   `json:"time"`
+	Type      string                                      `json:"type"` // Always "response"
+	Content   *genai.Content                              `json:"content,omitempty"`
+	Usage     *genai.GenerateContentResponseUsageMetadata `json:"usage,omitempty"`
+	Error     string                                      `json:"error,omitempty"`
+	ErrorCode int                                         `json:"error_code,omitempty"` // HTTP status of an API error
+}
+
+// Tracer appends model requests and responses to a JSONL file (--trace-file),
+// giving a replayable record of a session. Records are encoded when they are
+// made but written by a background goroutine, so a slow disk never stalls the
+// stream. Secrets are replaced with [REDACTED] before anything is written.
+type Tracer struct {
+	file    *os.File
+	records chan []byte
+	done    chan struct{}
+	secrets [][]byte
+	once    sync.Once
+	err     error // First write error, reported by Close
+}
+
+// NewTracer opens path for appending and starts the writer. Every occurrence
+// of a non-empty secret is redacted from the trace.
+func NewTracer(path string, secrets ...string) (*Tracer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+
+	t := &Tracer{
+		file:    file,
+		records: make(chan []byte, traceQueueSize),
+		done:    make(chan struct{}),
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			t.secrets = append(t.secrets, []byte(secret))
+		}
+	}
+	go t.write()
+	return t, nil
+}
+
+// write drains the queue into the file until Close.
+func (t *Tracer) write() {
+	defer close(t.done)
+	w := bufio.NewWriter(t.file)
+	for record := range t.records {
+		if _, err := w.Write(record); err != nil && t.err == nil {
+			t.err = err
+		}
+		// Flush whenever the queue empties so the file stays current
+		if len(t.records) == 0 {
+			if err := w.Flush(); err != nil && t.err == nil {
+				t.err = err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil && t.err == nil {
+		t.err = err
+	}
+}
+
+// record encodes v as one line and queues it. A nil Tracer records nothing.
+func (t *Tracer) record(v any) {
+	if t == nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"type": "error", "error": fmt.Sprintf("failed to encode trace record: %v", err)})
+	}
+	for _, secret := range t.secrets {
+		data = bytes.ReplaceAll(data, secret, []byte(redactSecret(string(secret))))
+	}
+	t.records <- append(data, '\n')
+}
+
+// Request records a request about to be sent.
+func (t *Tracer) Request(model string, contents []*genai.Content, config *genai.GenerateContentConfig) {
+	if t == nil {
+		return
+	}
+	t.record(traceRequest{
+		Time:     time.Now(),
+		Type:     "request",
+		Model:    model,
+		Contents: contents,
+		Config:   config,
+	})
+}
+
+// Response records the response to the last request, or the error that
+// ended it.
+func (t *Tracer) Response(content *genai.Content, usage *genai.GenerateContentResponseUsageMetadata, err error) {
+	if t == nil {
+		return
+	}
+	record := traceResponse{Time: time.Now(), Type: "response", Content: content, Usage: usage}
+	if err != nil {
+		record.Error = err.Error()
+		var apiErr genai.APIError
+		var apiErrPtr *genai.APIError
+		if errors.As(err, &apiErr) {
+			record.ErrorCode = apiErr.Code
+		} else if errors.As(err, &apiErrPtr) {
+			record.ErrorCode = apiErrPtr.Code
+		}
+	}
+	t.record(record)
+}
+
+// Close writes any queued records and closes the file. It is safe to call
+// more than once, and on a nil Tracer.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.once.Do(func() {
+		close(t.records)
+		<-t.done
+		if err := t.file.Close(); err != nil && t.err == nil {
+			t.err = err
+		}
+	})
+	return t.err
+}
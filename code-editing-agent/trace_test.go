@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// readTrace returns the records in a trace file, decoded as maps.
+func readTrace(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []map[string]any
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("trace line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// recordTypes lists the type of each record.
+func recordTypes(records []map[string]any) []string {
+	types := make([]string, len(records))
+	for i, record := range records {
+		types[i], _ = record["type"].(string)
+	}
+	return types
+}
+
+func TestTraceTurn(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []traceResponse
+		wantErr   bool
+		wantTypes []string
+		check     func(t *testing.T, records []map[string]any)
+	}{
+		{name: "one answer",
+			responses: []traceResponse{{Type: "response", Content: genai.NewContentFromText("Hi.", genai.RoleModel)}},
+			wantTypes: []string{"request", "response"},
+			check: func(t *testing.T, records []map[string]any) {
+				if records[0]["model"] != defaultModel {
+					t.Errorf("request model = %v", records[0]["model"])
+				}
+				if contents, _ := records[0]["contents"].([]any); len(contents) != 1 {
+					t.Errorf("request contents = %v, want the prompt", records[0]["contents"])
+				}
+				if !strings.Contains(mustJSON(t, records[1]["content"]), "Hi.") {
+					t.Errorf("response = %v, want the answer", records[1])
+				}
+			}},
+		{name: "tool round",
+			responses: []traceResponse{
+				{Type: "response", Content: functionCallContent("list_files", map[string]any{"path": "."})},
+				{Type: "response", Content: genai.NewContentFromText("Done.", genai.RoleModel)},
+			},
+			wantTypes: []string{"request", "response", "request", "response"},
+			check: func(t *testing.T, records []map[string]any) {
+				// The second request carries the call and its result
+				if contents, _ := records[2]["contents"].([]any); len(contents) != 3 {
+					t.Errorf("second request has %d contents, want 3", len(contents))
+				}
+			}},
+		{name: "failed request",
+			responses: []traceResponse{{Type: "response", Error: "bad request", ErrorCode: 400}},
+			wantErr:   true,
+			wantTypes: []string{"request", "response"},
+			check: func(t *testing.T, records []map[string]any) {
+				if records[1]["error_code"] != float64(400) || records[1]["error"] == "" {
+					t.Errorf("response = %v, want the error and its status", records[1])
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := newTestAgent(t, &ReplayClient{path: "script", responses: tt.responses}, nil)
+			agent.maxRetries = 0
+			path := filepath.Join(t.TempDir(), "trace.jsonl")
+			tracer, err := NewTracer(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			agent.tracer = tracer
+
+			_, err = agent.runTurn(context.Background(), "hello")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runTurn error = %v, want error %v", err, tt.wantErr)
+			}
+			if err := tracer.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			records := readTrace(t, path)
+			if got := recordTypes(records); !slices.Equal(got, tt.wantTypes) {
+				t.Fatalf("records = %q, want %q", got, tt.wantTypes)
+			}
+			tt.check(t, records)
+		})
+	}
+}
+
+// mustJSON encodes v for substring checks.
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTracerRedactsSecrets(t *testing.T) {
+	const key = "AIza-secret-key"
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	tracer, err := NewTracer(path, key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.Request("m", []*genai.Content{genai.NewContentFromText("my key is "+key, genai.RoleUser)}, nil)
+	tracer.Response(nil, nil, errors.New("rejected key "+key))
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), key) {
+		t.Errorf("trace contains the key:\n%s", data)
+	}
+	if n := strings.Count(string(data), "[REDACTED]"); n != 2 {
+		t.Errorf("trace has %d redactions, want 2:\n%s", n, data)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("trace file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestTracerClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	if err := os.WriteFile(path, []byte(`{"type":"request"}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tracer, err := NewTracer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More records than the queue holds, all of which must reach the file
+	for range traceQueueSize * 3 {
+		tracer.Response(genai.NewContentFromText("x", genai.RoleModel), nil, nil)
+	}
+	for range 2 {
+		if err := tracer.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+	if got := len(readTrace(t, path)); got != traceQueueSize*3+1 {
+		t.Errorf("trace has %d records, want the existing one plus %d appended", got, traceQueueSize*3)
+	}
+
+	var nilTracer *Tracer
+	nilTracer.Request("m", nil, nil)
+	nilTracer.Response(nil, nil, nil)
+	if err := nilTracer.Close(); err != nil {
+		t.Errorf("nil Close = %v", err)
+	}
+
+	if _, err := NewTracer(filepath.Join(t.TempDir(), "missing", "trace.jsonl")); err == nil {
+		t.Error("NewTracer succeeded in a missing directory")
+	}
+}
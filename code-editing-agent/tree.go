@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+const (
+	defaultTreeDepth = 3    // Levels tree descends when max_depth is not given
+	maxTreeNodes     = 1000 // Upper bound on entries one tree call returns
+)
+
+// treeFiles returns the directory hierarchy under a path as nested nodes:
+// {"name": "src/", "children": [...]} for directories and {"name": "main.go"}
+// for files. Directories below max_depth that have entries are marked
+// "more": true instead of being expanded, as are directories cut short by
+// max_nodes.
+func treeFiles(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+	path, err := getOptionalStringArg(args, "path", ".")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	includeIgnored, err := getOptionalBoolArg(args, "include_ignored", false)
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+
+	maxDepth, hasDepth, err := getOptionalIntArg(args, "max_depth")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if !hasDepth {
+		maxDepth = defaultTreeDepth
+	}
+	if maxDepth < 1 {
+		return NewErrorResult("invalid_argument", "max_depth must be at least 1", nil)
+	}
+
+	maxNodes, hasNodes, err := getOptionalIntArg(args, "max_nodes")
+	if err != nil {
+		return NewErrorResult("invalid_argument", err.Error(), nil)
+	}
+	if !hasNodes || maxNodes > maxTreeNodes {
+		maxNodes = maxTreeNodes
+	}
+	if maxNodes < 1 {
+		return NewErrorResult("invalid_argument", "max_nodes must be at least 1", nil)
+	}
+
+	resolvedPath, err := tc.Sandbox.Resolve(path, AccessListDir)
+	if sandboxErr, ok := err.(*SandboxError); ok {
+		return NewErrorResultFromSandbox(sandboxErr)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to resolve path: %v", err), nil)
+	}
+
+	info, err := tc.Sandbox.FS.Stat(resolvedPath)
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to stat path: %v", err), nil)
+	}
+	if !info.IsDir() {
+		return NewErrorResult("invalid_argument", fmt.Sprintf("not a directory: %s", path), []string{
+			"Use read_file or stat_file for a single file",
+		})
+	}
+
+	w := &treeWalker{
+		sandbox:        tc.Sandbox,
+		ignore:         NewIgnoreMatcher(tc.Sandbox.FS, tc.Sandbox.Root),
+		includeIgnored: includeIgnored,
+		maxDepth:       maxDepth,
+		maxNodes:       maxNodes,
+	}
+	children, err := w.walk(ctx, resolvedPath, 1)
+	if ctx.Err() != nil {
+		return NewErrorResult("timeout", "the tree walk was stopped because the turn ended", nil)
+	}
+	if err != nil {
+		return NewErrorResult("io_error", fmt.Sprintf("failed to list directory: %v", err), nil)
+	}
+
+	return NewSuccessResult(map[string]any{
+		"tree":      map[string]any{"name": filepath.ToSlash(filepath.Clean(path)) + "/", "children": children},
+		"nodes":     w.nodes,
+		"truncated": w.truncated,
+	})
+}
+
+// treeWalker carries the limits and counters of one tree call.
+type treeWalker struct {
+	sandbox        *PathSandbox
+	ignore         *IgnoreMatcher
+	includeIgnored bool
+	maxDepth       int
+	maxNodes       int
+
+	nodes     int  // Entries added so far
+	truncated bool // Set once maxNodes stopped the walk
+}
+
+// walk returns the nodes for the entries of dir, which is depth levels below
+// the requested directory. Only the root directory's read error is returned;
+// unreadable subdirectories are shown without children.
+func (w *treeWalker) walk(ctx context.Context, dir string, depth int) ([]map[string]any, error) {
+	entries, err := w.sandbox.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	children := []map[string]any{}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return children, err
+		}
+
+		p := filepath.Join(dir, entry.Name())
+		rootRel, err := filepath.Rel(w.sandbox.Root, p)
+		if err != nil {
+			continue
+		}
+		isDir := entry.IsDir()
+		if w.sandbox.Excluded(rootRel, isDir) || (!w.includeIgnored && w.ignore.Match(rootRel, isDir)) {
+			continue
+		}
+		// Skip symlinks the sandbox would refuse to follow (e.g. pointing outside the root)
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if _, err := w.sandbox.Resolve(rootRel, AccessReadFile); err != nil {
+				continue
+			}
+		}
+
+		if w.nodes >= w.maxNodes {
+			w.truncated = true
+			return children, nil
+		}
+		w.nodes++
+
+		if !isDir {
+			children = append(children, map[string]any{"name": entry.Name()})
+			continue
+		}
+
+		node := map[string]any{"name": entry.Name() + "/"}
+		if depth >= w.maxDepth {
+			if sub, err := w.sandbox.ReadDir(p); err == nil && len(sub) > 0 {
+				node["more"] = true
+			}
+		} else if sub, err := w.walk(ctx, p, depth+1); err == nil || ctx.Err() != nil {
+			node["children"] = sub
+			if w.truncated {
+				node["more"] = true // Cut short by max_nodes
+			}
+		}
+		children = append(children, node)
+		if w.truncated {
+			return children, nil
+		}
+	}
+	return children, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// renderTree writes a tree node compactly: a file is its name, a directory
+// its name followed by its children in parentheses, and "+" marks one with
+// more entries than were shown. An unexpanded directory has no parentheses.
+func renderTree(node map[string]any) string {
+	var b strings.Builder
+	b.WriteString(node["name"].(string))
+	if children, ok := node["children"].([]map[string]any); ok {
+		names := make([]string, len(children))
+		for i, child := range children {
+			names[i] = renderTree(child)
+		}
+		b.WriteString("(" + strings.Join(names, " ") + ")")
+	}
+	if node["more"] == true {
+		b.WriteString("+")
+	}
+	return b.String()
+}
+
+func TestTree(t *testing.T) {
+	files := map[string]string{
+		".gitignore":        "build/\n*.log\n",
+		"README.md":         "# Demo\n",
+		"debug.log":         "noise\n",
+		"src/main.go":       "package main\n",
+		"src/pkg/util.go":   "package pkg\n",
+		"src/pkg/deep/x.go": "package deep\n",
+		"build/out.bin":     "\x00",
+	}
+	tests := []struct {
+		name      string
+		args      map[string]any
+		wantErr   string // Error code; "" for success
+		want      string // Rendered tree
+		wantNodes int
+		truncated bool
+	}{
+		{name: "default depth", args: map[string]any{},
+			want: "./(.gitignore README.md empty/() src/(main.go pkg/(deep/+ util.go)))", wantNodes: 8},
+		{name: "depth one", args: map[string]any{"max_depth": 1},
+			want: "./(.gitignore README.md empty/ src/+)", wantNodes: 4},
+		{name: "depth two", args: map[string]any{"max_depth": 2},
+			want: "./(.gitignore README.md empty/() src/(main.go pkg/+))", wantNodes: 6},
+		{name: "deeper", args: map[string]any{"max_depth": 4},
+			want: "./(.gitignore README.md empty/() src/(main.go pkg/(deep/(x.go) util.go)))", wantNodes: 9},
+		{name: "subdirectory", args: map[string]any{"path": "src/pkg"},
+			want: "src/pkg/(deep/(x.go) util.go)", wantNodes: 3},
+		{name: "include ignored", args: map[string]any{"include_ignored": true, "max_depth": 1},
+			want: "./(.gitignore README.md build/+ debug.log empty/ src/+)", wantNodes: 6},
+		{name: "node cap", args: map[string]any{"max_nodes": 5},
+			want: "./(.gitignore README.md empty/() src/(main.go)+)", wantNodes: 5, truncated: true},
+		{name: "node cap at the top", args: map[string]any{"max_nodes": 2},
+			want: "./(.gitignore README.md)", wantNodes: 2, truncated: true},
+		{name: "cap above the limit", args: map[string]any{"max_nodes": maxTreeNodes * 10},
+			want: "./(.gitignore README.md empty/() src/(main.go pkg/(deep/+ util.go)))", wantNodes: 8},
+		{name: "zero depth", args: map[string]any{"max_depth": 0}, wantErr: "invalid_argument"},
+		{name: "zero nodes", args: map[string]any{"max_nodes": 0}, wantErr: "invalid_argument"},
+		{name: "a file", args: map[string]any{"path": "README.md"}, wantErr: "invalid_argument"},
+		{name: "missing", args: map[string]any{"path": "nope"}, wantErr: "not_found"},
+		{name: "outside the root", args: map[string]any{"path": ".."}, wantErr: "permission_denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestToolContext(t, files)
+			if err := os.Mkdir(filepath.Join(tc.Sandbox.Root, "empty"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			result := treeFiles(context.Background(), tt.args, tc)
+			if tt.wantErr != "" {
+				if result.OK || result.Error.Code != tt.wantErr {
+					t.Errorf("result = %s, want error %s", resultJSON(t, result), tt.wantErr)
+				}
+				return
+			}
+			if !result.OK {
+				t.Fatalf("failed: %s", resultJSON(t, result))
+			}
+			if got := renderTree(result.Data["tree"].(map[string]any)); got != tt.want {
+				t.Errorf("tree = %s, want %s", got, tt.want)
+			}
+			if result.Data["nodes"] != tt.wantNodes || result.Data["truncated"] != tt.truncated {
+				t.Errorf("nodes = %v, truncated = %v; want %d, %v", result.Data["nodes"], result.Data["truncated"], tt.wantNodes, tt.truncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"unicode/utf8"
+)
+
+// defaultMaxResultBytes caps the encoded size of a tool result sent back to
+// the model, so one huge listing or search cannot flood the context window.
+const defaultMaxResultBytes = 256 << 10
+
+// truncationHint tells the model how to get at what was cut.
+const truncationHint = "This result was too large and has been cut short; narrow the request (a subdirectory, a more specific pattern, or a line range) to see the rest"
+
+// truncateResult returns result with its data cut down so the encoded result
+// fits in limit bytes, marked with truncated=true and a hint. The largest
+// strings and lists are shortened first. A limit of 0 disables truncation.
+func truncateResult(result *ToolResult, limit int) *ToolResult {
+	if limit <= 0 || result.Data == nil || encodedSize(result.AsMap()) <= limit {
+		return result
+	}
+
+	data := maps.Clone(result.Data)
+	data["truncated"] = true
+	data["truncation_hint"] = truncationHint
+	truncated := &ToolResult{OK: result.OK, Data: data, Error: result.Error}
+
+	for range 16 {
+		excess := encodedSize(truncated.AsMap()) - limit
+		if excess <= 0 {
+			return truncated
+		}
+		key, size := largestField(data)
+		if key == "" {
+			break
+		}
+		if shrunk, ok := shrinkValue(data[key], size, size-excess); ok {
+			data[key] = shrunk
+		} else {
+			delete(data, key)
+		}
+	}
+
+	// Nothing left to shorten, or it would not converge: keep only the markers
+	if encodedSize(truncated.AsMap()) > limit {
+		truncated.Data = map[string]any{"truncated": true, "truncation_hint": truncationHint}
+	}
+	return truncated
+}
+
+// largestField returns the key of the largest shrinkable value in data and
+// its encoded size, ignoring the truncation markers.
+func largestField(data map[string]any) (string, int) {
+	var largest string
+	var largestSize int
+	for key, value := range data {
+		if key == "truncated" || key == "truncation_hint" {
+			continue
+		}
+		if size := encodedSize(value); size > largestSize {
+			largest, largestSize = key, size
+		}
+	}
+	return largest, largestSize
+}
+
+// shrinkValue cuts a string or slice that encodes to size bytes so it encodes
+// to roughly target bytes. It reports false for values it cannot shorten.
+func shrinkValue(value any, size, target int) (any, bool) {
+	target = max(target, 0)
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		s := v.String()
+		keep := len(s) * target / size
+		for keep > 0 && !utf8.RuneStart(s[keep]) {
+			keep--
+		}
+		return s[:keep], true
+	case reflect.Slice:
+		keep := v.Len() * target / size
+		if keep >= v.Len() && keep > 0 {
+			keep = v.Len() - 1
+		}
+		return v.Slice(0, keep).Interface(), true
+	default:
+		return nil, false
+	}
+}
+
+// encodedSize returns how many bytes value occupies as JSON.
+func encodedSize(value any) int {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+func TestTruncateResult(t *testing.T) {
+	many := make([]string, 1000)
+	for i := range many {
+		many[i] = fmt.Sprintf("file%04d.txt", i)
+	}
+	nested := map[string]any{"blob": strings.Repeat("x", 5000)}
+	tests := []struct {
+		name          string
+		result        *ToolResult
+		limit         int
+		wantTruncated bool
+		check         func(t *testing.T, data map[string]any)
+	}{
+		{"under the limit", NewSuccessResult(map[string]any{"content": "small"}), 1000, false, nil},
+		{"disabled", NewSuccessResult(map[string]any{"content": strings.Repeat("x", 5000)}), 0, false, nil},
+		{"error without data", NewErrorResult("io_error", strings.Repeat("x", 5000), nil), 100, false, nil},
+		{"long string", NewSuccessResult(map[string]any{"content": strings.Repeat("x", 5000), "path": "a.txt"}), 1000, true,
+			func(t *testing.T, data map[string]any) {
+				if content := data["content"].(string); len(content) == 0 || len(content) >= 5000 {
+					t.Errorf("content kept %d bytes, want it shortened but not emptied", len(content))
+				}
+				if data["path"] != "a.txt" {
+					t.Errorf("path = %v, want the small field kept", data["path"])
+				}
+			}},
+		{"multibyte string", NewSuccessResult(map[string]any{"content": strings.Repeat("é✓", 2000)}), 1000, true,
+			func(t *testing.T, data map[string]any) {
+				if content := data["content"].(string); !utf8.ValidString(content) {
+					t.Errorf("content was cut mid-rune: %q", content[len(content)-4:])
+				}
+			}},
+		{"long list", NewSuccessResult(map[string]any{"files": many, "count": len(many)}), 2000, true,
+			func(t *testing.T, data map[string]any) {
+				files := data["files"].([]string)
+				if len(files) == 0 || len(files) >= len(many) || !reflect.DeepEqual(files, many[:len(files)]) {
+					t.Errorf("files kept %d entries, want a shorter prefix of the list", len(files))
+				}
+				if data["count"] != len(many) {
+					t.Errorf("count = %v, want the original count kept", data["count"])
+				}
+			}},
+		{"unshrinkable value", NewSuccessResult(map[string]any{"nested": nested, "path": "a.txt"}), 500, true,
+			func(t *testing.T, data map[string]any) {
+				if _, ok := data["nested"]; ok {
+					t.Error("nested map was kept")
+				}
+			}},
+		{"limit below the markers", NewSuccessResult(map[string]any{"content": strings.Repeat("x", 5000)}), 10, true,
+			func(t *testing.T, data map[string]any) {
+				if len(data) != 2 {
+					t.Errorf("data = %v, want only the markers", data)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := encodedSize(tt.result.AsMap())
+			got := truncateResult(tt.result, tt.limit)
+			if encodedSize(tt.result.AsMap()) != before {
+				t.Error("truncateResult modified its argument")
+			}
+			if !tt.wantTruncated {
+				if got != tt.result {
+					t.Error("result was replaced although it needed no truncation")
+				}
+				return
+			}
+			if got.Data["truncated"] != true || got.Data["truncation_hint"] != truncationHint {
+				t.Errorf("data = %v, want the truncation markers", got.Data)
+			}
+			if got.OK != tt.result.OK {
+				t.Errorf("ok = %v, want %v", got.OK, tt.result.OK)
+			}
+			if size := encodedSize(got.AsMap()); size > tt.limit && len(got.Data) > 2 {
+				t.Errorf("encoded size %d exceeds the %d byte limit", size, tt.limit)
+			}
+			if tt.check != nil {
+				tt.check(t, got.Data)
+			}
+		})
+	}
+}
+
+func TestHugeListingIsTruncated(t *testing.T) {
+	// list_files pages its output, so the cap is set below one full page
+	const limit = 16 << 10
+	agent := newTestAgent(t, NewScriptedClient(), func(cfg *Config) { cfg.MaxResultBytes = limit })
+	dir := filepath.Join(agent.sandbox.Root, "big")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10_000 {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("entry-%05d.txt", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call := &genai.FunctionCall{Name: "list_files", Args: map[string]any{"path": "big", "page_size": maxListPageSize}}
+	parts := agent.executeToolCalls(context.Background(), []*genai.FunctionCall{call}, newRepeatTracker(0))
+	response := parts[0].FunctionResponse.Response
+	if response["ok"] != true {
+		t.Fatalf("list_files failed: %v", response["error"])
+	}
+	data := response["data"].(map[string]any)
+	if data["truncated"] != true || data["truncation_hint"] != truncationHint {
+		t.Errorf("listing of 10,000 entries is not flagged as truncated: keys %v", reflect.ValueOf(data).MapKeys())
+	}
+	if size := encodedSize(response); size > limit {
+		t.Errorf("encoded response is %d bytes, want at most %d", size, limit)
+	}
+	if files, _ := data["files"].([]string); len(files) == 0 || len(files) >= maxListPageSize {
+		t.Errorf("kept %d files, want a shortened listing", len(files))
+	}
+}
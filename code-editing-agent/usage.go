@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/genai"
+)
+
+// errTokenBudgetExceeded stops the agent once the session token budget is spent.
+var errTokenBudgetExceeded = errors.New("token budget exceeded")
+
+// Usage holds accumulated token counts for a session.
+type Usage struct {
+	PromptTokens    int
+	CandidateTokens int
+	TotalTokens     int
+}
+
+// add accumulates the counts reported for one model response.
+func (u *Usage) add(meta *genai.GenerateContentResponseUsageMetadata) {
+	if meta == nil {
+		return
+	}
+	u.PromptTokens += int(meta.PromptTokenCount)
+	u.CandidateTokens += int(meta.CandidatesTokenCount)
+	u.TotalTokens += int(meta.TotalTokenCount)
+}
+
+// Usage returns the token counts accumulated so far.
+func (a *Agent) Usage() Usage {
+	return a.usage
+}
+
+// CountTokens counts the tokens text costs for the agent's current model.
+// It lets the agent serve as the ToolContext's TokenCounter.
+func (a *Agent) CountTokens(ctx context.Context, text string) (int, error) {
+	resp, err := a.client.CountTokens(ctx, a.model, genai.Text(text), nil)
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.TotalTokens), nil
+}
+
+// overBudget reports whether the session has exceeded its token budget.
+// A zero budget means unlimited.
+func (a *Agent) overBudget() bool {
+	return a.maxTokens > 0 && a.usage.TotalTokens > a.maxTokens
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// validateArgs checks args against a tool's declared parameter schema and
+// returns every violation found: missing required fields, unknown fields,
+// wrong types, and values outside an enum. Values the argument getters can
+// coerce, such as "true" for a boolean, are accepted. An object schema
+// rejects fields it does not declare, even when it declares none. A nil
+// schema accepts anything.
+func validateArgs(schema *genai.Schema, args map[string]any) []string {
+	if schema == nil {
+		return nil
+	}
+	return validateObject("", schema, args)
+}
+
+// validateObject checks the fields of an object value; prefix names the
+// enclosing field in messages.
+func validateObject(prefix string, schema *genai.Schema, fields map[string]any) []string {
+	var violations []string
+	for _, name := range schema.Required {
+		if _, ok := fields[name]; !ok {
+			violations = append(violations, fmt.Sprintf("missing required argument %s", prefix+name))
+		}
+	}
+
+	// Sorted so the message is stable for a given call
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prop, ok := schema.Properties[name]
+		if !ok {
+			if schema.Type == genai.TypeObject || len(schema.Properties) > 0 {
+				violations = append(violations, fmt.Sprintf("unknown argument %s", prefix+name))
+			}
+			continue
+		}
+		violations = append(violations, validateValue(prefix+name, prop, fields[name])...)
+	}
+	return violations
+}
+
+// validateValue checks a single value against its schema.
+func validateValue(name string, schema *genai.Schema, value any) []string {
+	switch schema.Type {
+	case genai.TypeString:
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("argument %s must be a string", name)}
+		}
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, s) {
+			return []string{fmt.Sprintf("argument %s must be one of %s", name, strings.Join(schema.Enum, ", "))}
+		}
+
+	case genai.TypeInteger:
+		if _, ok := toInt(value); !ok {
+			return []string{fmt.Sprintf("argument %s must be an integer", name)}
+		}
+
+	case genai.TypeNumber:
+		switch value.(type) {
+		case float64, float32, int, int64:
+		default:
+			return []string{fmt.Sprintf("argument %s must be a number", name)}
+		}
+
+	case genai.TypeBoolean:
+		if _, ok := toBool(value); !ok {
+			return []string{fmt.Sprintf("argument %s must be a boolean", name)}
+		}
+
+	case genai.TypeArray:
+		items, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("argument %s must be an array", name)}
+		}
+		if schema.Items == nil {
+			return nil
+		}
+		var violations []string
+		for i, item := range items {
+			violations = append(violations, validateValue(fmt.Sprintf("%s[%d]", name, i), schema.Items, item)...)
+		}
+		return violations
+
+	case genai.TypeObject:
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("argument %s must be an object", name)}
+		}
+		return validateObject(name+".", schema, fields)
+	}
+	return nil
+}
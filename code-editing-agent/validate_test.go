@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestValidateArgs(t *testing.T) {
+	schema := &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"path":  {Type: genai.TypeString},
+			"mode":  {Type: genai.TypeString, Enum: []string{"fast", "slow"}},
+			"count": {Type: genai.TypeInteger},
+			"ratio": {Type: genai.TypeNumber},
+			"force": {Type: genai.TypeBoolean},
+			"tags":  {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+			"opts": {Type: genai.TypeObject, Properties: map[string]*genai.Schema{
+				"depth": {Type: genai.TypeInteger},
+			}},
+		},
+		Required: []string{"path"},
+	}
+	empty := &genai.Schema{Type: genai.TypeObject, Properties: map[string]*genai.Schema{}}
+
+	tests := []struct {
+		name   string
+		schema *genai.Schema
+		args   map[string]any
+		want   []string
+	}{
+		{"valid", schema, map[string]any{"path": "a", "mode": "fast", "count": float64(2), "ratio": 0.5, "force": true, "tags": []any{"x"}, "opts": map[string]any{"depth": float64(1)}}, nil},
+		{"coerced values", schema, map[string]any{"path": "a", "count": "3", "force": "true"}, nil},
+		{"missing required", schema, map[string]any{}, []string{"missing required argument path"}},
+		{"unknown", schema, map[string]any{"path": "a", "colour": "red"}, []string{"unknown argument colour"}},
+		{"wrong types", schema, map[string]any{"path": 1.0, "count": 1.5, "ratio": "x", "force": "maybe"}, []string{
+			"argument count must be an integer",
+			"argument force must be a boolean",
+			"argument path must be a string",
+			"argument ratio must be a number",
+		}},
+		{"enum", schema, map[string]any{"path": "a", "mode": "medium"}, []string{"argument mode must be one of fast, slow"}},
+		{"array items", schema, map[string]any{"path": "a", "tags": []any{"x", 2.0}}, []string{"argument tags[1] must be a string"}},
+		{"not an array", schema, map[string]any{"path": "a", "tags": "x"}, []string{"argument tags must be an array"}},
+		{"nested object", schema, map[string]any{"path": "a", "opts": map[string]any{"depth": "deep", "extra": 1.0}}, []string{
+			"argument opts.depth must be an integer",
+			"unknown argument opts.extra",
+		}},
+		{"no properties declared", empty, map[string]any{}, nil},
+		{"unknown with no properties declared", empty, map[string]any{"path": "a"}, []string{"unknown argument path"}},
+		{"object with nil properties", &genai.Schema{Type: genai.TypeObject}, map[string]any{"x": 1.0}, []string{"unknown argument x"}},
+		{"nil schema", nil, map[string]any{"anything": 1.0}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateArgs(tt.schema, tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinToolsRejectUnknownArgs(t *testing.T) {
+	registry := NewDefaultRegistry()
+	tc := newTestToolContext(t, nil)
+	for _, name := range []string{"undo_last_edit", "project_overview", "read_file"} {
+		result := registry.Execute(t.Context(), &genai.FunctionCall{Name: name, Args: map[string]any{"path": "x", "bogus": true}}, tc)
+		if result.OK || result.Error.Code != "invalid_argument" {
+			t.Errorf("%s with an unknown argument = %s, want invalid_argument", name, resultJSON(t, result))
+		}
+	}
+}
+
+func TestExecuteValidatesArgs(t *testing.T) {
+	var runs int
+	registry := NewRegistry(&FuncTool{
+		Decl: &genai.FunctionDeclaration{
+			Name: "resize",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"path":  {Type: genai.TypeString},
+					"width": {Type: genai.TypeInteger},
+					"keep":  {Type: genai.TypeBoolean},
+				},
+				Required: []string{"path", "width"},
+			},
+		},
+		Run: func(ctx context.Context, args map[string]any, tc *ToolContext) *ToolResult {
+			runs++
+			return NewSuccessResult(map[string]any{})
+		},
+	})
+	tc := newTestToolContext(t, nil)
+
+	tests := []struct {
+		name    string
+		tool    string
+		args    map[string]any
+		wantErr string   // Error code; "" for success
+		want    []string // Violations the message must list
+	}{
+		{"valid", "resize", map[string]any{"path": "a.png", "width": float64(10)}, "", nil},
+		{"coerced", "resize", map[string]any{"path": "a.png", "width": "10", "keep": "false"}, "", nil},
+		{"every missing field", "resize", map[string]any{}, "invalid_argument", []string{
+			"missing required argument path",
+			"missing required argument width",
+		}},
+		{"every wrong type", "resize", map[string]any{"path": true, "width": 1.5, "keep": "sometimes"}, "invalid_argument", []string{
+			"argument path must be a string",
+			"argument width must be an integer",
+			"argument keep must be a boolean",
+		}},
+		{"missing and unknown", "resize", map[string]any{"path": "a.png", "height": float64(5)}, "invalid_argument", []string{
+			"missing required argument width",
+			"unknown argument height",
+		}},
+		{"unknown tool", "crop", map[string]any{}, "invalid_argument", []string{"unknown tool: crop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs = 0
+			result := registry.Execute(t.Context(), &genai.FunctionCall{Name: tt.tool, Args: tt.args}, tc)
+			if tt.wantErr == "" {
+				if !result.OK || runs != 1 {
+					t.Errorf("result = %s after %d runs, want one successful run", resultJSON(t, result), runs)
+				}
+				return
+			}
+			if result.OK || result.Error.Code != tt.wantErr {
+				t.Fatalf("result = %s, want error %s", resultJSON(t, result), tt.wantErr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(result.Error.Message, want) {
+					t.Errorf("message %q does not list %q", result.Error.Message, want)
+				}
+			}
+			if runs != 0 {
+				t.Errorf("tool ran %d times with invalid arguments", runs)
+			}
+		})
+	}
+}
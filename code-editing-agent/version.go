@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// When commit or buildDate are unset, the VCS details Go stamps into the
+// binary are used instead, if any.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// printVersion writes the version, commit, build date, Go version, and the
+// default model to w, for --version.
+func printVersion(w io.Writer) {
+	rev, built, modified := commit, buildDate, false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if rev == "" {
+					rev = setting.Value
+				}
+			case "vcs.time":
+				if built == "" {
+					built = setting.Value
+				}
+			case "vcs.modified":
+				modified = commit == "" && setting.Value == "true"
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	} else if modified {
+		rev += " (modified)"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+
+	fmt.Fprintf(w, "agent %s\n", version)
+	fmt.Fprintf(w, "commit: %s\n", rev)
+	fmt.Fprintf(w, "built: %s\n", built)
+	fmt.Fprintf(w, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(w, "default model: %s\n", defaultModel)
+}
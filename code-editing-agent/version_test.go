@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPrintVersion(t *testing.T) {
+	goLine := "go: " + runtime.Version() + " " + runtime.GOOS + "/" + runtime.GOARCH
+	tests := []struct {
+		name                    string
+		version, commit, built  string
+		wantVersion, wantCommit string
+		wantBuilt               string
+	}{
+		// Test binaries carry no VCS stamp to fall back on
+		{name: "ldflags unset", version: "dev",
+			wantVersion: "agent dev", wantCommit: "commit: unknown", wantBuilt: "built: unknown"},
+		{name: "ldflags set", version: "v1.2.0", commit: "0123abc", built: "2026-01-02T03:04:05Z",
+			wantVersion: "agent v1.2.0", wantCommit: "commit: 0123abc", wantBuilt: "built: 2026-01-02T03:04:05Z"},
+		{name: "only the version set", version: "v1.2.0",
+			wantVersion: "agent v1.2.0", wantCommit: "commit: unknown", wantBuilt: "built: unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldVersion, oldCommit, oldBuilt := version, commit, buildDate
+			t.Cleanup(func() { version, commit, buildDate = oldVersion, oldCommit, oldBuilt })
+			version, commit, buildDate = tt.version, tt.commit, tt.built
+
+			var out bytes.Buffer
+			printVersion(&out)
+			want := []string{tt.wantVersion, tt.wantCommit, tt.wantBuilt, goLine, "default model: " + defaultModel}
+			if got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("printVersion wrote\n%s\nwant\n%s", out.String(), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+func TestRunVersionNeedsNoSetup(t *testing.T) {
+	// No credentials, and a config file that does not exist: --version
+	// reports before either is looked at
+	for _, env := range []string{"GEMINI_API_KEY", "GOOGLE_API_KEY", "GOOGLE_GENAI_USE_VERTEXAI"} {
+		t.Setenv(env, "")
+	}
+	var stdout, stderr bytes.Buffer
+	args := []string{"agent", "--version", "--config", "/nonexistent/agent.toml"}
+	if code := run(args, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+		t.Fatalf("run exit code = %d, want %d (stderr: %s)", code, exitOK, stderr.String())
+	}
+	for _, want := range []string{"agent dev\n", "commit: ", "built: ", "go: ", "default model: " + defaultModel} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("stdout = %q, want it to contain %q", stdout.String(), want)
+		}
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("stderr = %q, want nothing", stderr.String())
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// wordWrapper soft-wraps streamed text at word boundaries so long lines fit
+// the terminal. Text arrives in arbitrary chunks, so the current word and the
+// whitespace before it are held back until the word is complete; nothing is
+// broken mid-word, and a word longer than the width is written on its own
+// line. Lines inside fenced code blocks are written unchanged.
+type wordWrapper struct {
+	out   io.Writer
+	width int // Columns to wrap at
+
+	col    int             // Columns already used on the current output line
+	space  strings.Builder // Whitespace waiting to be written before word
+	word   strings.Builder // Word being accumulated
+	line   strings.Builder // Start of the current input line, to spot fences
+	inCode bool            // Inside a ``` fenced block
+}
+
+// newWordWrapper returns a wordWrapper writing to out at width columns.
+// col is how many columns the current line already uses, e.g. for a prefix.
+func newWordWrapper(out io.Writer, width, col int) *wordWrapper {
+	return &wordWrapper{out: out, width: width, col: col}
+}
+
+// WriteString wraps text and writes whatever is complete.
+func (w *wordWrapper) WriteString(text string) {
+	for _, r := range text {
+		if r == '\n' {
+			w.endLine()
+			continue
+		}
+		if w.line.Len() < len("```") {
+			w.line.WriteRune(r)
+		}
+		switch {
+		case w.inCode:
+			io.WriteString(w.out, string(r))
+			w.col++
+		case r == ' ' || r == '\t':
+			w.flushWord()
+			w.space.WriteRune(r)
+		default:
+			w.word.WriteRune(r)
+		}
+	}
+}
+
+// Flush writes any held-back word, e.g. at the end of a response.
+func (w *wordWrapper) Flush() {
+	w.flushWord()
+	w.space.Reset()
+}
+
+// endLine finishes an input line and tracks code fences.
+func (w *wordWrapper) endLine() {
+	w.Flush()
+	io.WriteString(w.out, "\n")
+	w.col = 0
+	if w.line.String() == "```" {
+		w.inCode = !w.inCode
+	}
+	w.line.Reset()
+}
+
+// flushWord writes the pending whitespace and word, starting a new line first
+// if the word would overflow this one. The first word of an input line never
+// moves, so its indentation is kept.
+func (w *wordWrapper) flushWord() {
+	if w.word.Len() == 0 {
+		return
+	}
+	space, word := w.space.String(), w.word.String()
+	w.space.Reset()
+	w.word.Reset()
+
+	wordCols := utf8.RuneCountInString(word)
+	if w.col > 0 && w.col+utf8.RuneCountInString(space)+wordCols > w.width {
+		io.WriteString(w.out, "\n")
+		w.col = 0
+		space = ""
+	}
+	io.WriteString(w.out, space+word)
+	w.col += utf8.RuneCountInString(space) + wordCols
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWordWrapper(t *testing.T) {
+	tests := []struct {
+		name  string
+		width int
+		col   int // Columns already used by a prefix
+		in    string
+		want  string
+	}{
+		{"fits", 20, 0, "short line", "short line"},
+		{"wraps at words", 10, 0, "the quick brown fox jumps", "the quick\nbrown fox\njumps"},
+		{"exact width", 9, 0, "the quick brown", "the quick\nbrown"},
+		{"prefix", 12, 8, "one two three", "one\ntwo three"},
+		{"long word alone", 5, 0, "a abcdefghij b", "a\nabcdefghij\nb"},
+		{"keeps newlines", 10, 0, "one\n\ntwo three four", "one\n\ntwo three\nfour"},
+		{"keeps indentation", 10, 0, "    indented words here", "    indented\nwords here"},
+		{"multibyte counts as one column", 7, 0, "héllo wörld", "héllo\nwörld"},
+		{"code block unchanged", 10, 0, "text here and more\n```go\nfunc main() { println(\"a long line\") }\n```\nafter the fence",
+			"text here\nand more\n```go\nfunc main() { println(\"a long line\") }\n```\nafter the\nfence"},
+		{"trailing space dropped at a wrap", 5, 0, "aaaa bbbb", "aaaa\nbbbb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The same text must wrap the same way however it is chunked
+			for _, chunks := range [][]string{{tt.in}, strings.Split(tt.in, ""), splitEvery(tt.in, 3)} {
+				var out strings.Builder
+				w := newWordWrapper(&out, tt.width, tt.col)
+				for _, chunk := range chunks {
+					w.WriteString(chunk)
+				}
+				w.Flush()
+				if out.String() != tt.want {
+					t.Errorf("wrapped %d chunks = %q, want %q", len(chunks), out.String(), tt.want)
+				}
+			}
+		})
+	}
+}
+
+// splitEvery splits s into pieces of n characters, the last possibly shorter.
+// Streamed parts are whole strings, so they never split a character.
+func splitEvery(s string, n int) []string {
+	var pieces []string
+	runes := []rune(s)
+	for len(runes) > n {
+		pieces = append(pieces, string(runes[:n]))
+		runes = runes[n:]
+	}
+	return append(pieces, string(runes))
+}
+
+func TestTerminalWidth(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tests := []struct {
+		columns string
+		want    int
+	}{
+		{"", 0},
+		{"120", 120},
+		{"wide", 0},
+		{"-5", 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("COLUMNS", tt.columns)
+		if got := terminalWidth(file); got != tt.want {
+			t.Errorf("terminalWidth with COLUMNS=%q = %d, want %d", tt.columns, got, tt.want)
+		}
+	}
+}
+
+func TestTerminalSinkWraps(t *testing.T) {
+	tests := []struct {
+		name  string
+		width int
+		want  string
+	}{
+		{"off", 0, "Gemini: one two three four five\n"},
+		// The prefix takes the first eight columns of the first line
+		{"on", 20, "Gemini: one two\nthree four five\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			sink := NewTerminalSink(&out, Styler{}, tt.width)
+			for _, chunk := range []string{"one tw", "o three fo", "ur five"} {
+				sink.OnModelText(chunk)
+			}
+			sink.OnModelDone()
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}